@@ -0,0 +1,160 @@
+// Package cache provides CLI commands for managing the local download cache.
+package cache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/bytesize"
+	labcache "github.com/GilmanLab/lab/tools/labctl/internal/cache"
+)
+
+// Cmd is the cache subcommand.
+var Cmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local download cache",
+	Long:  "Commands for listing, verifying, pruning, and clearing source images cached by images sync.",
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cached downloads",
+	RunE: func(_ *cobra.Command, _ []string) error {
+		c, err := openCache()
+		if err != nil {
+			return err
+		}
+		return runList(c, os.Stdout)
+	},
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Evict least recently used downloads above a size limit",
+	RunE: func(_ *cobra.Command, _ []string) error {
+		c, err := openCache()
+		if err != nil {
+			return err
+		}
+		maxSize, err := bytesize.Parse(pruneMaxSize)
+		if err != nil {
+			return fmt.Errorf("--max-size: %w", err)
+		}
+		return runPrune(c, maxSize, os.Stdout)
+	},
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Remove cached downloads that no longer match their checksum",
+	Long: `Hash every cached download and remove those whose contents no longer match
+their checksum. Sync also hashes each cached download before using it, and
+downloads it again when it no longer matches; verify checks the whole cache
+at once.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		c, err := openCache()
+		if err != nil {
+			return err
+		}
+		return runVerify(c, os.Stdout)
+	},
+}
+
+var clearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached downloads",
+	RunE: func(_ *cobra.Command, _ []string) error {
+		c, err := openCache()
+		if err != nil {
+			return err
+		}
+		return runPrune(c, 0, os.Stdout)
+	},
+}
+
+var (
+	cacheDir     string
+	pruneMaxSize string
+)
+
+func init() {
+	Cmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "Download cache directory (default: $XDG_CACHE_HOME/labctl/downloads)")
+	pruneCmd.Flags().StringVar(&pruneMaxSize, "max-size", "20GiB", "Keep the most recently used downloads up to this size")
+
+	Cmd.AddCommand(listCmd)
+	Cmd.AddCommand(verifyCmd)
+	Cmd.AddCommand(pruneCmd)
+	Cmd.AddCommand(clearCmd)
+}
+
+func openCache() (*labcache.Cache, error) {
+	dir := cacheDir
+	if dir == "" {
+		var err error
+		dir, err = labcache.DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return labcache.New(dir, 0)
+}
+
+// runList lists cache entries, most recently used first.
+func runList(c *labcache.Cache, out io.Writer) error {
+	entries, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		_, _ = fmt.Fprintf(out, "No cached downloads in %s\n", c.Dir())
+		return nil
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CHECKSUM\tSIZE\tLAST USED")
+	_, _ = fmt.Fprintln(w, "--------\t----\t---------")
+	for _, e := range entries {
+		total += e.Size
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", e.Checksum, bytesize.Format(e.Size), e.LastUsed.Format("2006-01-02 15:04"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "\n%d download(s), %s in %s\n", len(entries), bytesize.Format(total), c.Dir())
+	return nil
+}
+
+// runPrune evicts least recently used entries until the cache is at most maxSize.
+func runPrune(c *labcache.Cache, maxSize int64, out io.Writer) error {
+	removed, err := c.Prune(maxSize)
+	for _, e := range removed {
+		_, _ = fmt.Fprintf(out, "Removed: %s (%s)\n", e.Checksum, bytesize.Format(e.Size))
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Removed %d cached download(s)\n", len(removed))
+	return nil
+}
+
+// runVerify removes cache entries whose contents no longer match their checksum.
+func runVerify(c *labcache.Cache, out io.Writer) error {
+	removed, err := c.Verify()
+	for _, e := range removed {
+		_, _ = fmt.Fprintf(out, "Removed corrupted: %s (%s)\n", e.Checksum, bytesize.Format(e.Size))
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Removed %d corrupted download(s)\n", len(removed))
+	return nil
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	labcache "github.com/GilmanLab/lab/tools/labctl/internal/cache"
+)
+
+func newCache(t *testing.T, dir string) *labcache.Cache {
+	t.Helper()
+	c, err := labcache.New(dir, 0)
+	require.NoError(t, err)
+	return c
+}
+
+func fill(t *testing.T, c *labcache.Cache, contents ...string) []string {
+	t.Helper()
+	var checksums []string
+	for _, s := range contents {
+		h := sha256.Sum256([]byte(s))
+		checksum := "sha256:" + hex.EncodeToString(h[:])
+		require.NoError(t, c.Put(checksum, bytes.NewReader([]byte(s))))
+		checksums = append(checksums, checksum)
+	}
+	return checksums
+}
+
+func TestRunList(t *testing.T) {
+	t.Run("lists entries", func(t *testing.T) {
+		c := newCache(t, t.TempDir())
+		checksums := fill(t, c, "first iso", "second iso")
+
+		var out bytes.Buffer
+		require.NoError(t, runList(c, &out))
+
+		assert.Contains(t, out.String(), "CHECKSUM")
+		assert.Contains(t, out.String(), checksums[0])
+		assert.Contains(t, out.String(), checksums[1])
+		assert.Contains(t, out.String(), "2 download(s), 19 B")
+	})
+
+	t.Run("empty cache", func(t *testing.T) {
+		dir := t.TempDir()
+
+		var out bytes.Buffer
+		require.NoError(t, runList(newCache(t, dir), &out))
+
+		assert.Contains(t, out.String(), "No cached downloads in "+dir)
+	})
+}
+
+func TestRunPrune(t *testing.T) {
+	t.Run("prune to size", func(t *testing.T) {
+		c := newCache(t, t.TempDir())
+		fill(t, c, "0123456789", "abcdefghij")
+
+		var out bytes.Buffer
+		require.NoError(t, runPrune(c, 10, &out))
+
+		assert.Contains(t, out.String(), "Removed 1 cached download(s)")
+		entries, err := c.List()
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		c := newCache(t, t.TempDir())
+		fill(t, c, "one", "two")
+
+		var out bytes.Buffer
+		require.NoError(t, runPrune(c, 0, &out))
+
+		assert.Contains(t, out.String(), "Removed 2 cached download(s)")
+	})
+}
+
+func TestRunVerify(t *testing.T) {
+	c := newCache(t, t.TempDir())
+	checksums := fill(t, c, "intact", "corrupted")
+
+	f, _, ok, err := c.Get(checksums[1])
+	require.NoError(t, err)
+	require.True(t, ok)
+	_ = f.Close()
+	require.NoError(t, os.WriteFile(f.Name(), []byte("tampered"), 0o600))
+
+	var out bytes.Buffer
+	require.NoError(t, runVerify(c, &out))
+
+	assert.Contains(t, out.String(), "Removed corrupted: "+checksums[1])
+	assert.Contains(t, out.String(), "Removed 1 corrupted download(s)")
+	entries, err := c.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, checksums[0], entries[0].Checksum)
+}
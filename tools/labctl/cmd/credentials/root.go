@@ -0,0 +1,77 @@
+// Package credentials provides CLI commands for managing e2 credentials.
+package credentials
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+)
+
+// Cmd is the credentials subcommand.
+var Cmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Manage e2 credentials",
+	Long:  "Commands for inspecting, editing, rotating, and verifying e2 credentials, without printing secrets.",
+}
+
+var whichCmd = &cobra.Command{
+	Use:   "which",
+	Short: "Show which provider supplies the credentials",
+	Long: `Resolve credentials the same way the images commands do and show which
+provider supplied them, with the endpoint, bucket and a masked access key.
+The secret key is never printed.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runWhich(labcredentials.NewChain(resolveOptions()), os.Stdout)
+	},
+}
+
+var (
+	credentialsFile string
+	sopsAgeKeyFile  string
+	profile         string
+)
+
+func init() {
+	Cmd.PersistentFlags().StringVar(&credentialsFile, "credentials", "", "Path to SOPS-encrypted credentials file")
+	Cmd.PersistentFlags().StringVar(&sopsAgeKeyFile, "sops-age-key-file", "", "Path to age private key")
+	Cmd.PersistentFlags().StringVar(&profile, "profile", "", "Named credentials profile from the labctl config file (default: $LABCTL_PROFILE)")
+	Cmd.MarkFlagsMutuallyExclusive("credentials", "profile")
+
+	Cmd.AddCommand(whichCmd)
+}
+
+func resolveOptions() labcredentials.ResolveOptions {
+	return labcredentials.ResolveOptions{
+		SOPSFile:   credentialsFile,
+		AgeKeyFile: sopsAgeKeyFile,
+		Profile:    profile,
+	}
+}
+
+// runWhich resolves chain and describes the credentials found.
+func runWhich(chain labcredentials.Chain, out io.Writer) error {
+	creds, provider, err := chain.Resolve()
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Provider:   %s\n", provider.Name())
+	_, _ = fmt.Fprintf(out, "Endpoint:   %s\n", creds.Endpoint)
+	_, _ = fmt.Fprintf(out, "Bucket:     %s\n", creds.Bucket)
+	_, _ = fmt.Fprintf(out, "Access key: %s\n", mask(creds.AccessKey))
+	_, _ = fmt.Fprintln(out, "Secret key: set")
+	return nil
+}
+
+// mask hides all but the last four characters of s.
+func mask(s string) string {
+	const visible = 4
+	if len(s) <= visible*2 {
+		return "****"
+	}
+	return "****" + s[len(s)-visible:]
+}
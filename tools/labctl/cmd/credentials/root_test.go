@@ -0,0 +1,47 @@
+package credentials
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+)
+
+func TestRunWhich(t *testing.T) {
+	t.Run("shows provider without secrets", func(t *testing.T) {
+		t.Setenv(labcredentials.EnvAccessKey, "AKIAEXAMPLE1234")
+		t.Setenv(labcredentials.EnvSecretKey, "supersecretvalue")
+		t.Setenv(labcredentials.EnvEndpoint, "https://e2.example.com")
+		t.Setenv(labcredentials.EnvBucket, "my-bucket")
+
+		var out bytes.Buffer
+		require.NoError(t, runWhich(labcredentials.Chain{&labcredentials.EnvProvider{}}, &out))
+
+		assert.Contains(t, out.String(), "Provider:   environment variables")
+		assert.Contains(t, out.String(), "Endpoint:   https://e2.example.com")
+		assert.Contains(t, out.String(), "Bucket:     my-bucket")
+		assert.Contains(t, out.String(), "Access key: ****1234")
+		assert.NotContains(t, out.String(), "AKIAEXAMPLE")
+		assert.NotContains(t, out.String(), "supersecretvalue")
+	})
+
+	t.Run("reports why each provider was skipped", func(t *testing.T) {
+		t.Setenv(labcredentials.EnvAccessKey, "")
+
+		var out bytes.Buffer
+		err := runWhich(labcredentials.Chain{&labcredentials.EnvProvider{}, &labcredentials.SOPSProvider{}}, &out)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "environment variables: not configured")
+		assert.Contains(t, err.Error(), "SOPS file: not configured")
+		assert.Empty(t, out.String())
+	})
+}
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "****5678", mask("ABCDEFGH12345678"))
+	assert.Equal(t, "****", mask("short"))
+	assert.Equal(t, "****", mask(""))
+}
@@ -0,0 +1,82 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the key pair in the SOPS-encrypted credentials file",
+	Long: `Replace the access and secret key in the SOPS-encrypted credentials file
+given with --credentials, keeping its endpoint and bucket. The new secret key
+is read from standard input, with a prompt when that is a terminal.
+
+The new keys are checked against the endpoint before the file is written, so
+a mistyped key never replaces a working one. Revoke the old key in the e2
+console once nothing uses it.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		secret, err := readSecret(os.Stdin, os.Stderr)
+		if err != nil {
+			return err
+		}
+		return runRotate(context.Background(), credentialsFile, sopsAgeKeyFile, rotateAccessKey, secret, !rotateNoVerify, os.Stdout)
+	},
+}
+
+var (
+	rotateAccessKey string
+	rotateNoVerify  bool
+)
+
+func init() {
+	rotateCmd.Flags().StringVar(&rotateAccessKey, "access-key", "", "New access key ID (required)")
+	rotateCmd.Flags().BoolVar(&rotateNoVerify, "no-verify", false, "Write the new keys without checking them against the endpoint")
+	_ = rotateCmd.MarkFlagRequired("access-key")
+
+	Cmd.AddCommand(rotateCmd)
+}
+
+// runRotate replaces the key pair in file with accessKey and secretKey,
+// checking the new pair against the endpoint first when verify is set.
+func runRotate(ctx context.Context, file, ageKeyFile, accessKey, secretKey string, verify bool, out io.Writer) error {
+	if file == "" {
+		return fmt.Errorf("--credentials is required")
+	}
+
+	current, err := labcredentials.ReadSOPS(file, ageKeyFile)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", file, err)
+	}
+	if accessKey == current.AccessKey {
+		return fmt.Errorf("access key %s is the current one; rotate needs a new key pair", mask(accessKey))
+	}
+
+	rotated := *current
+	rotated.AccessKey = accessKey
+	rotated.SecretKey = secretKey
+	if err := rotated.Validate(); err != nil {
+		return fmt.Errorf("validate credentials: %w", err)
+	}
+
+	if verify {
+		_, _ = fmt.Fprintf(out, "Verifying access key %s against s3://%s at %s\n", mask(accessKey), rotated.Bucket, rotated.Endpoint)
+		if err := checkAccess(ctx, &rotated); err != nil {
+			return fmt.Errorf("new keys failed verification, %s left unchanged: %w", file, err)
+		}
+	}
+
+	if err := labcredentials.WriteSOPS(file, &rotated); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Rotated keys in %s: access key %s replaces %s\n", file, mask(accessKey), mask(current.AccessKey))
+	_, _ = fmt.Fprintf(out, "Revoke the old access key %s once nothing uses it.\n", mask(current.AccessKey))
+	return nil
+}
@@ -0,0 +1,78 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store/s3test"
+)
+
+func TestRunRotate(t *testing.T) {
+	server := s3test.NewServer("newaccess5678", "newsecret", "lab-images")
+	defer server.Close()
+
+	// setup writes a credentials file holding the old key pair.
+	setup := func(t *testing.T) (file, keyFile string) {
+		t.Helper()
+		file, keyFile = sopsFixture(t)
+		require.NoError(t, runSet(file, keyFile, labcredentials.E2Credentials{
+			AccessKey: "oldaccess1234",
+			SecretKey: "oldsecret",
+			Endpoint:  server.URL(),
+			Bucket:    "lab-images",
+		}, &bytes.Buffer{}))
+		return file, keyFile
+	}
+
+	t.Run("verifies and writes the new keys", func(t *testing.T) {
+		file, keyFile := setup(t)
+
+		var out bytes.Buffer
+		require.NoError(t, runRotate(context.Background(), file, keyFile, "newaccess5678", "newsecret", true, &out))
+
+		got := readFixture(t, file, keyFile)
+		assert.Equal(t, "newaccess5678", got.AccessKey)
+		assert.Equal(t, "newsecret", got.SecretKey)
+		assert.Equal(t, server.URL(), got.Endpoint)
+		assert.Equal(t, "lab-images", got.Bucket)
+		assert.Contains(t, out.String(), "access key ****5678 replaces ****1234")
+		assert.Contains(t, out.String(), "Revoke the old access key ****1234")
+	})
+
+	t.Run("keys that fail verification leave the file unchanged", func(t *testing.T) {
+		file, keyFile := setup(t)
+
+		err := runRotate(context.Background(), file, keyFile, "newaccess5678", "mistyped", true, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "new keys failed verification, "+file+" left unchanged")
+		assert.Equal(t, "oldaccess1234", readFixture(t, file, keyFile).AccessKey)
+	})
+
+	t.Run("no-verify skips the check", func(t *testing.T) {
+		file, keyFile := setup(t)
+
+		require.NoError(t, runRotate(context.Background(), file, keyFile, "unknownaccess", "unknownsecret", false, &bytes.Buffer{}))
+		assert.Equal(t, "unknownaccess", readFixture(t, file, keyFile).AccessKey)
+	})
+
+	t.Run("same access key", func(t *testing.T) {
+		file, keyFile := setup(t)
+
+		err := runRotate(context.Background(), file, keyFile, "oldaccess1234", "newsecret", true, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rotate needs a new key pair")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		file, keyFile := sopsFixture(t)
+
+		err := runRotate(context.Background(), file, keyFile, "newaccess5678", "newsecret", true, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SOPS file not found")
+	})
+}
@@ -0,0 +1,136 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+)
+
+var setCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Create or update the SOPS-encrypted credentials file",
+	Long: `Set fields of the SOPS-encrypted credentials file given with --credentials,
+creating it if it does not exist. Fields not given keep their current values.
+The file is encrypted for the keys of the matching creation rule in the
+nearest .sops.yaml, and the result must pass validation before it is written.
+
+The secret key is never taken as a flag: with --secret-key-stdin it is read
+from standard input, with a prompt when that is a terminal.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		changes := labcredentials.E2Credentials{
+			AccessKey: setAccessKey,
+			Endpoint:  setEndpoint,
+			Bucket:    setBucket,
+		}
+		if setSecretKeyStdin {
+			secret, err := readSecret(os.Stdin, os.Stderr)
+			if err != nil {
+				return err
+			}
+			changes.SecretKey = secret
+		}
+		return runSet(credentialsFile, sopsAgeKeyFile, changes, os.Stdout)
+	},
+}
+
+var (
+	setAccessKey      string
+	setEndpoint       string
+	setBucket         string
+	setSecretKeyStdin bool
+)
+
+func init() {
+	setCmd.Flags().StringVar(&setAccessKey, "access-key", "", "Access key ID")
+	setCmd.Flags().StringVar(&setEndpoint, "endpoint", "", "e2 endpoint URL")
+	setCmd.Flags().StringVar(&setBucket, "bucket", "", "e2 bucket name")
+	setCmd.Flags().BoolVar(&setSecretKeyStdin, "secret-key-stdin", false, "Read the secret key from standard input")
+
+	Cmd.AddCommand(setCmd)
+}
+
+// runSet applies the non-empty fields of changes to the credentials in file
+// and writes it back encrypted.
+func runSet(file, ageKeyFile string, changes labcredentials.E2Credentials, out io.Writer) error {
+	if file == "" {
+		return fmt.Errorf("--credentials is required")
+	}
+	if changes == (labcredentials.E2Credentials{}) {
+		return fmt.Errorf("nothing to set: give --access-key, --secret-key-stdin, --endpoint or --bucket")
+	}
+
+	creds, err := readExisting(file, ageKeyFile)
+	if err != nil {
+		return err
+	}
+
+	if changes.AccessKey != "" {
+		creds.AccessKey = changes.AccessKey
+	}
+	if changes.SecretKey != "" {
+		creds.SecretKey = changes.SecretKey
+	}
+	if changes.Endpoint != "" {
+		creds.Endpoint = changes.Endpoint
+	}
+	if changes.Bucket != "" {
+		creds.Bucket = changes.Bucket
+	}
+
+	if err := labcredentials.WriteSOPS(file, creds); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Wrote %s (access key %s, bucket %s at %s)\n", file, mask(creds.AccessKey), creds.Bucket, creds.Endpoint)
+	return nil
+}
+
+// readExisting decrypts the credentials in file, or returns empty
+// credentials when it does not exist yet.
+func readExisting(file, ageKeyFile string) (*labcredentials.E2Credentials, error) {
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+		return &labcredentials.E2Credentials{}, nil
+	}
+
+	creds, err := labcredentials.ReadSOPS(file, ageKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", file, err)
+	}
+	return creds, nil
+}
+
+// readSecret reads a secret key from in. When in is a terminal it prompts
+// on prompt and does not echo the input; otherwise it reads the first line.
+func readSecret(in io.Reader, prompt io.Writer) (string, error) {
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) { //nolint:gosec // G115: file descriptors fit in int
+		_, _ = fmt.Fprint(prompt, "Secret key: ")
+		secret, err := term.ReadPassword(int(f.Fd())) //nolint:gosec // G115: file descriptors fit in int
+		_, _ = fmt.Fprintln(prompt)
+		if err != nil {
+			return "", fmt.Errorf("read secret key: %w", err)
+		}
+		return checkSecret(string(secret))
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read secret key: %w", err)
+	}
+	return checkSecret(strings.TrimRight(line, "\r\n"))
+}
+
+func checkSecret(secret string) (string, error) {
+	if secret == "" {
+		return "", fmt.Errorf("no secret key on standard input")
+	}
+	return secret, nil
+}
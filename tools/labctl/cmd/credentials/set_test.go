@@ -0,0 +1,84 @@
+package credentials
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+)
+
+func TestRunSet(t *testing.T) {
+	full := labcredentials.E2Credentials{
+		AccessKey: "access123",
+		SecretKey: "secret456",
+		Endpoint:  "https://e2.example.com",
+		Bucket:    "lab-images",
+	}
+
+	t.Run("creates the file", func(t *testing.T) {
+		file, keyFile := sopsFixture(t)
+
+		var out bytes.Buffer
+		require.NoError(t, runSet(file, keyFile, full, &out))
+
+		assert.Equal(t, &full, readFixture(t, file, keyFile))
+		assert.Contains(t, out.String(), "Wrote "+file+" (access key ****", "access key is masked")
+		assert.NotContains(t, out.String(), "secret456")
+	})
+
+	t.Run("updates only the fields given", func(t *testing.T) {
+		file, keyFile := sopsFixture(t)
+		require.NoError(t, runSet(file, keyFile, full, &bytes.Buffer{}))
+
+		require.NoError(t, runSet(file, keyFile, labcredentials.E2Credentials{Bucket: "lab-backups"}, &bytes.Buffer{}))
+
+		got := readFixture(t, file, keyFile)
+		assert.Equal(t, "lab-backups", got.Bucket)
+		assert.Equal(t, "access123", got.AccessKey)
+		assert.Equal(t, "secret456", got.SecretKey)
+	})
+
+	t.Run("rejects invalid credentials", func(t *testing.T) {
+		file, keyFile := sopsFixture(t)
+
+		err := runSet(file, keyFile, labcredentials.E2Credentials{AccessKey: "access123"}, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "secret_key is required")
+		assert.NoFileExists(t, file)
+	})
+
+	t.Run("requires a file and a change", func(t *testing.T) {
+		err := runSet("", "", full, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--credentials is required")
+
+		file, keyFile := sopsFixture(t)
+		err = runSet(file, keyFile, labcredentials.E2Credentials{}, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "nothing to set")
+	})
+}
+
+func TestReadSecret(t *testing.T) {
+	t.Run("reads the first line", func(t *testing.T) {
+		secret, err := readSecret(strings.NewReader("secret456\r\nignored\n"), &bytes.Buffer{})
+		require.NoError(t, err)
+		assert.Equal(t, "secret456", secret)
+	})
+
+	t.Run("reads without a trailing newline", func(t *testing.T) {
+		secret, err := readSecret(strings.NewReader("secret456"), &bytes.Buffer{})
+		require.NoError(t, err)
+		assert.Equal(t, "secret456", secret)
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		_, err := readSecret(strings.NewReader(""), &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no secret key on standard input")
+	})
+}
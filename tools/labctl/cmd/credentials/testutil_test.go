@@ -0,0 +1,46 @@
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/require"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+)
+
+// sopsFixture sets up a directory with a .sops.yaml for a new age identity
+// and returns the path of a credentials file under it, not yet created, and
+// the identity's key file.
+func sopsFixture(t *testing.T) (file, keyFile string) {
+	t.Helper()
+
+	for _, key := range []string{"SOPS_AGE_KEY", "SOPS_AGE_KEY_FILE", "SOPS_AGE_KEY_CMD"} {
+		t.Setenv(key, "")
+		_ = os.Unsetenv(key)
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	rules := "creation_rules:\n  - path_regex: .*\\.sops\\.yaml$\n    age: " + identity.Recipient().String() + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".sops.yaml"), []byte(rules), 0o600))
+	keyFile = filepath.Join(dir, "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte(identity.String()+"\n"), 0o600))
+
+	return filepath.Join(dir, "e2.sops.yaml"), keyFile
+}
+
+// readFixture decrypts a credentials file written by the commands.
+func readFixture(t *testing.T, file, keyFile string) *labcredentials.E2Credentials {
+	t.Helper()
+
+	creds, err := labcredentials.FromSOPS(file, keyFile)
+	require.NoError(t, err)
+	return creds
+}
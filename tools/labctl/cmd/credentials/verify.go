@@ -0,0 +1,61 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store"
+)
+
+// verifyTimeout bounds the requests made to prove a key pair works.
+const verifyTimeout = 30 * time.Second
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that the credentials can access the bucket",
+	Long: `Resolve credentials as which does, then prove they work with a HeadBucket
+and a one-object ListObjects request against the endpoint.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runVerify(context.Background(), labcredentials.NewChain(resolveOptions()), os.Stdout)
+	},
+}
+
+func init() {
+	Cmd.AddCommand(verifyCmd)
+}
+
+// runVerify resolves chain and checks the credentials found against the
+// endpoint.
+func runVerify(ctx context.Context, chain labcredentials.Chain, out io.Writer) error {
+	creds, provider, err := chain.Resolve()
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Verifying access key %s from %s against s3://%s at %s\n",
+		mask(creds.AccessKey), provider.Name(), creds.Bucket, creds.Endpoint)
+	if err := checkAccess(ctx, creds); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(out, "OK: HeadBucket and ListObjects succeeded")
+	return nil
+}
+
+// checkAccess checks that creds can read their bucket.
+func checkAccess(ctx context.Context, creds *labcredentials.E2Credentials) error {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	client, err := store.NewS3Client(creds, store.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("create S3 client: %w", err)
+	}
+	return client.CheckAccess(ctx)
+}
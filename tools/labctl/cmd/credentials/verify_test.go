@@ -0,0 +1,48 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	labcredentials "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store/s3test"
+)
+
+func TestRunVerify(t *testing.T) {
+	server := s3test.NewServer("access123", "secret456", "lab-images")
+	defer server.Close()
+
+	setEnv := func(t *testing.T, secretKey string) {
+		t.Helper()
+		t.Setenv(labcredentials.EnvAccessKey, "access123")
+		t.Setenv(labcredentials.EnvSecretKey, secretKey)
+		t.Setenv(labcredentials.EnvEndpoint, server.URL())
+		t.Setenv(labcredentials.EnvBucket, "lab-images")
+	}
+	chain := labcredentials.Chain{&labcredentials.EnvProvider{}}
+
+	t.Run("working keys", func(t *testing.T) {
+		setEnv(t, "secret456")
+
+		var out bytes.Buffer
+		require.NoError(t, runVerify(context.Background(), chain, &out))
+
+		assert.Contains(t, out.String(), "from environment variables against s3://lab-images at "+server.URL())
+		assert.Contains(t, out.String(), "OK: HeadBucket and ListObjects succeeded")
+		assert.NotContains(t, out.String(), "secret456")
+	})
+
+	t.Run("wrong secret key", func(t *testing.T) {
+		setEnv(t, "wrong")
+
+		var out bytes.Buffer
+		err := runVerify(context.Background(), chain, &out)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "head bucket s3://lab-images")
+		assert.NotContains(t, out.String(), "OK")
+	})
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/GilmanLab/lab/tools/labctl/internal/bytesize"
 	"github.com/GilmanLab/lab/tools/labctl/internal/credentials"
 	"github.com/GilmanLab/lab/tools/labctl/internal/store"
 )
@@ -24,11 +25,14 @@ var listCmd = &cobra.Command{
 var (
 	listCredentials    string
 	listSOPSAgeKeyFile string
+	listProfile        string
 )
 
 func init() {
 	listCmd.Flags().StringVar(&listCredentials, "credentials", "", "Path to SOPS-encrypted credentials file")
 	listCmd.Flags().StringVar(&listSOPSAgeKeyFile, "sops-age-key-file", "", "Path to age private key")
+	listCmd.Flags().StringVar(&listProfile, "profile", "", "Named credentials profile from the labctl config file (default: $LABCTL_PROFILE)")
+	listCmd.MarkFlagsMutuallyExclusive("credentials", "profile")
 }
 
 func runList(_ *cobra.Command, _ []string) error {
@@ -38,6 +42,7 @@ func runList(_ *cobra.Command, _ []string) error {
 	creds, err := credentials.Resolve(credentials.ResolveOptions{
 		SOPSFile:   listCredentials,
 		AgeKeyFile: listSOPSAgeKeyFile,
+		Profile:    listProfile,
 	})
 	if err != nil {
 		return fmt.Errorf("resolve credentials: %w", err)
@@ -90,7 +95,7 @@ func runListWithClient(ctx context.Context, client store.Client, out io.Writer)
 		}
 
 		// Format size
-		sizeStr := formatSize(metadata.Size)
+		sizeStr := bytesize.Format(metadata.Size)
 
 		// Truncate checksum for display
 		checksumStr := metadata.Checksum
@@ -112,22 +117,3 @@ func runListWithClient(ctx context.Context, client store.Client, out io.Writer)
 
 	return w.Flush()
 }
-
-func formatSize(bytes int64) string {
-	const (
-		kb = 1024
-		mb = kb * 1024
-		gb = mb * 1024
-	)
-
-	switch {
-	case bytes >= gb:
-		return fmt.Sprintf("%.2f GB", float64(bytes)/gb)
-	case bytes >= mb:
-		return fmt.Sprintf("%.2f MB", float64(bytes)/mb)
-	case bytes >= kb:
-		return fmt.Sprintf("%.2f KB", float64(bytes)/kb)
-	default:
-		return fmt.Sprintf("%d B", bytes)
-	}
-}
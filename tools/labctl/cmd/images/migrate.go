@@ -0,0 +1,96 @@
+package images
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the image manifest to the newest apiVersion",
+	Long: `Rewrite manifest files at an older apiVersion to the newest one.
+
+Every file the manifest loads, including included fragments, is converted in
+place. Comments and key order are kept. Older versions still load, with a
+deprecation warning, until they are migrated.`,
+	RunE: runMigrate,
+}
+
+var (
+	migrateManifest string
+	migrateDryRun   bool
+)
+
+func init() {
+	migrateCmd.Flags().StringVar(&migrateManifest, "manifest", "./images/images.yaml", "Path to images.yaml or a directory of manifest files")
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Print the migrated files instead of writing them")
+}
+
+func runMigrate(_ *cobra.Command, _ []string) error {
+	return runMigrateTo(migrateManifest, migrateDryRun, os.Stdout)
+}
+
+// runMigrateTo migrates every manifest file for manifestPath. In dry-run mode
+// the migrated files are written to out instead of back to disk. Every file
+// is migrated before any is written, so a file that cannot be migrated, such
+// as one at an unknown apiVersion, leaves them all untouched.
+func runMigrateTo(manifestPath string, dryRun bool, out io.Writer) error {
+	files, err := config.ManifestFiles(manifestPath)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+
+	// migration is a file converted from an older API version
+	type migration struct {
+		file string
+		from string
+		data []byte
+	}
+	var migrations []migration
+	for _, file := range files {
+		data, err := os.ReadFile(file) //nolint:gosec // G304: Path is provided by user or a manifest include
+		if err != nil {
+			return fmt.Errorf("read manifest file: %w", err)
+		}
+
+		migrated, from, err := config.MigrateData(data)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %w", file, err)
+		}
+		if from == config.SupportedAPIVersion {
+			_, _ = fmt.Fprintf(out, "%s: already %s\n", file, from)
+			continue
+		}
+		migrations = append(migrations, migration{file: file, from: from, data: migrated})
+	}
+
+	for _, m := range migrations {
+		if dryRun {
+			_, _ = fmt.Fprintf(out, "# %s: would migrate from %s to %s\n%s", m.file, m.from, config.SupportedAPIVersion, m.data)
+			continue
+		}
+
+		info, err := os.Stat(m.file)
+		if err != nil {
+			return fmt.Errorf("stat manifest file: %w", err)
+		}
+		if err := os.WriteFile(m.file, m.data, info.Mode()); err != nil {
+			return fmt.Errorf("write manifest file: %w", err)
+		}
+		_, _ = fmt.Fprintf(out, "%s: migrated from %s to %s\n", m.file, m.from, config.SupportedAPIVersion)
+	}
+
+	return nil
+}
+
+// printWarnings reports non-fatal problems found while loading the manifest.
+func printWarnings(w io.Writer, manifest *config.ImageManifest) {
+	for _, warning := range manifest.Warnings {
+		_, _ = fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+}
@@ -0,0 +1,100 @@
+package images
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMigrateTo(t *testing.T) {
+	const manifest = `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test
+spec:
+  includes: [team.yaml]
+  images:
+    # Kept comment
+    - name: talos
+      source:
+        url: https://example.com/talos.raw.xz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        decompress: xz
+      destination: talos/talos.raw
+      validation:
+        algorithm: sha256
+        expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+`
+	const fragment = `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: team
+spec:
+  images: []
+`
+
+	setup := func(t *testing.T) (string, string) {
+		dir := t.TempDir()
+		root := filepath.Join(dir, "images.yaml")
+		require.NoError(t, os.WriteFile(root, []byte(manifest), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "team.yaml"), []byte(fragment), 0o600))
+		return dir, root
+	}
+
+	t.Run("rewrites older files in place", func(t *testing.T) {
+		dir, root := setup(t)
+
+		var out bytes.Buffer
+		require.NoError(t, runMigrateTo(root, false, &out))
+		assert.Contains(t, out.String(), root+": migrated from images.lab.gilman.io/v1alpha1 to images.lab.gilman.io/v1beta1")
+		assert.Contains(t, out.String(), "team.yaml: already images.lab.gilman.io/v1beta1")
+
+		data, err := os.ReadFile(root)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "apiVersion: images.lab.gilman.io/v1beta1")
+		assert.Contains(t, string(data), "# Kept comment")
+		assert.NotContains(t, string(data), "algorithm:")
+
+		info, err := os.Stat(root)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		unchanged, err := os.ReadFile(filepath.Join(dir, "team.yaml"))
+		require.NoError(t, err)
+		assert.Equal(t, fragment, string(unchanged))
+	})
+
+	t.Run("dry run leaves files alone", func(t *testing.T) {
+		_, root := setup(t)
+
+		var out bytes.Buffer
+		require.NoError(t, runMigrateTo(root, true, &out))
+		assert.Contains(t, out.String(), "would migrate from images.lab.gilman.io/v1alpha1")
+		assert.Contains(t, out.String(), "apiVersion: images.lab.gilman.io/v1beta1")
+
+		data, err := os.ReadFile(root)
+		require.NoError(t, err)
+		assert.Equal(t, manifest, string(data))
+	})
+
+	t.Run("unknown apiVersion is an error", func(t *testing.T) {
+		root := filepath.Join(t.TempDir(), "images.yaml")
+		unknown := strings.Replace(fragment, "v1beta1", "v2", 1)
+		require.NoError(t, os.WriteFile(root, []byte(unknown), 0o600))
+
+		var out bytes.Buffer
+		err := runMigrateTo(root, false, &out)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unsupported apiVersion "images.lab.gilman.io/v2"`)
+		assert.NotContains(t, out.String(), "migrated from")
+
+		data, err := os.ReadFile(root) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		assert.Equal(t, unknown, string(data))
+	})
+}
@@ -3,6 +3,8 @@ package images
 import (
 	"context"
 	"fmt"
+	"os"
+	"path"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -19,7 +21,11 @@ var pruneCmd = &cobra.Command{
 
 The prune command compares images in e2 storage against the manifest and
 removes any that are no longer referenced. This is a manual-only operation
-and is not run automatically.`,
+and is not run automatically.
+
+With --selector, only storage under the directories of the selected images'
+destinations is considered, so a team can prune its own images without
+touching anyone else's.`,
 	RunE: runPrune,
 }
 
@@ -27,14 +33,19 @@ var (
 	pruneManifest       string
 	pruneCredentials    string
 	pruneSOPSAgeKeyFile string
+	pruneProfile        string
 	pruneDryRun         bool
+	pruneSelector       string
 )
 
 func init() {
-	pruneCmd.Flags().StringVar(&pruneManifest, "manifest", "./images/images.yaml", "Path to images.yaml")
+	pruneCmd.Flags().StringVar(&pruneManifest, "manifest", "./images/images.yaml", "Path to images.yaml or a directory of manifest files")
 	pruneCmd.Flags().StringVar(&pruneCredentials, "credentials", "", "Path to SOPS-encrypted credentials file")
 	pruneCmd.Flags().StringVar(&pruneSOPSAgeKeyFile, "sops-age-key-file", "", "Path to age private key")
+	pruneCmd.Flags().StringVar(&pruneProfile, "profile", "", "Named credentials profile from the labctl config file (default: $LABCTL_PROFILE)")
 	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Show what would be removed")
+	pruneCmd.Flags().StringVar(&pruneSelector, "selector", "", "Only prune around images matching this selector (e.g. group=talos,team=platform)")
+	pruneCmd.MarkFlagsMutuallyExclusive("credentials", "profile")
 }
 
 func runPrune(_ *cobra.Command, _ []string) error {
@@ -45,11 +56,18 @@ func runPrune(_ *cobra.Command, _ []string) error {
 	if err != nil {
 		return fmt.Errorf("load manifest: %w", err)
 	}
+	printWarnings(os.Stdout, manifest)
+
+	sel, err := config.ParseSelector(pruneSelector)
+	if err != nil {
+		return fmt.Errorf("--selector: %w", err)
+	}
 
 	// Resolve credentials
 	creds, err := credentials.Resolve(credentials.ResolveOptions{
 		SOPSFile:   pruneCredentials,
 		AgeKeyFile: pruneSOPSAgeKeyFile,
+		Profile:    pruneProfile,
 	})
 	if err != nil {
 		return fmt.Errorf("resolve credentials: %w", err)
@@ -61,16 +79,23 @@ func runPrune(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("create S3 client: %w", err)
 	}
 
-	return runPruneWithClient(ctx, client, manifest, pruneDryRun)
+	return runPruneWithClient(ctx, client, manifest, sel, pruneDryRun)
 }
 
 // runPruneWithClient performs the prune operation using the provided store client.
 // This function enables dependency injection for testing.
-func runPruneWithClient(ctx context.Context, client store.Client, manifest *config.ImageManifest, dryRun bool) error {
+func runPruneWithClient(ctx context.Context, client store.Client, manifest *config.ImageManifest, sel config.Selector, dryRun bool) error {
 	// Build set of expected destinations from manifest
 	expected := make(map[string]bool)
 	for _, img := range manifest.Spec.Images {
-		expected[img.Destination] = true
+		for _, dest := range img.Destinations() {
+			expected[dest] = true
+		}
+	}
+
+	scope, err := pruneScope(manifest, sel)
+	if err != nil {
+		return err
 	}
 
 	// List all images in storage
@@ -90,7 +115,7 @@ func runPruneWithClient(ctx context.Context, client store.Client, manifest *conf
 		// Convert key to destination path
 		destPath := strings.TrimPrefix(key, "images/")
 
-		if !expected[destPath] {
+		if !expected[destPath] && scope(destPath) {
 			orphaned = append(orphaned, destPath)
 		}
 	}
@@ -129,3 +154,42 @@ func runPruneWithClient(ctx context.Context, client store.Client, manifest *conf
 
 	return nil
 }
+
+// pruneScope returns a function reporting whether a destination may be
+// pruned. Without a selector everything is in scope; with one, only paths
+// under the directories of the selected images' destinations are.
+func pruneScope(manifest *config.ImageManifest, sel config.Selector) (func(string) bool, error) {
+	if sel.Empty() {
+		return func(string) bool { return true }, nil
+	}
+
+	selected := manifest.Select(sel)
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("selector matches no images")
+	}
+
+	var dirs []string
+	exact := make(map[string]bool)
+	for _, img := range selected {
+		for _, dest := range img.Destinations() {
+			if dir := path.Dir(dest); dir != "." {
+				dirs = append(dirs, dir+"/")
+			} else {
+				// Top-level destinations have no directory of their own
+				exact[dest] = true
+			}
+		}
+	}
+
+	return func(dest string) bool {
+		if exact[dest] {
+			return true
+		}
+		for _, dir := range dirs {
+			if strings.HasPrefix(dest, dir) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -90,7 +90,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, true)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, true)
 
 		require.NoError(t, err)
 		assert.Empty(t, client.deletedKeys)
@@ -112,7 +112,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, false)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, false)
 
 		require.NoError(t, err)
 		// Should delete the orphaned image and its metadata
@@ -135,7 +135,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, true)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, true)
 
 		require.NoError(t, err)
 		// Dry run should not delete anything
@@ -151,7 +151,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, false)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, false)
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "list images")
@@ -173,7 +173,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, false)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, false)
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "delete image")
@@ -192,7 +192,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, false)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, false)
 
 		require.NoError(t, err)
 		// Should not attempt to delete directories
@@ -218,7 +218,7 @@ func TestRunPruneWithClient(t *testing.T) {
 			},
 		}
 
-		err := runPruneWithClient(context.Background(), client, manifest, false)
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, false)
 
 		require.NoError(t, err)
 		// Should delete both orphaned images
@@ -229,4 +229,84 @@ func TestRunPruneWithClient(t *testing.T) {
 			assert.NotContains(t, key, "keep/keep.iso")
 		}
 	})
+
+	t.Run("keeps extracted member destinations", func(t *testing.T) {
+		manifest := &config.ImageManifest{
+			Spec: config.Spec{
+				Images: []config.Image{
+					{
+						Name: "hook",
+						Source: config.Source{
+							Extract: &config.Extract{
+								Format: "tar.gz",
+								Members: []config.ExtractMember{
+									{Path: "vmlinuz-x86_64", Destination: "hook/vmlinuz-x86_64"},
+									{Path: "initramfs-x86_64", Destination: "hook/initramfs-x86_64"},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		client := &mockStoreClient{
+			listFunc: func(_ context.Context, _ string) ([]string, error) {
+				return []string{
+					"images/hook/vmlinuz-x86_64",
+					"images/hook/initramfs-x86_64",
+					"images/hook/hook.tar.gz",
+				}, nil
+			},
+		}
+
+		err := runPruneWithClient(context.Background(), client, manifest, config.Selector{}, false)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"images/hook/hook.tar.gz", "metadata/hook/hook.tar.gz.json"}, client.deletedKeys)
+	})
+
+	t.Run("selector limits pruning to selected directories", func(t *testing.T) {
+		manifest := &config.ImageManifest{
+			Spec: config.Spec{
+				Images: []config.Image{
+					{Name: "talos", Groups: []string{"talos"}, Destination: "talos/talos-1.9.iso"},
+					{Name: "vyos", Groups: []string{"network"}, Destination: "vyos/vyos.iso"},
+				},
+			},
+		}
+		sel, err := config.ParseSelector("group=talos")
+		require.NoError(t, err)
+
+		client := &mockStoreClient{
+			listFunc: func(_ context.Context, _ string) ([]string, error) {
+				return []string{
+					"images/talos/talos-1.9.iso",
+					"images/talos/talos-1.8.iso",
+					"images/vyos/vyos-old.iso",
+				}, nil
+			},
+		}
+
+		err = runPruneWithClient(context.Background(), client, manifest, sel, false)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"images/talos/talos-1.8.iso", "metadata/talos/talos-1.8.iso.json"}, client.deletedKeys)
+	})
+
+	t.Run("selector matching nothing is an error", func(t *testing.T) {
+		manifest := &config.ImageManifest{
+			Spec: config.Spec{
+				Images: []config.Image{{Name: "vyos", Destination: "vyos/vyos.iso"}},
+			},
+		}
+		sel, err := config.ParseSelector("group=talos")
+		require.NoError(t, err)
+
+		err = runPruneWithClient(context.Background(), &mockStoreClient{}, manifest, sel, false)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "selector matches no images")
+		assert.Empty(t, manifest.Select(sel))
+	})
 }
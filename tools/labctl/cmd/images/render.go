@@ -0,0 +1,66 @@
+package images
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the resolved image manifest",
+	Long: `Print the image manifest as sync sees it, for review.
+
+Included fragments are merged into a single manifest and template variables
+are expanded. Each image carries its merged vars, which updateFile
+replacement values can still refer to as {{ .Vars.name }} at sync time.`,
+	RunE: runRender,
+}
+
+var (
+	renderManifest string
+	renderSelector string
+)
+
+func init() {
+	renderCmd.Flags().StringVar(&renderManifest, "manifest", "./images/images.yaml", "Path to images.yaml or a directory of manifest files")
+	renderCmd.Flags().StringVar(&renderSelector, "selector", "", "Only render images matching this selector (e.g. group=talos)")
+}
+
+func runRender(_ *cobra.Command, _ []string) error {
+	return runRenderTo(renderManifest, renderSelector, os.Stdout)
+}
+
+// runRenderTo writes the resolved manifest to out. The manifest is not
+// validated, so a broken manifest can still be rendered and inspected.
+func runRenderTo(manifestPath, selector string, out io.Writer) error {
+	manifest, err := config.LoadManifestRaw(manifestPath)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	printWarnings(os.Stderr, manifest)
+
+	sel, err := config.ParseSelector(selector)
+	if err != nil {
+		return fmt.Errorf("--selector: %w", err)
+	}
+
+	// Includes are already merged and spec vars folded into each image
+	rendered := *manifest
+	rendered.Spec.Includes = nil
+	rendered.Spec.Vars = nil
+	rendered.Spec.Images = manifest.Select(sel)
+
+	_, _ = fmt.Fprintf(out, "# Rendered from %s\n", manifestPath)
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&rendered); err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,64 @@
+package images
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRenderTo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "images.yaml")
+	content := `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test
+spec:
+  vars:
+    version: "1.9.1"
+  images:
+    - name: talos-{{ .Vars.version }}
+      groups: [talos]
+      source:
+        url: https://factory.talos.dev/v{{ .Vars.version }}/metal-amd64.raw.xz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: talos/talos-{{ .Vars.version }}.raw
+    - name: vyos
+      source:
+        url: https://example.com/vyos.iso
+        checksum: sha256:def4560000000000000000000000000000000000000000000000000000000000
+      destination: vyos/vyos.iso
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	t.Run("renders resolved manifest", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, runRenderTo(path, "", &out))
+
+		rendered := out.String()
+		assert.Contains(t, rendered, "# Rendered from "+path)
+		assert.Contains(t, rendered, "name: talos-1.9.1")
+		assert.Contains(t, rendered, "url: https://factory.talos.dev/v1.9.1/metal-amd64.raw.xz")
+		assert.Contains(t, rendered, "destination: talos/talos-1.9.1.raw")
+		assert.Contains(t, rendered, "name: vyos")
+		assert.NotContains(t, rendered, "{{")
+	})
+
+	t.Run("selector filters images", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, runRenderTo(path, "group=talos", &out))
+
+		assert.Contains(t, out.String(), "talos-1.9.1")
+		assert.NotContains(t, out.String(), "vyos")
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		err := runRenderTo(path, "=", &bytes.Buffer{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--selector")
+	})
+}
@@ -9,7 +9,7 @@ import (
 var Cmd = &cobra.Command{
 	Use:   "images",
 	Short: "Manage lab images",
-	Long:  "Commands for syncing, validating, listing, pruning, and uploading lab images to e2 storage.",
+	Long:  "Commands for syncing, validating, rendering, comparing, listing, pruning, and uploading lab images to e2 storage.",
 }
 
 func init() {
@@ -18,4 +18,8 @@ func init() {
 	Cmd.AddCommand(listCmd)
 	Cmd.AddCommand(pruneCmd)
 	Cmd.AddCommand(uploadCmd)
+	Cmd.AddCommand(renderCmd)
+	Cmd.AddCommand(schemaCmd)
+	Cmd.AddCommand(migrateCmd)
+	Cmd.AddCommand(statusCmd)
 }
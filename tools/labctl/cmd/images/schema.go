@@ -0,0 +1,58 @@
+package images
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema for images.yaml",
+	Long: `Print the JSON Schema for image manifests, generated from the config types.
+
+Point the YAML language server at the schema for completion and inline errors:
+
+  # yaml-language-server: $schema=../tools/labctl/internal/config/images.schema.json
+
+The committed copy is regenerated with go generate ./internal/config.`,
+	RunE: runSchema,
+}
+
+var schemaOutput string
+
+func init() {
+	schemaCmd.Flags().StringVarP(&schemaOutput, "output", "o", "", "Write the schema to this file instead of stdout")
+}
+
+func runSchema(_ *cobra.Command, _ []string) error {
+	if schemaOutput == "" {
+		return writeSchema(os.Stdout)
+	}
+
+	f, err := os.Create(schemaOutput)
+	if err != nil {
+		return fmt.Errorf("create schema file: %w", err)
+	}
+	if err := writeSchema(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSchema writes the generated manifest schema to out.
+func writeSchema(out io.Writer) error {
+	data, err := config.MarshalSchema(config.GenerateSchema())
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("write schema: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package images
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+)
+
+func TestRunSchema(t *testing.T) {
+	t.Run("stdout matches committed schema", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, writeSchema(&out))
+		assert.Equal(t, string(config.SchemaFile), out.String())
+	})
+
+	t.Run("writes output file", func(t *testing.T) {
+		origOutput := schemaOutput
+		defer func() { schemaOutput = origOutput }()
+
+		schemaOutput = filepath.Join(t.TempDir(), "images.schema.json")
+		require.NoError(t, runSchema(nil, nil))
+
+		data, err := os.ReadFile(schemaOutput)
+		require.NoError(t, err)
+		assert.Equal(t, config.SchemaFile, data)
+	})
+}
@@ -0,0 +1,287 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+	"github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/github"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store"
+)
+
+// ErrChangesPending is returned by status when sync would upload images.
+// labctl exits with status 2 for it, so scripts can tell pending changes
+// from failures.
+var ErrChangesPending = errors.New("changes pending")
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Compare the manifest against e2 storage",
+	Long: `Show what sync would do without downloading anything.
+
+The status command reads the stored metadata of every manifest image and
+classifies each destination as in-sync, outdated (stored checksum differs),
+or missing, and lists images in the bucket that no manifest image publishes
+as extra. GitHub release sources are resolved through the API to learn their
+checksum. Images with an oci target also get a row for the registry
+reference, which is outdated when the tag no longer points at the manifest
+recorded by the last push.
+
+The command exits with status 2 when sync would upload anything. Extra
+images do not count, since only prune removes them.`,
+	RunE: runStatus,
+}
+
+var (
+	statusManifest       string
+	statusCredentials    string
+	statusSOPSAgeKeyFile string
+	statusProfile        string
+	statusSelector       string
+)
+
+func init() {
+	statusCmd.Flags().StringVar(&statusManifest, "manifest", "./images/images.yaml", "Path to images.yaml or a directory of manifest files")
+	statusCmd.Flags().StringVar(&statusCredentials, "credentials", "", "Path to SOPS-encrypted credentials file")
+	statusCmd.Flags().StringVar(&statusSOPSAgeKeyFile, "sops-age-key-file", "", "Path to age private key")
+	statusCmd.Flags().StringVar(&statusProfile, "profile", "", "Named credentials profile from the labctl config file (default: $LABCTL_PROFILE)")
+	statusCmd.Flags().StringVar(&statusSelector, "selector", "", "Only show images matching this selector (e.g. group=talos,team=platform)")
+	statusCmd.MarkFlagsMutuallyExclusive("credentials", "profile")
+}
+
+// Image states reported by status.
+const (
+	stateInSync   = "in-sync"
+	stateOutdated = "outdated"
+	stateMissing  = "missing"
+	stateExtra    = "extra"
+)
+
+// imageStatus is the state of one destination in the bucket.
+type imageStatus struct {
+	name        string
+	destination string
+	state       string
+	detail      string
+}
+
+func runStatus(cmd *cobra.Command, _ []string) error {
+	ctx := context.Background()
+
+	// Load manifest
+	manifest, err := config.LoadManifest(statusManifest)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	printWarnings(os.Stdout, manifest)
+
+	sel, err := config.ParseSelector(statusSelector)
+	if err != nil {
+		return fmt.Errorf("--selector: %w", err)
+	}
+
+	// Resolve credentials
+	creds, err := credentials.Resolve(credentials.ResolveOptions{
+		SOPSFile:   statusCredentials,
+		AgeKeyFile: statusSOPSAgeKeyFile,
+		Profile:    statusProfile,
+	})
+	if err != nil {
+		return fmt.Errorf("resolve credentials: %w", err)
+	}
+
+	// Create S3 client
+	client, err := store.NewS3Client(creds, store.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("create S3 client: %w", err)
+	}
+
+	err = runStatusWithClient(ctx, client, http.DefaultClient, manifest, sel, os.Stdout)
+	if errors.Is(err, ErrChangesPending) && cmd != nil {
+		cmd.SilenceUsage = true
+	}
+	return err
+}
+
+// runStatusWithClient prints the state of the selected images using the
+// provided store and HTTP clients, and returns ErrChangesPending if sync
+// would upload anything. This function enables dependency injection for testing.
+func runStatusWithClient(ctx context.Context, client store.Client, httpClient HTTPClient, manifest *config.ImageManifest, sel config.Selector, out io.Writer) error {
+	images := manifest.Select(sel)
+	scope, err := pruneScope(manifest, sel)
+	if err != nil {
+		return err
+	}
+
+	var statuses []imageStatus
+	expected := make(map[string]bool)
+	for _, img := range manifest.Spec.Images {
+		for _, dest := range img.Destinations() {
+			expected[dest] = true
+		}
+	}
+
+	for _, img := range images {
+		if img.Source.GitHub != nil {
+			src, _, err := resolveGitHubSource(ctx, httpClient, github.DefaultBaseURL, img.Source)
+			if err != nil {
+				return fmt.Errorf("image %q: %w", img.Name, err)
+			}
+			img.Source = src
+		}
+
+		for _, a := range artifactChecksums(img) {
+			s, err := destinationStatus(ctx, client, img.Name, a.destination, a.checksum, img.Transform)
+			if err != nil {
+				return fmt.Errorf("image %q: %w", img.Name, err)
+			}
+			statuses = append(statuses, s)
+		}
+
+		if img.OCI != nil {
+			s, err := ociStatus(ctx, client, httpClient, img)
+			if err != nil {
+				return fmt.Errorf("image %q: %w", img.Name, err)
+			}
+			statuses = append(statuses, s)
+		}
+	}
+
+	keys, err := client.List(ctx, "images/")
+	if err != nil {
+		return fmt.Errorf("list images: %w", err)
+	}
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		dest := strings.TrimPrefix(key, "images/")
+		if !expected[dest] && scope(dest) {
+			statuses = append(statuses, imageStatus{name: "-", destination: dest, state: stateExtra})
+		}
+	}
+
+	counts := make(map[string]int)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tDESTINATION\tSTATUS\tDETAIL")
+	for _, s := range statuses {
+		counts[s.state]++
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.name, s.destination, s.state, s.detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	pending := counts[stateMissing] + counts[stateOutdated]
+	_, _ = fmt.Fprintf(out, "\nPlan: %d to upload (%d missing, %d outdated), %d in sync, %d extra\n",
+		pending, counts[stateMissing], counts[stateOutdated], counts[stateInSync], counts[stateExtra])
+	if counts[stateExtra] > 0 {
+		_, _ = fmt.Fprintln(out, "Extra images are only removed by labctl images prune")
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%w: %d image(s) to upload", ErrChangesPending, pending)
+	}
+	return nil
+}
+
+// destinationStatus compares the stored metadata of a destination with the
+// checksum and transform the manifest expects there.
+func destinationStatus(ctx context.Context, client store.Client, name, dest, checksum string, transform *config.Transform) (imageStatus, error) {
+	s := imageStatus{name: name, destination: dest}
+
+	exists, err := client.Exists(ctx, store.MetadataKey(dest))
+	if err != nil {
+		return s, fmt.Errorf("check %s: %w", dest, err)
+	}
+	if !exists {
+		s.state = stateMissing
+		return s, nil
+	}
+
+	metadata, err := client.GetMetadata(ctx, dest)
+	if err != nil {
+		return s, fmt.Errorf("read metadata for %s: %w", dest, err)
+	}
+	if metadata.Checksum != checksum {
+		s.state = stateOutdated
+		s.detail = fmt.Sprintf("%s -> %s", metadata.Checksum, checksum)
+		return s, nil
+	}
+	if !transformMatches(metadata.Transform, transform) {
+		var stored store.TransformMetadata
+		if metadata.Transform != nil {
+			stored = *metadata.Transform
+		}
+		var want config.Transform
+		if transform != nil {
+			want = *transform
+		}
+		s.state = stateOutdated
+		s.detail = fmt.Sprintf("transform %s -> %s", describeTransform(stored.Format, stored.Sparse), describeTransform(want.Format, want.Sparse))
+		return s, nil
+	}
+
+	s.state = stateInSync
+	return s, nil
+}
+
+// ociStatus compares the OCI artifact recorded in the metadata of img's first
+// destination with what its registry reference resolves to now, the same
+// check sync makes before skipping an image.
+func ociStatus(ctx context.Context, client store.Client, httpClient HTTPClient, img config.Image) (imageStatus, error) {
+	s := imageStatus{name: img.Name, destination: img.OCI.Reference}
+	dest := img.Destinations()[0]
+
+	exists, err := client.Exists(ctx, store.MetadataKey(dest))
+	if err != nil {
+		return s, fmt.Errorf("check %s: %w", dest, err)
+	}
+	if !exists {
+		s.state = stateMissing
+		return s, nil
+	}
+
+	metadata, err := client.GetMetadata(ctx, dest)
+	if err != nil {
+		return s, fmt.Errorf("read metadata for %s: %w", dest, err)
+	}
+	if metadata.OCI == nil {
+		s.state = stateMissing
+		return s, nil
+	}
+	if metadata.OCI.Reference != img.OCI.Reference {
+		s.state = stateOutdated
+		s.detail = fmt.Sprintf("pushed to %s", metadata.OCI.Reference)
+		return s, nil
+	}
+
+	ref, err := oci.ParseReference(img.OCI.Reference)
+	if err != nil {
+		return s, err
+	}
+	digest, err := oci.NewClient(httpClient, oci.CredentialsFromEnv()).ManifestDigest(ctx, ref)
+	if err != nil {
+		return s, err
+	}
+	switch digest {
+	case metadata.OCI.Digest:
+		s.state = stateInSync
+	case "":
+		s.state = stateMissing
+	default:
+		s.state = stateOutdated
+		s.detail = fmt.Sprintf("%s -> %s", metadata.OCI.Digest, digest)
+	}
+	return s, nil
+}
@@ -0,0 +1,181 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci/ocitest"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store"
+)
+
+// statusStore returns a mock store holding metadata with the given checksums
+// by destination.
+func statusStore(stored map[string]string) *mockStoreClient {
+	return &mockStoreClient{
+		existsFunc: func(_ context.Context, key string) (bool, error) {
+			dest := strings.TrimSuffix(strings.TrimPrefix(key, "metadata/"), ".json")
+			_, ok := stored[dest]
+			return ok, nil
+		},
+		getMetadataFunc: func(_ context.Context, dest string) (*store.ImageMetadata, error) {
+			return &store.ImageMetadata{Name: dest, Checksum: stored[dest]}, nil
+		},
+		listFunc: func(context.Context, string) ([]string, error) {
+			var keys []string
+			for dest := range stored {
+				keys = append(keys, store.ImageKey(dest))
+			}
+			return keys, nil
+		},
+	}
+}
+
+func TestRunStatusWithClient(t *testing.T) {
+	current := "sha256:" + strings.Repeat("a", 64)
+	stale := "sha256:" + strings.Repeat("b", 64)
+
+	manifest := &config.ImageManifest{
+		Spec: config.Spec{
+			Images: []config.Image{
+				{Name: "talos", Groups: []string{"talos"}, Destination: "talos/talos.iso", Source: config.Source{URL: "https://example.com/talos.iso", Checksum: current}},
+				{Name: "vyos", Destination: "vyos/vyos.iso", Source: config.Source{URL: "https://example.com/vyos.iso", Checksum: current}},
+				{Name: "ubuntu", Destination: "ubuntu/ubuntu.iso", Source: config.Source{URL: "https://example.com/ubuntu.iso", Checksum: current}},
+			},
+		},
+	}
+
+	t.Run("classifies every destination", func(t *testing.T) {
+		client := statusStore(map[string]string{
+			"talos/talos.iso": current,
+			"vyos/vyos.iso":   stale,
+			"old/old.iso":     current,
+		})
+
+		var out bytes.Buffer
+		err := runStatusWithClient(context.Background(), client, http.DefaultClient, manifest, config.Selector{}, &out)
+		require.ErrorIs(t, err, ErrChangesPending)
+		assert.Contains(t, err.Error(), "2 image(s) to upload")
+
+		output := out.String()
+		assert.Regexp(t, `talos\s+talos/talos.iso\s+in-sync`, output)
+		assert.Regexp(t, `vyos\s+vyos/vyos.iso\s+outdated\s+`+stale+` -> `+current, output)
+		assert.Regexp(t, `ubuntu\s+ubuntu/ubuntu.iso\s+missing`, output)
+		assert.Regexp(t, `-\s+old/old.iso\s+extra`, output)
+		assert.Contains(t, output, "Plan: 2 to upload (1 missing, 1 outdated), 1 in sync, 1 extra")
+	})
+
+	t.Run("in sync", func(t *testing.T) {
+		client := statusStore(map[string]string{
+			"talos/talos.iso":   current,
+			"vyos/vyos.iso":     current,
+			"ubuntu/ubuntu.iso": current,
+			"old/old.iso":       current,
+		})
+
+		var out bytes.Buffer
+		err := runStatusWithClient(context.Background(), client, http.DefaultClient, manifest, config.Selector{}, &out)
+		require.NoError(t, err, "extra images are not pending changes")
+		assert.Contains(t, out.String(), "Plan: 0 to upload (0 missing, 0 outdated), 3 in sync, 1 extra")
+	})
+
+	t.Run("selector limits images and extras", func(t *testing.T) {
+		client := statusStore(map[string]string{
+			"talos/talos.iso": current,
+			"talos/old.iso":   current,
+			"old/old.iso":     current,
+		})
+		sel, err := config.ParseSelector("group=talos")
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		err = runStatusWithClient(context.Background(), client, http.DefaultClient, manifest, sel, &out)
+		require.NoError(t, err)
+
+		output := out.String()
+		assert.Contains(t, output, "talos/old.iso")
+		assert.NotContains(t, output, "old/old.iso")
+		assert.NotContains(t, output, "vyos")
+	})
+
+	t.Run("OCI target", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		ref := oci.Reference{Registry: registry.Host(), Repository: "mirror/talos", Tag: "v1"}
+		push := func(data []byte) string {
+			digest, err := oci.NewClient(registry.Client(), oci.Credentials{}).PushArtifact(context.Background(), ref,
+				[]oci.Layer{{File: bytes.NewReader(data), Size: int64(len(data)), Title: "talos.iso"}})
+			require.NoError(t, err)
+			return digest
+		}
+		pushed := push([]byte("talos"))
+
+		ociManifest := &config.ImageManifest{
+			Spec: config.Spec{
+				Images: []config.Image{
+					{
+						Name:        "talos",
+						Destination: "talos/talos.iso",
+						Source:      config.Source{URL: "https://example.com/talos.iso", Checksum: current},
+						OCI:         &config.OCITarget{Reference: registry.Host() + "/mirror/talos:v1"},
+					},
+				},
+			},
+		}
+		statusWith := func(recorded *store.OCIMetadata) (string, error) {
+			client := statusStore(map[string]string{"talos/talos.iso": current})
+			client.getMetadataFunc = func(_ context.Context, dest string) (*store.ImageMetadata, error) {
+				return &store.ImageMetadata{Name: dest, Checksum: current, OCI: recorded}, nil
+			}
+			var out bytes.Buffer
+			err := runStatusWithClient(context.Background(), client, registry.Client(), ociManifest, config.Selector{}, &out)
+			return out.String(), err
+		}
+
+		output, err := statusWith(&store.OCIMetadata{Reference: ociManifest.Spec.Images[0].OCI.Reference, Digest: pushed})
+		require.NoError(t, err)
+		assert.Regexp(t, `talos\s+`+regexp.QuoteMeta(registry.Host())+`/mirror/talos:v1\s+in-sync`, output)
+		assert.Contains(t, output, "2 in sync")
+
+		output, err = statusWith(nil)
+		require.ErrorIs(t, err, ErrChangesPending)
+		assert.Regexp(t, `/mirror/talos:v1\s+missing`, output)
+
+		moved := push([]byte("other"))
+		output, err = statusWith(&store.OCIMetadata{Reference: ociManifest.Spec.Images[0].OCI.Reference, Digest: pushed})
+		require.ErrorIs(t, err, ErrChangesPending)
+		assert.Regexp(t, `/mirror/talos:v1\s+outdated\s+`+pushed+` -> `+moved, output)
+		assert.Contains(t, output, "Plan: 1 to upload (0 missing, 1 outdated), 1 in sync")
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		client := &mockStoreClient{
+			existsFunc: func(context.Context, string) (bool, error) { return false, errors.New("connection refused") },
+		}
+
+		err := runStatusWithClient(context.Background(), client, http.DefaultClient, manifest, config.Selector{}, &bytes.Buffer{})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrChangesPending)
+		assert.Contains(t, err.Error(), "connection refused")
+	})
+}
+
+func TestRunStatus(t *testing.T) {
+	origManifest := statusManifest
+	defer func() { statusManifest = origManifest }()
+
+	statusManifest = "/nonexistent/path/images.yaml"
+	err := runStatus(nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "load manifest")
+}
@@ -1,25 +1,34 @@
 package images
 
 import (
-	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"net/http"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/klauspost/compress/zstd"
 	"github.com/spf13/cobra"
-	"github.com/ulikunitz/xz"
 
+	"github.com/GilmanLab/lab/tools/labctl/internal/archive"
+	"github.com/GilmanLab/lab/tools/labctl/internal/bytesize"
+	"github.com/GilmanLab/lab/tools/labctl/internal/cache"
 	"github.com/GilmanLab/lab/tools/labctl/internal/config"
 	"github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/diskimage"
+	"github.com/GilmanLab/lab/tools/labctl/internal/github"
+	"github.com/GilmanLab/lab/tools/labctl/internal/lock"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci"
+	"github.com/GilmanLab/lab/tools/labctl/internal/ratelimit"
 	"github.com/GilmanLab/lab/tools/labctl/internal/store"
 	"github.com/GilmanLab/lab/tools/labctl/internal/updater"
 )
@@ -30,6 +39,58 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// syncOptions holds the settings and dependencies of one sync run. runSync
+// builds it from flags and the manifest and passes it to each image, as other
+// commands pass their clients; tests build it with newSyncOptions.
+type syncOptions struct {
+	// httpClient downloads sources and talks to GitHub and OCI registries
+	httpClient HTTPClient
+
+	// githubBaseURL is the GitHub API endpoint used to resolve release assets
+	githubBaseURL string
+
+	// clock returns the current time for transfer window checks and the lockfile
+	clock func() time.Time
+
+	dryRun bool
+	force  bool
+
+	// plan makes dry runs consult the store and diff file updates
+	plan bool
+
+	// Transfer limits; a nil limiter is unlimited and a nil window allows
+	// transfers at any time
+	downloadLimiter *ratelimit.Limiter
+	uploadLimiter   *ratelimit.Limiter
+	transferWindow  *config.TransferWindow
+
+	// cache holds verified source downloads keyed by checksum; nil disables caching
+	cache *cache.Cache
+
+	// lock records synced images unless it is nil; a frozen sync only syncs
+	// the sources pinned in it
+	lock   *lock.Lockfile
+	frozen bool
+
+	// publish says where synced images are published, for the download URL
+	// and NAS path given to updateFile templates
+	publish *config.Publish
+
+	// updateRoot confines updateFile reads and writes, since manifests come
+	// from pull requests; empty allows any path
+	updateRoot string
+}
+
+// newSyncOptions returns options for a sync that uses httpClient, the
+// public GitHub API and the wall clock, with everything else disabled.
+func newSyncOptions(httpClient HTTPClient) *syncOptions {
+	return &syncOptions{
+		httpClient:    httpClient,
+		githubBaseURL: github.DefaultBaseURL,
+		clock:         time.Now,
+	}
+}
+
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Sync images to e2 storage",
@@ -42,19 +103,43 @@ downstream builds.`,
 }
 
 var (
-	syncManifest       string
-	syncCredentials    string
-	syncSOPSAgeKeyFile string
-	syncDryRun         bool
-	syncForce          bool
+	syncManifest        string
+	syncCredentials     string
+	syncSOPSAgeKeyFile  string
+	syncProfile         string
+	syncDryRun          bool
+	syncPlan            bool
+	syncForce           bool
+	syncMaxDownloadRate string
+	syncMaxUploadRate   string
+	syncIgnoreWindow    bool
+	syncCacheDir        string
+	syncCacheMaxSize    string
+	syncNoCache         bool
+	syncSelector        string
+	syncLock            string
+	syncFrozen          bool
 )
 
 func init() {
-	syncCmd.Flags().StringVar(&syncManifest, "manifest", "./images/images.yaml", "Path to images.yaml")
+	syncCmd.Flags().StringVar(&syncManifest, "manifest", "./images/images.yaml", "Path to images.yaml or a directory of manifest files")
 	syncCmd.Flags().StringVar(&syncCredentials, "credentials", "", "Path to SOPS-encrypted credentials file")
 	syncCmd.Flags().StringVar(&syncSOPSAgeKeyFile, "sops-age-key-file", "", "Path to age private key for SOPS decryption")
+	syncCmd.Flags().StringVar(&syncProfile, "profile", "", "Named credentials profile from the labctl config file (default: $LABCTL_PROFILE)")
 	syncCmd.Flags().BoolVar(&syncDryRun, "dry-run", false, "Show what would be done without executing")
+	syncCmd.Flags().BoolVar(&syncPlan, "plan", false, "Like --dry-run, but check e2 for existing images and show file diffs")
 	syncCmd.Flags().BoolVar(&syncForce, "force", false, "Force re-upload even if checksums match")
+	syncCmd.Flags().StringVar(&syncMaxDownloadRate, "max-download-rate", "", "Limit download bandwidth (e.g. 10MB/s, 512KiB/s)")
+	syncCmd.Flags().StringVar(&syncMaxUploadRate, "max-upload-rate", "", "Limit upload bandwidth (e.g. 10MB/s, 512KiB/s)")
+	syncCmd.Flags().BoolVar(&syncIgnoreWindow, "ignore-transfer-window", false, "Transfer even outside the manifest transfer window")
+	syncCmd.Flags().StringVar(&syncCacheDir, "cache-dir", "", "Download cache directory (default: $XDG_CACHE_HOME/labctl/downloads)")
+	syncCmd.Flags().StringVar(&syncCacheMaxSize, "cache-max-size", "20GiB", "Evict least recently used downloads above this size (0 for no limit)")
+	syncCmd.Flags().BoolVar(&syncNoCache, "no-cache", false, "Do not read or fill the download cache")
+	syncCmd.Flags().StringVar(&syncSelector, "selector", "", "Only sync images matching this selector (e.g. group=talos,team=platform)")
+	syncCmd.Flags().StringVar(&syncLock, "lock", "", "Path to the lockfile (default: images.lock.yaml next to the manifest)")
+	syncCmd.Flags().BoolVar(&syncFrozen, "frozen", false, "Only sync sources pinned in the lockfile, and do not update it")
+	syncCmd.MarkFlagsMutuallyExclusive("dry-run", "plan")
+	syncCmd.MarkFlagsMutuallyExclusive("credentials", "profile")
 }
 
 func runSync(_ *cobra.Command, _ []string) error {
@@ -65,17 +150,71 @@ func runSync(_ *cobra.Command, _ []string) error {
 	if err != nil {
 		return fmt.Errorf("load manifest: %w", err)
 	}
+	printWarnings(os.Stdout, manifest)
+
+	sel, err := config.ParseSelector(syncSelector)
+	if err != nil {
+		return fmt.Errorf("--selector: %w", err)
+	}
+	images := manifest.Select(sel)
+
+	opts := newSyncOptions(http.DefaultClient)
+
+	downloadRate, err := ratelimit.ParseRate(syncMaxDownloadRate)
+	if err != nil {
+		return fmt.Errorf("--max-download-rate: %w", err)
+	}
+	uploadRate, err := ratelimit.ParseRate(syncMaxUploadRate)
+	if err != nil {
+		return fmt.Errorf("--max-upload-rate: %w", err)
+	}
+	opts.downloadLimiter = ratelimit.NewLimiter(downloadRate)
+	opts.uploadLimiter = ratelimit.NewLimiter(uploadRate)
+	if !syncIgnoreWindow {
+		opts.transferWindow = manifest.Spec.TransferWindow
+	}
+
+	// A plan is a dry run that still reads the store
+	opts.dryRun = syncDryRun || syncPlan
+	opts.plan = syncPlan
+	opts.force = syncForce
+
+	if !syncNoCache && !opts.dryRun {
+		opts.cache, err = openCache(syncCacheDir, syncCacheMaxSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	lockPath := syncLock
+	if lockPath == "" {
+		lockPath = lock.DefaultPath(syncManifest)
+	}
+	opts.lock, err = lock.Load(lockPath)
+	if err != nil {
+		return fmt.Errorf("load lockfile: %w", err)
+	}
+	opts.frozen = syncFrozen
+	opts.publish = manifest.Spec.Publish
+	if opts.updateRoot, err = repoRoot(); err != nil {
+		return err
+	}
 
 	fmt.Printf("Syncing images from manifest: %s\n", syncManifest)
-	fmt.Printf("Found %d image(s)\n\n", len(manifest.Spec.Images))
+	if sel.Empty() {
+		fmt.Printf("Found %d image(s)\n\n", len(manifest.Spec.Images))
+	} else {
+		fmt.Printf("Selected %d of %d image(s)\n\n", len(images), len(manifest.Spec.Images))
+	}
 
 	// Skip credentials and S3 client setup in dry-run mode
-	var client *store.S3Client
+	var client store.Client
 	if !syncDryRun {
 		// Resolve credentials
 		creds, err := credentials.Resolve(credentials.ResolveOptions{
 			SOPSFile:   syncCredentials,
 			AgeKeyFile: syncSOPSAgeKeyFile,
+			Profile:    syncProfile,
 		})
 		if err != nil {
 			return fmt.Errorf("resolve credentials: %w", err)
@@ -92,8 +231,8 @@ func runSync(_ *cobra.Command, _ []string) error {
 	filesChanged := false
 
 	// Process each image
-	for _, img := range manifest.Spec.Images {
-		changed, err := syncImageWithHTTP(ctx, client, http.DefaultClient, img, syncDryRun, syncForce)
+	for _, img := range images {
+		changed, err := syncImage(ctx, client, img, opts)
 		if err != nil {
 			return fmt.Errorf("sync image %q: %w", img.Name, err)
 		}
@@ -102,6 +241,25 @@ func runSync(_ *cobra.Command, _ []string) error {
 		}
 	}
 
+	// Record resolved sources; stale entries are only dropped on full syncs
+	if !opts.dryRun && !opts.frozen {
+		if sel.Empty() {
+			names := make([]string, len(images))
+			for i, img := range images {
+				names[i] = img.Name
+			}
+			opts.lock.Retain(names)
+		}
+		changed, err := opts.lock.Save(lockPath)
+		if err != nil {
+			return fmt.Errorf("save lockfile: %w", err)
+		}
+		if changed {
+			fmt.Printf("Lockfile updated: %s\n", lockPath)
+			filesChanged = true
+		}
+	}
+
 	// Write GitHub Actions output
 	if err := writeGitHubOutput("files_changed", fmt.Sprintf("%t", filesChanged)); err != nil {
 		// Log but don't fail - not running in GitHub Actions
@@ -116,160 +274,955 @@ func runSync(_ *cobra.Command, _ []string) error {
 	return nil
 }
 
-// syncImage syncs an image using the default HTTP client.
-// This is a convenience wrapper for syncImageWithHTTP.
-func syncImage(ctx context.Context, client store.Client, img config.Image, dryRun, force bool) (bool, error) {
-	return syncImageWithHTTP(ctx, client, http.DefaultClient, img, dryRun, force)
-}
-
-// syncImageWithHTTP syncs an image using the provided HTTP and store clients.
-// This function enables dependency injection for testing.
-func syncImageWithHTTP(ctx context.Context, client store.Client, httpClient HTTPClient, img config.Image, dryRun, force bool) (bool, error) {
+// syncImage syncs an image using the store client and the settings and
+// HTTP client in opts.
+func syncImage(ctx context.Context, client store.Client, img config.Image, opts *syncOptions) (bool, error) {
 	fmt.Printf("Processing: %s\n", img.Name)
 
-	effectiveChecksum := img.EffectiveChecksum()
+	var err error
+	if opts.frozen {
+		img, err = pinnedSource(img, opts)
+	} else {
+		img, err = resolveSource(ctx, img, opts)
+	}
+	if err != nil {
+		return false, err
+	}
 
 	// Check if image already exists with matching checksum
-	if !dryRun && !force {
-		matches, err := client.ChecksumMatches(ctx, img.Destination, effectiveChecksum)
+	if (!opts.dryRun || opts.plan) && !opts.force {
+		matches, err := checksumsMatch(ctx, client, img)
 		if err != nil {
 			return false, fmt.Errorf("check existing image: %w", err)
 		}
+		if matches && img.OCI != nil {
+			matches, err = ociArtifactMatches(ctx, client, opts.httpClient, img)
+			if err != nil {
+				return false, fmt.Errorf("check existing OCI artifact: %w", err)
+			}
+		}
 		if matches {
 			fmt.Printf("  Skipping: checksum matches existing image\n")
+			recordLock(img, skippedSourceSize(ctx, client, img, opts), opts)
+			return false, nil
+		}
+	}
+
+	// Defer transfers outside the manifest transfer window
+	if opts.transferWindow != nil {
+		inside, err := opts.transferWindow.Contains(opts.clock())
+		if err != nil {
+			return false, fmt.Errorf("check transfer window: %w", err)
+		}
+		if !inside {
+			fmt.Printf("  Deferred: outside transfer window %s\n", opts.transferWindow)
 			return false, nil
 		}
 	}
 
-	if dryRun {
+	if opts.dryRun {
 		fmt.Printf("  Would download: %s\n", img.Source.URL)
-		fmt.Printf("  Would upload to: %s\n", store.ImageKey(img.Destination))
-		if img.UpdateFile != nil {
-			fmt.Printf("  Would update file: %s\n", img.UpdateFile.Path)
+		if img.Source.Extract != nil {
+			for _, m := range img.Source.Extract.Members {
+				fmt.Printf("  Would extract: %s\n", m.Path)
+			}
+		}
+		for _, dest := range img.Destinations() {
+			fmt.Printf("  Would upload to: %s\n", store.ImageKey(dest))
+		}
+		if img.Transform != nil {
+			fmt.Printf("  Would convert to: %s\n", img.Transform.Format)
+		}
+		if img.OCI != nil {
+			fmt.Printf("  Would push to: %s\n", img.OCI.Reference)
+		}
+		if err := planFileUpdates(ctx, client, img, opts); err != nil {
+			return false, err
 		}
 		return false, nil
 	}
 
-	// Download source image to temp file
-	fmt.Printf("  Downloading from: %s\n", img.Source.URL)
-	tempFile, size, err := downloadToTempWithClient(ctx, httpClient, img.Source.URL)
+	// Download and verify the source image, falling back to mirrors
+	tempFile, size, servedURL, release, err := downloadSource(ctx, img.Source, opts)
 	if err != nil {
 		return false, fmt.Errorf("download: %w", err)
 	}
-	defer func() {
-		_ = tempFile.Close()
-		_ = os.Remove(tempFile.Name())
-	}()
+	defer release()
 
-	// Verify source checksum
-	fmt.Printf("  Verifying source checksum...\n")
-	if _, err := tempFile.Seek(0, 0); err != nil {
-		return false, fmt.Errorf("seek temp file: %w", err)
+	// Extract or decompress into the files to upload
+	artifacts, cleanup, err := prepareArtifacts(img, tempFile, size)
+	defer cleanup()
+	if err != nil {
+		return false, err
 	}
-	if err := verifyChecksum(tempFile, img.Source.Checksum); err != nil {
-		return false, fmt.Errorf("source checksum verification: %w", err)
+
+	// Convert to another disk format if specified
+	if img.Transform != nil {
+		cleanupTransform, err := transformArtifacts(artifacts, img.Transform)
+		defer cleanupTransform()
+		if err != nil {
+			return false, err
+		}
 	}
 
-	// Decompress if needed
-	var uploadFile *os.File
-	var uploadSize int64
-	if img.Source.Decompress != "" {
-		fmt.Printf("  Decompressing (%s)...\n", img.Source.Decompress)
-		if _, err := tempFile.Seek(0, 0); err != nil {
-			return false, fmt.Errorf("seek temp file: %w", err)
+	// Mirrors are plain HTTPS, so the type follows the URL that served the bytes
+	sourceType := "http"
+	switch {
+	case servedURL != img.Source.URL:
+	case img.Source.GitHub != nil:
+		sourceType = "github"
+	case oci.IsOCI(img.Source.URL):
+		sourceType = "oci"
+	}
+
+	for _, a := range artifacts {
+		// Upload to e2
+		if _, err := a.file.Seek(0, 0); err != nil {
+			return false, fmt.Errorf("seek upload file: %w", err)
+		}
+		imageKey := store.ImageKey(a.destination)
+		fmt.Printf("  Uploading to: %s (%s)\n", imageKey, bytesize.Format(a.size))
+		if err := client.Upload(ctx, imageKey, ratelimit.NewReader(ctx, a.file, opts.uploadLimiter), a.size); err != nil {
+			return false, fmt.Errorf("upload: %w", err)
+		}
+	}
+
+	// Push to the OCI registry if specified, every artifact as a layer of
+	// one manifest
+	var pushed *store.OCIMetadata
+	if img.OCI != nil {
+		layers := make([]oci.Layer, 0, len(artifacts))
+		for _, a := range artifacts {
+			if _, err := a.file.Seek(0, 0); err != nil {
+				return false, fmt.Errorf("seek upload file: %w", err)
+			}
+			layers = append(layers, oci.Layer{File: a.file, Size: a.size, Title: path.Base(a.destination)})
+		}
+		fmt.Printf("  Pushing to: %s\n", img.OCI.Reference)
+		digest, err := pushOCIArtifact(ctx, opts.httpClient, img.OCI, layers)
+		if err != nil {
+			return false, fmt.Errorf("push OCI artifact: %w", err)
+		}
+		fmt.Printf("  Pushed manifest: %s\n", digest)
+		pushed = &store.OCIMetadata{Reference: img.OCI.Reference, Digest: digest}
+	}
+
+	var published publishedImage
+	for i, a := range artifacts {
+		// Write metadata
+		metadata := &store.ImageMetadata{
+			Name:       img.Name,
+			Checksum:   a.checksum,
+			Size:       a.size,
+			UploadedAt: time.Now().UTC(),
+			Source: store.SourceMetadata{
+				Type: sourceType,
+				URL:  servedURL,
+			},
+			Transform: a.transform,
+			OCI:       pushed,
+		}
+		if err := client.PutMetadata(ctx, a.destination, metadata); err != nil {
+			return false, fmt.Errorf("write metadata: %w", err)
+		}
+		if i == 0 {
+			published = publishedImage{
+				destination: a.destination,
+				checksum:    a.checksum,
+				size:        a.size,
+				uploadedAt:  metadata.UploadedAt,
+			}
+		}
+	}
+
+	recordLock(img, size, opts)
+
+	// Apply file updates if specified
+	filesChanged, err := applyFileUpdates(ctx, client, img, published, opts)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Printf("  Done\n")
+	return filesChanged, nil
+}
+
+// publishedImage describes the synced copy of an image. For images with
+// several artifacts it describes the first.
+type publishedImage struct {
+	destination string
+	checksum    string
+	size        int64
+	uploadedAt  time.Time
+}
+
+// plannedImage describes the copy of img a sync would publish at now, for
+// dry runs where nothing has been uploaded yet. The size is unknown and left
+// at 0.
+func plannedImage(img config.Image, now time.Time) publishedImage {
+	planned := publishedImage{uploadedAt: now.UTC()}
+	if checksums := artifactChecksums(img); len(checksums) > 0 {
+		planned.destination = checksums[0].destination
+		planned.checksum = checksums[0].checksum
+	}
+	return planned
+}
+
+// applyFileUpdates applies img's file updates to every file their paths
+// match, and reports whether any file changed. Every file is checked before
+// any is written, so a violated match expectation leaves them all untouched.
+func applyFileUpdates(ctx context.Context, client store.Client, img config.Image, published publishedImage, opts *syncOptions) (bool, error) {
+	updates := img.FileUpdates()
+	if len(updates) == 0 {
+		return false, nil
+	}
+	data, err := templateData(ctx, client, img, published, opts.publish)
+	if err != nil {
+		return false, err
+	}
+
+	// fileChange is a previewed update of one matched file
+	type fileChange struct {
+		target  string
+		updater *updater.FileUpdater
+		diff    string
+	}
+	var changes []fileChange
+	for _, uf := range updates {
+		targets, fileUpdater, err := newFileUpdater(uf, data, opts.updateRoot)
+		if err != nil {
+			return false, err
+		}
+		for _, target := range targets {
+			diff, diffErr := fileUpdater.Diff(target)
+			if diffErr != nil {
+				return false, fmt.Errorf("update file %s: %w", target, diffErr)
+			}
+			changes = append(changes, fileChange{target: target, updater: fileUpdater, diff: diff})
+		}
+	}
+
+	changed := false
+	for _, c := range changes {
+		if c.diff == "" {
+			fmt.Printf("  File unchanged: %s\n", displayPath(opts.updateRoot, c.target))
+			continue
+		}
+		if _, err := c.updater.UpdateFile(c.target); err != nil {
+			return false, fmt.Errorf("update file %s: %w", c.target, err)
+		}
+		fmt.Printf("  File updated: %s\n", displayPath(opts.updateRoot, c.target))
+		printDiff(c.diff, "    ")
+		changed = true
+	}
+	return changed, nil
+}
+
+// planFileUpdates prints the files that img's file updates would change. A
+// plan prints the unified diff of each file instead of just its name.
+func planFileUpdates(ctx context.Context, client store.Client, img config.Image, opts *syncOptions) error {
+	updates := img.FileUpdates()
+	if len(updates) == 0 {
+		return nil
+	}
+	data, err := templateData(ctx, client, img, plannedImage(img, opts.clock()), opts.publish)
+	if err != nil {
+		return err
+	}
+
+	for _, uf := range updates {
+		targets, fileUpdater, err := newFileUpdater(uf, data, opts.updateRoot)
+		if err != nil {
+			return err
+		}
+
+		for _, target := range targets {
+			if !opts.plan {
+				fmt.Printf("  Would update file: %s\n", displayPath(opts.updateRoot, target))
+				continue
+			}
+			if diffErr := printFileDiff(fileUpdater, target, opts.updateRoot); diffErr != nil {
+				return diffErr
+			}
+		}
+	}
+	return nil
+}
+
+// templateData returns the updateFile template data for img, given the
+// published copy and where it is published. The download URL is the public
+// URL under publish.baseURL. A presigned URL is created only when one of
+// img's templates uses .PresignedURL and client is not nil, since it expires
+// and each one is a live credential.
+func templateData(ctx context.Context, client store.Client, img config.Image, published publishedImage, publish *config.Publish) (updater.TemplateData, error) {
+	imageKey := store.ImageKey(published.destination)
+	var presigned string
+	if client != nil && usesPresignedURL(img.FileUpdates()...) {
+		expiry, err := publish.Expiry()
+		if err != nil {
+			return updater.TemplateData{}, fmt.Errorf("publish: %w", err)
+		}
+		presigned, err = client.PresignURL(ctx, imageKey, expiry)
+		if err != nil {
+			return updater.TemplateData{}, fmt.Errorf("download URL: %w", err)
+		}
+	}
+
+	return updater.TemplateData{
+		Name:         img.Name,
+		Destination:  published.destination,
+		ImageKey:     imageKey,
+		NASPath:      publish.NASPath(published.destination),
+		URL:          publish.PublicURL(published.destination),
+		PresignedURL: presigned,
+		Validation:   published.checksum,
+		Size:         published.size,
+		UploadedAt:   published.uploadedAt,
+		Source: updater.SourceData{
+			URL:      img.Source.URL,
+			Checksum: img.Source.Checksum,
+		},
+		Vars: img.Vars,
+	}, nil
+}
+
+// usesPresignedURL reports whether any replacement of updates uses
+// .PresignedURL.
+func usesPresignedURL(updates ...config.UpdateFile) bool {
+	for _, uf := range updates {
+		for _, r := range uf.Replacements {
+			if updater.UsesField(r.Value, "PresignedURL") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newFileUpdater returns the files matching uf.Path and the updater for uf,
+// with templates evaluated against data and files confined to root. Updates
+// that use .PresignedURL may only target files git ignores.
+func newFileUpdater(uf config.UpdateFile, data updater.TemplateData, root string) ([]string, *updater.FileUpdater, error) {
+	targets, err := uf.Targets(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("update files: %w", err)
+	}
+	if usesPresignedURL(uf) {
+		for _, target := range targets {
+			if err := checkIgnored(target, root); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	replacements := make([]updater.Replacement, len(uf.Replacements))
+	for i, r := range uf.Replacements {
+		replacements[i] = updater.Replacement{
+			Type:          r.Type,
+			Pattern:       r.Pattern,
+			Path:          r.Path,
+			Value:         r.Value,
+			ExpectMatches: r.ExpectMatches,
+			Required:      r.Required,
+		}
+	}
+
+	fileUpdater, err := updater.New(replacements, data, updater.WithRoot(root))
+	if err != nil {
+		return nil, nil, fmt.Errorf("create file updater: %w", err)
+	}
+	return targets, fileUpdater, nil
+}
+
+// checkIgnored returns an error unless git ignores path. Tracked files are
+// never ignored, so a presigned URL cannot end up in a commit. Errors show
+// path relative to root.
+func checkIgnored(path, root string) error {
+	cmd := exec.Command("git", "-C", filepath.Dir(path), "check-ignore", "-q", "--", path) //nolint:gosec // G204: fixed git subcommand on a file path
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return fmt.Errorf("update file %s: .PresignedURL expires and is only written to files git ignores", displayPath(root, path))
+	default:
+		return fmt.Errorf("update file %s: check that git ignores it: %w", displayPath(root, path), err)
+	}
+}
+
+// repoRoot returns the root of the git repository containing the working
+// directory, or the working directory itself outside a repository.
+func repoRoot() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("get working directory: %w", err)
+	}
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+		if filepath.Dir(dir) == dir {
+			return wd, nil
+		}
+	}
+}
+
+// displayPath returns path relative to root when it is inside it, for
+// output.
+func displayPath(root, path string) string {
+	if root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// printFileDiff prints the unified diff that fileUpdater would apply to
+// path, without writing the file. Paths are shown relative to root.
+func printFileDiff(fileUpdater *updater.FileUpdater, path, root string) error {
+	diff, err := fileUpdater.Diff(path)
+	if err != nil {
+		return fmt.Errorf("diff file: %w", err)
+	}
+	if diff == "" {
+		fmt.Printf("  File unchanged: %s\n", displayPath(root, path))
+		return nil
+	}
+
+	fmt.Printf("  Would update file: %s\n", displayPath(root, path))
+	printDiff(diff, "    ")
+	return nil
+}
+
+// printDiff prints a unified diff with each line indented.
+func printDiff(diff, indent string) {
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		fmt.Printf("%s%s\n", indent, line)
+	}
+}
+
+// artifact is a file produced by the sync pipeline and the destination it is
+// uploaded to.
+type artifact struct {
+	destination string
+	checksum    string
+	file        *os.File
+	size        int64
+
+	// transform records the conversion applied to file, if any
+	transform *store.TransformMetadata
+}
+
+// checksumsMatch reports whether every destination of img already holds an
+// image with the expected checksum, converted as img.Transform asks.
+func checksumsMatch(ctx context.Context, client store.Client, img config.Image) (bool, error) {
+	for _, a := range artifactChecksums(img) {
+		matches, err := client.ChecksumMatches(ctx, a.destination, a.checksum)
+		if err != nil || !matches {
+			return false, err
 		}
-		decompFile, decompSize, err := decompress(tempFile, img.Source.Decompress)
+
+		metadata, err := client.GetMetadata(ctx, a.destination)
 		if err != nil {
-			return false, fmt.Errorf("decompress: %w", err)
+			return false, fmt.Errorf("read metadata for %s: %w", a.destination, err)
+		}
+		if !transformMatches(metadata.Transform, img.Transform) {
+			return false, nil
 		}
-		defer func() {
+	}
+	return true, nil
+}
+
+// transformMatches reports whether stored, the transform recorded in an
+// image's metadata, is the one t produces: the same format and options, with
+// the checksum of the converted image recorded. An image uploaded without a
+// transform matches only when t is nil.
+func transformMatches(stored *store.TransformMetadata, t *config.Transform) bool {
+	if t == nil || stored == nil {
+		return t == nil && stored == nil
+	}
+	return stored.Format == t.Format && stored.Sparse == t.Sparse && stored.Checksum != ""
+}
+
+// describeTransform describes a transform for status output.
+func describeTransform(format string, sparse bool) string {
+	switch {
+	case format == "":
+		return "none"
+	case sparse:
+		return format + " (sparse)"
+	default:
+		return format
+	}
+}
+
+// destinationChecksum is a destination and the checksum recorded in its metadata.
+type destinationChecksum struct {
+	destination string
+	checksum    string
+}
+
+// artifactChecksums returns the checksum that sync records for each
+// destination of img: the member validation checksum for extracted archives,
+// or the effective image checksum otherwise.
+func artifactChecksums(img config.Image) []destinationChecksum {
+	if img.Source.Extract == nil {
+		return []destinationChecksum{{destination: img.Destination, checksum: img.EffectiveChecksum()}}
+	}
+
+	checksums := make([]destinationChecksum, len(img.Source.Extract.Members))
+	for i, m := range img.Source.Extract.Members {
+		checksums[i] = destinationChecksum{destination: m.Destination, checksum: m.Validation.Expected}
+	}
+	return checksums
+}
+
+// prepareArtifacts turns a downloaded source file into the files to upload:
+// the extracted archive members, the decompressed image, or the source itself.
+// The returned cleanup function removes any temp files and is never nil.
+func prepareArtifacts(img config.Image, source *os.File, size int64) ([]artifact, func(), error) {
+	if _, err := source.Seek(0, 0); err != nil {
+		return nil, func() {}, fmt.Errorf("seek temp file: %w", err)
+	}
+
+	switch {
+	case img.Source.Extract != nil:
+		return extractArtifacts(img.Source.Extract, source)
+	case img.Source.Decompress != "":
+		fmt.Printf("  Decompressing (%s)...\n", img.Source.Decompress)
+		decompFile, decompSize, err := decompress(source, img.Source.Decompress)
+		if err != nil {
+			return nil, func() {}, fmt.Errorf("decompress: %w", err)
+		}
+		cleanup := func() {
 			_ = decompFile.Close()
 			_ = os.Remove(decompFile.Name())
-		}()
+		}
 
 		// Verify post-decompression checksum if validation is specified
 		if img.Validation != nil && img.Validation.Expected != "" {
 			fmt.Printf("  Verifying decompressed checksum...\n")
 			if _, err := decompFile.Seek(0, 0); err != nil {
-				return false, fmt.Errorf("seek decompressed file: %w", err)
+				return nil, cleanup, fmt.Errorf("seek decompressed file: %w", err)
 			}
 			if err := verifyChecksum(decompFile, img.Validation.Expected); err != nil {
-				return false, fmt.Errorf("decompressed checksum verification: %w", err)
+				return nil, cleanup, fmt.Errorf("decompressed checksum verification: %w", err)
 			}
 		}
 
-		uploadFile = decompFile
-		uploadSize = decompSize
-	} else {
-		uploadFile = tempFile
-		uploadSize = size
+		return []artifact{{destination: img.Destination, checksum: img.EffectiveChecksum(), file: decompFile, size: decompSize}}, cleanup, nil
+	default:
+		return []artifact{{destination: img.Destination, checksum: img.EffectiveChecksum(), file: source, size: size}}, func() {}, nil
 	}
+}
 
-	// Upload to e2
-	if _, err := uploadFile.Seek(0, 0); err != nil {
-		return false, fmt.Errorf("seek upload file: %w", err)
-	}
-	imageKey := store.ImageKey(img.Destination)
-	fmt.Printf("  Uploading to: %s (%s)\n", imageKey, formatSize(uploadSize))
-	if err := client.Upload(ctx, imageKey, uploadFile, uploadSize); err != nil {
-		return false, fmt.Errorf("upload: %w", err)
+// extractArtifacts extracts the configured members from an archive and
+// verifies each one against its validation checksum.
+func extractArtifacts(ex *config.Extract, archiveFile *os.File) ([]artifact, func(), error) {
+	fmt.Printf("  Extracting (%s)...\n", ex.Format)
+
+	patterns := make([]string, len(ex.Members))
+	for i, m := range ex.Members {
+		patterns[i] = m.Path
 	}
 
-	// Write metadata
-	metadata := &store.ImageMetadata{
-		Name:       img.Name,
-		Checksum:   effectiveChecksum,
-		Size:       uploadSize,
-		UploadedAt: time.Now().UTC(),
-		Source: store.SourceMetadata{
-			Type: "http",
-			URL:  img.Source.URL,
-		},
+	members, err := archive.Extract(archiveFile, ex.Format, patterns)
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("extract: %w", err)
 	}
-	if err := client.PutMetadata(ctx, img.Destination, metadata); err != nil {
-		return false, fmt.Errorf("write metadata: %w", err)
+	cleanup := func() {
+		for _, m := range members {
+			_ = m.File.Close()
+			_ = os.Remove(m.File.Name())
+		}
 	}
 
-	// Apply file updates if specified
-	filesChanged := false
-	if img.UpdateFile != nil {
-		fmt.Printf("  Updating file: %s\n", img.UpdateFile.Path)
-
-		replacements := make([]updater.Replacement, len(img.UpdateFile.Replacements))
-		for i, r := range img.UpdateFile.Replacements {
-			replacements[i] = updater.Replacement{
-				Pattern: r.Pattern,
-				Value:   r.Value,
-			}
+	artifacts := make([]artifact, len(members))
+	for i, m := range members {
+		fmt.Printf("  Verifying %s checksum...\n", m.Name)
+		if _, err := m.File.Seek(0, 0); err != nil {
+			return nil, cleanup, fmt.Errorf("seek extracted file: %w", err)
+		}
+		if err := verifyChecksum(m.File, ex.Members[i].Validation.Expected); err != nil {
+			return nil, cleanup, fmt.Errorf("%s checksum verification: %w", m.Name, err)
 		}
 
-		data := updater.TemplateData{
-			Source: updater.SourceData{
-				URL:      img.Source.URL,
-				Checksum: img.Source.Checksum,
-			},
+		artifacts[i] = artifact{
+			destination: ex.Members[i].Destination,
+			checksum:    ex.Members[i].Validation.Expected,
+			file:        m.File,
+			size:        m.Size,
+		}
+	}
+
+	return artifacts, cleanup, nil
+}
+
+// transformArtifacts converts each artifact to the transform format in place,
+// recording the checksum of the converted file. The source checksum is kept
+// as the artifact checksum so that unchanged sources are still skipped.
+// The returned cleanup function removes the converted files and is never nil.
+func transformArtifacts(artifacts []artifact, t *config.Transform) (func(), error) {
+	var converted []*os.File
+	cleanup := func() {
+		for _, f := range converted {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
 		}
+	}
 
-		fileUpdater, err := updater.New(replacements, data)
+	for i := range artifacts {
+		a := &artifacts[i]
+		fmt.Printf("  Converting to %s...\n", t.Format)
+		out, size, err := diskimage.Convert(a.file, t.Format, diskimage.Options{Sparse: t.Sparse})
 		if err != nil {
-			return false, fmt.Errorf("create file updater: %w", err)
+			return cleanup, fmt.Errorf("transform: %w", err)
+		}
+		if out == a.file {
+			fmt.Printf("  Already %s, uploading unchanged\n", t.Format)
+		} else {
+			converted = append(converted, out)
 		}
 
-		modified, err := fileUpdater.UpdateFile(img.UpdateFile.Path)
+		if _, err := out.Seek(0, 0); err != nil {
+			return cleanup, fmt.Errorf("seek converted file: %w", err)
+		}
+		checksum, err := sha256Checksum(out)
 		if err != nil {
-			return false, fmt.Errorf("update file: %w", err)
+			return cleanup, fmt.Errorf("compute converted checksum: %w", err)
 		}
+		fmt.Printf("  Converted checksum: %s\n", checksum)
 
-		if modified {
-			fmt.Printf("  File updated: %s\n", img.UpdateFile.Path)
-			filesChanged = true
+		a.file = out
+		a.size = size
+		a.transform = &store.TransformMetadata{Format: t.Format, Sparse: t.Sparse, Checksum: checksum}
+	}
+
+	return cleanup, nil
+}
+
+// sha256Checksum returns the "sha256:<hex>" checksum of r.
+func sha256Checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resolveSource returns a copy of img with Source.URL and Source.Checksum
+// filled in for sources that are resolved at sync time, such as GitHub
+// release assets. Other sources are returned unchanged.
+func resolveSource(ctx context.Context, img config.Image, opts *syncOptions) (config.Image, error) {
+	if img.Source.GitHub == nil {
+		return img, nil
+	}
+
+	src, asset, err := resolveGitHubSource(ctx, opts.httpClient, opts.githubBaseURL, img.Source)
+	if err != nil {
+		return img, err
+	}
+	fmt.Printf("  Resolved %s@%s: %s\n", src.GitHub.Repo, asset.Tag, asset.Name)
+
+	img.Source = src
+	return img, nil
+}
+
+// resolveGitHubSource resolves a source.github block to the asset's browser
+// download URL and checksum through the GitHub API at baseURL. The asset
+// digest, when GitHub provides one, must agree with any checksum given in the
+// manifest.
+func resolveGitHubSource(ctx context.Context, httpClient HTTPClient, baseURL string, src config.Source) (config.Source, *github.Asset, error) {
+	gh := src.GitHub
+	client := github.NewClient(httpClient, github.TokenFromEnv(), github.WithBaseURL(baseURL))
+
+	asset, err := client.ResolveAsset(ctx, gh.Repo, gh.Tag, gh.TagPattern, gh.Prerelease, gh.Asset)
+	if err != nil {
+		return src, nil, fmt.Errorf("resolve GitHub release asset: %w", err)
+	}
+
+	switch {
+	case src.Checksum == "" && asset.Digest == "":
+		return src, nil, fmt.Errorf("GitHub asset %s has no digest, set source.checksum", asset.Name)
+	case src.Checksum == "":
+		src.Checksum = asset.Digest
+	case asset.Digest != "" && asset.Digest != src.Checksum:
+		return src, nil, fmt.Errorf("source.checksum %s does not match GitHub asset digest %s", src.Checksum, asset.Digest)
+	}
+
+	src.URL = asset.BrowserDownloadURL
+	return src, asset, nil
+}
+
+// pinnedSource returns a copy of img with the source pinned in the lockfile.
+// Sources resolved at sync time take the locked URL and checksum without
+// being resolved again; other sources must match their lockfile entry, so
+// that a manifest change is not synced until the lockfile is refreshed.
+func pinnedSource(img config.Image, opts *syncOptions) (config.Image, error) {
+	entry, ok := opts.lock.Get(img.Name)
+	if !ok {
+		return img, fmt.Errorf("not pinned in lockfile, run sync without --frozen to update it")
+	}
+
+	manifest := lockEntry(img, 0, opts.clock())
+	if img.Source.GitHub != nil {
+		if img.Source.Checksum != "" && img.Source.Checksum != entry.Checksum {
+			return img, fmt.Errorf("source.checksum %s does not match lockfile checksum %s", img.Source.Checksum, entry.Checksum)
+		}
+		manifest.URL = entry.URL
+		manifest.Checksum = entry.Checksum
+	}
+	if !entry.Pins(manifest) {
+		return img, fmt.Errorf("source differs from lockfile (locked %s %s), run sync without --frozen to update it", entry.URL, entry.Checksum)
+	}
+
+	fmt.Printf("  Pinned by lockfile: %s\n", entry.URL)
+	img.Source.URL = entry.URL
+	img.Source.Checksum = entry.Checksum
+	return img, nil
+}
+
+// lockEntry returns the lockfile entry for a resolved image, resolved at now.
+func lockEntry(img config.Image, size int64, now time.Time) lock.Entry {
+	entry := lock.Entry{
+		Name:       img.Name,
+		URL:        img.Source.URL,
+		Checksum:   img.Source.Checksum,
+		Size:       size,
+		ResolvedAt: now.UTC().Truncate(time.Second),
+	}
+	if img.Validation != nil {
+		entry.Validation = img.Validation.Expected
+	}
+	return entry
+}
+
+// recordLock records the resolved source of a synced image in the lockfile.
+// size is the source size, or 0 if it is unknown.
+func recordLock(img config.Image, size int64, opts *syncOptions) {
+	if opts.lock == nil || opts.frozen {
+		return
+	}
+	opts.lock.Set(lockEntry(img, size, opts.clock()))
+}
+
+// skippedSourceSize returns the source size to record for an image that is
+// already synced and so is not downloaded. The lockfile entry keeps a size it
+// already has; otherwise the stored metadata holds it when the image was
+// uploaded as downloaded, and the source URL is probed when it was not. An
+// unknown size is 0.
+func skippedSourceSize(ctx context.Context, client store.Client, img config.Image, opts *syncOptions) int64 {
+	if opts.lock == nil || opts.frozen || opts.dryRun {
+		return 0
+	}
+	if entry, ok := opts.lock.Get(img.Name); ok && entry.Size > 0 && entry.Pins(lockEntry(img, 0, opts.clock())) {
+		return entry.Size
+	}
+
+	if img.Source.Extract == nil && img.Source.Decompress == "" && img.Transform == nil {
+		metadata, err := client.GetMetadata(ctx, img.Destination)
+		if err == nil && metadata.Size > 0 {
+			return metadata.Size
+		}
+	}
+
+	if oci.IsOCI(img.Source.URL) {
+		return 0
+	}
+	info, err := checkURL(ctx, opts.httpClient, img.Source.URL)
+	if err != nil {
+		fmt.Printf("  Warning: source size for lockfile: %v\n", err)
+		return 0
+	}
+	return max(info.size, 0)
+}
+
+// openCache opens the download cache in dir, or the default cache directory
+// when dir is empty.
+func openCache(dir, maxSize string) (*cache.Cache, error) {
+	size, err := bytesize.Parse(maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("--cache-max-size: %w", err)
+	}
+
+	if dir == "" {
+		dir, err = cache.DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cache.New(dir, size)
+}
+
+// downloadSource downloads a resolved image source to a temp file and
+// verifies it against the source checksum. The source URL is tried first,
+// then each mirror in order, until one serves matching bytes. It returns the
+// URL that served the file and a release function that closes the file and
+// removes it unless it belongs to the download cache.
+//
+// The download cache is consulted by checksum before any URL is tried, and
+// filled after a verified download; cache failures are reported but never
+// fail the sync. A cache hit is hashed against the source checksum before it
+// is used; an entry that no longer matches is removed and downloaded again.
+func downloadSource(ctx context.Context, src config.Source, opts *syncOptions) (*os.File, int64, string, func(), error) {
+	if opts.cache != nil {
+		file, size, ok, err := opts.cache.Get(src.Checksum)
+		switch {
+		case err != nil:
+			fmt.Printf("  Warning: download cache: %v\n", err)
+		case ok:
+			fmt.Printf("  Using cached download: %s\n", src.Checksum)
+			return file, size, src.URL, func() { _ = file.Close() }, nil
+		}
+	}
+
+	urls := append([]string{src.URL}, src.Mirrors...)
+	var errs []error
+	for i, url := range urls {
+		candidate := src
+		if i > 0 {
+			fmt.Printf("  Trying mirror: %s\n", url)
+			candidate = config.Source{URL: url, Checksum: src.Checksum}
 		} else {
-			fmt.Printf("  File unchanged: %s\n", img.UpdateFile.Path)
+			fmt.Printf("  Downloading from: %s\n", url)
 		}
+
+		file, size, err := fetchVerified(ctx, candidate, opts)
+		if err != nil {
+			if len(urls) > 1 {
+				fmt.Printf("  Failed: %v\n", err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			continue
+		}
+		release := func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+
+		if opts.cache != nil {
+			if _, err := file.Seek(0, 0); err != nil {
+				release()
+				return nil, 0, "", nil, fmt.Errorf("seek temp file: %w", err)
+			}
+			if err := opts.cache.Put(src.Checksum, file); err != nil {
+				fmt.Printf("  Warning: download cache: %v\n", err)
+			}
+		}
+
+		return file, size, url, release, nil
 	}
 
-	fmt.Printf("  Done\n")
-	return filesChanged, nil
+	if len(errs) == 1 {
+		return nil, 0, "", nil, errors.Unwrap(errs[0])
+	}
+	return nil, 0, "", nil, fmt.Errorf("all %d source URLs failed: %w", len(urls), errors.Join(errs...))
+}
+
+// fetchVerified downloads a source and verifies it against the source checksum.
+func fetchVerified(ctx context.Context, src config.Source, opts *syncOptions) (*os.File, int64, error) {
+	file, size, err := fetchSource(ctx, src, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fmt.Printf("  Verifying source checksum...\n")
+	if _, err = file.Seek(0, 0); err == nil {
+		err = verifyChecksum(file, src.Checksum)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, 0, fmt.Errorf("source checksum verification: %w", err)
+	}
+
+	return file, size, nil
+}
+
+// fetchSource downloads a resolved image source to a temp file, limited to
+// the download rate in opts.
+func fetchSource(ctx context.Context, src config.Source, opts *syncOptions) (*os.File, int64, error) {
+	body, err := openSource(ctx, opts.httpClient, opts.githubBaseURL, src)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = body.Close() }()
+
+	return copyToTemp(ctx, body, opts.downloadLimiter)
+}
+
+// openSource opens a resolved image source for reading, dispatching on the
+// source type: oci:// sources are pulled from a registry, GitHub assets
+// through the GitHub client for the API at githubBaseURL, and everything else
+// over HTTPS.
+func openSource(ctx context.Context, httpClient HTTPClient, githubBaseURL string, src config.Source) (io.ReadCloser, error) {
+	switch {
+	case src.GitHub != nil:
+		return openGitHubAsset(ctx, httpClient, githubBaseURL, src.URL)
+	case oci.IsOCI(src.URL):
+		return openOCIBlob(ctx, httpClient, src.URL)
+	default:
+		return openURL(ctx, httpClient, src.URL)
+	}
+}
+
+// openGitHubAsset opens a release asset URL returned by resolveSource.
+// GITHUB_TOKEN, when set, routes the download through the API so private
+// assets work.
+func openGitHubAsset(ctx context.Context, httpClient HTTPClient, baseURL, url string) (io.ReadCloser, error) {
+	client := github.NewClient(httpClient, github.TokenFromEnv(), github.WithBaseURL(baseURL))
+	return client.Download(ctx, url)
+}
+
+// openOCIBlob opens a blob by digest from an OCI registry.
+// Registry credentials are read from the environment; anonymous access is used otherwise.
+func openOCIBlob(ctx context.Context, httpClient HTTPClient, url string) (io.ReadCloser, error) {
+	ref, err := oci.ParseReference(url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := oci.NewClient(httpClient, oci.CredentialsFromEnv()).FetchBlob(ctx, ref)
+	return body, err
+}
+
+// pushOCIArtifact pushes the layers as one OCI artifact to the target reference.
+func pushOCIArtifact(ctx context.Context, httpClient HTTPClient, target *config.OCITarget, layers []oci.Layer) (string, error) {
+	ref, err := oci.ParseReference(target.Reference)
+	if err != nil {
+		return "", err
+	}
+	return oci.NewClient(httpClient, oci.CredentialsFromEnv()).PushArtifact(ctx, ref, layers)
+}
+
+// ociArtifactMatches reports whether the OCI target of img still resolves to
+// the manifest recorded when the image was last pushed. A tag that is
+// missing, was pushed for another reference, or now points at a different
+// manifest does not match.
+func ociArtifactMatches(ctx context.Context, client store.Client, httpClient HTTPClient, img config.Image) (bool, error) {
+	destination := img.Destinations()[0]
+	metadata, err := client.GetMetadata(ctx, destination)
+	if err != nil {
+		return false, fmt.Errorf("read metadata for %s: %w", destination, err)
+	}
+	if metadata.OCI == nil || metadata.OCI.Reference != img.OCI.Reference {
+		return false, nil
+	}
+
+	ref, err := oci.ParseReference(img.OCI.Reference)
+	if err != nil {
+		return false, err
+	}
+	digest, err := oci.NewClient(httpClient, oci.CredentialsFromEnv()).ManifestDigest(ctx, ref)
+	if err != nil {
+		return false, err
+	}
+	return digest == metadata.OCI.Digest, nil
 }
 
 // downloadToTemp downloads a URL to a temp file using the default HTTP client.
@@ -280,28 +1233,45 @@ func downloadToTemp(ctx context.Context, url string) (*os.File, int64, error) {
 // downloadToTempWithClient downloads a URL to a temp file using the provided HTTP client.
 // This function enables dependency injection for testing.
 func downloadToTempWithClient(ctx context.Context, client HTTPClient, url string) (*os.File, int64, error) {
+	body, err := openURL(ctx, client, url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = body.Close() }()
+
+	return copyToTemp(ctx, body, nil)
+}
+
+// openURL issues a GET request for url and returns the response body.
+func openURL(ctx context.Context, client HTTPClient, url string) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
-		return nil, 0, fmt.Errorf("create request: %w", err)
+		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("User-Agent", "labctl/1.0")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("HTTP request: %w", err)
+		return nil, fmt.Errorf("HTTP request: %w", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
 	}
 
+	return resp.Body, nil
+}
+
+// copyToTemp writes the contents of r to a new temp file, limited to the
+// rate of limiter; a nil limiter is unlimited.
+func copyToTemp(ctx context.Context, r io.Reader, limiter *ratelimit.Limiter) (*os.File, int64, error) {
 	tempFile, err := os.CreateTemp("", "labctl-download-*")
 	if err != nil {
 		return nil, 0, fmt.Errorf("create temp file: %w", err)
 	}
 
-	size, err := io.Copy(tempFile, resp.Body)
+	size, err := io.Copy(tempFile, ratelimit.NewReader(ctx, r, limiter))
 	if err != nil {
 		_ = tempFile.Close()
 		_ = os.Remove(tempFile.Name())
@@ -347,49 +1317,21 @@ func verifyChecksum(r io.Reader, expected string) error {
 const maxDecompressedSize = 50 * 1024 * 1024 * 1024
 
 func decompress(r io.Reader, format string) (*os.File, int64, error) {
-	var reader io.Reader
-	var cleanup func()
-
-	switch format {
-	case "xz":
-		xzReader, err := xz.NewReader(r)
-		if err != nil {
-			return nil, 0, fmt.Errorf("create xz reader: %w", err)
-		}
-		reader = xzReader
-	case "gzip":
-		gzReader, err := gzip.NewReader(r)
-		if err != nil {
-			return nil, 0, fmt.Errorf("create gzip reader: %w", err)
-		}
-		reader = gzReader
-		cleanup = func() { _ = gzReader.Close() }
-	case "zstd":
-		zstdReader, err := zstd.NewReader(r)
-		if err != nil {
-			return nil, 0, fmt.Errorf("create zstd reader: %w", err)
-		}
-		reader = zstdReader
-		cleanup = func() { zstdReader.Close() }
-	default:
-		return nil, 0, fmt.Errorf("unsupported decompression format: %s", format)
+	reader, cleanup, err := archive.Decompressor(r, format)
+	if err != nil {
+		return nil, 0, err
 	}
+	defer cleanup()
 
 	// Wrap with a limit reader to prevent decompression bombs
 	limitedReader := io.LimitReader(reader, maxDecompressedSize)
 
 	tempFile, err := os.CreateTemp("", "labctl-decompress-*")
 	if err != nil {
-		if cleanup != nil {
-			cleanup()
-		}
 		return nil, 0, fmt.Errorf("create temp file: %w", err)
 	}
 
 	size, err := io.Copy(tempFile, limitedReader)
-	if cleanup != nil {
-		cleanup()
-	}
 	if err != nil {
 		_ = tempFile.Close()
 		_ = os.Remove(tempFile.Name())
@@ -1,24 +1,34 @@
 package images
 
 import (
+	"archive/tar"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/GilmanLab/lab/tools/labctl/internal/cache"
 	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+	"github.com/GilmanLab/lab/tools/labctl/internal/lock"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci/ocitest"
+	"github.com/GilmanLab/lab/tools/labctl/internal/ratelimit"
 	"github.com/GilmanLab/lab/tools/labctl/internal/store"
 )
 
@@ -221,11 +231,11 @@ func TestSyncImage(t *testing.T) {
 			Destination: "test/test.iso",
 			Source: config.Source{
 				URL:      "https://example.com/test.iso",
-				Checksum: "sha256:abc123",
+				Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000",
 			},
 		}
 
-		changed, err := syncImage(context.Background(), client, img, false, false)
+		changed, err := syncImage(context.Background(), client, img, newSyncOptions(http.DefaultClient))
 
 		require.NoError(t, err)
 		assert.False(t, changed)
@@ -240,11 +250,13 @@ func TestSyncImage(t *testing.T) {
 			Destination: "test/test.iso",
 			Source: config.Source{
 				URL:      "https://example.com/test.iso",
-				Checksum: "sha256:abc123",
+				Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000",
 			},
 		}
 
-		changed, err := syncImage(context.Background(), client, img, true, false)
+		opts := newSyncOptions(http.DefaultClient)
+		opts.dryRun = true
+		changed, err := syncImage(context.Background(), client, img, opts)
 
 		require.NoError(t, err)
 		assert.False(t, changed)
@@ -267,13 +279,15 @@ func TestSyncImage(t *testing.T) {
 			Destination: "test/test.iso",
 			Source: config.Source{
 				URL:      "https://example.com/test.iso",
-				Checksum: "sha256:abc123",
+				Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000",
 			},
 		}
 
 		// With force=true and dryRun=true, it should show what would be done
 		// without checking checksum
-		_, err := syncImage(context.Background(), client, img, true, true)
+		opts := newSyncOptions(http.DefaultClient)
+		opts.dryRun, opts.force = true, true
+		_, err := syncImage(context.Background(), client, img, opts)
 
 		require.NoError(t, err)
 		assert.False(t, checksumChecked) // Should not check checksum with force
@@ -291,17 +305,328 @@ func TestSyncImage(t *testing.T) {
 			Destination: "test/test.iso",
 			Source: config.Source{
 				URL:      "https://example.com/test.iso",
-				Checksum: "sha256:abc123",
+				Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000",
 			},
 		}
 
-		_, err := syncImage(context.Background(), client, img, false, false)
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(http.DefaultClient))
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "check existing image")
 	})
 }
 
+func TestSyncImagePlan(t *testing.T) {
+	opts := newSyncOptions(http.DefaultClient)
+	opts.dryRun, opts.plan = true, true
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vyos.pkr.hcl")
+	content := `vyos_iso_url = "https://old.example.com/old.iso"`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644)) //nolint:gosec // G306: test file
+
+	img := config.Image{
+		Name:        "test-image",
+		Destination: "test/test.iso",
+		Source: config.Source{
+			URL:      "https://example.com/test.iso",
+			Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000",
+		},
+		UpdateFile: &config.UpdateFile{
+			Path: path,
+			Replacements: []config.Replacement{
+				{Pattern: `vyos_iso_url = "[^"]*"`, Value: `vyos_iso_url = "{{ .Source.URL }}"`},
+			},
+		},
+	}
+
+	t.Run("consults the store", func(t *testing.T) {
+		var checked []string
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, imagePath, _ string) (bool, error) {
+				checked = append(checked, imagePath)
+				return true, nil
+			},
+		}
+
+		changed, err := syncImage(context.Background(), client, img, opts)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, []string{"test/test.iso"}, checked)
+	})
+
+	t.Run("diffs file updates without writing", func(t *testing.T) {
+		client := &mockStoreClient{}
+
+		changed, err := syncImage(context.Background(), client, img, opts)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Empty(t, client.uploadedKeys)
+		assert.Empty(t, client.putMetadataCalls)
+
+		got, err := os.ReadFile(path) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got))
+	})
+
+	t.Run("missing update file", func(t *testing.T) {
+		missing := img
+		missing.UpdateFile = &config.UpdateFile{Path: filepath.Join(dir, "missing.hcl"), Replacements: img.UpdateFile.Replacements}
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, missing, opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "matches no files")
+	})
+}
+
+func TestSyncImageUpdateFileTemplateData(t *testing.T) {
+	content := []byte("test image content for template data")
+	h := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(h[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	image := func(path, value string) config.Image {
+		return config.Image{
+			Name:        "test-image",
+			Destination: "test/test.iso",
+			Source:      config.Source{URL: server.URL + "/releases/test.iso", Checksum: checksum},
+			UpdateFile: &config.UpdateFile{
+				Path:         path,
+				Replacements: []config.Replacement{{Pattern: `^.*$`, Value: value}},
+			},
+		}
+	}
+	run := func(t *testing.T, client *mockStoreClient, img config.Image, publish *config.Publish) string {
+		t.Helper()
+		opts := newSyncOptions(server.Client())
+		opts.publish = publish
+		changed, err := syncImage(context.Background(), client, img, opts)
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		got, err := os.ReadFile(img.UpdateFile.Path) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		return string(got)
+	}
+	placeholder := func(t *testing.T, path string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(path, []byte("placeholder"), 0o644)) //nolint:gosec // G306: test file
+	}
+	noPresign := func(t *testing.T) *mockStoreClient {
+		return &mockStoreClient{presignURLFunc: func(context.Context, string, time.Duration) (string, error) {
+			t.Error("presigned a URL no template uses")
+			return "", nil
+		}}
+	}
+
+	t.Run("public URL and NAS root from publish", func(t *testing.T) {
+		publish := &config.Publish{NASRoot: "/mnt/images", BaseURL: "https://images.example.com/"}
+		path := filepath.Join(t.TempDir(), "image.txt")
+		placeholder(t, path)
+
+		got := run(t, noPresign(t), image(path, "{{ .Name }} {{ .Destination }} {{ .ImageKey }} {{ .NASPath }} {{ .Size }} "+
+			"{{ .Validation | trimPrefix \"sha256:\" | len }} {{ .Source.URL | base }} {{ .URL }}"), publish)
+		assert.Equal(t, fmt.Sprintf("test-image test/test.iso images/test/test.iso /mnt/images/test/test.iso %d 64 test.iso "+
+			"https://images.example.com/test/test.iso", len(content)), got)
+	})
+
+	t.Run("no presigned URL without publish", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "image.txt")
+		placeholder(t, path)
+
+		assert.Equal(t, "/volume1/images/test/test.iso", run(t, noPresign(t), image(path, "{{ .NASPath }}"), nil))
+	})
+
+	t.Run("presigned URL in a git-ignored file", func(t *testing.T) {
+		dir := gitRepo(t)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.local\n"), 0o644)) //nolint:gosec // G306: test file
+		path := filepath.Join(dir, "image.local")
+		placeholder(t, path)
+
+		assert.Equal(t, "https://e2.example.com/lab-images/images/test/test.iso?X-Amz-Signature=test",
+			run(t, &mockStoreClient{}, image(path, "{{ .PresignedURL }}"), nil))
+	})
+
+	t.Run("presigned URL refused in a committed file", func(t *testing.T) {
+		dir := gitRepo(t)
+		path := filepath.Join(dir, "image.txt")
+		placeholder(t, path)
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, image(path, "{{ .PresignedURL }}"), newSyncOptions(server.Client()))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "only written to files git ignores")
+
+		got, err := os.ReadFile(path) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		assert.Equal(t, "placeholder", string(got))
+	})
+}
+
+// gitRepo returns a new git repository in a temporary directory.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	out, err := exec.Command("git", "init", "-q", dir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return dir
+}
+
+func TestSyncImageUpdateFiles(t *testing.T) {
+	content := []byte("test image content for update files")
+	h := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(h[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	setup := func(t *testing.T) string {
+		t.Helper()
+		dir := t.TempDir()
+		for name, data := range map[string]string{
+			"capi/controlplane.yaml": "image: talos-1.9.0\n",
+			"capi/workers.yaml":      "image: talos-1.9.1\n",
+			"docs/talos.md":          "Talos image: talos-1.9.0\n",
+		} {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+			require.NoError(t, os.WriteFile(path, []byte(data), 0o644)) //nolint:gosec // G306: test file
+		}
+		return dir
+	}
+	replacements := []config.Replacement{{Pattern: `talos-[0-9.]+`, Value: "{{ .Name }}"}}
+	image := func(updates ...config.UpdateFile) config.Image {
+		return config.Image{
+			Name:        "talos-1.9.1",
+			Destination: "talos/talos.iso",
+			Source:      config.Source{URL: server.URL, Checksum: checksum},
+			UpdateFiles: updates,
+		}
+	}
+	read := func(t *testing.T, path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("updates every file matched by each glob", func(t *testing.T) {
+		dir := setup(t)
+		img := image(
+			config.UpdateFile{Path: filepath.Join(dir, "capi", "*.yaml"), Replacements: replacements},
+			config.UpdateFile{Path: filepath.Join(dir, "docs", "talos.md"), Replacements: replacements},
+		)
+
+		changed, err := syncImage(context.Background(), &mockStoreClient{}, img, newSyncOptions(server.Client()))
+
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "image: talos-1.9.1\n", read(t, filepath.Join(dir, "capi", "controlplane.yaml")))
+		assert.Equal(t, "image: talos-1.9.1\n", read(t, filepath.Join(dir, "capi", "workers.yaml")))
+		assert.Equal(t, "Talos image: talos-1.9.1\n", read(t, filepath.Join(dir, "docs", "talos.md")))
+	})
+
+	t.Run("pattern matching nothing is an error", func(t *testing.T) {
+		dir := setup(t)
+		img := image(config.UpdateFile{Path: filepath.Join(dir, "harvester", "*.yaml"), Replacements: replacements})
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, img, newSyncOptions(server.Client()))
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "matches no files")
+	})
+
+	t.Run("violated expectation fails without writing", func(t *testing.T) {
+		dir := setup(t)
+		strict := []config.Replacement{
+			{Pattern: `image: (talos)-[0-9.]+`, Value: "image: $1-{{ .Vars.version }}", Required: true},
+			{Pattern: `talos-[0-9.]+`, Value: "{{ .Name }}", ExpectMatches: 2},
+		}
+		img := image(config.UpdateFile{Path: filepath.Join(dir, "capi", "*.yaml"), Replacements: strict})
+		img.Vars = map[string]string{"version": "1.9.1"}
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, img, newSyncOptions(server.Client()))
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `pattern "talos-[0-9.]+" matched 1 time(s), expected 2`)
+		assert.Equal(t, "image: talos-1.9.0\n", read(t, filepath.Join(dir, "capi", "controlplane.yaml")))
+	})
+
+	t.Run("single updateFile is still applied", func(t *testing.T) {
+		dir := setup(t)
+		img := image()
+		img.UpdateFile = &config.UpdateFile{Path: filepath.Join(dir, "docs", "talos.md"), Replacements: replacements}
+
+		changed, err := syncImage(context.Background(), &mockStoreClient{}, img, newSyncOptions(server.Client()))
+
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "Talos image: talos-1.9.1\n", read(t, filepath.Join(dir, "docs", "talos.md")))
+	})
+
+	t.Run("dry run lists matched files without writing", func(t *testing.T) {
+		dir := setup(t)
+		img := image(config.UpdateFile{Path: filepath.Join(dir, "capi", "*.yaml"), Replacements: replacements})
+
+		opts := newSyncOptions(server.Client())
+		opts.dryRun = true
+		changed, err := syncImage(context.Background(), nil, img, opts)
+
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "image: talos-1.9.0\n", read(t, filepath.Join(dir, "capi", "controlplane.yaml")))
+	})
+}
+
+func TestRepoRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.Chdir(wd)) }()
+
+	t.Run("finds the enclosing git repository", func(t *testing.T) {
+		repo, err := filepath.EvalSymlinks(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, os.Mkdir(filepath.Join(repo, ".git"), 0o750))
+		nested := filepath.Join(repo, "tools", "labctl")
+		require.NoError(t, os.MkdirAll(nested, 0o750))
+		require.NoError(t, os.Chdir(nested))
+
+		root, err := repoRoot()
+		require.NoError(t, err)
+		assert.Equal(t, repo, root)
+	})
+}
+
+func TestSyncImageUpdateFileOutsideRoot(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "vars.hcl")
+	require.NoError(t, os.WriteFile(outside, []byte(`url = "old"`), 0o600))
+
+	img := config.Image{
+		Name:        "test-image",
+		Destination: "test/test.iso",
+		Source:      config.Source{URL: "https://example.com/test.iso", Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000"},
+		UpdateFile: &config.UpdateFile{
+			Path:         filepath.Join(root, "..", filepath.Base(filepath.Dir(outside)), "vars.hcl"),
+			Replacements: []config.Replacement{{Pattern: `url = "[^"]*"`, Value: `url = "{{ .Source.URL }}"`}},
+		},
+	}
+
+	opts := newSyncOptions(http.DefaultClient)
+	opts.dryRun, opts.plan = true, true
+	opts.updateRoot = root
+
+	_, err := syncImage(context.Background(), &mockStoreClient{}, img, opts)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside the repository root")
+}
+
 func TestSyncImageWithHTTP(t *testing.T) {
 	// Helper to compute SHA256 checksum
 	computeChecksum := func(data []byte) string {
@@ -351,7 +676,7 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			},
 		}
 
-		changed, err := syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		changed, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		require.NoError(t, err)
 		assert.False(t, changed) // No updateFile, so no file changes
@@ -421,7 +746,7 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			},
 		}
 
-		changed, err := syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		changed, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		require.NoError(t, err)
 		assert.False(t, changed)
@@ -452,11 +777,11 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			Destination: "test/missing.iso",
 			Source: config.Source{
 				URL:      server.URL,
-				Checksum: "sha256:abc123",
+				Checksum: "sha256:abc1230000000000000000000000000000000000000000000000000000000000",
 			},
 		}
 
-		_, err := syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "download")
@@ -486,7 +811,7 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			},
 		}
 
-		_, err := syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "source checksum verification")
@@ -520,7 +845,7 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			},
 		}
 
-		_, err := syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "upload")
@@ -557,7 +882,7 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			},
 		}
 
-		_, err := syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "write metadata")
@@ -600,22 +925,928 @@ func TestSyncImageWithHTTP(t *testing.T) {
 			},
 		}
 
-		_, err = syncImageWithHTTP(context.Background(), client, server.Client(), img, false, false)
+		_, err = syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "decompressed checksum verification")
 	})
 }
 
+func TestSyncImageWithOCI(t *testing.T) {
+	t.Run("pulls OCI blob source and pushes OCI artifact", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "registry-token"
+		defer registry.Close()
+
+		content := []byte("talos installer blob")
+		digest := registry.PutBlob("siderolabs/installer", content)
+
+		var uploadedData []byte
+		var savedMetadata *store.ImageMetadata
+		client := &mockStoreClient{
+			uploadFunc: func(_ context.Context, _ string, body io.Reader, _ int64) error {
+				var err error
+				uploadedData, err = io.ReadAll(body)
+				return err
+			},
+			putMetadataFunc: func(_ context.Context, _ string, metadata *store.ImageMetadata) error {
+				savedMetadata = metadata
+				return nil
+			},
+		}
+
+		img := config.Image{
+			Name:        "talos-installer",
+			Destination: "talos/installer.raw",
+			Source: config.Source{
+				URL:      "oci://" + registry.Host() + "/siderolabs/installer@" + digest,
+				Checksum: digest,
+			},
+			OCI: &config.OCITarget{
+				Reference: registry.Host() + "/gilmanlab/talos-installer:v1.9.1",
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(registry.Client()))
+		require.NoError(t, err)
+
+		assert.Equal(t, content, uploadedData)
+		require.NotNil(t, savedMetadata)
+		assert.Equal(t, "oci", savedMetadata.Source.Type)
+
+		_, ok := registry.Manifest("gilmanlab/talos-installer", "v1.9.1")
+		assert.True(t, ok)
+		pushed, ok := registry.Blob("gilmanlab/talos-installer", digest)
+		require.True(t, ok)
+		assert.Equal(t, content, pushed)
+	})
+
+	t.Run("re-pushes when OCI artifact is missing or moved", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		content := []byte("image content")
+		digest := registry.PutBlob("upstream/image", content)
+
+		var saved *store.ImageMetadata
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+				return true, nil
+			},
+			getMetadataFunc: func(_ context.Context, _ string) (*store.ImageMetadata, error) {
+				if saved == nil {
+					return &store.ImageMetadata{}, nil
+				}
+				return saved, nil
+			},
+			putMetadataFunc: func(_ context.Context, _ string, metadata *store.ImageMetadata) error {
+				saved = metadata
+				return nil
+			},
+		}
+
+		img := config.Image{
+			Name:        "image",
+			Destination: "test/image.raw",
+			Source: config.Source{
+				URL:      "oci://" + registry.Host() + "/upstream/image@" + digest,
+				Checksum: digest,
+			},
+			OCI: &config.OCITarget{Reference: registry.Host() + "/mirror/image:v1"},
+		}
+
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(registry.Client()))
+		require.NoError(t, err)
+
+		manifest, ok := registry.Manifest("mirror/image", "v1")
+		require.True(t, ok)
+		require.NotNil(t, saved.OCI)
+		assert.Equal(t, img.OCI.Reference, saved.OCI.Reference)
+		assert.Equal(t, ocitest.Digest(manifest), saved.OCI.Digest)
+
+		// Second run skips because both e2 and the registry are current
+		client.uploadedKeys = nil
+		_, err = syncImage(context.Background(), client, img, newSyncOptions(registry.Client()))
+		require.NoError(t, err)
+		assert.Empty(t, client.uploadedKeys)
+
+		// Moving the tag to another manifest re-pushes
+		other := []byte("other content")
+		_, err = oci.NewClient(registry.Client(), oci.Credentials{}).PushArtifact(context.Background(),
+			oci.Reference{Registry: registry.Host(), Repository: "mirror/image", Tag: "v1"},
+			[]oci.Layer{{File: bytes.NewReader(other), Size: int64(len(other)), Title: "other"}})
+		require.NoError(t, err)
+
+		_, err = syncImage(context.Background(), client, img, newSyncOptions(registry.Client()))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"images/test/image.raw"}, client.uploadedKeys)
+		repushed, ok := registry.Manifest("mirror/image", "v1")
+		require.True(t, ok)
+		assert.Equal(t, manifest, repushed)
+	})
+
+	t.Run("pushes extracted members as layers of one manifest", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		kernel, initramfs := []byte("kernel image"), []byte("initial ramdisk")
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		for _, member := range []struct {
+			name    string
+			content []byte
+		}{{"vmlinuz", kernel}, {"initramfs", initramfs}} {
+			require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: member.name, Mode: 0o644, Size: int64(len(member.content))}))
+			_, err := tarWriter.Write(member.content)
+			require.NoError(t, err)
+		}
+		require.NoError(t, tarWriter.Close())
+		digest := registry.PutBlob("upstream/hook", buf.Bytes())
+
+		var saved []*store.ImageMetadata
+		client := &mockStoreClient{
+			putMetadataFunc: func(_ context.Context, _ string, metadata *store.ImageMetadata) error {
+				saved = append(saved, metadata)
+				return nil
+			},
+		}
+
+		img := config.Image{
+			Name: "hook",
+			Source: config.Source{
+				URL:      "oci://" + registry.Host() + "/upstream/hook@" + digest,
+				Checksum: digest,
+				Extract: &config.Extract{
+					Format: "tar",
+					Members: []config.ExtractMember{
+						{Path: "vmlinuz", Destination: "hook/vmlinuz-x86_64", Validation: &config.Validation{Algorithm: "sha256", Expected: ocitest.Digest(kernel)}},
+						{Path: "initramfs", Destination: "hook/initramfs-x86_64", Validation: &config.Validation{Algorithm: "sha256", Expected: ocitest.Digest(initramfs)}},
+					},
+				},
+			},
+			OCI: &config.OCITarget{Reference: registry.Host() + "/mirror/hook:v1"},
+		}
+
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(registry.Client()))
+		require.NoError(t, err)
+
+		data, ok := registry.Manifest("mirror/hook", "v1")
+		require.True(t, ok)
+		var manifest oci.Manifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		require.Len(t, manifest.Layers, 2)
+		assert.Equal(t, ocitest.Digest(kernel), manifest.Layers[0].Digest)
+		assert.Equal(t, "vmlinuz-x86_64", manifest.Layers[0].Annotations[oci.AnnotationTitle])
+		assert.Equal(t, ocitest.Digest(initramfs), manifest.Layers[1].Digest)
+		assert.Equal(t, "initramfs-x86_64", manifest.Layers[1].Annotations[oci.AnnotationTitle])
+
+		require.Len(t, saved, 2)
+		for _, metadata := range saved {
+			require.NotNil(t, metadata.OCI)
+			assert.Equal(t, ocitest.Digest(data), metadata.OCI.Digest)
+		}
+	})
+
+	t.Run("missing OCI blob", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		digest := ocitest.Digest([]byte("missing"))
+		img := config.Image{
+			Name:        "missing",
+			Destination: "test/missing.raw",
+			Source: config.Source{
+				URL:      "oci://" + registry.Host() + "/upstream/image@" + digest,
+				Checksum: digest,
+			},
+		}
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, img, newSyncOptions(registry.Client()))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "download")
+	})
+}
+
+func TestSyncImageWithGitHub(t *testing.T) {
+	content := []byte("vyos iso content")
+	h := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(h[:])
+
+	newServer := func(t *testing.T, assetDigest string) *httptest.Server {
+		t.Helper()
+		release := `{"tag_name": "2025.12.20-0020-rolling", "assets": [{
+  "name": "vyos-2025.12.20-0020-rolling-generic-amd64.iso",
+  "url": "%[1]s/repos/vyos/vyos-nightly-build/releases/assets/1",
+  "browser_download_url": "%[1]s/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso",
+  "digest": %[2]q
+}]}`
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repos/vyos/vyos-nightly-build/releases/latest", "/repos/vyos/vyos-nightly-build/releases/tags/2025.12.20-0020-rolling":
+				_, _ = fmt.Fprintf(w, release, server.URL, assetDigest)
+			case "/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso":
+				// Like a private asset, only served through the API with a token
+				if r.Header.Get("Authorization") != "" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = w.Write(content)
+			case "/repos/vyos/vyos-nightly-build/releases/assets/1":
+				if r.Header.Get("Authorization") != "Bearer private-token" || r.Header.Get("Accept") != "application/octet-stream" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = w.Write(content)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		return server
+	}
+	// githubOptions syncs through server for both the API and downloads
+	githubOptions := func(server *httptest.Server) *syncOptions {
+		opts := newSyncOptions(server.Client())
+		opts.githubBaseURL = server.URL
+		return opts
+	}
+
+	img := config.Image{
+		Name:        "vyos-iso",
+		Destination: "vyos/vyos.iso",
+		Source: config.Source{
+			GitHub: &config.GitHubSource{
+				Repo:  "vyos/vyos-nightly-build",
+				Tag:   "latest",
+				Asset: "*-generic-amd64.iso",
+			},
+		},
+	}
+
+	t.Run("uses asset digest as checksum", func(t *testing.T) {
+		t.Setenv("GITHUB_TOKEN", "")
+		server := newServer(t, digest)
+		defer server.Close()
+
+		var checkedChecksum string
+		var savedMetadata *store.ImageMetadata
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, checksum string) (bool, error) {
+				checkedChecksum = checksum
+				return false, nil
+			},
+			uploadFunc: func(_ context.Context, _ string, body io.Reader, _ int64) error {
+				_, err := io.Copy(io.Discard, body)
+				return err
+			},
+			putMetadataFunc: func(_ context.Context, _ string, metadata *store.ImageMetadata) error {
+				savedMetadata = metadata
+				return nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, img, githubOptions(server))
+		require.NoError(t, err)
+
+		assert.Equal(t, digest, checkedChecksum)
+		require.NotNil(t, savedMetadata)
+		assert.Equal(t, digest, savedMetadata.Checksum)
+		assert.Equal(t, "github", savedMetadata.Source.Type)
+		assert.Equal(t, server.URL+"/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso", savedMetadata.Source.URL)
+	})
+
+	t.Run("downloads private assets through the API with a token", func(t *testing.T) {
+		t.Setenv("GITHUB_TOKEN", "private-token")
+		server := newServer(t, digest)
+		defer server.Close()
+
+		var uploadedData []byte
+		var savedMetadata *store.ImageMetadata
+		client := &mockStoreClient{
+			uploadFunc: func(_ context.Context, key string, body io.Reader, _ int64) error {
+				if key != "images/vyos/vyos.iso" {
+					return nil
+				}
+				var err error
+				uploadedData, err = io.ReadAll(body)
+				return err
+			},
+			putMetadataFunc: func(_ context.Context, _ string, metadata *store.ImageMetadata) error {
+				savedMetadata = metadata
+				return nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, img, githubOptions(server))
+		require.NoError(t, err)
+		assert.Equal(t, content, uploadedData)
+
+		// The recorded URL is the same one an unauthenticated sync records
+		require.NotNil(t, savedMetadata)
+		assert.Equal(t, server.URL+"/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso", savedMetadata.Source.URL)
+	})
+
+	t.Run("manifest checksum must agree with asset digest", func(t *testing.T) {
+		t.Setenv("GITHUB_TOKEN", "")
+		server := newServer(t, digest)
+		defer server.Close()
+
+		pinned := img
+		pinned.Source.Checksum = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, pinned, githubOptions(server))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not match GitHub asset digest")
+	})
+
+	t.Run("asset without digest requires checksum", func(t *testing.T) {
+		t.Setenv("GITHUB_TOKEN", "")
+		server := newServer(t, "")
+		defer server.Close()
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, img, githubOptions(server))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "has no digest")
+	})
+}
+
+func TestSyncImageWithExtract(t *testing.T) {
+	computeChecksum := func(data []byte) string {
+		h := sha256.Sum256(data)
+		return "sha256:" + hex.EncodeToString(h[:])
+	}
+
+	kernel := []byte("kernel image")
+	initramfs := []byte("initial ramdisk")
+
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+	for name, content := range map[string][]byte{"vmlinuz-x86_64": kernel, "initramfs-x86_64": initramfs} {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tarWriter.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzWriter.Close())
+	bundle := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(bundle)
+	}))
+	defer server.Close()
+
+	newImage := func(kernelChecksum string) config.Image {
+		return config.Image{
+			Name: "hook",
+			Source: config.Source{
+				URL:      server.URL,
+				Checksum: computeChecksum(bundle),
+				Extract: &config.Extract{
+					Format: "tar.gz",
+					Members: []config.ExtractMember{
+						{Path: "vmlinuz-*", Destination: "hook/vmlinuz-x86_64", Validation: &config.Validation{Algorithm: "sha256", Expected: kernelChecksum}},
+						{Path: "initramfs-x86_64", Destination: "hook/initramfs-x86_64", Validation: &config.Validation{Algorithm: "sha256", Expected: computeChecksum(initramfs)}},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("uploads each member with its own metadata", func(t *testing.T) {
+		uploads := make(map[string][]byte)
+		metadata := make(map[string]*store.ImageMetadata)
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+				return false, nil
+			},
+			uploadFunc: func(_ context.Context, key string, body io.Reader, _ int64) error {
+				data, err := io.ReadAll(body)
+				uploads[key] = data
+				return err
+			},
+			putMetadataFunc: func(_ context.Context, imagePath string, m *store.ImageMetadata) error {
+				metadata[imagePath] = m
+				return nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, newImage(computeChecksum(kernel)), newSyncOptions(server.Client()))
+		require.NoError(t, err)
+
+		assert.Equal(t, kernel, uploads["images/hook/vmlinuz-x86_64"])
+		assert.Equal(t, initramfs, uploads["images/hook/initramfs-x86_64"])
+		require.Contains(t, metadata, "hook/vmlinuz-x86_64")
+		assert.Equal(t, computeChecksum(kernel), metadata["hook/vmlinuz-x86_64"].Checksum)
+		assert.Equal(t, int64(len(kernel)), metadata["hook/vmlinuz-x86_64"].Size)
+		assert.Equal(t, computeChecksum(initramfs), metadata["hook/initramfs-x86_64"].Checksum)
+	})
+
+	t.Run("skips when every member matches", func(t *testing.T) {
+		var checked []string
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, imagePath string, _ string) (bool, error) {
+				checked = append(checked, imagePath)
+				return true, nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, newImage(computeChecksum(kernel)), newSyncOptions(server.Client()))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"hook/vmlinuz-x86_64", "hook/initramfs-x86_64"}, checked)
+		assert.Empty(t, client.uploadedKeys)
+	})
+
+	t.Run("member checksum mismatch", func(t *testing.T) {
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+				return false, nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, newImage(computeChecksum([]byte("other"))), newSyncOptions(server.Client()))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "vmlinuz-x86_64 checksum verification")
+		assert.Empty(t, client.uploadedKeys)
+	})
+}
+
+func TestSyncImageWithTransform(t *testing.T) {
+	raw := bytes.Repeat([]byte("raw disk "), 20000)
+	sourceChecksum := "sha256:" + hex.EncodeToString(sha256Sum(raw))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(raw)
+	}))
+	defer server.Close()
+
+	var uploaded []byte
+	var savedMetadata *store.ImageMetadata
+	client := &mockStoreClient{
+		checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+			return false, nil
+		},
+		uploadFunc: func(_ context.Context, _ string, body io.Reader, _ int64) error {
+			var err error
+			uploaded, err = io.ReadAll(body)
+			return err
+		},
+		putMetadataFunc: func(_ context.Context, _ string, m *store.ImageMetadata) error {
+			savedMetadata = m
+			return nil
+		},
+	}
+
+	img := config.Image{
+		Name:        "vyos-qcow2",
+		Destination: "vyos/vyos.qcow2",
+		Source: config.Source{
+			URL:      server.URL,
+			Checksum: sourceChecksum,
+		},
+		Transform: &config.Transform{Format: "qcow2", Sparse: true},
+	}
+
+	_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
+	require.NoError(t, err)
+
+	assert.Equal(t, "QFI\xfb", string(uploaded[:4]))
+
+	// The source checksum stays the idempotency key; the converted file gets its own
+	require.NotNil(t, savedMetadata)
+	assert.Equal(t, sourceChecksum, savedMetadata.Checksum)
+	assert.Equal(t, int64(len(uploaded)), savedMetadata.Size)
+	require.NotNil(t, savedMetadata.Transform)
+	assert.Equal(t, "qcow2", savedMetadata.Transform.Format)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sha256Sum(uploaded)), savedMetadata.Transform.Checksum)
+}
+
+func TestSyncImageTransformChanges(t *testing.T) {
+	raw := bytes.Repeat([]byte("raw disk "), 20000)
+	sourceChecksum := "sha256:" + hex.EncodeToString(sha256Sum(raw))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(raw)
+	}))
+	defer server.Close()
+
+	qcow2 := &store.TransformMetadata{Format: "qcow2", Checksum: "sha256:converted"}
+	tests := []struct {
+		name      string
+		stored    *store.TransformMetadata
+		transform *config.Transform
+		wantSync  bool
+	}{
+		{name: "no transform before or after", wantSync: false},
+		{name: "transform added to a synced image", transform: &config.Transform{Format: "qcow2"}, wantSync: true},
+		{name: "transform removed", stored: qcow2, wantSync: true},
+		{name: "same transform", stored: qcow2, transform: &config.Transform{Format: "qcow2"}, wantSync: false},
+		{name: "sparse option changed", stored: qcow2, transform: &config.Transform{Format: "qcow2", Sparse: true}, wantSync: true},
+		{name: "converted checksum not recorded", stored: &store.TransformMetadata{Format: "qcow2"}, transform: &config.Transform{Format: "qcow2"}, wantSync: true},
+		{name: "already in the target format", transform: &config.Transform{Format: "raw"}, wantSync: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uploaded []byte
+			var saved *store.ImageMetadata
+			client := &mockStoreClient{
+				checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+					return true, nil
+				},
+				getMetadataFunc: func(_ context.Context, _ string) (*store.ImageMetadata, error) {
+					return &store.ImageMetadata{Checksum: sourceChecksum, Transform: tt.stored}, nil
+				},
+				uploadFunc: func(_ context.Context, _ string, body io.Reader, _ int64) error {
+					var err error
+					uploaded, err = io.ReadAll(body)
+					return err
+				},
+				putMetadataFunc: func(_ context.Context, _ string, m *store.ImageMetadata) error {
+					saved = m
+					return nil
+				},
+			}
+
+			img := config.Image{
+				Name:        "vyos",
+				Destination: "vyos/vyos.img",
+				Source:      config.Source{URL: server.URL, Checksum: sourceChecksum},
+				Transform:   tt.transform,
+			}
+
+			_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
+			require.NoError(t, err)
+			if !tt.wantSync {
+				assert.Empty(t, client.uploadedKeys)
+				return
+			}
+			assert.Equal(t, []string{"images/vyos/vyos.img"}, client.uploadedKeys)
+			require.NotNil(t, saved)
+			if tt.transform == nil {
+				assert.Nil(t, saved.Transform)
+				return
+			}
+			assert.Equal(t, tt.transform.Format, saved.Transform.Format)
+			assert.Equal(t, tt.transform.Sparse, saved.Transform.Sparse)
+			assert.Equal(t, "sha256:"+hex.EncodeToString(sha256Sum(uploaded)), saved.Transform.Checksum)
+			if tt.transform.Format == "raw" {
+				assert.Equal(t, raw, uploaded, "raw image is uploaded unchanged")
+			}
+		})
+	}
+}
+
+func sha256Sum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func TestSyncImageTransferLimits(t *testing.T) {
+	content := []byte("image inside the transfer window")
+	h := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(h[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	img := config.Image{
+		Name:        "windowed",
+		Destination: "test/windowed.iso",
+		Source:      config.Source{URL: server.URL, Checksum: checksum},
+	}
+
+	window := &config.TransferWindow{Start: "22:00", End: "06:00", Timezone: "UTC"}
+
+	t.Run("defers outside the window", func(t *testing.T) {
+		opts := newSyncOptions(server.Client())
+		opts.transferWindow = window
+		opts.clock = func() time.Time { return time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC) }
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+				return false, nil
+			},
+		}
+
+		changed, err := syncImage(context.Background(), client, img, opts)
+
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Empty(t, client.uploadedKeys)
+	})
+
+	t.Run("transfers inside the window through the limiters", func(t *testing.T) {
+		opts := newSyncOptions(server.Client())
+		opts.transferWindow = window
+		opts.clock = func() time.Time { return time.Date(2025, 1, 1, 23, 30, 0, 0, time.UTC) }
+		opts.downloadLimiter = ratelimit.NewLimiter(1 << 20)
+		opts.uploadLimiter = ratelimit.NewLimiter(1 << 20)
+
+		var uploaded []byte
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+				return false, nil
+			},
+			uploadFunc: func(_ context.Context, _ string, body io.Reader, _ int64) error {
+				var err error
+				uploaded, err = io.ReadAll(body)
+				return err
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, img, opts)
+
+		require.NoError(t, err)
+		assert.Equal(t, content, uploaded)
+	})
+}
+
+func TestSyncImageLockfile(t *testing.T) {
+	resolvedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	t.Setenv("GITHUB_TOKEN", "")
+
+	content := []byte("vyos iso content")
+	checksum := "sha256:" + hex.EncodeToString(sha256Sum(content))
+
+	var releaseLookups int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/vyos/vyos-nightly-build/releases/latest":
+			releaseLookups++
+			_, _ = fmt.Fprintf(w, `{"tag_name": "2025.12.20", "assets": [{
+  "name": "vyos-2025.12.20-generic-amd64.iso",
+  "url": "%[1]s/repos/vyos/vyos-nightly-build/releases/assets/1",
+  "browser_download_url": "%[1]s/download/vyos-2025.12.20.iso",
+  "digest": %[2]q
+}]}`, server.URL, checksum)
+		case "/download/vyos-2025.12.20.iso", "/download/vyos-2025.12.01.iso", "/test.iso":
+			_, _ = w.Write(content)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	// lockOptions syncs through server with a new lockfile
+	lockOptions := func(frozen bool) *syncOptions {
+		opts := newSyncOptions(server.Client())
+		opts.githubBaseURL = server.URL
+		opts.clock = func() time.Time { return resolvedAt }
+		opts.lock, opts.frozen = lock.New(), frozen
+		return opts
+	}
+
+	githubImage := config.Image{
+		Name:        "vyos-iso",
+		Destination: "vyos/vyos.iso",
+		Source: config.Source{
+			GitHub: &config.GitHubSource{Repo: "vyos/vyos-nightly-build", Tag: "latest", Asset: "*-generic-amd64.iso"},
+		},
+	}
+	urlImage := config.Image{
+		Name:        "test-image",
+		Destination: "test/test.iso",
+		Source:      config.Source{URL: server.URL + "/test.iso", Checksum: checksum},
+	}
+
+	t.Run("records the resolved source", func(t *testing.T) {
+		opts := lockOptions(false)
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, githubImage, opts)
+		require.NoError(t, err)
+
+		entry, ok := opts.lock.Get("vyos-iso")
+		require.True(t, ok)
+		assert.Equal(t, lock.Entry{
+			Name:       "vyos-iso",
+			URL:        server.URL + "/download/vyos-2025.12.20.iso",
+			Checksum:   checksum,
+			Size:       int64(len(content)),
+			ResolvedAt: resolvedAt,
+		}, entry)
+	})
+
+	t.Run("records skipped images with the size from stored metadata", func(t *testing.T) {
+		opts := lockOptions(false)
+		client := &mockStoreClient{
+			checksumMatchFunc: func(context.Context, string, string) (bool, error) { return true, nil },
+			getMetadataFunc: func(context.Context, string) (*store.ImageMetadata, error) {
+				return &store.ImageMetadata{Checksum: checksum, Size: int64(len(content))}, nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, urlImage, opts)
+		require.NoError(t, err)
+
+		entry, ok := opts.lock.Get("test-image")
+		require.True(t, ok)
+		assert.Equal(t, urlImage.Source.URL, entry.URL)
+		assert.Equal(t, int64(len(content)), entry.Size)
+	})
+
+	t.Run("records skipped images with the size of the source URL", func(t *testing.T) {
+		opts := lockOptions(false)
+		client := &mockStoreClient{
+			checksumMatchFunc: func(context.Context, string, string) (bool, error) { return true, nil },
+			// The stored size is that of the decompressed image, not the source
+			getMetadataFunc: func(context.Context, string) (*store.ImageMetadata, error) {
+				return &store.ImageMetadata{Checksum: checksum, Size: 1 << 20}, nil
+			},
+		}
+		decompressed := urlImage
+		decompressed.Source.Decompress = "xz"
+
+		_, err := syncImage(context.Background(), client, decompressed, opts)
+		require.NoError(t, err)
+
+		entry, ok := opts.lock.Get("test-image")
+		require.True(t, ok)
+		assert.Equal(t, int64(len(content)), entry.Size)
+	})
+
+	t.Run("frozen sync uses the pinned source without resolving it", func(t *testing.T) {
+		opts := lockOptions(true)
+		opts.lock.Set(lock.Entry{Name: "vyos-iso", URL: server.URL + "/download/vyos-2025.12.01.iso", Checksum: checksum})
+		releaseLookups = 0
+
+		var savedMetadata *store.ImageMetadata
+		client := &mockStoreClient{
+			putMetadataFunc: func(_ context.Context, _ string, metadata *store.ImageMetadata) error {
+				savedMetadata = metadata
+				return nil
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, githubImage, opts)
+		require.NoError(t, err)
+		assert.Zero(t, releaseLookups)
+		require.NotNil(t, savedMetadata)
+		assert.Equal(t, server.URL+"/download/vyos-2025.12.01.iso", savedMetadata.Source.URL)
+	})
+
+	t.Run("frozen sync refuses unpinned images", func(t *testing.T) {
+		opts := lockOptions(true)
+		client := &mockStoreClient{}
+
+		_, err := syncImage(context.Background(), client, urlImage, opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not pinned in lockfile")
+		assert.Empty(t, client.uploadedKeys)
+	})
+
+	t.Run("frozen sync refuses sources that differ from the lockfile", func(t *testing.T) {
+		opts := lockOptions(true)
+		opts.lock.Set(lock.Entry{Name: "test-image", URL: server.URL + "/old.iso", Checksum: checksum})
+
+		_, err := syncImage(context.Background(), &mockStoreClient{}, urlImage, opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "source differs from lockfile")
+	})
+}
+
+func TestDownloadSourceCache(t *testing.T) {
+	content := []byte("cacheable image")
+	h := sha256.Sum256(content)
+	src := config.Source{Checksum: "sha256:" + hex.EncodeToString(h[:])}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+	src.URL = server.URL
+
+	opts := newSyncOptions(server.Client())
+	var err error
+	opts.cache, err = cache.New(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		file, size, _, release, err := downloadSource(context.Background(), src, opts)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), size)
+
+		data, err := os.ReadFile(file.Name())
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+		release()
+	}
+
+	// Later downloads are served from the cache, and releasing a cached
+	// file leaves the entry in place
+	assert.Equal(t, 1, requests)
+	entries, err := opts.cache.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// A cached file corrupted on disk is downloaded again and replaced
+	require.NoError(t, os.WriteFile(entries[0].Path, []byte("corrupted image"), 0o600))
+	file, _, _, release, err := downloadSource(context.Background(), src, opts)
+	require.NoError(t, err)
+	data, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	release()
+	assert.Equal(t, 2, requests)
+
+	cached, _, ok, err := opts.cache.Get(src.Checksum)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_ = cached.Close()
+}
+
+func TestDownloadSourceMirrors(t *testing.T) {
+	content := []byte("mirrored image")
+	h := sha256.Sum256(content)
+	checksum := "sha256:" + hex.EncodeToString(h[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/corrupt":
+			_, _ = w.Write([]byte("wrong bytes"))
+		default:
+			_, _ = w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("falls back to the first working mirror", func(t *testing.T) {
+		src := config.Source{
+			URL:      server.URL + "/down",
+			Checksum: checksum,
+			Mirrors:  []string{server.URL + "/corrupt", server.URL + "/good", server.URL + "/unused"},
+		}
+
+		file, _, servedURL, release, err := downloadSource(context.Background(), src, newSyncOptions(server.Client()))
+		require.NoError(t, err)
+		defer release()
+		assert.NotNil(t, file)
+
+		assert.Equal(t, server.URL+"/good", servedURL)
+	})
+
+	t.Run("reports every failure when all URLs fail", func(t *testing.T) {
+		src := config.Source{
+			URL:      server.URL + "/down",
+			Checksum: checksum,
+			Mirrors:  []string{server.URL + "/corrupt"},
+		}
+
+		_, _, _, _, err := downloadSource(context.Background(), src, newSyncOptions(server.Client()))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "all 2 source URLs failed")
+		assert.Contains(t, err.Error(), "HTTP 503")
+		assert.Contains(t, err.Error(), "checksum mismatch")
+	})
+
+	t.Run("metadata records the serving mirror", func(t *testing.T) {
+		var savedMetadata *store.ImageMetadata
+		client := &mockStoreClient{
+			checksumMatchFunc: func(_ context.Context, _ string, _ string) (bool, error) {
+				return false, nil
+			},
+			putMetadataFunc: func(_ context.Context, _ string, m *store.ImageMetadata) error {
+				savedMetadata = m
+				return nil
+			},
+		}
+
+		img := config.Image{
+			Name:        "mirrored",
+			Destination: "test/mirrored.iso",
+			Source: config.Source{
+				URL:      server.URL + "/down",
+				Checksum: checksum,
+				Mirrors:  []string{server.URL + "/good"},
+			},
+		}
+
+		_, err := syncImage(context.Background(), client, img, newSyncOptions(server.Client()))
+		require.NoError(t, err)
+
+		require.NotNil(t, savedMetadata)
+		assert.Equal(t, server.URL+"/good", savedMetadata.Source.URL)
+		assert.Equal(t, "http", savedMetadata.Source.Type)
+	})
+}
+
 func TestRunSync(t *testing.T) {
 	// Save and restore globals
 	origDryRun := syncDryRun
 	origForce := syncForce
 	origManifest := syncManifest
+	origDownloadRate := syncMaxDownloadRate
 	defer func() {
 		syncDryRun = origDryRun
 		syncForce = origForce
 		syncManifest = origManifest
+		syncMaxDownloadRate = origDownloadRate
 	}()
 
 	t.Run("dry run mode shows what would be done", func(t *testing.T) {
@@ -631,7 +1862,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/test.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: test/test.iso
 `
 		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
@@ -645,6 +1876,30 @@ spec:
 		// because it never actually tries to create a client
 		err = runSync(nil, nil)
 		assert.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(dir, lock.FileName), "dry run must not write the lockfile")
+	})
+
+	t.Run("invalid download rate", func(t *testing.T) {
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "images.yaml")
+		manifest := `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  images: []
+`
+		require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o644)) //nolint:gosec
+
+		syncManifest = manifestPath
+		syncDryRun = true
+		syncMaxDownloadRate = "fast"
+		defer func() { syncMaxDownloadRate = "" }()
+
+		err := runSync(nil, nil)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--max-download-rate")
 	})
 
 	t.Run("manifest file not found", func(t *testing.T) {
@@ -4,11 +4,37 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
+	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+
 	"github.com/GilmanLab/lab/tools/labctl/internal/store"
 )
 
+// captureStdout returns what fn prints to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+
+	fn()
+	require.NoError(t, w.Close())
+	return string(<-done)
+}
+
 // mockStoreClient implements store.Client for testing.
 type mockStoreClient struct {
 	uploadFunc        func(ctx context.Context, key string, body io.Reader, size int64) error
@@ -19,6 +45,7 @@ type mockStoreClient struct {
 	getMetadataFunc   func(ctx context.Context, imagePath string) (*store.ImageMetadata, error)
 	putMetadataFunc   func(ctx context.Context, imagePath string, metadata *store.ImageMetadata) error
 	checksumMatchFunc func(ctx context.Context, imagePath, expectedChecksum string) (bool, error)
+	presignURLFunc    func(ctx context.Context, key string, expiry time.Duration) (string, error)
 	uploadedKeys      []string
 	deletedKeys       []string
 	putMetadataCalls  []*store.ImageMetadata
@@ -87,3 +114,10 @@ func (m *mockStoreClient) ChecksumMatches(ctx context.Context, imagePath, expect
 	}
 	return false, nil
 }
+
+func (m *mockStoreClient) PresignURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if m.presignURLFunc != nil {
+		return m.presignURLFunc(ctx, key, expiry)
+	}
+	return "https://e2.example.com/lab-images/" + key + "?X-Amz-Signature=test", nil
+}
@@ -2,10 +2,7 @@ package images
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -13,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/ratelimit"
 	"github.com/GilmanLab/lab/tools/labctl/internal/store"
 )
 
@@ -32,7 +30,9 @@ var (
 	uploadDestination    string
 	uploadCredentials    string
 	uploadSOPSAgeKeyFile string
+	uploadProfile        string
 	uploadName           string
+	uploadMaxRate        string
 )
 
 func init() {
@@ -40,19 +40,28 @@ func init() {
 	uploadCmd.Flags().StringVar(&uploadDestination, "destination", "", "Destination path in e2 bucket (required)")
 	uploadCmd.Flags().StringVar(&uploadCredentials, "credentials", "", "Path to SOPS-encrypted credentials file")
 	uploadCmd.Flags().StringVar(&uploadSOPSAgeKeyFile, "sops-age-key-file", "", "Path to age private key")
+	uploadCmd.Flags().StringVar(&uploadProfile, "profile", "", "Named credentials profile from the labctl config file (default: $LABCTL_PROFILE)")
 	uploadCmd.Flags().StringVar(&uploadName, "name", "", "Image name for metadata (defaults to destination filename)")
+	uploadCmd.Flags().StringVar(&uploadMaxRate, "max-upload-rate", "", "Limit upload bandwidth (e.g. 10MB/s, 512KiB/s)")
 
 	_ = uploadCmd.MarkFlagRequired("source")
 	_ = uploadCmd.MarkFlagRequired("destination")
+	uploadCmd.MarkFlagsMutuallyExclusive("credentials", "profile")
 }
 
 func runUpload(_ *cobra.Command, _ []string) error {
 	ctx := context.Background()
 
+	rate, err := ratelimit.ParseRate(uploadMaxRate)
+	if err != nil {
+		return fmt.Errorf("--max-upload-rate: %w", err)
+	}
+
 	// Resolve credentials
 	creds, err := credentials.Resolve(credentials.ResolveOptions{
 		SOPSFile:   uploadCredentials,
 		AgeKeyFile: uploadSOPSAgeKeyFile,
+		Profile:    uploadProfile,
 	})
 	if err != nil {
 		return fmt.Errorf("resolve credentials: %w", err)
@@ -64,12 +73,13 @@ func runUpload(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("create S3 client: %w", err)
 	}
 
-	return runUploadWithClient(ctx, client)
+	return runUploadWithClient(ctx, client, ratelimit.NewLimiter(rate))
 }
 
-// runUploadWithClient performs the upload using the provided store client.
+// runUploadWithClient performs the upload using the provided store client,
+// limited to the rate of limiter; a nil limiter is unlimited.
 // This function enables dependency injection for testing.
-func runUploadWithClient(ctx context.Context, client store.Client) error {
+func runUploadWithClient(ctx context.Context, client store.Client, limiter *ratelimit.Limiter) error {
 	// Get file info
 	info, err := os.Stat(uploadSource)
 	if err != nil {
@@ -94,7 +104,7 @@ func runUploadWithClient(ctx context.Context, client store.Client) error {
 	// Upload to e2
 	imageKey := store.ImageKey(uploadDestination)
 	fmt.Printf("Uploading to %s...\n", imageKey)
-	if err := client.Upload(ctx, imageKey, file, info.Size()); err != nil {
+	if err := client.Upload(ctx, imageKey, ratelimit.NewReader(ctx, file, limiter), info.Size()); err != nil {
 		return fmt.Errorf("upload image: %w", err)
 	}
 
@@ -136,10 +146,5 @@ func computeFileChecksum(path string) (string, error) {
 	}
 	defer func() { _ = file.Close() }()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-
-	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+	return sha256Checksum(file)
 }
@@ -11,7 +11,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/ratelimit"
 	"github.com/GilmanLab/lab/tools/labctl/internal/store"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store/s3test"
 )
 
 func TestComputeFileChecksum(t *testing.T) {
@@ -94,7 +97,7 @@ func TestRunUploadWithClient(t *testing.T) {
 
 		client := &mockStoreClient{}
 
-		err = runUploadWithClient(context.Background(), client)
+		err = runUploadWithClient(context.Background(), client, nil)
 
 		require.NoError(t, err)
 		assert.Len(t, client.uploadedKeys, 1)
@@ -120,7 +123,7 @@ func TestRunUploadWithClient(t *testing.T) {
 			},
 		}
 
-		err = runUploadWithClient(context.Background(), client)
+		err = runUploadWithClient(context.Background(), client, nil)
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "upload image")
@@ -142,7 +145,7 @@ func TestRunUploadWithClient(t *testing.T) {
 			},
 		}
 
-		err = runUploadWithClient(context.Background(), client)
+		err = runUploadWithClient(context.Background(), client, nil)
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "write metadata")
@@ -155,7 +158,7 @@ func TestRunUploadWithClient(t *testing.T) {
 
 		client := &mockStoreClient{}
 
-		err := runUploadWithClient(context.Background(), client)
+		err := runUploadWithClient(context.Background(), client, nil)
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "stat source file")
@@ -173,10 +176,38 @@ func TestRunUploadWithClient(t *testing.T) {
 
 		client := &mockStoreClient{}
 
-		err = runUploadWithClient(context.Background(), client)
+		err = runUploadWithClient(context.Background(), client, nil)
 
 		require.NoError(t, err)
 		assert.Len(t, client.putMetadataCalls, 1)
 		assert.Equal(t, "my-image", client.putMetadataCalls[0].Name) // Extension removed
 	})
+
+	t.Run("rate-limited upload to an S3 endpoint", func(t *testing.T) {
+		server := s3test.NewServer("access123", "secret456", "lab-images")
+		defer server.Close()
+		client, err := store.NewS3Client(&credentials.E2Credentials{
+			AccessKey: "access123",
+			SecretKey: "secret456",
+			Endpoint:  server.URL(),
+			Bucket:    "lab-images",
+		})
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		sourcePath := filepath.Join(dir, "test.iso")
+		content := []byte("test image content")
+		require.NoError(t, os.WriteFile(sourcePath, content, 0o600))
+		uploadSource = sourcePath
+		uploadDestination = "test/test.iso"
+		uploadName = ""
+
+		require.NoError(t, runUploadWithClient(context.Background(), client, ratelimit.NewLimiter(1<<30)))
+
+		got, ok := server.Object("lab-images", "images/test/test.iso")
+		require.True(t, ok)
+		assert.Equal(t, content, got)
+		_, ok = server.Object("lab-images", "metadata/test/test.iso.json")
+		assert.True(t, ok)
+	})
 }
@@ -1,31 +1,79 @@
 package images
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/GilmanLab/lab/tools/labctl/internal/bytesize"
 	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+	"github.com/GilmanLab/lab/tools/labctl/internal/github"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci"
+	"github.com/GilmanLab/lab/tools/labctl/internal/updater"
 )
 
 var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate the image manifest",
-	Long: `Validate manifest syntax, check source URLs, and verify updateFile regex patterns.
+	Long: `Validate manifest syntax, check source URLs, and verify updateFile replacements.
 
 The validate command performs a dry-run validation of the image manifest,
-checking that all URLs are reachable (via HEAD requests) and that regex
-patterns in updateFile sections compile successfully.`,
+checking that all URLs are reachable, that regex patterns in updateFile
+sections compile, and that structured paths parse.
+Every updateFile target must exist relative to the repository root. The
+replacements are applied to each target in memory: each must match at least
+once and meet its expectMatches, and validate reports whether the file would
+change. Templates see the same data a dry-run sync would, including
+spec.publish and the resolved GitHub release asset.
+Every source mirror is also checked; an image only fails when neither its
+URL nor any mirror is reachable.
+
+URLs are checked with a HEAD request, falling back to a GET for the first
+byte when the server rejects HEAD. Redirects are followed, and the size and
+last modification time are reported when the server provides them. Images
+are checked concurrently (--concurrency), each within --timeout. With --deep,
+the first reachable URL of each image is downloaded and verified against the
+source checksum, so a wrong checksum fails validation rather than sync.
+
+With --offline, source URLs are not checked and GitHub sources are not
+resolved, so file update previews see their unresolved URL and checksum.
+
+With --schema-only, each manifest file is only checked against the JSON Schema
+generated from the config types (see labctl images schema), without
+expanding vars or checking URLs.
+
+The manifest structure is always checked as a whole; --selector limits the
+URL and file update checks to the matching images.`,
 	RunE: runValidate,
 }
 
-var validateManifest string
+var (
+	validateManifest    string
+	validateSelector    string
+	validateSchemaOnly  bool
+	validateDeep        bool
+	validateTimeout     time.Duration
+	validateConcurrency int
+	validateOffline     bool
+)
 
 func init() {
-	validateCmd.Flags().StringVar(&validateManifest, "manifest", "./images/images.yaml", "Path to images.yaml")
+	validateCmd.Flags().StringVar(&validateManifest, "manifest", "./images/images.yaml", "Path to images.yaml or a directory of manifest files")
+	validateCmd.Flags().StringVar(&validateSelector, "selector", "", "Only check URLs of images matching this selector (e.g. group=talos)")
+	validateCmd.Flags().BoolVar(&validateSchemaOnly, "schema-only", false, "Only check manifest files against the JSON Schema")
+	validateCmd.Flags().BoolVar(&validateDeep, "deep", false, "Download each source and verify its checksum")
+	validateCmd.Flags().DurationVar(&validateTimeout, "timeout", 0, "Time limit for the URL checks of each image (default 1m, or 30m with --deep)")
+	validateCmd.Flags().IntVar(&validateConcurrency, "concurrency", 4, "Number of images to check at once")
+	validateCmd.Flags().BoolVar(&validateOffline, "offline", false, "Skip URL checks and GitHub release resolution")
 }
 
 // httpClient defines the HTTP operations used for URL validation.
@@ -34,25 +82,61 @@ type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-// defaultHTTPClient is the default HTTP client used for URL validation.
-var defaultHTTPClient httpClient = &http.Client{
-	Timeout: 30 * time.Second,
-}
+// defaultHTTPClient is the default HTTP client used for URL validation. It
+// has no overall timeout: each image's checks are bounded by --timeout
+// instead, so --deep can download large images.
+var defaultHTTPClient httpClient = &http.Client{}
+
+const (
+	// defaultURLTimeout bounds the URL checks of each image.
+	defaultURLTimeout = time.Minute
+
+	// defaultDeepTimeout bounds the checks of each image with --deep, which
+	// downloads the whole source.
+	defaultDeepTimeout = 30 * time.Minute
+)
 
 func runValidate(_ *cobra.Command, _ []string) error {
-	return runValidateWithClient(defaultHTTPClient)
+	root, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	return runValidateWithClient(defaultHTTPClient, root)
 }
 
-func runValidateWithClient(client httpClient) error {
+// runValidateWithClient validates the manifest, checking sources with client
+// and confining file updates to root; an empty root allows any path.
+func runValidateWithClient(client httpClient, root string) error {
 	fmt.Printf("Validating manifest: %s\n", validateManifest)
 
+	if validateSchemaOnly {
+		return validateSchema(validateManifest)
+	}
+	if validateConcurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if validateOffline && validateDeep {
+		return fmt.Errorf("--deep downloads every source and cannot be used with --offline")
+	}
+
 	// Load manifest without validation to collect all errors
 	manifest, err := config.LoadManifestRaw(validateManifest)
 	if err != nil {
 		return fmt.Errorf("load manifest: %w", err)
 	}
+	printWarnings(os.Stdout, manifest)
 
-	fmt.Printf("Found %d image(s)\n\n", len(manifest.Spec.Images))
+	sel, err := config.ParseSelector(validateSelector)
+	if err != nil {
+		return fmt.Errorf("--selector: %w", err)
+	}
+	images := manifest.Select(sel)
+
+	if sel.Empty() {
+		fmt.Printf("Found %d image(s)\n\n", len(manifest.Spec.Images))
+	} else {
+		fmt.Printf("Selected %d of %d image(s)\n\n", len(images), len(manifest.Spec.Images))
+	}
 
 	// Collect all errors
 	var allErrors []error
@@ -69,22 +153,48 @@ func runValidateWithClient(client httpClient) error {
 	}
 	fmt.Println()
 
-	// Check all source URLs via HEAD requests (only for images with valid URLs)
+	// Check all source URLs (only for images with valid URLs)
 	fmt.Println("Checking source URLs...")
-	for _, img := range manifest.Spec.Images {
-		// Skip URL check if the image doesn't have a valid URL
-		if img.Source.URL == "" || img.Name == "" {
+	var checked []config.Image
+	for _, img := range images {
+		if (img.Source.URL == "" && img.Source.GitHub == nil) || img.Name == "" || validateOffline {
 			continue
 		}
+		checked = append(checked, img)
+	}
+	if validateOffline {
+		fmt.Println("  Skipped (--offline)")
+	}
+	for i, check := range checkSources(client, checked) {
+		<-check.done
+		fmt.Print(check.output.String())
+		if check.err != nil {
+			allErrors = append(allErrors, fmt.Errorf("image %q URL check: %w", checked[i].Name, check.err))
+		}
+	}
 
-		fmt.Printf("  %s... ", img.Name)
+	// Simulate file updates, so a manifest whose targets or patterns went
+	// stale fails here instead of at sync time
+	fmt.Println()
+	fmt.Println("Checking file updates...")
+	for _, img := range images {
+		if len(img.FileUpdates()) == 0 {
+			continue
+		}
 
-		if err := checkURL(context.Background(), client, img.Source.URL); err != nil {
-			allErrors = append(allErrors, fmt.Errorf("image %q URL check: %w", img.Name, err))
-			fmt.Println("FAILED")
-			fmt.Printf("    Error: %v\n", err)
-		} else {
-			fmt.Println("OK")
+		fmt.Printf("  %s\n", img.Name)
+		if img.Source.GitHub != nil && !validateOffline {
+			src, _, err := resolveGitHubSource(context.Background(), client, github.DefaultBaseURL, img.Source)
+			if err != nil {
+				fmt.Printf("    source... FAILED\n")
+				fmt.Printf("      Error: %v\n", err)
+				allErrors = append(allErrors, fmt.Errorf("image %q file updates: %w", img.Name, err))
+				continue
+			}
+			img.Source = src
+		}
+		for _, err := range checkFileUpdates(img, manifest.Spec.Publish, root) {
+			allErrors = append(allErrors, fmt.Errorf("image %q file updates: %w", img.Name, err))
 		}
 	}
 
@@ -98,26 +208,385 @@ func runValidateWithClient(client httpClient) error {
 	return nil
 }
 
-func checkURL(ctx context.Context, client httpClient, url string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, http.NoBody)
+// checkFileUpdates applies img's file updates to every matched file in
+// memory, printing whether each would change, and returns an error for each
+// missing target, failed replacement, or pattern that matches nothing. Files
+// are confined to root. Templates see img as published under publish, as in
+// a dry-run sync, so img should already have its source resolved.
+func checkFileUpdates(img config.Image, publish *config.Publish, root string) []error {
+	data, err := templateData(context.Background(), nil, img, plannedImage(img, time.Now()), publish)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	fail := func(name string, err error) {
+		fmt.Printf("    %s... FAILED\n", name)
+		fmt.Printf("      Error: %v\n", err)
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+
+	for _, uf := range img.FileUpdates() {
+		targets, fileUpdater, err := newFileUpdater(uf, data, root)
+		if err != nil {
+			fail(uf.Path, err)
+			continue
+		}
+
+		for _, target := range targets {
+			name := displayPath(root, target)
+			sim, err := fileUpdater.Simulate(target)
+			if err != nil {
+				fail(name, err)
+				continue
+			}
+
+			var unmatched []error
+			for i, n := range sim.Matches {
+				if n == 0 {
+					unmatched = append(unmatched, fmt.Errorf("replacement[%d] %s matches nothing", i, describeReplacement(uf.Replacements[i])))
+				}
+			}
+			switch {
+			case len(unmatched) > 0:
+				fail(name, errors.Join(unmatched...))
+			case sim.Changed:
+				fmt.Printf("    %s... OK (would change)\n", name)
+			default:
+				fmt.Printf("    %s... OK (up to date)\n", name)
+			}
+		}
+	}
+	return errs
+}
+
+// describeReplacement returns the pattern or path of a replacement.
+func describeReplacement(r config.Replacement) string {
+	if r.Type == "" || r.Type == updater.TypeRegex {
+		return fmt.Sprintf("pattern %q", r.Pattern)
+	}
+	return fmt.Sprintf("%s path %s", r.Type, r.Path)
+}
+
+// sourceCheck is the result of checking one image's source URLs. done is
+// closed once output and err are set.
+type sourceCheck struct {
+	done   chan struct{}
+	output bytes.Buffer
+	err    error
+}
+
+// checkSources checks the sources of images concurrently, up to
+// validateConcurrency at a time. The returned checks are in image order, so
+// callers can print each as soon as it and those before it are done.
+func checkSources(client httpClient, images []config.Image) []*sourceCheck {
+	timeout := validateTimeout
+	if timeout == 0 {
+		timeout = defaultURLTimeout
+		if validateDeep {
+			timeout = defaultDeepTimeout
+		}
+	}
+
+	checks := make([]*sourceCheck, len(images))
+	sem := make(chan struct{}, validateConcurrency)
+	for i, img := range images {
+		check := &sourceCheck{done: make(chan struct{})}
+		checks[i] = check
+		go func() {
+			defer close(check.done)
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			check.err = checkImageSource(ctx, client, img, &check.output)
+			if check.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				check.err = fmt.Errorf("timed out after %s: %w", timeout, check.err)
+			}
+		}()
+	}
+	return checks
+}
+
+// checkImageSource checks the source URL and mirrors of img, writing a report
+// to w. The image only fails when neither its URL nor any mirror is reachable
+// or, with --deep, when the first reachable URL does not match the checksum.
+func checkImageSource(ctx context.Context, client httpClient, img config.Image, w io.Writer) error {
+	fmt.Fprintf(w, "  %s... ", img.Name)
+
+	info, err := checkSource(ctx, client, img.Source)
+	verify := img.Source
+	if err != nil {
+		fmt.Fprintln(w, "FAILED")
+		fmt.Fprintf(w, "    Error: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "OK%s\n", info)
+		if info.url != "" && info.url != img.Source.URL {
+			fmt.Fprintf(w, "    Redirected to: %s\n", info.url)
+		}
+	}
+
+	// Mirrors are fallbacks, so the image only fails when no URL is reachable
+	reachable := err == nil
+	for _, mirror := range img.Source.Mirrors {
+		mirrorInfo, mirrorErr := checkURL(ctx, client, mirror)
+		if mirrorErr != nil {
+			fmt.Fprintf(w, "    Mirror %s: FAILED (%v)\n", mirror, mirrorErr)
+			continue
+		}
+
+		fmt.Fprintf(w, "    Mirror %s: OK%s\n", mirror, mirrorInfo)
+		if !reachable {
+			verify = config.Source{URL: mirror, Checksum: img.Source.Checksum}
+		}
+		reachable = true
+	}
+
+	switch {
+	case reachable && err != nil:
+		fmt.Fprintf(w, "    Warning: source URL unreachable, sync will use a mirror\n")
+	case !reachable:
+		return err
+	}
+
+	if !validateDeep {
+		return nil
+	}
+	if err := verifySource(ctx, client, verify); err != nil {
+		fmt.Fprintf(w, "    Checksum: FAILED (%v)\n", err)
+		return fmt.Errorf("checksum: %w", err)
+	}
+	fmt.Fprintf(w, "    Checksum: OK\n")
+	return nil
+}
+
+// sourceInfo describes a reachable source, as far as the server reports it.
+type sourceInfo struct {
+	url          string    // Final URL after redirects
+	size         int64     // Content length; 0 when unknown
+	lastModified time.Time // Zero when unknown
+}
+
+// String formats the known details as a parenthesized suffix, or returns an
+// empty string when nothing is known.
+func (s *sourceInfo) String() string {
+	var details []string
+	if s.size > 0 {
+		details = append(details, bytesize.Format(s.size))
+	}
+	if !s.lastModified.IsZero() {
+		details = append(details, "modified "+s.lastModified.UTC().Format(time.DateOnly))
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
+
+// checkSource checks that a source is reachable, dispatching on the source type.
+// GitHub sources are checked by resolving the release asset through the API.
+func checkSource(ctx context.Context, client httpClient, src config.Source) (*sourceInfo, error) {
+	switch {
+	case src.GitHub != nil:
+		resolved, asset, err := resolveGitHubSource(ctx, client, github.DefaultBaseURL, src)
+		if err != nil {
+			return nil, err
+		}
+		return &sourceInfo{url: resolved.URL, size: asset.Size}, nil
+	case oci.IsOCI(src.URL):
+		return &sourceInfo{url: src.URL}, checkOCIBlob(ctx, client, src.URL)
+	default:
+		return checkURL(ctx, client, src.URL)
+	}
+}
+
+// verifySource downloads a source and verifies it against its checksum,
+// without keeping the download.
+func verifySource(ctx context.Context, client httpClient, src config.Source) error {
+	if src.GitHub != nil {
+		resolved, _, err := resolveGitHubSource(ctx, client, github.DefaultBaseURL, src)
+		if err != nil {
+			return err
+		}
+		src = resolved
+	}
+	if src.Checksum == "" {
+		return fmt.Errorf("no checksum to verify")
+	}
+
+	body, err := openSource(ctx, client, github.DefaultBaseURL, src)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return err
 	}
+	defer func() { _ = body.Close() }()
 
-	// Set a user agent to avoid being blocked by some servers
-	req.Header.Set("User-Agent", "labctl/1.0")
+	return verifyChecksum(body, src.Checksum)
+}
 
-	resp, err := client.Do(req)
+// checkOCIBlob checks that the registry has the blob named by an oci:// URL.
+func checkOCIBlob(ctx context.Context, client httpClient, url string) error {
+	ref, err := oci.ParseReference(url)
 	if err != nil {
-		return fmt.Errorf("HEAD request failed: %w", err)
+		return err
+	}
+
+	exists, err := oci.NewClient(client, oci.CredentialsFromEnv()).BlobExists(ctx, ref, ref.Digest)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("blob %s not found", ref.Digest)
+	}
+
+	return nil
+}
+
+// checkURL checks that url is reachable with a HEAD request. Some CDNs reject
+// HEAD, so when it fails with an HTTP status the check is retried with a GET
+// for the first byte.
+func checkURL(ctx context.Context, client httpClient, url string) (*sourceInfo, error) {
+	resp, finalURL, err := probeURL(ctx, client, http.MethodHead, url)
+	if err != nil {
+		return nil, fmt.Errorf("HEAD request failed: %w", err)
+	}
+
+	if !success(resp) {
+		_ = resp.Body.Close()
+		headStatus := resp.StatusCode
+
+		resp, finalURL, err = probeURL(ctx, client, http.MethodGet, url)
+		if err != nil {
+			return nil, fmt.Errorf("HEAD returned HTTP %d, ranged GET failed: %w", headStatus, err)
+		}
+		if !success(resp) {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+		}
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	// Accept 2xx and 3xx status codes as success
-	// Some servers return 302/301 for downloads
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	info := &sourceInfo{url: finalURL, size: resp.ContentLength}
+	if total, ok := rangeTotal(resp); ok {
+		info.size = total
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.lastModified = modified
 	}
+	return info, nil
+}
 
+// maxRedirects is the number of redirects probeURL follows, matching net/http.
+const maxRedirects = 10
+
+// probeURL sends a HEAD request, or a GET for the first byte, and follows
+// redirects itself so that clients which return them, rather than following
+// them, are handled too. It returns the response and the URL that served it.
+func probeURL(ctx context.Context, client httpClient, method, url string) (*http.Response, string, error) {
+	for range maxRedirects + 1 {
+		req, err := http.NewRequestWithContext(ctx, method, url, http.NoBody)
+		if err != nil {
+			return nil, "", fmt.Errorf("create request: %w", err)
+		}
+
+		// Set a user agent to avoid being blocked by some servers
+		req.Header.Set("User-Agent", "labctl/1.0")
+		if method == http.MethodGet {
+			req.Header.Set("Range", "bytes=0-0")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		if resp.Request != nil {
+			url = resp.Request.URL.String()
+		}
+
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return resp, url, nil
+		}
+
+		_ = resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return nil, "", fmt.Errorf("HTTP %d redirect without a Location header", resp.StatusCode)
+		}
+		next, err := req.URL.Parse(location)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid redirect Location %q: %w", location, err)
+		}
+		url = next.String()
+	}
+
+	return nil, "", fmt.Errorf("stopped after %d redirects", maxRedirects)
+}
+
+// success reports whether resp has a 2xx status.
+func success(resp *http.Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// rangeTotal returns the complete length from the Content-Range header of a
+// partial response, such as "bytes 0-0/1234".
+func rangeTotal(resp *http.Response) (int64, bool) {
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, false
+	}
+
+	contentRange := resp.Header.Get("Content-Range")
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, false
+	}
+
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
+
+// validateSchema checks every manifest file against the generated JSON Schema.
+func validateSchema(manifestPath string) error {
+	files, err := config.ManifestFiles(manifestPath)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+
+	schema := config.GenerateSchema()
+	var errCount int
+
+	fmt.Println("Checking manifest files against schema...")
+	for _, file := range files {
+		data, err := os.ReadFile(file) //nolint:gosec // G304: Path is provided by user or a manifest include
+		if err != nil {
+			return fmt.Errorf("read manifest file: %w", err)
+		}
+
+		errs, err := config.ValidateSchema(schema, file, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, err := range errs {
+			fmt.Printf("  ERROR: %v\n", err)
+		}
+		if len(errs) == 0 {
+			fmt.Printf("  %s: OK\n", file)
+		}
+		errCount += len(errs)
+	}
+
+	fmt.Println()
+	if errCount > 0 {
+		fmt.Printf("Validation failed with %d error(s)\n", errCount)
+		return fmt.Errorf("validation failed with %d error(s)", errCount)
+	}
+
+	fmt.Println("All validations passed")
 	return nil
 }
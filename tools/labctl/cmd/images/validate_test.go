@@ -2,15 +2,25 @@ package images
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/config"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci/ocitest"
 )
 
 // mockHTTPClient implements httpClient for testing.
@@ -35,6 +45,22 @@ func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	}, nil
 }
 
+// serverClient sends every request to server, whatever its host, so code
+// that talks to the public GitHub API can be pointed at a test server.
+type serverClient struct {
+	server *httptest.Server
+}
+
+func (c serverClient) Do(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(c.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = target.Scheme, target.Host, ""
+	return c.server.Client().Do(req)
+}
+
 func TestRunValidateWithClient(t *testing.T) {
 	// Save and restore the global validateManifest
 	origManifest := validateManifest
@@ -53,7 +79,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/test.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: test/test.iso
 `
 		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
@@ -70,7 +96,7 @@ spec:
 			},
 		}
 
-		err = runValidateWithClient(client)
+		err = runValidateWithClient(client, "")
 		assert.NoError(t, err)
 	})
 
@@ -88,7 +114,7 @@ spec:
     - name: bad-image-1
       source:
         url: http://insecure.com/test.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: test/test1.iso
     - name: bad-image-2
       source:
@@ -102,7 +128,7 @@ spec:
 		validateManifest = manifestPath
 		client := &mockHTTPClient{}
 
-		err = runValidateWithClient(client)
+		err = runValidateWithClient(client, "")
 		assert.Error(t, err)
 		// Should report multiple errors
 		assert.Contains(t, err.Error(), "2 error(s)")
@@ -121,7 +147,7 @@ spec:
     - name: unreachable-image
       source:
         url: https://unreachable.example.com/test.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: test/test.iso
 `
 		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
@@ -138,7 +164,82 @@ spec:
 			},
 		}
 
-		err = runValidateWithClient(client)
+		err = runValidateWithClient(client, "")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "1 error(s)")
+	})
+
+	t.Run("unreachable source with reachable mirror", func(t *testing.T) {
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "images.yaml")
+
+		manifest := `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  images:
+    - name: mirrored-image
+      source:
+        url: https://primary.example.com/test.iso
+        mirrors:
+          - https://dead-mirror.example.com/test.iso
+          - https://mirror.example.com/test.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: test/test.iso
+`
+		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
+		require.NoError(t, err)
+
+		validateManifest = manifestPath
+		notFound := func() *http.Response {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Status:     "404 Not Found",
+				Body:       io.NopCloser(strings.NewReader("")),
+			}
+		}
+		client := &mockHTTPClient{
+			responses: map[string]*http.Response{
+				"https://primary.example.com/test.iso":     notFound(),
+				"https://dead-mirror.example.com/test.iso": notFound(),
+			},
+		}
+
+		err = runValidateWithClient(client, "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("source and all mirrors unreachable", func(t *testing.T) {
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "images.yaml")
+
+		manifest := `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  images:
+    - name: mirrored-image
+      source:
+        url: https://primary.example.com/test.iso
+        mirrors:
+          - https://mirror.example.com/test.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: test/test.iso
+`
+		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
+		require.NoError(t, err)
+
+		validateManifest = manifestPath
+		client := &mockHTTPClient{
+			errors: map[string]error{
+				"https://primary.example.com/test.iso": errors.New("connection refused"),
+				"https://mirror.example.com/test.iso":  errors.New("connection refused"),
+			},
+		}
+
+		err = runValidateWithClient(client, "")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "1 error(s)")
 	})
@@ -147,7 +248,7 @@ spec:
 		validateManifest = "/nonexistent/path/images.yaml"
 		client := &mockHTTPClient{}
 
-		err := runValidateWithClient(client)
+		err := runValidateWithClient(client, "")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "load manifest")
 	})
@@ -162,7 +263,7 @@ spec:
 		validateManifest = manifestPath
 		client := &mockHTTPClient{}
 
-		err = runValidateWithClient(client)
+		err = runValidateWithClient(client, "")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "load manifest")
 	})
@@ -181,12 +282,12 @@ spec:
     - name: http-image
       source:
         url: http://insecure.com/test.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: test/test1.iso
     - name: unreachable-image
       source:
         url: https://unreachable.example.com/test.iso
-        checksum: sha256:def456
+        checksum: sha256:def4560000000000000000000000000000000000000000000000000000000000
       destination: test/test2.iso
 `
 		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
@@ -203,11 +304,242 @@ spec:
 			},
 		}
 
-		err = runValidateWithClient(client)
+		err = runValidateWithClient(client, "")
 		assert.Error(t, err)
 		// Should report 2 errors: http URL + unreachable URL
 		assert.Contains(t, err.Error(), "2 error(s)")
 	})
+	t.Run("schema only", func(t *testing.T) {
+		origSchemaOnly := validateSchemaOnly
+		defer func() { validateSchemaOnly = origSchemaOnly }()
+
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "images.yaml")
+
+		// Schema violations only; the unreachable URL is never checked
+		manifest := `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  images:
+    - name: bad-image
+      source:
+        url: https://unreachable.example.com/test.iso
+        checksum: sha256:abc123
+        decompress: bz2
+      destination: test/test.iso
+      extra: true
+`
+		err := os.WriteFile(manifestPath, []byte(manifest), 0o644) //nolint:gosec
+		require.NoError(t, err)
+
+		validateManifest = manifestPath
+		validateSchemaOnly = true
+		client := &mockHTTPClient{
+			errors: map[string]error{"https://unreachable.example.com/test.iso": errors.New("should not be called")},
+		}
+
+		err = runValidateWithClient(client, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "3 error(s)")
+	})
+
+	t.Run("replacement expectations", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "talos.yaml")
+		require.NoError(t, os.WriteFile(target, []byte("image: talos-1.9.0\ninstaller: talos-1.9.0\n"), 0o644)) //nolint:gosec // G306: test file
+
+		write := func(t *testing.T, replacements string) {
+			t.Helper()
+			manifest := `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  images:
+    - name: talos-1.9.1
+      source:
+        url: https://example.com/talos.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: talos/talos.iso
+      updateFiles:
+        - path: ` + filepath.Join(dir, "*.yaml") + `
+          replacements:
+` + replacements
+			manifestPath := filepath.Join(dir, "manifest", "images.yaml")
+			require.NoError(t, os.MkdirAll(filepath.Dir(manifestPath), 0o750))
+			require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o644)) //nolint:gosec // G306: test file
+			validateManifest = manifestPath
+		}
+
+		t.Run("met", func(t *testing.T) {
+			write(t, `            - pattern: 'talos-[0-9.]+'
+              value: '{{ .Name }}'
+              expectMatches: 2
+`)
+			assert.NoError(t, runValidateWithClient(&mockHTTPClient{}, ""))
+		})
+
+		t.Run("violated", func(t *testing.T) {
+			write(t, `            - pattern: 'talos-[0-9.]+'
+              value: '{{ .Name }}'
+              expectMatches: 1
+            - pattern: 'kernel: .*'
+              value: 'kernel: $1'
+              required: true
+`)
+			err := runValidateWithClient(&mockHTTPClient{}, "")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "1 error(s)")
+		})
+
+		t.Run("pattern matches nothing", func(t *testing.T) {
+			write(t, `            - pattern: 'talos-[0-9.]+'
+              value: '{{ .Name }}'
+            - pattern: 'kernel: .*'
+              value: 'kernel: 6.12'
+`)
+			var err error
+			output := captureStdout(t, func() { err = runValidateWithClient(&mockHTTPClient{}, "") })
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "1 error(s)")
+			assert.Contains(t, output, `replacement[1] pattern "kernel: .*" matches nothing`)
+		})
+
+		t.Run("reports pending changes", func(t *testing.T) {
+			write(t, `            - pattern: 'image: talos-[0-9.]+'
+              value: 'image: {{ .Name }}'
+            - pattern: 'installer: talos-[0-9.]+'
+              value: 'installer: talos-1.9.0'
+`)
+			var err error
+			output := captureStdout(t, func() { err = runValidateWithClient(&mockHTTPClient{}, "") })
+			require.NoError(t, err)
+			assert.Contains(t, output, "talos.yaml... OK (would change)")
+
+			got, err := os.ReadFile(target) //nolint:gosec // G304: test file from t.TempDir()
+			require.NoError(t, err)
+			assert.Equal(t, "image: talos-1.9.0\ninstaller: talos-1.9.0\n", string(got))
+		})
+
+		t.Run("reports up to date files", func(t *testing.T) {
+			write(t, `            - pattern: 'talos-[0-9.]+'
+              value: 'talos-1.9.0'
+`)
+			var err error
+			output := captureStdout(t, func() { err = runValidateWithClient(&mockHTTPClient{}, "") })
+			require.NoError(t, err)
+			assert.Contains(t, output, "talos.yaml... OK (up to date)")
+		})
+	})
+
+	t.Run("file update preview matches what sync writes", func(t *testing.T) {
+		content := []byte("vyos iso content")
+		h := sha256.Sum256(content)
+		digest := "sha256:" + hex.EncodeToString(h[:])
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repos/vyos/vyos-nightly-build/releases/latest":
+				_, _ = fmt.Fprintf(w, `{"tag_name": "2025.12.20", "assets": [{
+  "name": "vyos-2025.12.20-generic-amd64.iso",
+  "browser_download_url": "%[1]s/vyos/vyos-nightly-build/releases/download/2025.12.20/vyos-2025.12.20-generic-amd64.iso",
+  "digest": %[2]q
+}]}`, server.URL, digest)
+			case "/vyos/vyos-nightly-build/releases/download/2025.12.20/vyos-2025.12.20-generic-amd64.iso":
+				_, _ = w.Write(content)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		t.Setenv("GITHUB_TOKEN", "")
+
+		dir := t.TempDir()
+		target := filepath.Join(dir, "vyos.txt")
+		require.NoError(t, os.WriteFile(target, []byte("placeholder"), 0o644)) //nolint:gosec // G306: test file
+		manifestPath := filepath.Join(dir, "images.yaml")
+		manifest := `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  publish:
+    baseURL: https://images.example.com/
+  images:
+    - name: vyos-iso
+      source:
+        github:
+          repo: vyos/vyos-nightly-build
+          tag: latest
+          asset: vyos-*-generic-amd64.iso
+      destination: vyos/vyos.iso
+      updateFile:
+        path: ` + target + `
+        replacements:
+          - pattern: '^.*$'
+            value: '{{ .URL }} {{ .Source.URL }} {{ .Validation }}'
+`
+		require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o644)) //nolint:gosec // G306: test file
+		validateManifest = manifestPath
+
+		loaded, err := config.LoadManifest(manifestPath)
+		require.NoError(t, err)
+		opts := newSyncOptions(server.Client())
+		opts.githubBaseURL = server.URL
+		opts.publish = loaded.Spec.Publish
+		changed, err := syncImage(context.Background(), &mockStoreClient{}, loaded.Spec.Images[0], opts)
+		require.NoError(t, err)
+		require.True(t, changed)
+		got, err := os.ReadFile(target) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		assert.Equal(t, "https://images.example.com/vyos/vyos.iso "+server.URL+
+			"/vyos/vyos-nightly-build/releases/download/2025.12.20/vyos-2025.12.20-generic-amd64.iso "+digest, string(got))
+
+		output := captureStdout(t, func() { err = runValidateWithClient(serverClient{server}, "") })
+		require.NoError(t, err)
+		assert.Contains(t, output, "vyos.txt... OK (up to date)")
+
+		// Offline, the source is not resolved, so the preview differs
+		origOffline := validateOffline
+		defer func() { validateOffline = origOffline }()
+		validateOffline = true
+		output = captureStdout(t, func() { err = runValidateWithClient(serverClient{server}, "") })
+		require.NoError(t, err)
+		assert.Contains(t, output, "vyos.txt... OK (would change)")
+	})
+
+	t.Run("missing updateFile target", func(t *testing.T) {
+		dir := t.TempDir()
+		manifestPath := filepath.Join(dir, "images.yaml")
+		manifest := `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: test-images
+spec:
+  images:
+    - name: talos-1.9.1
+      source:
+        url: https://example.com/talos.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: talos/talos.iso
+      updateFile:
+        path: ` + filepath.Join(dir, "missing.yaml") + `
+        replacements:
+          - pattern: 'talos-[0-9.]+'
+            value: '{{ .Name }}'
+`
+		require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o644)) //nolint:gosec // G306: test file
+		validateManifest = manifestPath
+
+		var err error
+		output := captureStdout(t, func() { err = runValidateWithClient(&mockHTTPClient{}, "") })
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 error(s)")
+		assert.Contains(t, output, "matches no files")
+	})
 }
 
 func TestCheckURL(t *testing.T) {
@@ -222,23 +554,85 @@ func TestCheckURL(t *testing.T) {
 			},
 		}
 
-		err := checkURL(context.Background(), client, "https://example.com/test.iso")
+		_, err := checkURL(context.Background(), client, "https://example.com/test.iso")
 		assert.NoError(t, err)
 	})
 
-	t.Run("redirect is acceptable", func(t *testing.T) {
+	t.Run("follows redirects", func(t *testing.T) {
 		client := &mockHTTPClient{
 			responses: map[string]*http.Response{
 				"https://example.com/redirect": {
 					StatusCode: http.StatusFound,
 					Status:     "302 Found",
+					Header:     http.Header{"Location": []string{"/download/test.iso"}},
 					Body:       io.NopCloser(strings.NewReader("")),
 				},
+				"https://example.com/download/test.iso": {
+					StatusCode:    http.StatusOK,
+					Status:        "200 OK",
+					ContentLength: 2048,
+					Header:        http.Header{"Last-Modified": []string{"Thu, 02 Jan 2025 15:04:05 GMT"}},
+					Body:          io.NopCloser(strings.NewReader("")),
+				},
 			},
 		}
 
-		err := checkURL(context.Background(), client, "https://example.com/redirect")
-		assert.NoError(t, err)
+		info, err := checkURL(context.Background(), client, "https://example.com/redirect")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/download/test.iso", info.url)
+		assert.Equal(t, " (2.00 KB, modified 2025-01-02)", info.String())
+	})
+
+	t.Run("redirect without Location fails", func(t *testing.T) {
+		client := &mockHTTPClient{
+			responses: map[string]*http.Response{
+				"https://example.com/redirect": {
+					StatusCode: http.StatusFound,
+					Status:     "302 Found",
+					Body:       io.NopCloser(strings.NewReader("")),
+				},
+			},
+		}
+
+		_, err := checkURL(context.Background(), client, "https://example.com/redirect")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "without a Location header")
+	})
+
+	t.Run("falls back to ranged GET when HEAD is rejected", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			assert.Equal(t, "bytes=0-0", r.Header.Get("Range"))
+			w.Header().Set("Content-Range", "bytes 0-0/5242880")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("x"))
+		}))
+		defer server.Close()
+
+		info, err := checkURL(context.Background(), server.Client(), server.URL+"/test.iso")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5242880), info.size)
+		assert.Equal(t, server.URL+"/test.iso", info.url)
+	})
+
+	t.Run("reports redirects followed by the client", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/old.iso", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/new.iso", http.StatusMovedPermanently)
+		})
+		mux.HandleFunc("/new.iso", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Length", "10")
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		info, err := checkURL(context.Background(), server.Client(), server.URL+"/old.iso")
+		require.NoError(t, err)
+		assert.Equal(t, server.URL+"/new.iso", info.url)
+		assert.Equal(t, int64(10), info.size)
 	})
 
 	t.Run("404 returns error", func(t *testing.T) {
@@ -252,7 +646,7 @@ func TestCheckURL(t *testing.T) {
 			},
 		}
 
-		err := checkURL(context.Background(), client, "https://example.com/notfound")
+		_, err := checkURL(context.Background(), client, "https://example.com/notfound")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "404")
 	})
@@ -268,7 +662,7 @@ func TestCheckURL(t *testing.T) {
 			},
 		}
 
-		err := checkURL(context.Background(), client, "https://example.com/error")
+		_, err := checkURL(context.Background(), client, "https://example.com/error")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "500")
 	})
@@ -280,8 +674,133 @@ func TestCheckURL(t *testing.T) {
 			},
 		}
 
-		err := checkURL(context.Background(), client, "https://example.com/network-error")
+		_, err := checkURL(context.Background(), client, "https://example.com/network-error")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "HEAD request failed")
 	})
 }
+
+func TestCheckSource(t *testing.T) {
+	t.Run("OCI blob exists", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		digest := registry.PutBlob("org/repo", []byte("content"))
+
+		_, err := checkSource(context.Background(), registry.Client(), config.Source{URL: "oci://" + registry.Host() + "/org/repo@" + digest})
+		assert.NoError(t, err)
+	})
+
+	t.Run("OCI blob missing", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		digest := ocitest.Digest([]byte("missing"))
+
+		_, err := checkSource(context.Background(), registry.Client(), config.Source{URL: "oci://" + registry.Host() + "/org/repo@" + digest})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not found")
+	})
+
+	t.Run("HTTPS URL uses HEAD check", func(t *testing.T) {
+		client := &mockHTTPClient{}
+
+		_, err := checkSource(context.Background(), client, config.Source{URL: "https://example.com/test.iso"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestVerifySource(t *testing.T) {
+	content := []byte("image content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	t.Run("checksum matches", func(t *testing.T) {
+		err := verifySource(context.Background(), server.Client(), config.Source{URL: server.URL, Checksum: ocitest.Digest(content)})
+		assert.NoError(t, err)
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		err := verifySource(context.Background(), server.Client(), config.Source{URL: server.URL, Checksum: ocitest.Digest([]byte("other"))})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksum mismatch")
+	})
+
+	t.Run("no checksum", func(t *testing.T) {
+		err := verifySource(context.Background(), server.Client(), config.Source{URL: server.URL})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no checksum")
+	})
+}
+
+func TestCheckSources(t *testing.T) {
+	origDeep, origTimeout, origConcurrency := validateDeep, validateTimeout, validateConcurrency
+	defer func() {
+		validateDeep, validateTimeout, validateConcurrency = origDeep, origTimeout, origConcurrency
+	}()
+
+	content := []byte("image content")
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow.iso", func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/good.iso", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(content)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer close(release)
+
+	image := func(name, path, checksum string, mirrors ...string) config.Image {
+		return config.Image{Name: name, Source: config.Source{URL: server.URL + path, Checksum: checksum, Mirrors: mirrors}}
+	}
+
+	t.Run("times out per image and keeps order", func(t *testing.T) {
+		validateDeep, validateTimeout, validateConcurrency = false, 100*time.Millisecond, 2
+
+		images := []config.Image{
+			image("slow", "/slow.iso", ocitest.Digest(content)),
+			image("good", "/good.iso", ocitest.Digest(content)),
+		}
+		checks := checkSources(server.Client(), images)
+		for _, check := range checks {
+			<-check.done
+		}
+
+		require.Error(t, checks[0].err)
+		assert.Contains(t, checks[0].err.Error(), "timed out after 100ms")
+		assert.True(t, strings.HasPrefix(checks[0].output.String(), "  slow... FAILED"))
+		assert.NoError(t, checks[1].err)
+		assert.Equal(t, "  good... OK (13 B)\n", checks[1].output.String())
+	})
+
+	t.Run("deep verifies the first reachable URL", func(t *testing.T) {
+		validateDeep, validateTimeout, validateConcurrency = true, 5*time.Second, 4
+
+		images := []config.Image{
+			image("good", "/good.iso", ocitest.Digest(content)),
+			image("wrong", "/good.iso", ocitest.Digest([]byte("other"))),
+			image("mirrored", "/missing.iso", ocitest.Digest(content), server.URL+"/good.iso"),
+		}
+		checks := checkSources(server.Client(), images)
+		for _, check := range checks {
+			<-check.done
+		}
+
+		assert.NoError(t, checks[0].err)
+		assert.Contains(t, checks[0].output.String(), "Checksum: OK")
+
+		require.Error(t, checks[1].err)
+		assert.Contains(t, checks[1].err.Error(), "checksum mismatch")
+
+		assert.NoError(t, checks[2].err)
+		assert.Contains(t, checks[2].output.String(), "sync will use a mirror")
+		assert.Contains(t, checks[2].output.String(), "Checksum: OK")
+	})
+}
@@ -4,6 +4,8 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/GilmanLab/lab/tools/labctl/cmd/cache"
+	"github.com/GilmanLab/lab/tools/labctl/cmd/credentials"
 	"github.com/GilmanLab/lab/tools/labctl/cmd/images"
 )
 
@@ -15,6 +17,8 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(images.Cmd)
+	rootCmd.AddCommand(cache.Cmd)
+	rootCmd.AddCommand(credentials.Cmd)
 }
 
 // Execute runs the root command.
@@ -0,0 +1,241 @@
+// Package archive extracts files from archive sources for the image pipeline.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Formats lists the supported archive formats.
+var Formats = []string{"tar", "tar.gz", "tar.xz", "tar.zst", "zip", "iso"}
+
+// maxMemberSize limits each extracted member to 50GB to prevent decompression bombs.
+const maxMemberSize = 50 * 1024 * 1024 * 1024
+
+// Member is an archive entry extracted to a temp file.
+type Member struct {
+	// Pattern is the path or glob that selected this entry.
+	Pattern string
+	// Name is the entry's path inside the archive.
+	Name string
+	// File holds the extracted contents. The caller must close and remove it.
+	File *os.File
+	// Size is the number of bytes extracted.
+	Size int64
+}
+
+// IsSupported reports whether format is a supported archive format.
+func IsSupported(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract extracts the entries selected by patterns from the archive file.
+// Each pattern is a path or path.Match glob that must match exactly one
+// regular file. Members are returned in pattern order.
+func Extract(archive *os.File, format string, patterns []string) ([]Member, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid member pattern %q: %w", p, err)
+		}
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek archive: %w", err)
+	}
+
+	m := &matcher{patterns: patterns, found: make([]*Member, len(patterns))}
+
+	var err error
+	switch format {
+	case "tar", "tar.gz", "tar.xz", "tar.zst":
+		err = extractTar(archive, strings.TrimPrefix(strings.TrimPrefix(format, "tar"), "."), m)
+	case "zip":
+		err = extractZip(archive, m)
+	case "iso":
+		err = extractISO(archive, m)
+	default:
+		err = fmt.Errorf("unsupported archive format: %s", format)
+	}
+	if err != nil {
+		m.cleanup()
+		return nil, err
+	}
+
+	members := make([]Member, len(patterns))
+	for i, found := range m.found {
+		if found == nil {
+			m.cleanup()
+			return nil, fmt.Errorf("no archive entry matches %q", patterns[i])
+		}
+		members[i] = *found
+	}
+
+	return members, nil
+}
+
+// Decompressor wraps r with a reader for the given codec (xz, gzip or zstd).
+// The returned cleanup function releases decoder resources and is never nil.
+func Decompressor(r io.Reader, codec string) (io.Reader, func(), error) {
+	switch codec {
+	case "xz":
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create xz reader: %w", err)
+		}
+		return xzReader, func() {}, nil
+	case "gzip", "gz":
+		gzReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create gzip reader: %w", err)
+		}
+		return gzReader, func() { _ = gzReader.Close() }, nil
+	case "zstd", "zst":
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create zstd reader: %w", err)
+		}
+		return zstdReader, zstdReader.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported decompression format: %s", codec)
+	}
+}
+
+// matcher tracks which patterns have matched an entry.
+type matcher struct {
+	patterns []string
+	found    []*Member
+}
+
+// match returns the index of the pattern matching name, or -1.
+// It fails if a pattern that already matched matches a second entry.
+func (m *matcher) match(name string, fold bool) (int, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	for i, p := range m.patterns {
+		candidate, pattern := name, strings.TrimPrefix(path.Clean("/"+p), "/")
+		if fold {
+			candidate, pattern = strings.ToLower(candidate), strings.ToLower(pattern)
+		}
+		if ok, _ := path.Match(pattern, candidate); !ok {
+			continue
+		}
+		if m.found[i] != nil {
+			return -1, fmt.Errorf("pattern %q matches both %s and %s, expected exactly one entry", p, m.found[i].Name, name)
+		}
+		return i, nil
+	}
+	return -1, nil
+}
+
+// store copies an entry's contents into a temp file for pattern i.
+func (m *matcher) store(i int, name string, r io.Reader) error {
+	tempFile, err := os.CreateTemp("", "labctl-extract-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+
+	size, err := io.Copy(tempFile, io.LimitReader(r, maxMemberSize))
+	if err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return fmt.Errorf("extract %s: %w", name, err)
+	}
+
+	m.found[i] = &Member{Pattern: m.patterns[i], Name: name, File: tempFile, Size: size}
+	return nil
+}
+
+func (m *matcher) cleanup() {
+	for _, f := range m.found {
+		if f != nil {
+			_ = f.File.Close()
+			_ = os.Remove(f.File.Name())
+		}
+	}
+}
+
+func extractTar(r io.Reader, codec string, m *matcher) error {
+	if codec != "" {
+		reader, cleanup, err := Decompressor(r, codec)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		r = reader
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		i, err := m.match(hdr.Name, false)
+		if err != nil {
+			return err
+		}
+		if i >= 0 {
+			if err := m.store(i, hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractZip(f *os.File, m *matcher) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat archive: %w", err)
+	}
+
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("read zip: %w", err)
+	}
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+
+		i, err := m.match(zf.Name, false)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("open %s: %w", zf.Name, err)
+		}
+		err = m.store(i, zf.Name, rc)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemp(t *testing.T, data []byte) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "archive-*")
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func readMember(t *testing.T, m Member) string {
+	t.Helper()
+	_, err := m.File.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err := io.ReadAll(m.File)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func cleanupMembers(members []Member) {
+	for _, m := range members {
+		_ = m.File.Close()
+		_ = os.Remove(m.File.Name())
+	}
+}
+
+func buildTar(t *testing.T, files map[string]string, gz bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gzw *gzip.Writer
+	if gz {
+		gzw = gzip.NewWriter(&buf)
+		w = gzw
+	}
+	tw := tar.NewWriter(w)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./hook/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if gzw != nil {
+		require.NoError(t, gzw.Close())
+	}
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.Create("images/")
+	require.NoError(t, err)
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	hook := map[string]string{
+		"./hook/vmlinuz-x86_64":   "kernel",
+		"./hook/initramfs-x86_64": "initramfs",
+		"./hook/checksums.txt":    "sums",
+	}
+
+	t.Run("tar.gz members by path and glob", func(t *testing.T) {
+		f := writeTemp(t, buildTar(t, hook, true))
+
+		members, err := Extract(f, "tar.gz", []string{"hook/vmlinuz-x86_64", "hook/initramfs-*"})
+		require.NoError(t, err)
+		defer cleanupMembers(members)
+
+		require.Len(t, members, 2)
+		assert.Equal(t, "hook/vmlinuz-x86_64", members[0].Pattern)
+		assert.Equal(t, "kernel", readMember(t, members[0]))
+		assert.Equal(t, int64(len("kernel")), members[0].Size)
+		assert.Equal(t, "initramfs", readMember(t, members[1]))
+	})
+
+	t.Run("plain tar", func(t *testing.T) {
+		f := writeTemp(t, buildTar(t, hook, false))
+
+		members, err := Extract(f, "tar", []string{"hook/checksums.txt"})
+		require.NoError(t, err)
+		defer cleanupMembers(members)
+
+		assert.Equal(t, "sums", readMember(t, members[0]))
+	})
+
+	t.Run("zip member", func(t *testing.T) {
+		f := writeTemp(t, buildZip(t, map[string]string{
+			"images/disk.qcow2": "qcow2 bytes",
+			"README.md":         "readme",
+		}))
+
+		members, err := Extract(f, "zip", []string{"images/*.qcow2"})
+		require.NoError(t, err)
+		defer cleanupMembers(members)
+
+		assert.Equal(t, "images/disk.qcow2", members[0].Name)
+		assert.Equal(t, "qcow2 bytes", readMember(t, members[0]))
+	})
+
+	t.Run("glob matching several entries", func(t *testing.T) {
+		f := writeTemp(t, buildTar(t, hook, true))
+
+		_, err := Extract(f, "tar.gz", []string{"hook/*-x86_64"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected exactly one entry")
+	})
+
+	t.Run("missing member", func(t *testing.T) {
+		f := writeTemp(t, buildTar(t, hook, true))
+
+		_, err := Extract(f, "tar.gz", []string{"hook/vmlinuz-aarch64"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `no archive entry matches "hook/vmlinuz-aarch64"`)
+	})
+
+	t.Run("corrupt archive", func(t *testing.T) {
+		f := writeTemp(t, []byte("not gzip"))
+
+		_, err := Extract(f, "tar.gz", []string{"anything"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "create gzip reader")
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		f := writeTemp(t, nil)
+
+		_, err := Extract(f, "rar", []string{"anything"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported archive format")
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		f := writeTemp(t, nil)
+
+		_, err := Extract(f, "tar", []string{"[invalid"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid member pattern")
+	})
+}
+
+func TestIsSupported(t *testing.T) {
+	assert.True(t, IsSupported("tar.gz"))
+	assert.True(t, IsSupported("iso"))
+	assert.False(t, IsSupported("rar"))
+}
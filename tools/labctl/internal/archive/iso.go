@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// ISO 9660 layout constants.
+const (
+	isoSectorSize       = 2048
+	isoFirstDescriptor  = 16
+	isoMaxDescriptors   = 64
+	isoRootRecordOffset = 156
+	isoMaxDepth         = 32
+
+	isoTypePrimary       = 1
+	isoTypeSupplementary = 2
+	isoTypeTerminator    = 255
+
+	isoFlagDirectory = 0x02
+)
+
+// isoEntry is a regular file found while walking the directory tree.
+type isoEntry struct {
+	name   string
+	extent int64
+	size   int64
+}
+
+// extractISO extracts members from an ISO 9660 image. Joliet names are used
+// when the image has a Joliet supplementary descriptor; otherwise primary
+// volume names are used with their ";1" version suffix removed. Primary
+// names are upper case, so matching ignores case in that mode.
+func extractISO(f *os.File, m *matcher) error {
+	root, joliet, err := isoRoot(f)
+	if err != nil {
+		return err
+	}
+
+	var entries []isoEntry
+	if err := isoWalk(f, root, "", joliet, 0, &entries); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		i, err := m.match(e.name, !joliet)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			continue
+		}
+		section := io.NewSectionReader(f, e.extent*isoSectorSize, e.size)
+		if err := m.store(i, e.name, section); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isoRoot reads the volume descriptors and returns the root directory record,
+// preferring the Joliet descriptor when present.
+func isoRoot(f *os.File) (root []byte, joliet bool, err error) {
+	var primary []byte
+	sector := make([]byte, isoSectorSize)
+
+	for n := 0; n < isoMaxDescriptors; n++ {
+		if _, err := f.ReadAt(sector, int64(isoFirstDescriptor+n)*isoSectorSize); err != nil {
+			return nil, false, fmt.Errorf("read ISO volume descriptor: %w", err)
+		}
+		if string(sector[1:6]) != "CD001" {
+			return nil, false, fmt.Errorf("not an ISO 9660 image")
+		}
+
+		record := append([]byte(nil), sector[isoRootRecordOffset:isoRootRecordOffset+34]...)
+		switch sector[0] {
+		case isoTypePrimary:
+			primary = record
+		case isoTypeSupplementary:
+			// Joliet escape sequences: %/@, %/C, %/E (UCS-2 levels 1-3)
+			esc := sector[88:91]
+			if esc[0] == '%' && esc[1] == '/' && (esc[2] == '@' || esc[2] == 'C' || esc[2] == 'E') {
+				return record, true, nil
+			}
+		case isoTypeTerminator:
+			if primary == nil {
+				return nil, false, fmt.Errorf("ISO image has no primary volume descriptor")
+			}
+			return primary, false, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("ISO volume descriptor set is not terminated")
+}
+
+// isoWalk collects regular files under the directory described by record.
+func isoWalk(f *os.File, record []byte, dir string, joliet bool, depth int, out *[]isoEntry) error {
+	if depth > isoMaxDepth {
+		return fmt.Errorf("ISO directory tree is deeper than %d levels", isoMaxDepth)
+	}
+
+	extent := int64(binary.LittleEndian.Uint32(record[2:6]))
+	size := int64(binary.LittleEndian.Uint32(record[10:14]))
+
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, extent*isoSectorSize); err != nil {
+		return fmt.Errorf("read ISO directory %q: %w", dir, err)
+	}
+
+	for off := 0; off < len(data); {
+		length := int(data[off])
+		if length == 0 {
+			// Records never span sectors; skip the padding to the next one
+			off = (off/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if length < 34 || off+length > len(data) {
+			return fmt.Errorf("malformed ISO directory record in %q", dir)
+		}
+
+		rec := data[off : off+length]
+		off += length
+
+		nameLen := int(rec[32])
+		if 33+nameLen > len(rec) {
+			return fmt.Errorf("malformed ISO directory record in %q", dir)
+		}
+		rawName := rec[33 : 33+nameLen]
+		if nameLen == 1 && (rawName[0] == 0 || rawName[0] == 1) {
+			continue // "." and ".."
+		}
+
+		name := isoName(rawName, joliet)
+		full := name
+		if dir != "" {
+			full = dir + "/" + name
+		}
+
+		if rec[25]&isoFlagDirectory != 0 {
+			if err := isoWalk(f, rec, full, joliet, depth+1, out); err != nil {
+				return err
+			}
+			continue
+		}
+
+		*out = append(*out, isoEntry{
+			name:   full,
+			extent: int64(binary.LittleEndian.Uint32(rec[2:6])),
+			size:   int64(binary.LittleEndian.Uint32(rec[10:14])),
+		})
+	}
+
+	return nil
+}
+
+// isoName decodes a directory record name, dropping the ";1" version suffix
+// and the trailing dot ISO 9660 adds to names without an extension.
+func isoName(raw []byte, joliet bool) string {
+	var name string
+	if joliet {
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(raw[2*i:])
+		}
+		name = string(utf16.Decode(units))
+	} else {
+		name = string(bytes.TrimRight(raw, "\x00"))
+	}
+
+	if i := strings.LastIndex(name, ";"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, ".")
+}
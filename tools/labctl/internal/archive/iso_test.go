@@ -0,0 +1,174 @@
+package archive
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildISO writes a minimal ISO 9660 image containing files (keyed by path)
+// and returns it opened for reading. With joliet set, names are encoded as
+// UCS-2 and advertised through a Joliet supplementary descriptor.
+func buildISO(t *testing.T, files map[string]string, joliet bool) *os.File {
+	t.Helper()
+
+	dirs := map[string]bool{"": true}
+	var names []string
+	for p := range files {
+		names = append(names, p)
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	sort.Strings(names)
+	var dirNames []string
+	for d := range dirs {
+		dirNames = append(dirNames, d)
+	}
+	sort.Strings(dirNames)
+
+	descriptors := 2
+	if joliet {
+		descriptors = 3
+	}
+	next := isoFirstDescriptor + descriptors
+	dirSector := map[string]int{}
+	for _, d := range dirNames {
+		dirSector[d] = next
+		next++
+	}
+	fileSector := map[string]int{}
+	for _, n := range names {
+		fileSector[n] = next
+		next += (len(files[n]) + isoSectorSize - 1) / isoSectorSize
+		if len(files[n]) == 0 {
+			next++
+		}
+	}
+
+	img := make([]byte, next*isoSectorSize)
+
+	encodeName := func(name string, dir bool) []byte {
+		if joliet {
+			units := utf16.Encode([]rune(name))
+			b := make([]byte, 2*len(units))
+			for i, u := range units {
+				binary.BigEndian.PutUint16(b[2*i:], u)
+			}
+			return b
+		}
+		if dir {
+			return []byte(strings.ToUpper(name))
+		}
+		return []byte(strings.ToUpper(name) + ";1")
+	}
+	record := func(name []byte, extent, size int, dir bool) []byte {
+		length := 33 + len(name)
+		if length%2 == 1 {
+			length++
+		}
+		rec := make([]byte, length)
+		rec[0] = byte(length)
+		binary.LittleEndian.PutUint32(rec[2:], uint32(extent))
+		binary.BigEndian.PutUint32(rec[6:], uint32(extent))
+		binary.LittleEndian.PutUint32(rec[10:], uint32(size))
+		binary.BigEndian.PutUint32(rec[14:], uint32(size))
+		if dir {
+			rec[25] = isoFlagDirectory
+		}
+		rec[32] = byte(len(name))
+		copy(rec[33:], name)
+		return rec
+	}
+
+	for _, d := range dirNames {
+		parent := path.Dir(d)
+		if d == "" || parent == "." {
+			parent = ""
+		}
+		off := dirSector[d] * isoSectorSize
+		off += copy(img[off:], record([]byte{0}, dirSector[d], isoSectorSize, true))
+		off += copy(img[off:], record([]byte{1}, dirSector[parent], isoSectorSize, true))
+		for _, c := range dirNames {
+			if c != "" && c != d && (path.Dir(c) == d || (d == "" && path.Dir(c) == ".")) {
+				off += copy(img[off:], record(encodeName(path.Base(c), true), dirSector[c], isoSectorSize, true))
+			}
+		}
+		for _, n := range names {
+			if path.Dir(n) == d || (d == "" && path.Dir(n) == ".") {
+				off += copy(img[off:], record(encodeName(path.Base(n), false), fileSector[n], len(files[n]), false))
+			}
+		}
+	}
+	for _, n := range names {
+		copy(img[fileSector[n]*isoSectorSize:], files[n])
+	}
+
+	root := record([]byte{0}, dirSector[""], isoSectorSize, true)
+	writeDescriptor := func(i int, typ byte) {
+		off := (isoFirstDescriptor + i) * isoSectorSize
+		img[off] = typ
+		copy(img[off+1:], "CD001")
+		img[off+6] = 1
+		if typ != isoTypeTerminator {
+			copy(img[off+isoRootRecordOffset:], root)
+		}
+		if typ == isoTypeSupplementary {
+			copy(img[off+88:], "%/E")
+		}
+	}
+	writeDescriptor(0, isoTypePrimary)
+	if joliet {
+		writeDescriptor(1, isoTypeSupplementary)
+	}
+	writeDescriptor(descriptors-1, isoTypeTerminator)
+
+	return writeTemp(t, img)
+}
+
+func TestExtractISO(t *testing.T) {
+	files := map[string]string{
+		"boot/vmlinuz":       "kernel image",
+		"boot/initrd.img":    "initial ramdisk",
+		"live/filesystem.fs": "squashfs",
+	}
+
+	t.Run("primary volume names", func(t *testing.T) {
+		iso := buildISO(t, files, false)
+
+		members, err := Extract(iso, "iso", []string{"boot/vmlinuz", "live/*.fs"})
+		require.NoError(t, err)
+		defer cleanupMembers(members)
+
+		require.Len(t, members, 2)
+		assert.Equal(t, "BOOT/VMLINUZ", members[0].Name)
+		assert.Equal(t, "kernel image", readMember(t, members[0]))
+		assert.Equal(t, "squashfs", readMember(t, members[1]))
+	})
+
+	t.Run("joliet names", func(t *testing.T) {
+		iso := buildISO(t, files, true)
+
+		members, err := Extract(iso, "iso", []string{"boot/initrd.img"})
+		require.NoError(t, err)
+		defer cleanupMembers(members)
+
+		assert.Equal(t, "boot/initrd.img", members[0].Name)
+		assert.Equal(t, "initial ramdisk", readMember(t, members[0]))
+	})
+
+	t.Run("not an ISO", func(t *testing.T) {
+		f := writeTemp(t, make([]byte, 40*isoSectorSize))
+
+		_, err := Extract(f, "iso", []string{"anything"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not an ISO 9660 image")
+	})
+}
@@ -0,0 +1,70 @@
+// Package bytesize parses and formats human-readable byte sizes.
+package bytesize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps size unit suffixes to multipliers. Decimal units follow the
+// convention used by network speeds; binary units are also accepted.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"K", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"B", 1},
+}
+
+// Parse parses a size such as "20GB", "512KiB", or "2000000" into bytes.
+// An empty string returns 0.
+func Parse(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a value like 20GB or 512MiB", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+// Format renders bytes using binary units, e.g. "1.50 MB" for 1572864.
+func Format(bytes int64) string {
+	const (
+		kb = 1024
+		mb = kb * 1024
+		gb = mb * 1024
+	)
+
+	switch {
+	case bytes >= gb:
+		return fmt.Sprintf("%.2f GB", float64(bytes)/gb)
+	case bytes >= mb:
+		return fmt.Sprintf("%.2f MB", float64(bytes)/mb)
+	case bytes >= kb:
+		return fmt.Sprintf("%.2f KB", float64(bytes)/kb)
+	default:
+		return fmt.Sprintf("%d B", bytes)
+	}
+}
@@ -0,0 +1,100 @@
+package bytesize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0", want: 0},
+		{in: "2000000", want: 2_000_000},
+		{in: "20GB", want: 20_000_000_000},
+		{in: "512MiB", want: 512 << 20},
+		{in: " 1.5 G ", want: 1_500_000_000},
+		{in: "100B", want: 100},
+		{in: "big", wantErr: true},
+		{in: "-1MB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid size")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytes    int64
+		expected string
+	}{
+		{
+			name:     "bytes",
+			bytes:    500,
+			expected: "500 B",
+		},
+		{
+			name:     "zero bytes",
+			bytes:    0,
+			expected: "0 B",
+		},
+		{
+			name:     "kilobytes",
+			bytes:    1024,
+			expected: "1.00 KB",
+		},
+		{
+			name:     "kilobytes with decimal",
+			bytes:    1536,
+			expected: "1.50 KB",
+		},
+		{
+			name:     "megabytes",
+			bytes:    1024 * 1024,
+			expected: "1.00 MB",
+		},
+		{
+			name:     "megabytes with decimal",
+			bytes:    1024*1024*10 + 1024*512,
+			expected: "10.50 MB",
+		},
+		{
+			name:     "gigabytes",
+			bytes:    1024 * 1024 * 1024,
+			expected: "1.00 GB",
+		},
+		{
+			name:     "gigabytes with decimal",
+			bytes:    1024*1024*1024*2 + 1024*1024*512,
+			expected: "2.50 GB",
+		},
+		{
+			name:     "large gigabytes",
+			bytes:    1024 * 1024 * 1024 * 50,
+			expected: "50.00 GB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Format(tt.bytes)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package cache provides a local content cache for downloaded source images,
+// keyed by source checksum.
+package cache
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tempPrefix marks partially written entries, which are never listed.
+const tempPrefix = ".tmp-"
+
+// checksumPattern restricts checksums to known algorithms and hex digests so
+// that entry names can never escape the cache directory.
+var checksumPattern = regexp.MustCompile(`^(sha256|sha512):([0-9a-f]+)$`)
+
+// Cache is a directory of downloaded files named by checksum. Contents are
+// verified when they are stored and hashed again on every read, so an entry
+// corrupted on disk is never served; Verify checks every entry at once. The
+// least recently used entries are evicted once the total size exceeds the
+// size limit.
+type Cache struct {
+	dir     string
+	maxSize int64
+}
+
+// Entry describes a cached file.
+type Entry struct {
+	Checksum string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// DefaultDir returns the default cache directory, $XDG_CACHE_HOME/labctl/downloads
+// (or the platform equivalent).
+func DefaultDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("determine user cache directory: %w", err)
+	}
+	return filepath.Join(base, "labctl", "downloads"), nil
+}
+
+// New returns a cache rooted at dir. Entries are evicted once the cache is
+// larger than maxSize bytes; a maxSize of zero means no limit. A negative
+// maxSize is an error.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("invalid cache size limit %d, expected 0 for no limit or a positive size", maxSize)
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// Dir returns the cache directory.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Get opens the entry for checksum read-only, after checking that its
+// contents still match the checksum. A missing entry returns ok=false; an
+// entry that no longer matches is removed and also returns ok=false, with an
+// error saying so. The caller must close the returned file and must not
+// write to or remove it.
+func (c *Cache) Get(checksum string) (file *os.File, size int64, ok bool, err error) {
+	name, err := entryName(checksum)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	entryPath := filepath.Join(c.dir, name)
+
+	file, err = os.Open(entryPath) //nolint:gosec // G304: Path is built from a validated checksum
+	if os.IsNotExist(err) {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("open cache entry: %w", err)
+	}
+
+	// Hash the entry on every read, so a file changed on disk since it was
+	// stored is replaced by a fresh download instead of being served
+	h, _ := newHash(checksum)
+	size, err = io.Copy(h, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, false, fmt.Errorf("read cache entry: %w", err)
+	}
+	if !matches(h, checksum) {
+		_ = file.Close()
+		if err := os.Remove(entryPath); err != nil && !os.IsNotExist(err) {
+			return nil, 0, false, fmt.Errorf("remove corrupt cache entry: %w", err)
+		}
+		return nil, 0, false, fmt.Errorf("cache entry for %s does not match its checksum, removed", checksum)
+	}
+
+	// Record the use for LRU eviction
+	now := time.Now()
+	_ = os.Chtimes(entryPath, now, now)
+
+	return file, size, true, nil
+}
+
+// Verify hashes every entry, removes those whose contents no longer match
+// their checksum, and returns the removed entries.
+func (c *Cache) Verify() ([]Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Entry
+	for _, e := range entries {
+		ok, err := verifyEntry(e)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			continue
+		}
+		if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("remove cache entry: %w", err)
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// verifyEntry reports whether the contents of an entry match its checksum.
+func verifyEntry(e Entry) (bool, error) {
+	f, err := os.Open(e.Path) //nolint:gosec // G304: Path is listed from the cache directory
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open cache entry: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	h, _ := newHash(e.Checksum)
+	if _, err := io.Copy(h, f); err != nil {
+		return false, fmt.Errorf("read cache entry: %w", err)
+	}
+	return matches(h, e.Checksum), nil
+}
+
+// Put stores the contents of r under checksum, then evicts least recently
+// used entries until the cache fits in its size limit, if it has one.
+// Contents that do not match the checksum, or that are larger than the
+// limit, are not stored.
+func (c *Cache) Put(checksum string, r io.Reader) error {
+	name, err := entryName(checksum)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return fmt.Errorf("create cache directory: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(c.dir, tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("create cache entry: %w", err)
+	}
+	defer func() { _ = os.Remove(tempFile.Name()) }()
+
+	h, _ := newHash(checksum)
+	size, err := io.Copy(io.MultiWriter(tempFile, h), r)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+
+	if !matches(h, checksum) {
+		return fmt.Errorf("contents do not match %s, not caching", checksum)
+	}
+	if c.maxSize > 0 && size > c.maxSize {
+		return nil
+	}
+
+	if err := os.Rename(tempFile.Name(), filepath.Join(c.dir, name)); err != nil {
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+
+	if c.maxSize == 0 {
+		return nil
+	}
+	_, err = c.Prune(c.maxSize)
+	return err
+}
+
+// List returns the cached entries, most recently used first.
+func (c *Cache) List() ([]Entry, error) {
+	dirEntries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cache directory: %w", err)
+	}
+
+	var entries []Entry
+	for _, de := range dirEntries {
+		checksum, ok := entryChecksum(de.Name())
+		if !ok || !de.Type().IsRegular() {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, Entry{
+			Checksum: checksum,
+			Path:     filepath.Join(c.dir, de.Name()),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune removes least recently used entries until the total size is at most
+// maxSize, and returns the removed entries.
+func (c *Cache) Prune(maxSize int64) ([]Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	var removed []Entry
+	for i := len(entries) - 1; i >= 0 && total > maxSize; i-- {
+		if err := os.Remove(entries[i].Path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("remove cache entry: %w", err)
+		}
+		total -= entries[i].Size
+		removed = append(removed, entries[i])
+	}
+
+	return removed, nil
+}
+
+// Clear removes every cached entry and returns the removed entries.
+func (c *Cache) Clear() ([]Entry, error) {
+	return c.Prune(0)
+}
+
+// entryName returns the file name for a checksum, e.g. "sha256-abc...".
+func entryName(checksum string) (string, error) {
+	if !checksumPattern.MatchString(checksum) {
+		return "", fmt.Errorf("invalid checksum %q for cache entry", checksum)
+	}
+	return strings.Replace(checksum, ":", "-", 1), nil
+}
+
+// entryChecksum is the inverse of entryName.
+func entryChecksum(name string) (string, bool) {
+	checksum := strings.Replace(name, "-", ":", 1)
+	return checksum, checksumPattern.MatchString(checksum)
+}
+
+func newHash(checksum string) (hash.Hash, error) {
+	switch {
+	case strings.HasPrefix(checksum, "sha256:"):
+		return sha256.New(), nil
+	case strings.HasPrefix(checksum, "sha512:"):
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm in %q", checksum)
+	}
+}
+
+func matches(h hash.Hash, checksum string) bool {
+	_, expected, _ := strings.Cut(checksum, ":")
+	return hex.EncodeToString(h.Sum(nil)) == expected
+}
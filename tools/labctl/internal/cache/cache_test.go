@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checksumOf(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func newCache(t *testing.T, dir string, maxSize int64) *Cache {
+	t.Helper()
+	c, err := New(dir, maxSize)
+	require.NoError(t, err)
+	return c
+}
+
+func readAndClose(t *testing.T, f *os.File) []byte {
+	t.Helper()
+	defer func() { _ = f.Close() }()
+	_, err := f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return data
+}
+
+func TestCache_PutGet(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		c := newCache(t, t.TempDir(), 0)
+		data := []byte("cached iso")
+		checksum := checksumOf(data)
+
+		require.NoError(t, c.Put(checksum, bytes.NewReader(data)))
+
+		f, size, ok, err := c.Get(checksum)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, int64(len(data)), size)
+		assert.Equal(t, data, readAndClose(t, f))
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		c := newCache(t, t.TempDir(), 0)
+
+		_, _, ok, err := c.Get(checksumOf([]byte("absent")))
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("get returns the entry read-only", func(t *testing.T) {
+		c := newCache(t, t.TempDir(), 0)
+		data := []byte("cached iso")
+		checksum := checksumOf(data)
+		require.NoError(t, c.Put(checksum, bytes.NewReader(data)))
+
+		f, _, ok, err := c.Get(checksum)
+		require.NoError(t, err)
+		require.True(t, ok)
+		defer func() { _ = f.Close() }()
+
+		_, err = f.Write([]byte("tampered"))
+		require.Error(t, err)
+	})
+
+	t.Run("get removes an entry that no longer matches", func(t *testing.T) {
+		dir := t.TempDir()
+		c := newCache(t, dir, 0)
+		data := []byte("cached iso")
+		checksum := checksumOf(data)
+		require.NoError(t, c.Put(checksum, bytes.NewReader(data)))
+
+		entries, err := c.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.NoError(t, os.WriteFile(entries[0].Path, []byte("corrupted"), 0o600))
+
+		_, _, ok, err := c.Get(checksum)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not match its checksum")
+		assert.False(t, ok)
+		assert.NoFileExists(t, entries[0].Path)
+	})
+
+	t.Run("put rejects mismatched contents", func(t *testing.T) {
+		dir := t.TempDir()
+		c := newCache(t, dir, 0)
+
+		err := c.Put(checksumOf([]byte("expected")), bytes.NewReader([]byte("actual")))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "do not match")
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("entries larger than the limit are not stored", func(t *testing.T) {
+		c := newCache(t, t.TempDir(), 4)
+		data := []byte("too large")
+
+		require.NoError(t, c.Put(checksumOf(data), bytes.NewReader(data)))
+
+		entries, err := c.List()
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		c := newCache(t, t.TempDir(), 0)
+		data := bytes.Repeat([]byte("x"), 1<<16)
+
+		require.NoError(t, c.Put(checksumOf(data), bytes.NewReader(data)))
+
+		entries, err := c.List()
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("invalid checksum", func(t *testing.T) {
+		c := newCache(t, t.TempDir(), 0)
+
+		_, _, _, err := c.Get("sha256:../../etc/passwd")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid checksum")
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(t.TempDir(), -1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid cache size limit -1")
+}
+
+func TestCache_Verify(t *testing.T) {
+	c := newCache(t, t.TempDir(), 0)
+	good, bad := []byte("original"), []byte("corrupted")
+	require.NoError(t, c.Put(checksumOf(good), bytes.NewReader(good)))
+	require.NoError(t, c.Put(checksumOf(bad), bytes.NewReader(bad)))
+
+	f, _, ok, err := c.Get(checksumOf(bad))
+	require.NoError(t, err)
+	require.True(t, ok)
+	path := f.Name()
+	_ = f.Close()
+	require.NoError(t, os.WriteFile(path, []byte("tampered"), 0o600))
+
+	removed, err := c.Verify()
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, checksumOf(bad), removed[0].Checksum)
+	assert.NoFileExists(t, path)
+
+	_, _, ok, err = c.Get(checksumOf(good))
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCache_Eviction(t *testing.T) {
+	dir := t.TempDir()
+	c := newCache(t, dir, 20)
+
+	old := []byte("0123456789")
+	recent := []byte("abcdefghij")
+	require.NoError(t, c.Put(checksumOf(old), bytes.NewReader(old)))
+	require.NoError(t, c.Put(checksumOf(recent), bytes.NewReader(recent)))
+
+	// Make the first entry the least recently used
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "sha256-"+checksumOf(old)[7:]), past, past))
+
+	newest := []byte("ABCDEFGHIJ")
+	require.NoError(t, c.Put(checksumOf(newest), bytes.NewReader(newest)))
+
+	entries, err := c.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.NotEqual(t, checksumOf(old), e.Checksum)
+	}
+}
+
+func TestCache_PruneClear(t *testing.T) {
+	c := newCache(t, t.TempDir(), 0)
+	for _, s := range []string{"one", "two", "three"} {
+		require.NoError(t, c.Put(checksumOf([]byte(s)), bytes.NewReader([]byte(s))))
+	}
+
+	removed, err := c.Prune(8)
+	require.NoError(t, err)
+	assert.Len(t, removed, 1)
+
+	removed, err = c.Clear()
+	require.NoError(t, err)
+	assert.Len(t, removed, 2)
+
+	entries, err := c.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDefaultDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG_CACHE_HOME is only honored on Linux")
+	}
+	t.Setenv("XDG_CACHE_HOME", "/tmp/xdg-cache")
+	t.Setenv("HOME", "/home/test")
+
+	dir, err := DefaultDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/tmp/xdg-cache", "labctl", "downloads"), dir)
+}
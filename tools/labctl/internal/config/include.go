@@ -0,0 +1,192 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loader reads a manifest and its fragments, loading each file at most once
+// so include cycles terminate.
+type loader struct {
+	visited map[string]bool
+	files   []string
+}
+
+// loadFile reads a manifest file and merges in the fragments matched by its
+// spec.includes globs, which are resolved relative to the file.
+func (l *loader) loadFile(path string) (*ImageManifest, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve manifest path: %w", err)
+	}
+	l.visited[abs] = true
+	l.files = append(l.files, path)
+
+	data, err := os.ReadFile(path) //nolint:gosec // G304: Path is provided by user or a manifest include
+	if err != nil {
+		return nil, fmt.Errorf("read manifest file: %w", err)
+	}
+
+	manifest, err := ParseManifestRaw(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range manifest.Spec.Images {
+		manifest.Spec.Images[i].file = path
+	}
+	for i, warning := range manifest.Warnings {
+		manifest.Warnings[i] = path + ": " + warning
+	}
+
+	for _, pattern := range manifest.Spec.Includes {
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid spec.includes pattern %q: %w", path, pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: spec.includes pattern %q matches no files", path, pattern)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			if err := l.include(manifest, match); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// ManifestFiles returns every manifest file that LoadManifest would read for
+// path, in load order.
+func ManifestFiles(path string) ([]string, error) {
+	l := &loader{visited: make(map[string]bool)}
+	if _, err := l.load(path); err != nil {
+		return nil, err
+	}
+	return l.files, nil
+}
+
+// load reads a manifest file or directory of manifest files.
+func (l *loader) load(path string) (*ImageManifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest file: %w", err)
+	}
+	if info.IsDir() {
+		return l.loadDir(path)
+	}
+	return l.loadFile(path)
+}
+
+// loadDir merges every ImageManifest file directly inside dir, in name order.
+// Other YAML files, such as SOPS-encrypted credentials, are skipped.
+func (l *loader) loadDir(dir string) (*ImageManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest directory: %w", err)
+	}
+
+	var manifest *ImageManifest
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if e.IsDir() || strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		ok, err := isManifestFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if manifest == nil {
+			if manifest, err = l.loadFile(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := l.include(manifest, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("no ImageManifest files found in %s", dir)
+	}
+	return manifest, nil
+}
+
+// include loads the fragment at path and merges it into manifest, unless it
+// has already been loaded.
+func (l *loader) include(manifest *ImageManifest, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolve manifest path: %w", err)
+	}
+	if l.visited[abs] {
+		return nil
+	}
+
+	fragment, err := l.loadFile(path)
+	if err != nil {
+		return err
+	}
+	return manifest.merge(fragment, path)
+}
+
+// merge appends the images of fragment to the manifest. Duplicate image
+// names and destinations are left to ValidateAll, which reports them with
+// the files they came from.
+func (m *ImageManifest) merge(fragment *ImageManifest, path string) error {
+	if fragment.APIVersion != m.APIVersion {
+		return fmt.Errorf("%s: apiVersion %q does not match %q", path, fragment.APIVersion, m.APIVersion)
+	}
+	if fragment.Kind != m.Kind {
+		return fmt.Errorf("%s: kind %q does not match %q", path, fragment.Kind, m.Kind)
+	}
+
+	if fragment.Spec.TransferWindow != nil {
+		if m.Spec.TransferWindow != nil {
+			return fmt.Errorf("%s: spec.transferWindow is already set by another manifest file", path)
+		}
+		m.Spec.TransferWindow = fragment.Spec.TransferWindow
+	}
+
+	if fragment.Spec.Publish != nil {
+		if m.Spec.Publish != nil {
+			return fmt.Errorf("%s: spec.publish is already set by another manifest file", path)
+		}
+		m.Spec.Publish = fragment.Spec.Publish
+	}
+
+	m.Spec.Images = append(m.Spec.Images, fragment.Spec.Images...)
+	m.Warnings = append(m.Warnings, fragment.Warnings...)
+	return nil
+}
+
+// isManifestFile reports whether the YAML file at path declares kind ImageManifest.
+func isManifestFile(path string) (bool, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: Path is within a user-provided directory
+	if err != nil {
+		return false, fmt.Errorf("read manifest file: %w", err)
+	}
+
+	var header struct {
+		Kind string `yaml:"kind"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return false, nil //nolint:nilerr // Files that are not YAML documents are not manifests
+	}
+	return header.Kind == "ImageManifest", nil
+}
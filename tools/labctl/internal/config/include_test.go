@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeManifest(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+	return path
+}
+
+func image(name, dest string) string {
+	return `    - name: ` + name + `
+      source:
+        url: https://example.com/` + name + `.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: ` + dest + `
+`
+}
+
+func header(name string) string {
+	return "apiVersion: images.lab.gilman.io/v1alpha1\nkind: ImageManifest\nmetadata:\n  name: " + name + "\n"
+}
+
+func TestLoadManifest_Includes(t *testing.T) {
+	t.Run("merges included fragments", func(t *testing.T) {
+		dir := t.TempDir()
+		root := writeManifest(t, dir, "images.yaml", header("lab")+`spec:
+  includes:
+    - teams/*.yaml
+  images:
+`+image("base", "base/base.iso"))
+		writeManifest(t, dir, "teams/network.yaml", header("network")+"spec:\n  images:\n"+image("vyos", "vyos/vyos.iso"))
+		writeManifest(t, dir, "teams/platform.yaml", header("platform")+"spec:\n  images:\n"+image("talos", "talos/talos.iso"))
+
+		manifest, err := LoadManifest(root)
+		require.NoError(t, err)
+		assert.Equal(t, "lab", manifest.Metadata.Name)
+
+		var names []string
+		for _, img := range manifest.Spec.Images {
+			names = append(names, img.Name)
+		}
+		assert.Equal(t, []string{"base", "vyos", "talos"}, names)
+	})
+
+	t.Run("include cycles load each file once", func(t *testing.T) {
+		dir := t.TempDir()
+		root := writeManifest(t, dir, "a.yaml", header("a")+"spec:\n  includes: [b.yaml]\n  images:\n"+image("a", "a/a.iso"))
+		writeManifest(t, dir, "b.yaml", header("b")+"spec:\n  includes: [a.yaml]\n  images:\n"+image("b", "b/b.iso"))
+
+		manifest, err := LoadManifest(root)
+		require.NoError(t, err)
+		assert.Len(t, manifest.Spec.Images, 2)
+	})
+
+	t.Run("pattern without matches", func(t *testing.T) {
+		dir := t.TempDir()
+		root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  includes: [missing/*.yaml]\n  images: []\n")
+
+		_, err := LoadManifest(root)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `spec.includes pattern "missing/*.yaml" matches no files`)
+	})
+
+	t.Run("duplicate name across fragments", func(t *testing.T) {
+		dir := t.TempDir()
+		root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  includes: [team.yaml]\n  images:\n"+image("talos", "talos/a.iso"))
+		writeManifest(t, dir, "team.yaml", header("team")+"spec:\n  images:\n"+image("talos", "talos/b.iso"))
+
+		_, err := LoadManifest(root)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `duplicate image name "talos"`)
+		assert.Contains(t, err.Error(), "images.yaml")
+		assert.Contains(t, err.Error(), "team.yaml")
+	})
+
+	t.Run("duplicate destination across fragments", func(t *testing.T) {
+		dir := t.TempDir()
+		root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  includes: [team.yaml]\n  images:\n"+image("a", "shared/image.iso"))
+		writeManifest(t, dir, "team.yaml", header("team")+"spec:\n  images:\n"+image("b", "shared/image.iso"))
+
+		raw, err := LoadManifestRaw(root)
+		require.NoError(t, err)
+
+		errs := raw.ValidateAll()
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), `duplicate destination "shared/image.iso"`)
+		assert.Contains(t, errs[0].Error(), `image[1] "b" in `+filepath.Join(dir, "team.yaml"))
+	})
+
+	t.Run("mismatched apiVersion", func(t *testing.T) {
+		dir := t.TempDir()
+		root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  includes: [team.yaml]\n  images: []\n")
+		writeManifest(t, dir, "team.yaml", "apiVersion: images.lab.gilman.io/v2\nkind: ImageManifest\nmetadata:\n  name: team\nspec:\n  images: []\n")
+
+		_, err := LoadManifest(root)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not match")
+	})
+
+	t.Run("transfer window set twice", func(t *testing.T) {
+		dir := t.TempDir()
+		window := "  transferWindow:\n    start: \"01:00\"\n    end: \"05:00\"\n"
+		root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  includes: [team.yaml]\n"+window+"  images: []\n")
+		writeManifest(t, dir, "team.yaml", header("team")+"spec:\n"+window+"  images: []\n")
+
+		_, err := LoadManifest(root)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "spec.transferWindow is already set")
+	})
+
+	t.Run("publish set twice", func(t *testing.T) {
+		dir := t.TempDir()
+		publish := "  publish:\n    nasRoot: /volume1/images\n"
+		root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  includes: [team.yaml]\n"+publish+"  images: []\n")
+		writeManifest(t, dir, "team.yaml", header("team")+"spec:\n"+publish+"  images: []\n")
+
+		_, err := LoadManifest(root)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "spec.publish is already set")
+	})
+}
+
+func TestLoadManifest_Directory(t *testing.T) {
+	t.Run("merges manifest files in name order", func(t *testing.T) {
+		dir := t.TempDir()
+		writeManifest(t, dir, "b-platform.yaml", header("platform")+"spec:\n  images:\n"+image("talos", "talos/talos.iso"))
+		writeManifest(t, dir, "a-network.yml", header("network")+"spec:\n  images:\n"+image("vyos", "vyos/vyos.iso"))
+		writeManifest(t, dir, "e2.sops.yaml", "access_key: ENC[AES256_GCM,data:abc]\n")
+		writeManifest(t, dir, ".sops.yaml", "creation_rules: []\n")
+		writeManifest(t, dir, "README.md", "# images\n")
+
+		manifest, err := LoadManifest(dir)
+		require.NoError(t, err)
+		assert.Equal(t, "network", manifest.Metadata.Name)
+		require.Len(t, manifest.Spec.Images, 2)
+		assert.Equal(t, "vyos", manifest.Spec.Images[0].Name)
+		assert.Equal(t, "talos", manifest.Spec.Images[1].Name)
+	})
+
+	t.Run("no manifests", func(t *testing.T) {
+		dir := t.TempDir()
+		writeManifest(t, dir, "e2.sops.yaml", "access_key: abc\n")
+
+		_, err := LoadManifest(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no ImageManifest files found")
+	})
+}
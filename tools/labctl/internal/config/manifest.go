@@ -2,16 +2,40 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/archive"
+	"github.com/GilmanLab/lab/tools/labctl/internal/diskimage"
+	"github.com/GilmanLab/lab/tools/labctl/internal/github"
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci"
+	"github.com/GilmanLab/lab/tools/labctl/internal/updater"
+)
+
+// Image manifest API versions. Manifests at older versions are converted to
+// SupportedAPIVersion when loaded, with a deprecation warning.
+const (
+	APIVersionV1Alpha1 = "images.lab.gilman.io/v1alpha1"
+	APIVersionV1Beta1  = "images.lab.gilman.io/v1beta1"
+
+	// SupportedAPIVersion is the newest API version, which the config types describe.
+	SupportedAPIVersion = APIVersionV1Beta1
 )
 
-// SupportedAPIVersion is the supported API version for the image manifest.
-const SupportedAPIVersion = "images.lab.gilman.io/v1alpha1"
+// DecompressFormats lists the supported source.decompress values.
+var DecompressFormats = []string{"xz", "gzip", "zstd"}
+
+// Algorithms lists the supported validation algorithms.
+var Algorithms = []string{"sha256", "sha512"}
 
 // ImageManifest represents the top-level image manifest configuration.
 type ImageManifest struct {
@@ -19,6 +43,10 @@ type ImageManifest struct {
 	Kind       string   `yaml:"kind"`
 	Metadata   Metadata `yaml:"metadata"`
 	Spec       Spec     `yaml:"spec"`
+
+	// Warnings holds non-fatal problems found while loading, such as a
+	// deprecated apiVersion.
+	Warnings []string `yaml:"-"`
 }
 
 // Metadata contains manifest metadata.
@@ -28,41 +56,163 @@ type Metadata struct {
 
 // Spec contains the list of images to manage.
 type Spec struct {
-	Images []Image `yaml:"images"`
+	Includes       []string          `yaml:"includes,omitempty"` // Globs of manifest fragments, relative to this file
+	Vars           map[string]string `yaml:"vars,omitempty"`     // Template variables for the images in this file
+	TransferWindow *TransferWindow   `yaml:"transferWindow,omitempty"`
+	Publish        *Publish          `yaml:"publish,omitempty"`
+	Images         []Image           `yaml:"images"`
+}
+
+// Default publish settings.
+const (
+	// DefaultNASRoot is where Synology Cloud Sync mirrors the images/ prefix.
+	DefaultNASRoot = "/volume1/images"
+
+	// MaxPresignExpiry is the longest lifetime S3 allows for presigned URLs.
+	MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// Publish describes where synced images can be fetched from, for updateFile
+// templates that point at the synced copy instead of upstream.
+type Publish struct {
+	NASRoot       string `yaml:"nasRoot,omitempty"`       // Local path of the images/ prefix on the NAS; defaults to DefaultNASRoot
+	BaseURL       string `yaml:"baseURL,omitempty"`       // Public URL of the images/ prefix; required by templates that use .URL
+	PresignExpiry string `yaml:"presignExpiry,omitempty"` // Lifetime of .PresignedURL, e.g. 24h; defaults to MaxPresignExpiry
+}
+
+// TransferWindow restricts when sync may download and upload images, so
+// scheduled runs defer large transfers outside the allowed hours.
+type TransferWindow struct {
+	Start    string `yaml:"start"`              // HH:MM
+	End      string `yaml:"end"`                // HH:MM; earlier than start wraps past midnight
+	Timezone string `yaml:"timezone,omitempty"` // IANA name; defaults to local time
 }
 
 // Image represents a single image configuration.
 type Image struct {
-	Name        string      `yaml:"name"`
-	Source      Source      `yaml:"source"`
-	Destination string      `yaml:"destination"`
-	Validation  *Validation `yaml:"validation,omitempty"`
-	UpdateFile  *UpdateFile `yaml:"updateFile,omitempty"`
+	Name        string            `yaml:"name"`
+	Groups      []string          `yaml:"groups,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Vars        map[string]string `yaml:"vars,omitempty"` // Overrides spec.vars for this image
+	Source      Source            `yaml:"source"`
+	Destination string            `yaml:"destination,omitempty"`
+	Validation  *Validation       `yaml:"validation,omitempty"`
+	Transform   *Transform        `yaml:"transform,omitempty"`
+	UpdateFile  *UpdateFile       `yaml:"updateFile,omitempty"`  // Single file update; updateFiles is preferred
+	UpdateFiles []UpdateFile      `yaml:"updateFiles,omitempty"` // File updates; paths may be globs
+	OCI         *OCITarget        `yaml:"oci,omitempty"`
+
+	file      string              // Manifest file the image was loaded from, if known
+	positions map[string]Position // YAML positions of the image and its fields
 }
 
 // Source defines where to download the image from.
 type Source struct {
-	URL        string `yaml:"url"`
-	Checksum   string `yaml:"checksum"`
-	Decompress string `yaml:"decompress,omitempty"` // xz, gzip, zstd
+	URL        string        `yaml:"url,omitempty"` // https:// URL or oci://registry/repository@digest
+	Checksum   string        `yaml:"checksum,omitempty"`
+	Decompress string        `yaml:"decompress,omitempty"` // xz, gzip, zstd
+	Mirrors    []string      `yaml:"mirrors,omitempty"`    // https:// fallbacks tried in order, verified against checksum
+	GitHub     *GitHubSource `yaml:"github,omitempty"`
+	Extract    *Extract      `yaml:"extract,omitempty"`
+}
+
+// Extract publishes one or more files pulled out of an archive source
+// instead of the source file itself.
+type Extract struct {
+	Format  string          `yaml:"format"` // tar, tar.gz, tar.xz, tar.zst, zip, iso
+	Members []ExtractMember `yaml:"members"`
+}
+
+// ExtractMember selects a single file inside an archive and where to upload it.
+type ExtractMember struct {
+	Path        string      `yaml:"path"` // Path or glob inside the archive; must match exactly one file
+	Destination string      `yaml:"destination"`
+	Validation  *Validation `yaml:"validation"`
+}
+
+// GitHubSource resolves the source URL from a GitHub release asset at sync time.
+// The asset's digest is used as the source checksum when none is given.
+type GitHubSource struct {
+	Repo       string `yaml:"repo"`                 // owner/name
+	Tag        string `yaml:"tag,omitempty"`        // Exact tag or "latest"
+	TagPattern string `yaml:"tagPattern,omitempty"` // Regex; newest matching release wins
+	Prerelease bool   `yaml:"prerelease,omitempty"` // Let latest and tagPattern select prereleases
+	Asset      string `yaml:"asset"`                // Glob matched against asset names
+}
+
+// Transform converts the image to another disk format after decompression
+// or extraction and before upload.
+type Transform struct {
+	Format string `yaml:"format"`           // qcow2, raw
+	Sparse bool   `yaml:"sparse,omitempty"` // Leave all-zero clusters unallocated (qcow2 only)
+}
+
+// OCITarget defines an OCI registry the image is pushed to alongside e2.
+type OCITarget struct {
+	Reference string `yaml:"reference"` // registry/repository:tag
 }
 
 // Validation defines post-processing validation rules.
 type Validation struct {
-	Algorithm string `yaml:"algorithm"` // sha256, sha512
+	Algorithm string `yaml:"-"` // sha256, sha512; derived from the Expected prefix
 	Expected  string `yaml:"expected"`
 }
 
+// UnmarshalYAML decodes the validation block and derives the algorithm from
+// the prefix of the expected checksum. The algorithm key of v1alpha1 is an
+// error rather than ignored, so a half-migrated manifest cannot pass.
+func (v *Validation) UnmarshalYAML(node *yaml.Node) error {
+	if key, _ := mappingEntry(node, "algorithm"); key != nil {
+		return &ValidationError{
+			Pos: Position{Line: key.Line, Column: key.Column},
+			Err: fmt.Errorf("validation.algorithm was removed in %s; the algorithm is the prefix of validation.expected", APIVersionV1Beta1),
+		}
+	}
+
+	type plain Validation
+	if err := node.Decode((*plain)(v)); err != nil {
+		return err
+	}
+	if algorithm, _, ok := strings.Cut(v.Expected, ":"); ok {
+		v.Algorithm = algorithm
+	}
+	return nil
+}
+
 // UpdateFile defines file updates to trigger downstream builds.
 type UpdateFile struct {
-	Path         string        `yaml:"path"`
+	Path         string        `yaml:"path"` // File path or glob, relative to the repository root
 	Replacements []Replacement `yaml:"replacements"`
 }
 
-// Replacement defines a regex-based replacement in a file.
+// Targets returns the files matching the update's path, sorted. A relative
+// path is resolved against root, or the working directory if root is empty.
+// A path that matches nothing is an error, so stale references are caught.
+func (u *UpdateFile) Targets(root string) ([]string, error) {
+	pattern := u.Path
+	if root != "" && !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(root, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern %q: %w", u.Path, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("path %q matches no files", u.Path)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Replacement defines a replacement in a file: a regex replacement by
+// default, or a structured edit of the value at a path.
 type Replacement struct {
-	Pattern string `yaml:"pattern"` // Regex pattern
-	Value   string `yaml:"value"`   // Template: {{ .Source.URL }}, {{ .Source.Checksum }}
+	Type          string `yaml:"type,omitempty"`          // regex (default), yamlpath, jsonpath, toml
+	Pattern       string `yaml:"pattern,omitempty"`       // Regex pattern, for type regex
+	Path          string `yaml:"path,omitempty"`          // Path such as spec.url or images[0].url, for the other types
+	Value         string `yaml:"value"`                   // Template over updater.TemplateData: {{ .Source.URL }}, {{ .URL }}, {{ .Vars.name }}; $1 or ${name} for regex groups
+	ExpectMatches int    `yaml:"expectMatches,omitempty"` // Exact number of matches in each file; 0 means any
+	Required      bool   `yaml:"required,omitempty"`      // Fail when nothing matches
 }
 
 // EffectiveChecksum returns the checksum to use for idempotency checks.
@@ -74,48 +224,104 @@ func (i *Image) EffectiveChecksum() string {
 	return i.Source.Checksum
 }
 
-// LoadManifest reads and parses an image manifest from a file.
+// FileUpdates returns the image's file updates: the updateFiles list, or
+// the single updateFile.
+func (i *Image) FileUpdates() []UpdateFile {
+	if i.UpdateFile != nil {
+		return []UpdateFile{*i.UpdateFile}
+	}
+	return i.UpdateFiles
+}
+
+// updateFileField pairs a file update with the path of its YAML field.
+type updateFileField struct {
+	field  string
+	update *UpdateFile
+}
+
+// updateFileFields returns the image's file updates with their field paths.
+func (i *Image) updateFileFields() []updateFileField {
+	var fields []updateFileField
+	if i.UpdateFile != nil {
+		fields = append(fields, updateFileField{"updateFile", i.UpdateFile})
+	}
+	for j := range i.UpdateFiles {
+		fields = append(fields, updateFileField{fmt.Sprintf("updateFiles[%d]", j), &i.UpdateFiles[j]})
+	}
+	return fields
+}
+
+// Destinations returns every destination path the image publishes to:
+// one per extracted member, or the image destination otherwise.
+func (i *Image) Destinations() []string {
+	if i.Source.Extract == nil {
+		return []string{i.Destination}
+	}
+	dests := make([]string, len(i.Source.Extract.Members))
+	for j, m := range i.Source.Extract.Members {
+		dests[j] = m.Destination
+	}
+	return dests
+}
+
+// LoadManifest reads, merges and validates an image manifest. The path may
+// be a manifest file, whose spec.includes are merged in, or a directory of
+// manifest fragments.
 func LoadManifest(path string) (*ImageManifest, error) {
-	data, err := os.ReadFile(path) //nolint:gosec // G304: Path is provided by user
+	manifest, err := LoadManifestRaw(path)
 	if err != nil {
-		return nil, fmt.Errorf("read manifest file: %w", err)
+		return nil, err
 	}
 
-	return ParseManifest(data)
+	if err := manifest.Validate(); err != nil {
+		return nil, fmt.Errorf("validate manifest: %w", err)
+	}
+
+	return manifest, nil
 }
 
 // ParseManifest parses an image manifest from YAML data.
 func ParseManifest(data []byte) (*ImageManifest, error) {
-	var manifest ImageManifest
-	if err := yaml.Unmarshal(data, &manifest); err != nil {
-		return nil, fmt.Errorf("parse manifest YAML: %w", err)
+	manifest, err := ParseManifestRaw(data)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := manifest.Validate(); err != nil {
 		return nil, fmt.Errorf("validate manifest: %w", err)
 	}
 
-	return &manifest, nil
+	return manifest, nil
 }
 
-// ParseManifestRaw parses an image manifest from YAML data without validation.
-// Use this when you want to collect all validation errors separately.
+// ParseManifestRaw parses an image manifest from YAML data and expands its
+// template variables, without validation. Use this when you want to collect
+// all validation errors separately.
 func ParseManifestRaw(data []byte) (*ImageManifest, error) {
-	var manifest ImageManifest
-	if err := yaml.Unmarshal(data, &manifest); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("parse manifest YAML: %w", err)
 	}
-	return &manifest, nil
+
+	manifest, from, err := decodeManifest(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest YAML: %w", err)
+	}
+	if warning := deprecationWarning(from); warning != "" {
+		manifest.Warnings = append(manifest.Warnings, warning)
+	}
+
+	if err := manifest.expandVars(); err != nil {
+		return nil, fmt.Errorf("expand manifest vars: %w", err)
+	}
+	return manifest, nil
 }
 
-// LoadManifestRaw reads and parses an image manifest without validation.
+// LoadManifestRaw reads and merges an image manifest without validation.
 // Use this when you want to collect all validation errors separately.
 func LoadManifestRaw(path string) (*ImageManifest, error) {
-	data, err := os.ReadFile(path) //nolint:gosec // G304: Path is provided by user
-	if err != nil {
-		return nil, fmt.Errorf("read manifest file: %w", err)
-	}
-	return ParseManifestRaw(data)
+	l := &loader{visited: make(map[string]bool)}
+	return l.load(path)
 }
 
 // Validate checks that the manifest is well-formed.
@@ -132,7 +338,7 @@ func (m *ImageManifest) ValidateAll() []error {
 	var errs []error
 
 	if m.APIVersion != SupportedAPIVersion {
-		errs = append(errs, fmt.Errorf("unsupported apiVersion %q, expected %q", m.APIVersion, SupportedAPIVersion))
+		errs = append(errs, fmt.Errorf("unsupported apiVersion %q, expected one of %s", m.APIVersion, strings.Join(APIVersions(), ", ")))
 	}
 
 	if m.Kind != "ImageManifest" {
@@ -143,19 +349,142 @@ func (m *ImageManifest) ValidateAll() []error {
 		errs = append(errs, fmt.Errorf("metadata.name is required"))
 	}
 
+	if m.Spec.TransferWindow != nil {
+		if _, _, _, err := m.Spec.TransferWindow.parse(); err != nil {
+			errs = append(errs, fmt.Errorf("spec.transferWindow: %w", err))
+		}
+	}
+
+	if m.Spec.Publish != nil {
+		for _, err := range m.Spec.Publish.validate() {
+			errs = append(errs, fmt.Errorf("spec.publish: %w", err))
+		}
+	}
+
 	for i, img := range m.Spec.Images {
 		imgName := img.Name
 		if imgName == "" {
 			imgName = fmt.Sprintf("unnamed-%d", i)
 		}
 		for _, err := range img.ValidateAll() {
+			// Keep the position first so the error still reads file:line: ...
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				errs = append(errs, &ValidationError{Pos: verr.Pos, Err: fmt.Errorf("image[%d] %q: %w", i, imgName, verr.Err)})
+				continue
+			}
 			errs = append(errs, fmt.Errorf("image[%d] %q: %w", i, imgName, err))
 		}
 	}
 
+	errs = append(errs, m.validateRules()...)
+
+	return errs
+}
+
+// validate checks the publish settings.
+func (p *Publish) validate() []error {
+	var errs []error
+	if p.NASRoot != "" && !path.IsAbs(p.NASRoot) {
+		errs = append(errs, fmt.Errorf("nasRoot must be an absolute path"))
+	}
+	if p.BaseURL != "" && !strings.HasPrefix(p.BaseURL, "https://") && !strings.HasPrefix(p.BaseURL, "http://") {
+		errs = append(errs, fmt.Errorf("baseURL must be an HTTP or HTTPS URL"))
+	}
+	if _, err := p.Expiry(); err != nil {
+		errs = append(errs, err)
+	}
 	return errs
 }
 
+// NASPath returns the path of a destination on the NAS. p may be nil.
+func (p *Publish) NASPath(destination string) string {
+	root := DefaultNASRoot
+	if p != nil && p.NASRoot != "" {
+		root = p.NASRoot
+	}
+	return path.Join(root, destination)
+}
+
+// PublicURL returns the public URL of a destination, or "" if no base URL
+// is set. p may be nil.
+func (p *Publish) PublicURL(destination string) string {
+	if p == nil || p.BaseURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(p.BaseURL, "/") + "/" + destination
+}
+
+// Expiry returns the lifetime of presigned URLs. p may be nil.
+func (p *Publish) Expiry() (time.Duration, error) {
+	if p == nil || p.PresignExpiry == "" {
+		return MaxPresignExpiry, nil
+	}
+	d, err := time.ParseDuration(p.PresignExpiry)
+	if err != nil {
+		return 0, fmt.Errorf("presignExpiry is invalid: %w", err)
+	}
+	if d <= 0 || d > MaxPresignExpiry {
+		return 0, fmt.Errorf("presignExpiry must be between 0 and %s", MaxPresignExpiry)
+	}
+	return d, nil
+}
+
+// Contains reports whether t falls inside the transfer window.
+func (w *TransferWindow) Contains(t time.Time) (bool, error) {
+	start, end, loc, err := w.parse()
+	if err != nil {
+		return false, err
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return minute >= start && minute < end, nil
+	}
+	// The window wraps past midnight, e.g. 22:00-06:00
+	return minute >= start || minute < end, nil
+}
+
+// String returns the window in "HH:MM-HH:MM timezone" form.
+func (w *TransferWindow) String() string {
+	tz := w.Timezone
+	if tz == "" {
+		tz = "local time"
+	}
+	return fmt.Sprintf("%s-%s %s", w.Start, w.End, tz)
+}
+
+// parse returns the window bounds as minutes after midnight and its location.
+func (w *TransferWindow) parse() (start, end int, loc *time.Location, err error) {
+	parseClock := func(field, value string) (int, error) {
+		t, err := time.Parse("15:04", value)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be in HH:MM form, got %q", field, value)
+		}
+		return t.Hour()*60 + t.Minute(), nil
+	}
+
+	if start, err = parseClock("start", w.Start); err != nil {
+		return 0, 0, nil, err
+	}
+	if end, err = parseClock("end", w.End); err != nil {
+		return 0, 0, nil, err
+	}
+	if start == end {
+		return 0, 0, nil, fmt.Errorf("start and end must differ")
+	}
+
+	loc = time.Local
+	if w.Timezone != "" {
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return 0, 0, nil, fmt.Errorf("invalid timezone %q: %w", w.Timezone, err)
+		}
+	}
+
+	return start, end, loc, nil
+}
+
 // Validate checks that the image configuration is valid.
 func (i *Image) Validate() error {
 	errs := i.ValidateAll()
@@ -170,65 +499,251 @@ func (i *Image) ValidateAll() []error {
 	var errs []error
 
 	if i.Name == "" {
-		errs = append(errs, fmt.Errorf("name is required"))
+		errs = append(errs, i.errorAt("name", fmt.Errorf("name is required")))
+	}
+
+	errs = append(errs, i.validateGroupsAndLabels()...)
+
+	switch {
+	case i.Source.GitHub != nil:
+		errs = append(errs, i.validateGitHubSource()...)
+	case i.Source.URL == "":
+		errs = append(errs, i.errorAt("source.url", fmt.Errorf("source.url is required")))
+	case oci.IsOCI(i.Source.URL):
+		errs = append(errs, i.validateOCISource()...)
+	case !strings.HasPrefix(i.Source.URL, "https://"):
+		errs = append(errs, i.errorAt("source.url", fmt.Errorf("source.url must use HTTPS or oci://")))
 	}
 
-	if i.Source.URL == "" {
-		errs = append(errs, fmt.Errorf("source.url is required"))
-	} else if !strings.HasPrefix(i.Source.URL, "https://") {
-		errs = append(errs, fmt.Errorf("source.url must use HTTPS"))
+	for j, mirror := range i.Source.Mirrors {
+		if !strings.HasPrefix(mirror, "https://") {
+			errs = append(errs, i.errorAt(fmt.Sprintf("source.mirrors[%d]", j), fmt.Errorf("source.mirrors[%d] must use HTTPS", j)))
+		}
 	}
 
-	if i.Source.Checksum == "" {
-		errs = append(errs, fmt.Errorf("source.checksum is required"))
+	// GitHub sources may take the checksum from the release asset digest
+	if i.Source.Checksum == "" && i.Source.GitHub == nil {
+		errs = append(errs, i.errorAt("source.checksum", fmt.Errorf("source.checksum is required")))
 	}
 
-	if i.Destination == "" {
-		errs = append(errs, fmt.Errorf("destination is required"))
+	switch {
+	case i.Source.Extract != nil:
+		errs = append(errs, i.validateExtract()...)
+	case i.Destination == "":
+		errs = append(errs, i.errorAt("destination", fmt.Errorf("destination is required")))
 	}
 
 	// Validate decompress option
 	if i.Source.Decompress != "" {
-		switch i.Source.Decompress {
-		case "xz", "gzip", "zstd":
-			// valid
-		default:
-			errs = append(errs, fmt.Errorf("unsupported decompress format %q, must be xz, gzip, or zstd", i.Source.Decompress))
+		if !slices.Contains(DecompressFormats, i.Source.Decompress) {
+			errs = append(errs, i.errorAt("source.decompress", fmt.Errorf("unsupported decompress format %q, must be xz, gzip, or zstd", i.Source.Decompress)))
 		}
 
 		// validation.expected is required when decompress is used
 		if i.Validation == nil || i.Validation.Expected == "" {
-			errs = append(errs, fmt.Errorf("validation.expected is required when decompress is used"))
+			errs = append(errs, i.errorAt("validation.expected", fmt.Errorf("validation.expected is required when decompress is used")))
 		}
 	}
 
 	// Validate algorithm if validation is specified
 	if i.Validation != nil {
-		switch i.Validation.Algorithm {
-		case "sha256", "sha512":
+		if i.Validation.Expected == "" {
+			errs = append(errs, i.errorAt("validation.expected", fmt.Errorf("validation.expected is required when validation is set")))
+		} else if err := validateAlgorithm(i.Validation.Algorithm); err != nil {
+			errs = append(errs, i.errorAt("validation.expected", err))
+		}
+	}
+
+	if i.Transform != nil {
+		switch i.Transform.Format {
+		case diskimage.FormatQCOW2, diskimage.FormatRaw:
 			// valid
 		default:
-			errs = append(errs, fmt.Errorf("unsupported validation algorithm %q, must be sha256 or sha512", i.Validation.Algorithm))
+			errs = append(errs, i.errorAt("transform.format", fmt.Errorf("unsupported transform format %q, must be %s", i.Transform.Format, strings.Join(diskimage.Formats, " or "))))
+		}
+
+		if i.Transform.Sparse && i.Transform.Format != diskimage.FormatQCOW2 {
+			errs = append(errs, i.errorAt("transform.sparse", fmt.Errorf("transform.sparse is only supported with qcow2")))
 		}
 	}
 
-	// Validate updateFile regex patterns compile
-	if i.UpdateFile != nil {
-		if i.UpdateFile.Path == "" {
-			errs = append(errs, fmt.Errorf("updateFile.path is required"))
+	if i.OCI != nil {
+		ref, err := oci.ParseReference(i.OCI.Reference)
+		switch {
+		case i.OCI.Reference == "":
+			errs = append(errs, i.errorAt("oci.reference", fmt.Errorf("oci.reference is required")))
+		case err != nil:
+			errs = append(errs, i.errorAt("oci.reference", fmt.Errorf("oci.reference is invalid: %w", err)))
+		case ref.Tag == "" || ref.Digest != "":
+			errs = append(errs, i.errorAt("oci.reference", fmt.Errorf("oci.reference must have a tag and no digest")))
 		}
+	}
 
-		for j, r := range i.UpdateFile.Replacements {
-			if r.Pattern == "" {
-				errs = append(errs, fmt.Errorf("updateFile.replacements[%d].pattern is required", j))
-			} else if _, err := regexp.Compile(r.Pattern); err != nil {
-				errs = append(errs, fmt.Errorf("updateFile.replacements[%d].pattern is invalid: %w", j, err))
-			}
+	// Validate file update globs, and that regex patterns compile and paths parse
+	if i.UpdateFile != nil && len(i.UpdateFiles) > 0 {
+		errs = append(errs, i.errorAt("updateFiles", fmt.Errorf("updateFile and updateFiles are mutually exclusive")))
+	}
+	for _, uf := range i.updateFileFields() {
+		if uf.update.Path == "" {
+			errs = append(errs, i.errorAt(uf.field+".path", fmt.Errorf("%s.path is required", uf.field)))
+		} else if _, err := filepath.Match(uf.update.Path, ""); err != nil {
+			errs = append(errs, i.errorAt(uf.field+".path", fmt.Errorf("%s.path is not a valid glob: %w", uf.field, err)))
+		}
 
-			if r.Value == "" {
-				errs = append(errs, fmt.Errorf("updateFile.replacements[%d].value is required", j))
-			}
+		for j, r := range uf.update.Replacements {
+			errs = append(errs, r.validate(i, fmt.Sprintf("%s.replacements[%d]", uf.field, j))...)
+		}
+	}
+
+	return errs
+}
+
+// validate checks the replacement at field of img. Regex replacements need a
+// pattern and structured replacements need a path, never both.
+func (r *Replacement) validate(img *Image, field string) []error {
+	var errs []error
+
+	switch {
+	case r.ExpectMatches < 0:
+		errs = append(errs, img.errorAt(field+".expectMatches", fmt.Errorf("%s.expectMatches must be at least 1", field)))
+	case r.ExpectMatches > 0 && r.Required:
+		errs = append(errs, img.errorAt(field+".required", fmt.Errorf("%s.required is implied by expectMatches", field)))
+	}
+
+	switch {
+	case r.Type == "" || r.Type == updater.TypeRegex:
+		if r.Path != "" {
+			errs = append(errs, img.errorAt(field+".path", fmt.Errorf("%s.path requires a structured type, not regex", field)))
+		}
+		if r.Pattern == "" {
+			errs = append(errs, img.errorAt(field+".pattern", fmt.Errorf("%s.pattern is required", field)))
+		} else if _, err := regexp.Compile(r.Pattern); err != nil {
+			errs = append(errs, img.errorAt(field+".pattern", fmt.Errorf("%s.pattern is invalid: %w", field, err)))
+		}
+	case slices.Contains(updater.Types, r.Type):
+		if r.Pattern != "" {
+			errs = append(errs, img.errorAt(field+".pattern", fmt.Errorf("%s.pattern is only used with type regex", field)))
+		}
+		if r.Path == "" {
+			errs = append(errs, img.errorAt(field+".path", fmt.Errorf("%s.path is required for type %s", field, r.Type)))
+		} else if err := updater.ValidatePath(r.Path); err != nil {
+			errs = append(errs, img.errorAt(field+".path", fmt.Errorf("%s.path is invalid: %w", field, err)))
 		}
+	default:
+		errs = append(errs, img.errorAt(field+".type", fmt.Errorf("%s.type %q is not supported, must be one of %s", field, r.Type, strings.Join(updater.Types, ", "))))
+	}
+
+	if r.Value == "" {
+		errs = append(errs, img.errorAt(field+".value", fmt.Errorf("%s.value is required", field)))
+	}
+	return errs
+}
+
+// validateExtract checks a source.extract block. Each member carries its own
+// destination and validation, so the image-level fields must not be set.
+func (i *Image) validateExtract() []error {
+	var errs []error
+	ex := i.Source.Extract
+
+	if ex.Format == "" {
+		errs = append(errs, i.errorAt("source.extract.format", fmt.Errorf("source.extract.format is required")))
+	} else if !archive.IsSupported(ex.Format) {
+		errs = append(errs, i.errorAt("source.extract.format", fmt.Errorf("unsupported source.extract.format %q, must be one of %s", ex.Format, strings.Join(archive.Formats, ", "))))
+	}
+
+	if i.Source.Decompress != "" {
+		errs = append(errs, i.errorAt("source.decompress", fmt.Errorf("source.decompress must not be set when source.extract is used")))
+	}
+	if i.Destination != "" {
+		errs = append(errs, i.errorAt("destination", fmt.Errorf("destination must not be set when source.extract is used, set it on each member")))
+	}
+	if i.Validation != nil {
+		errs = append(errs, i.errorAt("validation", fmt.Errorf("validation must not be set when source.extract is used, set it on each member")))
+	}
+	if i.OCI != nil {
+		errs = append(errs, i.errorAt("oci", fmt.Errorf("oci is not supported with source.extract")))
+	}
+
+	if len(ex.Members) == 0 {
+		errs = append(errs, i.errorAt("source.extract.members", fmt.Errorf("source.extract.members must not be empty")))
+	}
+
+	for j, m := range ex.Members {
+		member := fmt.Sprintf("source.extract.members[%d]", j)
+		if m.Path == "" {
+			errs = append(errs, i.errorAt(member+".path", fmt.Errorf("source.extract.members[%d].path is required", j)))
+		} else if _, err := path.Match(m.Path, ""); err != nil {
+			errs = append(errs, i.errorAt(member+".path", fmt.Errorf("source.extract.members[%d].path is invalid: %w", j, err)))
+		}
+
+		if m.Destination == "" {
+			errs = append(errs, i.errorAt(member+".destination", fmt.Errorf("source.extract.members[%d].destination is required", j)))
+		}
+
+		if m.Validation == nil || m.Validation.Expected == "" {
+			errs = append(errs, i.errorAt(member+".validation.expected", fmt.Errorf("source.extract.members[%d].validation.expected is required", j)))
+		} else if err := validateAlgorithm(m.Validation.Algorithm); err != nil {
+			errs = append(errs, i.errorAt(member+".validation.expected", fmt.Errorf("source.extract.members[%d]: %w", j, err)))
+		}
+	}
+
+	return errs
+}
+
+// validateAlgorithm checks a validation algorithm name.
+func validateAlgorithm(algorithm string) error {
+	if !slices.Contains(Algorithms, algorithm) {
+		return fmt.Errorf("unsupported validation algorithm %q, must be sha256 or sha512", algorithm)
+	}
+	return nil
+}
+
+// validateOCISource checks an oci:// source URL. The URL must name a blob by
+// digest, and source.checksum must agree with it.
+func (i *Image) validateOCISource() []error {
+	ref, err := oci.ParseReference(i.Source.URL)
+	if err != nil {
+		return []error{i.errorAt("source.url", fmt.Errorf("source.url is invalid: %w", err))}
+	}
+	if ref.Digest == "" {
+		return []error{i.errorAt("source.url", fmt.Errorf("source.url must reference a blob by digest"))}
+	}
+	if i.Source.Checksum != "" && i.Source.Checksum != ref.Digest {
+		return []error{i.errorAt("source.checksum", fmt.Errorf("source.checksum %q does not match OCI digest %q", i.Source.Checksum, ref.Digest))}
+	}
+	return nil
+}
+
+// validateGitHubSource checks a source.github block.
+func (i *Image) validateGitHubSource() []error {
+	var errs []error
+	gh := i.Source.GitHub
+
+	if i.Source.URL != "" {
+		errs = append(errs, i.errorAt("source.url", fmt.Errorf("source.url must not be set when source.github is used")))
+	}
+
+	if owner, name, ok := strings.Cut(gh.Repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		errs = append(errs, i.errorAt("source.github.repo", fmt.Errorf("source.github.repo must be in owner/name form")))
+	}
+
+	switch {
+	case gh.Tag == "" && gh.TagPattern == "":
+		errs = append(errs, i.errorAt("source.github.tag", fmt.Errorf("source.github.tag or source.github.tagPattern is required")))
+	case gh.Tag != "" && gh.TagPattern != "":
+		errs = append(errs, i.errorAt("source.github.tagPattern", fmt.Errorf("only one of source.github.tag and source.github.tagPattern may be set")))
+	case gh.TagPattern != "":
+		if _, err := regexp.Compile(gh.TagPattern); err != nil {
+			errs = append(errs, i.errorAt("source.github.tagPattern", fmt.Errorf("source.github.tagPattern is invalid: %w", err)))
+		}
+	case gh.Prerelease && gh.Tag != github.TagLatest:
+		errs = append(errs, i.errorAt("source.github.prerelease", fmt.Errorf("source.github.prerelease only applies to tag latest and tagPattern, an exact tag selects its release either way")))
+	}
+
+	if gh.Asset == "" {
+		errs = append(errs, i.errorAt("source.github.asset", fmt.Errorf("source.github.asset is required")))
+	} else if _, err := path.Match(gh.Asset, ""); err != nil {
+		errs = append(errs, i.errorAt("source.github.asset", fmt.Errorf("source.github.asset is invalid: %w", err)))
 	}
 
 	return errs
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,7 +27,7 @@ spec:
     - name: talos-1.9.1
       source:
         url: https://factory.talos.dev/image/metal-amd64.raw.xz
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: talos/talos-1.9.1-amd64.raw
 `,
 		},
@@ -41,12 +42,12 @@ spec:
     - name: talos-1.9.1
       source:
         url: https://factory.talos.dev/image/metal-amd64.raw.xz
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
         decompress: xz
       destination: talos/talos-1.9.1-amd64.raw
       validation:
         algorithm: sha256
-        expected: sha256:def456
+        expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
 `,
 		},
 		{
@@ -60,7 +61,7 @@ spec:
     - name: vyos-iso
       source:
         url: https://github.com/vyos/vyos-rolling-nightly-builds/releases/download/1.5/vyos-1.5.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: vyos/vyos-1.5.iso
       updateFile:
         path: infrastructure/example/vars.hcl
@@ -112,7 +113,7 @@ spec:
   images:
     - source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
 `,
 			wantErr: `image[0] "unnamed-0": name is required`,
@@ -127,7 +128,7 @@ spec:
   images:
     - name: test-image
       source:
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
 `,
 			wantErr: "source.url is required",
@@ -143,7 +144,7 @@ spec:
     - name: test-image
       source:
         url: http://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
 `,
 			wantErr: "source.url must use HTTPS",
@@ -174,7 +175,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
 `,
 			wantErr: "destination is required",
 		},
@@ -189,7 +190,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
         decompress: zip
       destination: images/image.iso
 `,
@@ -206,7 +207,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.raw.xz
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
         decompress: xz
       destination: images/image.raw
 `,
@@ -223,7 +224,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
       validation:
         algorithm: md5
@@ -242,7 +243,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
       updateFile:
         path: some/file.txt
@@ -263,7 +264,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
       updateFile:
         replacements:
@@ -272,6 +273,739 @@ spec:
 `,
 			wantErr: "updateFile.path is required",
 		},
+		{
+			name: "valid updateFiles with globs",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFiles:
+        - path: infrastructure/capi/*.yaml
+          replacements:
+            - pattern: 'talos-[0-9.]+'
+              value: '{{ .Name }}'
+        - path: docs/talos.md
+          replacements:
+            - pattern: 'talos-[0-9.]+'
+              value: '{{ .Name }}'
+`,
+		},
+		{
+			name: "updateFile and updateFiles together",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFile:
+        path: docs/talos.md
+        replacements: [{pattern: a, value: b}]
+      updateFiles:
+        - path: docs/other.md
+          replacements: [{pattern: a, value: b}]
+`,
+			wantErr: "updateFile and updateFiles are mutually exclusive",
+		},
+		{
+			name: "updateFiles entry with invalid glob",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFiles:
+        - path: docs/talos.md
+          replacements: [{pattern: a, value: b}]
+        - path: 'docs/[talos.md'
+          replacements: [{type: yamlpath, value: b}]
+`,
+			wantErr: "updateFiles[1].path is not a valid glob",
+		},
+		{
+			name: "expectMatches with required",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFiles:
+        - path: docs/talos.md
+          replacements: [{pattern: a, value: b, expectMatches: 2, required: true}]
+`,
+			wantErr: "updateFiles[0].replacements[0].required is implied by expectMatches",
+		},
+		{
+			name: "negative expectMatches",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFiles:
+        - path: docs/talos.md
+          replacements: [{pattern: a, value: b, expectMatches: -1}]
+`,
+			wantErr: "updateFiles[0].replacements[0].expectMatches must be at least 1",
+		},
+		{
+			name: "updateFiles replacement errors name the entry",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFiles:
+        - path: docs/talos.md
+          replacements: [{type: yamlpath, value: b}]
+`,
+			wantErr: "updateFiles[0].replacements[0].path is required for type yamlpath",
+		},
+		{
+			name: "valid structured replacements",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFile:
+        path: some/file.yaml
+        replacements:
+          - type: yamlpath
+            path: spec.url
+            value: '{{ .Source.URL }}'
+          - type: toml
+            path: includes_chroot[0].url
+            value: '{{ .Source.URL }}'
+`,
+		},
+		{
+			name: "structured replacement without path",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFile:
+        path: some/file.yaml
+        replacements:
+          - type: jsonpath
+            value: '{{ .Source.URL }}'
+`,
+			wantErr: "updateFile.replacements[0].path is required for type jsonpath",
+		},
+		{
+			name: "structured replacement with pattern",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFile:
+        path: some/file.yaml
+        replacements:
+          - type: yamlpath
+            path: spec.url
+            pattern: url
+            value: '{{ .Source.URL }}'
+`,
+			wantErr: "updateFile.replacements[0].pattern is only used with type regex",
+		},
+		{
+			name: "invalid replacement path",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFile:
+        path: some/file.yaml
+        replacements:
+          - type: yamlpath
+            path: spec..url
+            value: '{{ .Source.URL }}'
+`,
+			wantErr: "updateFile.replacements[0].path is invalid",
+		},
+		{
+			name: "unsupported replacement type",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      updateFile:
+        path: some/file.yaml
+        replacements:
+          - type: xml
+            path: spec.url
+            value: '{{ .Source.URL }}'
+`,
+			wantErr: "updateFile.replacements[0].type \"xml\" is not supported",
+		},
+		{
+			name: "valid manifest with OCI source and destination",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: talos-installer
+      source:
+        url: oci://ghcr.io/siderolabs/installer@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+        checksum: sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+      destination: talos/installer.raw
+      oci:
+        reference: ghcr.io/gilmanlab/images/talos-installer:v1.9.1
+`,
+		},
+		{
+			name: "OCI source without digest",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: talos-installer
+      source:
+        url: oci://ghcr.io/siderolabs/installer:v1.9.1
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: talos/installer.raw
+`,
+			wantErr: "source.url must reference a blob by digest",
+		},
+		{
+			name: "OCI source checksum mismatch",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: talos-installer
+      source:
+        url: oci://ghcr.io/siderolabs/installer@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: talos/installer.raw
+`,
+			wantErr: "does not match OCI digest",
+		},
+		{
+			name: "OCI destination without tag",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: test-image
+      source:
+        url: https://example.com/image.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: images/image.iso
+      oci:
+        reference: ghcr.io/gilmanlab/images/test
+`,
+			wantErr: "oci.reference must have a tag and no digest",
+		},
+		{
+			name: "valid manifest with GitHub release source",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: vyos-iso
+      source:
+        github:
+          repo: vyos/vyos-nightly-build
+          tag: latest
+          asset: vyos-*-generic-amd64.iso
+      destination: vyos/vyos.iso
+`,
+		},
+		{
+			name: "GitHub source with url",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: vyos-iso
+      source:
+        url: https://example.com/vyos.iso
+        github:
+          repo: vyos/vyos-nightly-build
+          tag: latest
+          asset: "*.iso"
+      destination: vyos/vyos.iso
+`,
+			wantErr: "source.url must not be set when source.github is used",
+		},
+		{
+			name: "GitHub source with tag and tagPattern",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: vyos-iso
+      source:
+        github:
+          repo: vyos/vyos-nightly-build
+          tag: latest
+          tagPattern: '^2025\.'
+          asset: "*.iso"
+      destination: vyos/vyos.iso
+`,
+			wantErr: "only one of source.github.tag and source.github.tagPattern may be set",
+		},
+		{
+			name: "GitHub prerelease with an exact tag",
+			yaml: `apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: vyos-iso
+      source:
+        github:
+          repo: vyos/vyos-nightly-build
+          tag: 2025.12.20-0020-rolling
+          prerelease: true
+          asset: "*.iso"
+      destination: vyos/vyos.iso
+`,
+			wantErr: "source.github.prerelease only applies to tag latest and tagPattern",
+		},
+		{
+			name: "GitHub source with invalid repo",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: vyos-iso
+      source:
+        github:
+          repo: vyos
+          tag: latest
+          asset: "*.iso"
+      destination: vyos/vyos.iso
+`,
+			wantErr: "source.github.repo must be in owner/name form",
+		},
+		{
+			name: "GitHub source with invalid asset glob",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: vyos-iso
+      source:
+        github:
+          repo: vyos/vyos-nightly-build
+          tag: latest
+          asset: "[invalid"
+      destination: vyos/vyos.iso
+`,
+			wantErr: "source.github.asset is invalid",
+		},
+		{
+			name: "valid manifest with archive extraction",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: hook
+      source:
+        url: https://github.com/tinkerbell/hook/releases/download/v0.10.0/hook_x86_64.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        extract:
+          format: tar.gz
+          members:
+            - path: vmlinuz-x86_64
+              destination: hook/vmlinuz-x86_64
+              validation:
+                algorithm: sha256
+                expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+            - path: initramfs-*
+              destination: hook/initramfs-x86_64
+              validation:
+                algorithm: sha256
+                expected: sha256:789abc0000000000000000000000000000000000000000000000000000000000
+`,
+		},
+		{
+			name: "extract with unsupported format",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: hook
+      source:
+        url: https://github.com/tinkerbell/hook/releases/download/v0.10.0/hook_x86_64.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        extract:
+          format: rar
+          members:
+            - path: vmlinuz-x86_64
+              destination: hook/vmlinuz-x86_64
+              validation:
+                algorithm: sha256
+                expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+`,
+			wantErr: "unsupported source.extract.format \"rar\"",
+		},
+		{
+			name: "extract with image destination",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: hook
+      source:
+        url: https://github.com/tinkerbell/hook/releases/download/v0.10.0/hook_x86_64.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        extract:
+          format: tar.gz
+          members:
+            - path: vmlinuz-x86_64
+              destination: hook/vmlinuz-x86_64
+              validation:
+                algorithm: sha256
+                expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+      destination: hook/hook.tar.gz
+`,
+			wantErr: "destination must not be set when source.extract is used",
+		},
+		{
+			name: "extract with decompress",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: hook
+      source:
+        url: https://github.com/tinkerbell/hook/releases/download/v0.10.0/hook_x86_64.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        decompress: gzip
+        extract:
+          format: tar
+          members:
+            - path: vmlinuz-x86_64
+              destination: hook/vmlinuz-x86_64
+              validation:
+                algorithm: sha256
+                expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+`,
+			wantErr: "source.decompress must not be set when source.extract is used",
+		},
+		{
+			name: "extract member without validation",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: hook
+      source:
+        url: https://github.com/tinkerbell/hook/releases/download/v0.10.0/hook_x86_64.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        extract:
+          format: zip
+          members:
+            - path: disk.qcow2
+              destination: hook/disk.qcow2
+`,
+			wantErr: "source.extract.members[0].validation.expected is required",
+		},
+		{
+			name: "extract without members",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: hook
+      source:
+        url: https://github.com/tinkerbell/hook/releases/download/v0.10.0/hook_x86_64.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        extract:
+          format: zip
+`,
+			wantErr: "source.extract.members must not be empty",
+		},
+		{
+			name: "valid manifest with mirrors",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: harvester
+      source:
+        url: https://releases.rancher.com/harvester/v1.4.0/harvester-v1.4.0-amd64.iso
+        mirrors:
+          - https://github.com/harvester/harvester/releases/download/v1.4.0/harvester-v1.4.0-amd64.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: harvester/harvester-v1.4.0-amd64.iso
+`,
+		},
+		{
+			name: "mirror without HTTPS",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: harvester
+      source:
+        url: https://releases.rancher.com/harvester/v1.4.0/harvester-v1.4.0-amd64.iso
+        mirrors:
+          - http://mirror.example.com/harvester-v1.4.0-amd64.iso
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: harvester/harvester-v1.4.0-amd64.iso
+`,
+			wantErr: "source.mirrors[0] must use HTTPS",
+		},
+		{
+			name: "valid manifest with transfer window",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  transferWindow:
+    start: "22:00"
+    end: "06:00"
+    timezone: America/Los_Angeles
+  images: []
+`,
+		},
+		{
+			name: "transfer window with invalid time",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  transferWindow:
+    start: "10pm"
+    end: "06:00"
+  images: []
+`,
+			wantErr: "spec.transferWindow: start must be in HH:MM form",
+		},
+		{
+			name: "transfer window with unknown timezone",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  transferWindow:
+    start: "22:00"
+    end: "06:00"
+    timezone: Mars/Olympus
+  images: []
+`,
+			wantErr: "invalid timezone",
+		},
+		{
+			name: "valid manifest with publish",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  publish:
+    nasRoot: /volume1/images
+    baseURL: https://images.lab.gilman.io
+    presignExpiry: 24h
+  images: []
+`,
+		},
+		{
+			name: "publish with relative NAS root",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  publish:
+    nasRoot: images
+  images: []
+`,
+			wantErr: "spec.publish: nasRoot must be an absolute path",
+		},
+		{
+			name: "publish with presign expiry over the S3 limit",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  publish:
+    presignExpiry: 200h
+  images: []
+`,
+			wantErr: "spec.publish: presignExpiry must be between 0 and 168h0m0s",
+		},
+		{
+			name: "valid manifest with transform",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: talos-1.9.1
+      source:
+        url: https://factory.talos.dev/image/metal-amd64.raw.xz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        decompress: xz
+      destination: talos/talos-1.9.1-amd64.qcow2
+      validation:
+        algorithm: sha256
+        expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+      transform:
+        format: qcow2
+        sparse: true
+`,
+		},
+		{
+			name: "transform with unsupported format",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: talos-1.9.1
+      source:
+        url: https://factory.talos.dev/image/metal-amd64.raw.xz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        decompress: xz
+      destination: talos/talos-1.9.1-amd64.qcow2
+      validation:
+        algorithm: sha256
+        expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+      transform:
+        format: vmdk
+`,
+			wantErr: "unsupported transform format \"vmdk\", must be raw or qcow2",
+		},
+		{
+			name: "sparse transform to raw",
+			yaml: `apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: lab-images
+spec:
+  images:
+    - name: talos-1.9.1
+      source:
+        url: https://factory.talos.dev/image/metal-amd64.raw.xz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        decompress: xz
+      destination: talos/talos-1.9.1-amd64.qcow2
+      validation:
+        algorithm: sha256
+        expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+      transform:
+        format: raw
+        sparse: true
+`,
+			wantErr: "transform.sparse is only supported with qcow2",
+		},
 	}
 
 	for _, tt := range tests {
@@ -304,7 +1038,7 @@ spec:
     - name: test-image
       source:
         url: https://example.com/image.iso
-        checksum: sha256:abc123
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
       destination: images/image.iso
 `
 		err := os.WriteFile(path, []byte(content), 0o600)
@@ -360,3 +1094,117 @@ func TestImage_EffectiveChecksum(t *testing.T) {
 		})
 	}
 }
+
+func TestImage_Destinations(t *testing.T) {
+	t.Run("single destination", func(t *testing.T) {
+		img := Image{Destination: "vyos/vyos.iso"}
+		assert.Equal(t, []string{"vyos/vyos.iso"}, img.Destinations())
+	})
+
+	t.Run("extracted members", func(t *testing.T) {
+		img := Image{
+			Source: Source{
+				Extract: &Extract{
+					Format: "tar.gz",
+					Members: []ExtractMember{
+						{Path: "vmlinuz-x86_64", Destination: "hook/vmlinuz-x86_64"},
+						{Path: "initramfs-x86_64", Destination: "hook/initramfs-x86_64"},
+					},
+				},
+			},
+		}
+		assert.Equal(t, []string{"hook/vmlinuz-x86_64", "hook/initramfs-x86_64"}, img.Destinations())
+	})
+}
+
+func TestTransferWindow_Contains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name   string
+		window TransferWindow
+		at     time.Time
+		want   bool
+	}{
+		{name: "inside daytime window", window: TransferWindow{Start: "09:00", End: "17:00", Timezone: "UTC"}, at: at(12, 0), want: true},
+		{name: "start is inclusive", window: TransferWindow{Start: "09:00", End: "17:00", Timezone: "UTC"}, at: at(9, 0), want: true},
+		{name: "end is exclusive", window: TransferWindow{Start: "09:00", End: "17:00", Timezone: "UTC"}, at: at(17, 0), want: false},
+		{name: "before wrapping window", window: TransferWindow{Start: "22:00", End: "06:00", Timezone: "UTC"}, at: at(21, 59), want: false},
+		{name: "after midnight in wrapping window", window: TransferWindow{Start: "22:00", End: "06:00", Timezone: "UTC"}, at: at(2, 30), want: true},
+		{name: "timezone conversion", window: TransferWindow{Start: "01:00", End: "06:00", Timezone: "America/New_York"}, at: at(7, 0), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.window.Contains(tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	t.Run("defaults when unset", func(t *testing.T) {
+		var p *Publish
+		assert.Equal(t, "/volume1/images/vyos/vyos.iso", p.NASPath("vyos/vyos.iso"))
+		assert.Empty(t, p.PublicURL("vyos/vyos.iso"))
+		expiry, err := p.Expiry()
+		require.NoError(t, err)
+		assert.Equal(t, MaxPresignExpiry, expiry)
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		p := &Publish{NASRoot: "/mnt/images", BaseURL: "https://images.example.com/", PresignExpiry: "12h"}
+		assert.Equal(t, "/mnt/images/vyos/vyos.iso", p.NASPath("vyos/vyos.iso"))
+		assert.Equal(t, "https://images.example.com/vyos/vyos.iso", p.PublicURL("vyos/vyos.iso"))
+		expiry, err := p.Expiry()
+		require.NoError(t, err)
+		assert.Equal(t, 12*time.Hour, expiry)
+	})
+}
+
+func TestUpdateFile_Targets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.yaml", "a.yaml", "c.md"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o600))
+	}
+
+	t.Run("glob matches sorted", func(t *testing.T) {
+		uf := UpdateFile{Path: filepath.Join(dir, "*.yaml")}
+		targets, err := uf.Targets("")
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")}, targets)
+	})
+
+	t.Run("literal path", func(t *testing.T) {
+		uf := UpdateFile{Path: filepath.Join(dir, "c.md")}
+		targets, err := uf.Targets("")
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "c.md")}, targets)
+	})
+
+	t.Run("relative to root", func(t *testing.T) {
+		uf := UpdateFile{Path: "*.md"}
+		targets, err := uf.Targets(dir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "c.md")}, targets)
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		uf := UpdateFile{Path: filepath.Join(dir, "*.toml")}
+		_, err := uf.Targets("")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "matches no files")
+	})
+}
+
+func TestImage_FileUpdates(t *testing.T) {
+	single := &UpdateFile{Path: "docs/talos.md"}
+	list := []UpdateFile{{Path: "capi/*.yaml"}, {Path: "docs/*.md"}}
+
+	assert.Empty(t, (&Image{}).FileUpdates())
+	assert.Equal(t, []UpdateFile{*single}, (&Image{UpdateFile: single}).FileUpdates())
+	assert.Equal(t, list, (&Image{UpdateFiles: list}).FileUpdates())
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// conversion loads manifest documents at an older API version. Each older
+// version has its own Go types, which decode converts to the current ones,
+// so conversions are checked by the compiler rather than by editing YAML.
+type conversion struct {
+	from   string
+	types  reflect.Type // Manifest type at from, for its schema
+	decode func(doc *yaml.Node) (*ImageManifest, error)
+}
+
+// conversions convert every older API version to SupportedAPIVersion.
+var conversions = []conversion{
+	{from: APIVersionV1Alpha1, types: reflect.TypeOf(v1alpha1ImageManifest{}), decode: decodeV1Alpha1},
+}
+
+// APIVersions lists every API version that can be loaded, oldest first.
+func APIVersions() []string {
+	versions := make([]string, 0, len(conversions)+1)
+	for _, c := range conversions {
+		versions = append(versions, c.from)
+	}
+	return append(versions, SupportedAPIVersion)
+}
+
+// conversionFrom returns the conversion from an older API version.
+func conversionFrom(version string) (conversion, bool) {
+	for _, c := range conversions {
+		if c.from == version {
+			return c, true
+		}
+	}
+	return conversion{}, false
+}
+
+// documentVersion returns the apiVersion of a parsed manifest document, or
+// "" if it has none.
+func documentVersion(doc *yaml.Node) string {
+	var header struct {
+		APIVersion string `yaml:"apiVersion"`
+	}
+	if doc.Kind == 0 || doc.Decode(&header) != nil {
+		return ""
+	}
+	return header.APIVersion
+}
+
+// decodeManifest decodes a parsed manifest document into the current types,
+// converting older API versions, and returns the API version it started at.
+// Documents with an unknown version decode as the current version and fail
+// validation later.
+func decodeManifest(doc *yaml.Node) (manifest *ImageManifest, from string, err error) {
+	from = documentVersion(doc)
+	if c, ok := conversionFrom(from); ok {
+		manifest, err = c.decode(doc)
+		if err != nil {
+			return nil, from, fmt.Errorf("convert %s to %s: %w", from, SupportedAPIVersion, err)
+		}
+		return manifest, from, nil
+	}
+
+	manifest = &ImageManifest{}
+	if doc.Kind != 0 {
+		if err := doc.Decode(manifest); err != nil {
+			return nil, from, err
+		}
+	}
+	return manifest, from, nil
+}
+
+// deprecationWarning returns the warning for loading a manifest at an older
+// API version, or "" if the version is current or unknown.
+func deprecationWarning(from string) string {
+	if _, ok := conversionFrom(from); !ok {
+		return ""
+	}
+	return fmt.Sprintf("apiVersion %s is deprecated, run \"labctl images migrate\" to upgrade to %s", from, SupportedAPIVersion)
+}
+
+// MigrateData upgrades manifest YAML to SupportedAPIVersion and returns the
+// API version it started at. The data is decoded into the types of its
+// version, converted, and encoded again, with comments, key order and
+// scalar styles carried over from the input. It must match the schema of
+// its version, so no field is dropped silently. Data already at
+// SupportedAPIVersion is returned unchanged; any other version is an error.
+func MigrateData(data []byte) (migrated []byte, from string, err error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, "", fmt.Errorf("parse manifest YAML: %w", err)
+	}
+
+	from = documentVersion(&doc)
+	if from == SupportedAPIVersion {
+		return data, from, nil
+	}
+	c, ok := conversionFrom(from)
+	if !ok {
+		return nil, from, fmt.Errorf("unsupported apiVersion %q, expected one of %s", from, strings.Join(APIVersions(), ", "))
+	}
+
+	if errs := validateNode(generateSchema(c.types), "", doc.Content[0]); len(errs) > 0 {
+		return nil, from, fmt.Errorf("does not match the %s schema: %w", from, errors.Join(errs...))
+	}
+	manifest, err := c.decode(&doc)
+	if err != nil {
+		return nil, from, fmt.Errorf("convert %s to %s: %w", from, SupportedAPIVersion, err)
+	}
+
+	var root yaml.Node
+	if err := root.Encode(manifest); err != nil {
+		return nil, from, fmt.Errorf("encode manifest: %w", err)
+	}
+	restoreFormat(&root, doc.Content[0])
+	out := &yaml.Node{
+		Kind:        yaml.DocumentNode,
+		HeadComment: doc.HeadComment,
+		LineComment: doc.LineComment,
+		FootComment: doc.FootComment,
+		Content:     []*yaml.Node{&root},
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return nil, from, fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, from, fmt.Errorf("encode manifest: %w", err)
+	}
+	return buf.Bytes(), from, nil
+}
+
+// restoreFormat carries comments, styles and key order over from orig to
+// out, the encoding of the converted document. Keys out has but orig lacks
+// are zero fields the encoder wrote and are dropped when empty. A comment
+// above a key the conversion removed moves to the key that follows it.
+func restoreFormat(out, orig *yaml.Node) {
+	if orig.Kind == yaml.AliasNode {
+		orig = orig.Alias
+	}
+	out.HeadComment, out.LineComment, out.FootComment = orig.HeadComment, orig.LineComment, orig.FootComment
+	if out.Kind != orig.Kind {
+		return
+	}
+
+	switch out.Kind {
+	case yaml.ScalarNode:
+		if out.Value == orig.Value {
+			out.Style = orig.Style
+		}
+	case yaml.SequenceNode:
+		out.Style = orig.Style
+		for i := range min(len(out.Content), len(orig.Content)) {
+			restoreFormat(out.Content[i], orig.Content[i])
+		}
+	case yaml.MappingNode:
+		out.Style = orig.Style
+		restoreMapping(out, orig)
+	}
+}
+
+// restoreMapping orders the keys of the mapping out as in orig, restoring
+// the format of each value.
+func restoreMapping(out, orig *yaml.Node) {
+	order := make(map[string]int)
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		order[orig.Content[i].Value] = i
+	}
+
+	var kept, added []*yaml.Node
+	for i := 0; i+1 < len(out.Content); i += 2 {
+		key, value := out.Content[i], out.Content[i+1]
+		j, ok := order[key.Value]
+		switch {
+		case ok:
+			restoreFormat(key, orig.Content[j])
+			restoreFormat(value, orig.Content[j+1])
+			kept = append(kept, key, value)
+		case !isEmptyNode(value):
+			added = append(added, key, value)
+		}
+	}
+	sort.Sort(byKeyOrder{kept, order})
+
+	// Move comments above removed keys to the next kept key
+	var pending string
+	next := 0
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		key := orig.Content[i]
+		if next < len(kept) && kept[next].Value == key.Value {
+			if pending != "" {
+				kept[next].HeadComment = strings.TrimSpace(pending + "\n" + kept[next].HeadComment)
+				pending = ""
+			}
+			next += 2
+			continue
+		}
+		if key.HeadComment != "" {
+			pending = strings.TrimSpace(pending + "\n" + key.HeadComment)
+		}
+	}
+
+	out.Content = append(kept, added...)
+}
+
+// byKeyOrder sorts mapping key-value pairs by their key's index in order.
+type byKeyOrder struct {
+	pairs []*yaml.Node
+	order map[string]int
+}
+
+func (b byKeyOrder) Len() int { return len(b.pairs) / 2 }
+
+func (b byKeyOrder) Less(i, j int) bool {
+	return b.order[b.pairs[2*i].Value] < b.order[b.pairs[2*j].Value]
+}
+
+func (b byKeyOrder) Swap(i, j int) {
+	b.pairs[2*i], b.pairs[2*j] = b.pairs[2*j], b.pairs[2*i]
+	b.pairs[2*i+1], b.pairs[2*j+1] = b.pairs[2*j+1], b.pairs[2*i+1]
+}
+
+// isEmptyNode reports whether node is null, an empty string, or an empty
+// sequence or mapping.
+func isEmptyNode(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Tag == "!!null" || node.Value == ""
+	case yaml.SequenceNode, yaml.MappingNode:
+		return len(node.Content) == 0
+	}
+	return false
+}
+
+// mappingEntry returns the key and value nodes for key in a mapping node, or nils.
+func mappingEntry(node *yaml.Node, key string) (keyNode, valueNode *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
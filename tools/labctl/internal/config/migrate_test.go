@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const v1alpha1Manifest = `# Lab images
+apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test
+spec:
+  images:
+    # Talos metal image
+    - name: talos
+      source:
+        url: https://example.com/talos.raw.xz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        decompress: xz
+      destination: talos/talos.raw
+      validation:
+        # Post-decompression checksum
+        algorithm: sha256
+        expected: sha256:def4560000000000000000000000000000000000000000000000000000000000
+    - name: hook
+      source:
+        url: https://example.com/hook.tar.gz
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+        extract:
+          format: tar.gz
+          members:
+            - path: vmlinuz
+              destination: hook/vmlinuz
+              validation:
+                algorithm: sha512
+                expected: sha512:` + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" + `
+`
+
+func TestMigrateData(t *testing.T) {
+	t.Run("converts v1alpha1 keeping comments and order", func(t *testing.T) {
+		migrated, from, err := MigrateData([]byte(v1alpha1Manifest))
+		require.NoError(t, err)
+		assert.Equal(t, APIVersionV1Alpha1, from)
+
+		out := string(migrated)
+		assert.Contains(t, out, "# Lab images\napiVersion: images.lab.gilman.io/v1beta1\n")
+		assert.Contains(t, out, "    # Talos metal image\n    - name: talos\n")
+		assert.Contains(t, out, "      validation:\n        # Post-decompression checksum\n        expected: sha256:def456")
+		assert.NotContains(t, out, "algorithm:")
+
+		// The result loads without a deprecation warning
+		manifest, err := ParseManifest(migrated)
+		require.NoError(t, err)
+		assert.Empty(t, manifest.Warnings)
+		assert.Equal(t, "sha256", manifest.Spec.Images[0].Validation.Algorithm)
+		assert.Equal(t, "sha512", manifest.Spec.Images[1].Source.Extract.Members[0].Validation.Algorithm)
+	})
+
+	t.Run("current version is unchanged", func(t *testing.T) {
+		data := []byte("apiVersion: images.lab.gilman.io/v1beta1\nkind: ImageManifest\n")
+		migrated, from, err := MigrateData(data)
+		require.NoError(t, err)
+		assert.Equal(t, SupportedAPIVersion, from)
+		assert.Equal(t, data, migrated)
+	})
+
+	t.Run("algorithm disagreeing with expected", func(t *testing.T) {
+		data := []byte(`apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test
+spec:
+  images:
+    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      validation:
+        algorithm: sha256
+        expected: sha512:` + sha512Hex + `
+`)
+		_, _, err := MigrateData(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `line 10, column 9: validation.algorithm "sha256" does not match the "sha512" prefix`)
+
+		var verr *ValidationError
+		assert.True(t, errors.As(err, &verr))
+	})
+
+	t.Run("unknown apiVersion", func(t *testing.T) {
+		for _, data := range []string{
+			"apiVersion: images.lab.gilman.io/v2\nkind: ImageManifest\n",
+			"kind: ImageManifest\n",
+		} {
+			_, _, err := MigrateData([]byte(data))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "unsupported apiVersion")
+		}
+	})
+
+	t.Run("field unknown to the version", func(t *testing.T) {
+		data := []byte(strings.Replace(v1alpha1Manifest, "      destination: talos/talos.raw\n",
+			"      destination: talos/talos.raw\n      destinaton: talos/typo.raw\n", 1))
+		_, _, err := MigrateData(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `line 15, column 7: spec.images[0]: unknown field "destinaton"`)
+	})
+}
+
+func TestParseManifest_V1Beta1RejectsAlgorithm(t *testing.T) {
+	_, err := ParseManifestRaw([]byte(`apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageManifest
+metadata:
+  name: test
+spec:
+  images:
+    - name: a
+      validation:
+        algorithm: sha256
+        expected: sha256:` + sha256Hex + `
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 9, column 9: validation.algorithm was removed in images.lab.gilman.io/v1beta1")
+}
+
+func TestParseManifest_DeprecatedVersion(t *testing.T) {
+	manifest, err := ParseManifest([]byte(v1alpha1Manifest))
+	require.NoError(t, err)
+
+	assert.Equal(t, SupportedAPIVersion, manifest.APIVersion)
+	require.Len(t, manifest.Warnings, 1)
+	assert.Contains(t, manifest.Warnings[0], "apiVersion images.lab.gilman.io/v1alpha1 is deprecated")
+	assert.Contains(t, manifest.Warnings[0], "labctl images migrate")
+}
+
+func TestAPIVersions(t *testing.T) {
+	assert.Equal(t, []string{APIVersionV1Alpha1, APIVersionV1Beta1}, APIVersions())
+}
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/updater"
+)
+
+// checksumPattern splits a checksum into its algorithm and digest.
+var checksumPattern = regexp.MustCompile(`^([a-z0-9]+):(.*)$`)
+
+// digestLengths is the hex digest length of each supported algorithm.
+var digestLengths = map[string]int{
+	"sha256": 64,
+	"sha512": 128,
+}
+
+var lowerHex = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// Position is a location in a manifest file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String returns the position as file:line:column, or "line L, column C"
+// when the file is unknown.
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// ValidationError is a validation error located in a manifest file.
+type ValidationError struct {
+	Pos Position
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Pos.String() + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// UnmarshalYAML decodes the image and records the position of each of its
+// fields, so manifest-wide validation errors can point at the offending line.
+func (i *Image) UnmarshalYAML(node *yaml.Node) error {
+	type plain Image
+	if err := node.Decode((*plain)(i)); err != nil {
+		return err
+	}
+
+	i.positions = map[string]Position{"": {Line: node.Line, Column: node.Column}}
+	recordPositions(node, "", i.positions)
+	return nil
+}
+
+// recordPositions records the position of every key under node, keyed by
+// its path, e.g. "source.checksum" or "source.extract.members[0].destination".
+func recordPositions(node *yaml.Node, prefix string, positions map[string]Position) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			key, value := node.Content[j], node.Content[j+1]
+			name := key.Value
+			if prefix != "" {
+				name = prefix + "." + key.Value
+			}
+			positions[name] = Position{Line: key.Line, Column: key.Column}
+			recordPositions(value, name, positions)
+		}
+	case yaml.SequenceNode:
+		for j, item := range node.Content {
+			name := fmt.Sprintf("%s[%d]", prefix, j)
+			positions[name] = Position{Line: item.Line, Column: item.Column}
+			recordPositions(item, name, positions)
+		}
+	}
+}
+
+// position returns the position of a field of the image, falling back to
+// its closest parent in the YAML, e.g. "source" for a missing
+// "source.checksum", and finally to the image itself.
+func (i *Image) position(field string) Position {
+	pos, ok := i.positions[field]
+	for !ok && field != "" {
+		field = field[:max(strings.LastIndexAny(field, ".["), 0)]
+		pos, ok = i.positions[field]
+	}
+	pos.File = i.file
+	return pos
+}
+
+// errorAt wraps err with the position of a field of the image, if known.
+func (i *Image) errorAt(field string, err error) error {
+	if i.positions == nil {
+		return err
+	}
+	return &ValidationError{Pos: i.position(field), Err: err}
+}
+
+// validateRules checks rules that span images or that need the YAML
+// positions: unique names, destinations and updateFile targets, safe
+// destination paths, well-formed checksums, and a publish.baseURL for
+// templates that use .URL. An algorithm that disagrees
+// with its expected checksum is caught when v1alpha1 manifests are converted.
+func (m *ImageManifest) validateRules() []error {
+	var errs []error
+	names := make(map[string]int)
+	dests := make(map[string]int)
+	var updateFiles []updateTarget
+	hasBaseURL := m.Spec.Publish != nil && m.Spec.Publish.BaseURL != ""
+
+	for i := range m.Spec.Images {
+		img := &m.Spec.Images[i]
+
+		if img.Name != "" {
+			if j, ok := names[img.Name]; ok {
+				errs = append(errs, img.errorAt("name", fmt.Errorf("duplicate image name %q (%s and %s)",
+					img.Name, m.describe(j), m.describe(i))))
+			} else {
+				names[img.Name] = i
+			}
+		}
+
+		for _, df := range img.destinationFields() {
+			field, dest := df.field, df.dest
+			if dest == "" {
+				continue
+			}
+			if err := validateDestination(dest); err != nil {
+				errs = append(errs, img.errorAt(field, fmt.Errorf("%s: %w", field, err)))
+			}
+			if j, ok := dests[dest]; ok && j != i {
+				errs = append(errs, img.errorAt(field, fmt.Errorf("duplicate destination %q (%s and %s)",
+					dest, m.describe(j), m.describe(i))))
+			} else {
+				dests[dest] = i
+			}
+		}
+
+		for _, uf := range img.updateFileFields() {
+			if uf.update.Path == "" {
+				continue
+			}
+			field := uf.field + ".path"
+			target := filepath.Clean(uf.update.Path)
+			if other, ok := overlappingTarget(updateFiles, target); ok {
+				err := fmt.Errorf("%s %q is also updated by %s", field, uf.update.Path, m.describe(other.image))
+				if other.path != target {
+					err = fmt.Errorf("%s %q can match the same files as %q, updated by %s",
+						field, uf.update.Path, other.path, m.describe(other.image))
+				}
+				errs = append(errs, img.errorAt(field, err))
+			} else {
+				updateFiles = append(updateFiles, updateTarget{path: target, image: i})
+			}
+
+			for j, r := range uf.update.Replacements {
+				if !hasBaseURL && updater.UsesField(r.Value, "URL") {
+					field := fmt.Sprintf("%s.replacements[%d].value", uf.field, j)
+					errs = append(errs, img.errorAt(field, fmt.Errorf("%s uses .URL, which needs spec.publish.baseURL; "+
+						"presigned URLs expire and must not be committed, use .PresignedURL in a git-ignored file", field)))
+				}
+			}
+		}
+
+		errs = append(errs, img.validateChecksums()...)
+	}
+
+	return errs
+}
+
+// updateTarget is an updateFile path glob, cleaned, and the image it
+// belongs to.
+type updateTarget struct {
+	path  string
+	image int
+}
+
+// overlappingTarget returns the first of targets whose glob can match a file
+// that path matches too.
+func overlappingTarget(targets []updateTarget, path string) (updateTarget, bool) {
+	for _, t := range targets {
+		if globsOverlap(t.path, path) {
+			return t, true
+		}
+	}
+	return updateTarget{}, false
+}
+
+// globsOverlap reports whether some path matches both of the filepath.Match
+// patterns a and b. A wildcard never matches a separator, so the patterns
+// must have the same number of elements, and each pair of elements must
+// match a common name.
+func globsOverlap(a, b string) bool {
+	as := strings.Split(filepath.ToSlash(a), "/")
+	bs := strings.Split(filepath.ToSlash(b), "/")
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if !elemsOverlap(globTokens(as[i]), globTokens(bs[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// globTokens splits a path element pattern into tokens: "*", or a pattern
+// that matches exactly one character (a literal, an escaped literal, "?",
+// or a class).
+func globTokens(pattern string) []string {
+	var tokens []string
+	for i := 0; i < len(pattern); {
+		_, n := utf8.DecodeRuneInString(pattern[i:])
+		switch pattern[i] {
+		case '\\':
+			if i+1 < len(pattern) {
+				_, width := utf8.DecodeRuneInString(pattern[i+1:])
+				n = 1 + width
+			}
+		case '[':
+			n = classLen(pattern[i:])
+		}
+		tokens = append(tokens, pattern[i:i+n])
+		i += n
+	}
+	return tokens
+}
+
+// classLen returns the length of the character class at the start of
+// pattern, including its brackets.
+func classLen(pattern string) int {
+	for j := 1; j < len(pattern); j++ {
+		switch pattern[j] {
+		case '\\':
+			j++
+		case ']':
+			return j + 1
+		}
+	}
+	return len(pattern)
+}
+
+// elemsOverlap reports whether the token sequences a and b match a common
+// name, by searching the pairs of positions both can reach on the same input.
+func elemsOverlap(a, b []string) bool {
+	seen := make(map[[2]int]bool)
+	var reach func(i, j int) bool
+	reach = func(i, j int) bool {
+		if seen[[2]int{i, j}] {
+			return false
+		}
+		seen[[2]int{i, j}] = true
+
+		aStar := i < len(a) && a[i] == "*"
+		bStar := j < len(b) && b[j] == "*"
+		switch {
+		case i == len(a) && j == len(b):
+			return true
+		case aStar && reach(i+1, j), bStar && reach(i, j+1):
+			return true
+		case aStar && j < len(b):
+			return reach(i, j+1)
+		case bStar && i < len(a):
+			return reach(i+1, j)
+		case i < len(a) && j < len(b):
+			return charsOverlap(a[i], b[j]) && reach(i+1, j+1)
+		}
+		return false
+	}
+	return reach(0, 0)
+}
+
+// charsOverlap reports whether two single-character patterns match a
+// common character. A class is a union of ranges, or the complement of one,
+// so if the patterns share a character they share one at or next to a range
+// endpoint of either.
+func charsOverlap(a, b string) bool {
+	var candidates []rune
+	for _, p := range []string{a, b} {
+		for _, r := range strings.TrimPrefix(p, "\\") {
+			candidates = append(candidates, r-1, r, r+1)
+		}
+	}
+	candidates = append(candidates, 'a', 0x10FFFF)
+	for _, r := range candidates {
+		if r <= 0 || r == '/' || !utf8.ValidRune(r) {
+			continue
+		}
+		name := string(r)
+		if ok, _ := filepath.Match(a, name); !ok {
+			continue
+		}
+		if ok, _ := filepath.Match(b, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationField pairs a destination with the path of its YAML field.
+type destinationField struct {
+	field string
+	dest  string
+}
+
+// destinationFields returns the image's destinations with their field paths.
+func (i *Image) destinationFields() []destinationField {
+	if i.Source.Extract == nil {
+		return []destinationField{{"destination", i.Destination}}
+	}
+	fields := make([]destinationField, len(i.Source.Extract.Members))
+	for j, member := range i.Source.Extract.Members {
+		fields[j] = destinationField{fmt.Sprintf("source.extract.members[%d].destination", j), member.Destination}
+	}
+	return fields
+}
+
+// validateDestination rejects destinations that could escape the images/ prefix.
+func validateDestination(dest string) error {
+	if strings.HasPrefix(dest, "/") {
+		return fmt.Errorf("%q must be a relative path", dest)
+	}
+	for _, elem := range strings.Split(dest, "/") {
+		if elem == ".." {
+			return fmt.Errorf("%q must not contain '..'", dest)
+		}
+	}
+	return nil
+}
+
+// validateChecksums checks the format of every checksum on the image.
+func (i *Image) validateChecksums() []error {
+	var errs []error
+	check := func(field, checksum string) {
+		if checksum == "" {
+			return
+		}
+		if err := validateChecksum(checksum); err != nil {
+			errs = append(errs, i.errorAt(field, fmt.Errorf("%s: %w", field, err)))
+		}
+	}
+	checkValidation := func(field string, v *Validation) {
+		if v != nil {
+			check(field+".expected", v.Expected)
+		}
+	}
+
+	check("source.checksum", i.Source.Checksum)
+	checkValidation("validation", i.Validation)
+	if i.Source.Extract != nil {
+		for j, member := range i.Source.Extract.Members {
+			checkValidation(fmt.Sprintf("source.extract.members[%d].validation", j), member.Validation)
+		}
+	}
+
+	return errs
+}
+
+// validateChecksum checks that a checksum is "<algorithm>:<hex digest>" with
+// a digest of the right length for the algorithm.
+func validateChecksum(checksum string) error {
+	match := checksumPattern.FindStringSubmatch(checksum)
+	if match == nil {
+		return fmt.Errorf("checksum %q must be in the form sha256:<hex> or sha512:<hex>", checksum)
+	}
+
+	algorithm, digest := match[1], match[2]
+	length, ok := digestLengths[algorithm]
+	if !ok {
+		return fmt.Errorf("checksum %q uses unsupported algorithm %q, must be sha256 or sha512", checksum, algorithm)
+	}
+	if len(digest) != length || !lowerHex.MatchString(digest) {
+		return fmt.Errorf("checksum %q must have %d lowercase hex characters after %s:", checksum, length, algorithm)
+	}
+	return nil
+}
+
+// describe identifies an image by index, name and, when known, its file.
+func (m *ImageManifest) describe(i int) string {
+	img := m.Spec.Images[i]
+	if img.file == "" {
+		return fmt.Sprintf("image[%d] %q", i, img.Name)
+	}
+	return fmt.Sprintf("image[%d] %q in %s", i, img.Name, img.file)
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	sha256Hex = strings.Repeat("a", 64)
+	sha512Hex = strings.Repeat("b", 128)
+)
+
+func TestImageManifest_ValidateRules(t *testing.T) {
+	header := "apiVersion: images.lab.gilman.io/v1alpha1\nkind: ImageManifest\nmetadata:\n  name: test\nspec:\n  images:\n"
+
+	tests := []struct {
+		name    string
+		images  string
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			images: `    - name: a
+      source:
+        url: https://example.com/a.iso
+        checksum: sha256:` + sha256Hex + `
+      destination: a/a.iso
+      validation:
+        algorithm: sha512
+        expected: sha512:` + sha512Hex + `
+`,
+		},
+		{
+			name: "duplicate name",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: a/a.iso
+    - name: a
+      source: {url: https://example.com/b.iso, checksum: sha256:` + sha256Hex + `}
+      destination: a/b.iso
+`,
+			wantErr: []string{`line 10, column 7: duplicate image name "a" (image[0] "a" and image[1] "a")`},
+		},
+		{
+			name: "duplicate destination",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: shared/image.iso
+    - name: b
+      source: {url: https://example.com/b.iso, checksum: sha256:` + sha256Hex + `}
+      destination: shared/image.iso
+`,
+			wantErr: []string{`line 12, column 7: duplicate destination "shared/image.iso"`},
+		},
+		{
+			name: "unsafe destinations",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: /etc/a.iso
+    - name: b
+      source:
+        url: https://example.com/b.tar
+        checksum: sha256:` + sha256Hex + `
+        extract:
+          format: tar
+          members:
+            - path: kernel
+              destination: b/../../kernel
+              validation: {algorithm: sha256, expected: sha256:` + sha256Hex + `}
+`,
+			wantErr: []string{
+				`line 9, column 7: destination: "/etc/a.iso" must be a relative path`,
+				`line 18, column 15: source.extract.members[0].destination: "b/../../kernel" must not contain '..'`,
+			},
+		},
+		{
+			name: "malformed checksums",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:abc}
+      destination: a/a.iso
+      validation:
+        algorithm: sha512
+        expected: sha512:` + strings.ToUpper(sha512Hex) + `
+    - name: b
+      source: {url: https://example.com/b.iso, checksum: md5:d41d8cd98f00b204e9800998ecf8427e}
+      destination: b/b.iso
+    - name: c
+      source: {url: https://example.com/c.iso, checksum: ` + sha256Hex + `}
+      destination: c/c.iso
+`,
+			wantErr: []string{
+				`line 8, column 48: source.checksum: checksum "sha256:abc" must have 64 lowercase hex characters after sha256:`,
+				`line 12, column 9: validation.expected: checksum "sha512:` + strings.ToUpper(sha512Hex) + `" must have 128 lowercase hex characters`,
+				`line 14, column 48: source.checksum: checksum "md5:d41d8cd98f00b204e9800998ecf8427e" uses unsupported algorithm "md5"`,
+				`line 17, column 48: source.checksum: checksum "` + sha256Hex + `" must be in the form sha256:<hex> or sha512:<hex>`,
+			},
+		},
+		{
+			name: "overlapping updateFile targets",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: a/a.iso
+      updateFile:
+        path: infra/vars.hcl
+        replacements: [{pattern: a, value: b}]
+    - name: b
+      source: {url: https://example.com/b.iso, checksum: sha256:` + sha256Hex + `}
+      destination: b/b.iso
+      updateFile:
+        path: ./infra/vars.hcl
+        replacements: [{pattern: c, value: d}]
+`,
+			wantErr: []string{`line 17, column 9: updateFile.path "./infra/vars.hcl" is also updated by image[0] "a"`},
+		},
+		{
+			name: "overlapping updateFiles targets",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: a/a.iso
+      updateFiles:
+        - path: infra/*.hcl
+          replacements: [{pattern: a, value: b}]
+        - path: infra/*.hcl
+          replacements: [{pattern: c, value: d}]
+`,
+			wantErr: []string{`line 13, column 11: updateFiles[1].path "infra/*.hcl" is also updated by image[0] "a"`},
+		},
+		{
+			name: "overlapping updateFile globs",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: a/a.iso
+      updateFiles:
+        - path: infra/*.hcl
+          replacements: [{pattern: a, value: b}]
+        - path: docs/*.md
+          replacements: [{pattern: a, value: b}]
+    - name: b
+      source: {url: https://example.com/b.iso, checksum: sha256:` + sha256Hex + `}
+      destination: b/b.iso
+      updateFiles:
+        - path: infra/vars.hcl
+          replacements: [{pattern: c, value: d}]
+        - path: infra/*.yaml
+          replacements: [{pattern: c, value: d}]
+`,
+			wantErr: []string{`line 19, column 11: updateFiles[0].path "infra/vars.hcl" can match the same files as "infra/*.hcl", updated by image[0] "a"`},
+		},
+		{
+			name: "download URL without publish.baseURL",
+			images: `    - name: a
+      source: {url: https://example.com/a.iso, checksum: sha256:` + sha256Hex + `}
+      destination: a/a.iso
+      updateFile:
+        path: infra/vars.hcl
+        replacements:
+          - pattern: url = .*
+            value: 'url = "{{ .URL }}"'
+          - pattern: nas = .*
+            value: 'nas = "{{ .NASPath }}"'
+`,
+			wantErr: []string{`line 14, column 13: updateFile.replacements[0].value uses .URL, which needs spec.publish.baseURL`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := ParseManifestRaw([]byte(header + tt.images))
+			require.NoError(t, err)
+
+			errs := manifest.ValidateAll()
+			require.Len(t, errs, len(tt.wantErr), "errors: %v", errs)
+			for i, want := range tt.wantErr {
+				assert.Contains(t, errs[i].Error(), want)
+
+				var verr *ValidationError
+				assert.True(t, errors.As(errs[i], &verr), "error %d should carry a position", i)
+			}
+		})
+	}
+}
+
+func TestPosition_String(t *testing.T) {
+	assert.Equal(t, "line 3, column 5", Position{Line: 3, Column: 5}.String())
+	assert.Equal(t, "images.yaml:3:5", Position{File: "images.yaml", Line: 3, Column: 5}.String())
+}
+
+func TestValidationError_PositionFromFile(t *testing.T) {
+	dir := t.TempDir()
+	root := writeManifest(t, dir, "images.yaml", header("lab")+"spec:\n  images:\n"+
+		"    - name: a\n      source:\n        url: https://example.com/a.iso\n        checksum: sha256:"+sha256Hex+"\n      destination: ../a.iso\n")
+
+	manifest, err := LoadManifestRaw(root)
+	require.NoError(t, err)
+
+	errs := manifest.ValidateAll()
+	require.Len(t, errs, 1)
+	assert.Equal(t, root+`:11:7: destination: "../a.iso" must not contain '..'`, errs[0].Error())
+}
+
+func TestImage_ValidateAllPositions(t *testing.T) {
+	dir := t.TempDir()
+	root := writeManifest(t, dir, "images.yaml", header("lab")+`spec:
+  images:
+    - name: a
+      labels: {group: x}
+      source:
+        url: http://example.com/a.iso
+      destination: a/a.iso
+      transform: {format: vmdk}
+      updateFile:
+        path: infra/vars.hcl
+        replacements:
+          - value: x
+`)
+
+	manifest, err := LoadManifestRaw(root)
+	require.NoError(t, err)
+
+	errs := manifest.ValidateAll()
+	want := []string{
+		root + `:8:16: image[0] "a": labels.group is reserved, use groups instead`,
+		root + `:10:9: image[0] "a": source.url must use HTTPS or oci://`,
+		root + `:9:7: image[0] "a": source.checksum is required`,
+		root + `:12:19: image[0] "a": unsupported transform format "vmdk", must be raw or qcow2`,
+		root + `:16:13: image[0] "a": updateFile.replacements[0].pattern is required`,
+	}
+	require.Len(t, errs, len(want), "errors: %v", errs)
+	for i, w := range want {
+		assert.Equal(t, w, errs[i].Error())
+
+		var verr *ValidationError
+		assert.True(t, errors.As(errs[i], &verr), "error %d should carry a position", i)
+	}
+}
+
+func TestGlobsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"infra/vars.hcl", "infra/vars.hcl", true},
+		{"infra/*.hcl", "infra/vars.hcl", true},
+		{"infra/v*", "infra/*.hcl", true},
+		{"infra/*", "*/vars.hcl", true},
+		{"capi/talos-?.yaml", "capi/talos-[0-9].yaml", true},
+		{"capi/[a-m]*.yaml", "capi/[^a-m]*.yaml", false},
+		{"capi/[a-m]*.yaml", "capi/[k-z]*.yaml", true},
+		{"infra/*.hcl", "infra/*.yaml", false},
+		{"infra/*.hcl", "infra/nested/vars.hcl", false},
+		{"infra/*", "docs/*", false},
+		{"docs/a\\*b.md", "docs/a*b.md", true},
+		{"docs/a\\*b.md", "docs/axb.md", false},
+		{"*a*b*", "*b*a*", true},
+		{"ab*", "*ba", true},
+		{"a?c", "ab", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, globsOverlap(tt.a, tt.b))
+			assert.Equal(t, tt.want, globsOverlap(tt.b, tt.a))
+		})
+	}
+}
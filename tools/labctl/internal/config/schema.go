@@ -0,0 +1,287 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/archive"
+	"github.com/GilmanLab/lab/tools/labctl/internal/diskimage"
+	"github.com/GilmanLab/lab/tools/labctl/internal/updater"
+)
+
+//go:generate go run ../.. images schema --output images.schema.json
+
+// SchemaFile is the committed JSON Schema for image manifests. It must match
+// GenerateSchema; a test fails when the config types change without the file
+// being regenerated.
+//
+//go:embed images.schema.json
+var SchemaFile []byte
+
+// ChecksumPattern is the JSON Schema pattern for checksums.
+const ChecksumPattern = `^(sha256:[0-9a-f]{64}|sha512:[0-9a-f]{128})$`
+
+// Schema is the subset of JSON Schema used to describe image manifests.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"` // false or *Schema
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// schemaConstraints adds enums, patterns and minimums to fields, keyed by Go type name
+// and YAML field name. The enums reuse the lists that validation checks.
+var schemaConstraints = map[string]Schema{
+	"ImageManifest.apiVersion":  {Enum: []string{SupportedAPIVersion}},
+	"ImageManifest.kind":        {Enum: []string{"ImageManifest"}},
+	"Source.checksum":           {Pattern: ChecksumPattern},
+	"Source.decompress":         {Enum: DecompressFormats},
+	"Validation.expected":       {Pattern: ChecksumPattern},
+	"Extract.format":            {Enum: archive.Formats},
+	"Transform.format":          {Enum: diskimage.Formats},
+	"Replacement.type":          {Enum: updater.Types},
+	"Replacement.expectMatches": {Minimum: minimum(1)},
+	"TransferWindow.start":      {Pattern: `^([01][0-9]|2[0-3]):[0-5][0-9]$`},
+	"TransferWindow.end":        {Pattern: `^([01][0-9]|2[0-3]):[0-5][0-9]$`},
+
+	// Older API versions, whose types otherwise share the constraints above
+	"v1alpha1ImageManifest.apiVersion": {Enum: []string{APIVersionV1Alpha1}},
+	"v1alpha1Validation.algorithm":     {Enum: Algorithms},
+}
+
+// versionPrefix matches the prefix of the Go types of older API versions,
+// such as v1alpha1Image, which are constrained like the current type.
+var versionPrefix = regexp.MustCompile(`^v[0-9]+(alpha|beta)[0-9]+`)
+
+// minimum returns a pointer to n, for Schema.Minimum.
+func minimum(n int) *int {
+	return &n
+}
+
+// GenerateSchema builds the JSON Schema for ImageManifest from the config
+// types. Fields without omitempty are required, and unknown fields are rejected.
+func GenerateSchema() *Schema {
+	return generateSchema(reflect.TypeOf(ImageManifest{}))
+}
+
+// generateSchema builds the JSON Schema for the manifest type t.
+func generateSchema(t reflect.Type) *Schema {
+	defs := make(map[string]*Schema)
+	s := structSchema(t, defs)
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = "ImageManifest"
+	s.Definitions = defs
+	return s
+}
+
+// MarshalSchema returns the schema as indented JSON with a trailing newline.
+func MarshalSchema(s *Schema) ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaFor returns the schema for t, adding named structs to defs.
+func schemaFor(t reflect.Type, defs map[string]*Schema) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), defs)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int:
+		return &Schema{Type: "integer"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil // Reserve the name so recursive types terminate
+			defs[name] = structSchema(t, defs)
+		}
+		return &Schema{Ref: "#/definitions/" + name}
+	default:
+		panic(fmt.Sprintf("config: no JSON Schema mapping for %s", t))
+	}
+}
+
+func structSchema(t reflect.Type, defs map[string]*Schema) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" || name == "" {
+			continue
+		}
+
+		prop := schemaFor(f.Type, defs)
+		c, ok := schemaConstraints[t.Name()+"."+name]
+		if !ok {
+			c, ok = schemaConstraints[versionPrefix.ReplaceAllString(t.Name(), "")+"."+name]
+		}
+		if ok {
+			prop.Enum = c.Enum
+			prop.Pattern = c.Pattern
+			prop.Minimum = c.Minimum
+		}
+		s.Properties[name] = prop
+
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
+
+// ValidateSchema checks YAML manifest data against the schema and returns
+// every violation with its position. Data at an older API version is checked
+// against the schema of that version instead. file is only used in error
+// positions.
+func ValidateSchema(s *Schema, file string, data []byte) ([]error, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse manifest YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return []error{&ValidationError{Pos: Position{File: file, Line: 1, Column: 1}, Err: fmt.Errorf("manifest is empty")}}, nil
+	}
+	if c, ok := conversionFrom(documentVersion(&doc)); ok {
+		s = generateSchema(c.types)
+	}
+
+	return validateNode(s, file, doc.Content[0]), nil
+}
+
+// validateNode checks the root node of a manifest document against the schema.
+func validateNode(s *Schema, file string, root *yaml.Node) []error {
+	v := &schemaValidator{root: s, file: file}
+	v.validate(root, s, "")
+	return v.errs
+}
+
+type schemaValidator struct {
+	root *Schema
+	file string
+	errs []error
+}
+
+func (v *schemaValidator) fail(node *yaml.Node, path, format string, args ...any) {
+	if path == "" {
+		path = "manifest"
+	}
+	v.errs = append(v.errs, &ValidationError{
+		Pos: Position{File: v.file, Line: node.Line, Column: node.Column},
+		Err: fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)),
+	})
+}
+
+func (v *schemaValidator) validate(node *yaml.Node, s *Schema, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if s.Ref != "" {
+		s = v.root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+	}
+
+	switch s.Type {
+	case "object":
+		v.validateObject(node, s, path)
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			v.fail(node, path, "must be a list")
+			return
+		}
+		for i, item := range node.Content {
+			v.validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "boolean":
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			v.fail(node, path, "must be true or false")
+		}
+	case "integer":
+		n, err := strconv.Atoi(node.Value)
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" || err != nil {
+			v.fail(node, path, "must be an integer")
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			v.fail(node, path, "%d must be at least %d", n, *s.Minimum)
+		}
+	case "string":
+		if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+			v.fail(node, path, "must be a string")
+			return
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, node.Value) {
+			v.fail(node, path, "%q must be one of %s", node.Value, strings.Join(s.Enum, ", "))
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(node.Value) {
+			v.fail(node, path, "%q does not match pattern %s", node.Value, s.Pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(node *yaml.Node, s *Schema, path string) {
+	if node.Kind != yaml.MappingNode {
+		v.fail(node, path, "must be a mapping")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		seen[key.Value] = true
+
+		child := key.Value
+		if path != "" {
+			child = path + "." + key.Value
+		}
+
+		if prop, ok := s.Properties[key.Value]; ok {
+			v.validate(value, prop, child)
+			continue
+		}
+		switch extra := s.AdditionalProperties.(type) {
+		case *Schema:
+			v.validate(value, extra, child)
+		default:
+			v.fail(key, path, "unknown field %q", key.Value)
+		}
+	}
+
+	for _, name := range s.Required {
+		if !seen[name] {
+			v.fail(node, path, "missing required field %q", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaFile_UpToDate(t *testing.T) {
+	generated, err := MarshalSchema(GenerateSchema())
+	require.NoError(t, err)
+
+	assert.Equal(t, string(generated), string(SchemaFile),
+		"images.schema.json is out of date with the config types, run: go generate ./internal/config")
+}
+
+func TestGenerateSchema(t *testing.T) {
+	s := GenerateSchema()
+
+	source := s.Definitions["Source"]
+	require.NotNil(t, source)
+	assert.Equal(t, DecompressFormats, source.Properties["decompress"].Enum)
+	assert.Equal(t, ChecksumPattern, source.Properties["checksum"].Pattern)
+	assert.Equal(t, "#/definitions/GitHubSource", source.Properties["github"].Ref)
+	assert.Equal(t, false, source.AdditionalProperties)
+
+	validation := s.Definitions["Validation"]
+	require.NotNil(t, validation)
+	assert.NotContains(t, validation.Properties, "algorithm")
+	assert.Equal(t, []string{"expected"}, validation.Required)
+
+	image := s.Definitions["Image"]
+	require.NotNil(t, image)
+	assert.Equal(t, []string{"name", "source"}, image.Required)
+	assert.Equal(t, "object", image.Properties["labels"].Type)
+	assert.NotContains(t, image.Properties, "file")
+}
+
+func TestValidateSchema(t *testing.T) {
+	sum := "sha256:" + strings.Repeat("a", 64)
+
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			yaml: header("test") + `spec:
+  vars:
+    version: "1.0"
+  images:
+    - name: talos
+      labels: {team: platform}
+      source:
+        url: https://example.com/talos.raw.xz
+        checksum: ` + sum + `
+        decompress: xz
+      destination: talos/talos.raw
+      validation:
+        algorithm: sha256
+        expected: ` + sum + `
+      transform:
+        format: qcow2
+        sparse: true
+`,
+		},
+		{
+			name: "violations",
+			yaml: header("test") + `spec:
+  images:
+    - name: talos
+      source:
+        url: https://example.com/talos.raw.xz
+        checksum: sha256:abc
+        decompress: bz2
+      destination: talos/talos.raw
+      validation:
+        algorithm: sha256
+      transform:
+        format: vmdk
+        sparse: "yes"
+      unknown: 1
+`,
+			wantErr: []string{
+				`line 10, column 19: spec.images[0].source.checksum: "sha256:abc" does not match pattern`,
+				`line 11, column 21: spec.images[0].source.decompress: "bz2" must be one of xz, gzip, zstd`,
+				`line 14, column 9: spec.images[0].validation: missing required field "expected"`,
+				`line 16, column 17: spec.images[0].transform.format: "vmdk" must be one of raw, qcow2`,
+				`line 17, column 17: spec.images[0].transform.sparse: must be true or false`,
+				`line 18, column 7: spec.images[0]: unknown field "unknown"`,
+			},
+		},
+		{
+			name: "replacement match counts",
+			yaml: header("test") + `spec:
+  images:
+    - name: talos
+      source:
+        url: https://example.com/talos.iso
+        checksum: ` + sum + `
+      destination: talos/talos.iso
+      updateFiles:
+        - path: docs/talos.md
+          replacements:
+            - {pattern: a, value: b, expectMatches: 2, required: false}
+            - {pattern: a, value: b, expectMatches: 0}
+            - {pattern: a, value: b, expectMatches: two}
+`,
+			wantErr: []string{
+				`line 16, column 53: spec.images[0].updateFiles[0].replacements[1].expectMatches: 0 must be at least 1`,
+				`line 17, column 53: spec.images[0].updateFiles[0].replacements[2].expectMatches: must be an integer`,
+			},
+		},
+		{
+			name: "algorithm is v1alpha1 only",
+			yaml: header("test") + `spec:
+  images:
+    - name: talos
+      source: {url: https://example.com/talos.iso, checksum: ` + sum + `}
+      validation: {algorithm: md5, expected: ` + sum + `}
+`,
+			wantErr: []string{`line 9, column 31: spec.images[0].validation.algorithm: "md5" must be one of sha256, sha512`},
+		},
+		{
+			name: "v1beta1 has no algorithm",
+			yaml: strings.Replace(header("test"), "v1alpha1", "v1beta1", 1) + `spec:
+  images:
+    - name: talos
+      source: {url: https://example.com/talos.iso, checksum: ` + sum + `}
+      validation: {algorithm: sha256, expected: ` + sum + `}
+`,
+			wantErr: []string{`line 9, column 20: spec.images[0].validation: unknown field "algorithm"`},
+		},
+		{
+			name:    "wrong apiVersion and missing spec",
+			yaml:    "apiVersion: v1\nkind: ImageManifest\nmetadata:\n  name: test\n",
+			wantErr: []string{`line 1, column 13: apiVersion: "v1" must be one of`, `line 1, column 1: manifest: missing required field "spec"`},
+		},
+		{
+			name:    "empty",
+			yaml:    "",
+			wantErr: []string{"manifest is empty"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, err := ValidateSchema(GenerateSchema(), "", []byte(tt.yaml))
+			require.NoError(t, err)
+			require.Len(t, errs, len(tt.wantErr), "errors: %v", errs)
+			for i, want := range tt.wantErr {
+				assert.Contains(t, errs[i].Error(), want)
+			}
+		})
+	}
+}
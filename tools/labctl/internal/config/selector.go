@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// GroupKey is the selector key that matches an image's groups instead of its labels.
+const GroupKey = "group"
+
+// Selector filters images by group and labels. Every requirement must match;
+// the zero value matches all images.
+type Selector struct {
+	requirements []requirement
+}
+
+type requirement struct {
+	key    string
+	value  string
+	negate bool // key!=value
+	exists bool // bare key: the label is set, or the image belongs to any group
+}
+
+// ParseSelector parses a comma-separated list of requirements:
+//
+//	group=talos         image is in the "talos" group
+//	team=platform       label team has value platform
+//	team!=platform      label team is unset or has another value
+//	team                label team is set
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var req requirement
+		switch {
+		case strings.Contains(part, "!="):
+			req.key, req.value, _ = strings.Cut(part, "!=")
+			req.negate = true
+		case strings.Contains(part, "="):
+			req.key, req.value, _ = strings.Cut(part, "=")
+			req.value = strings.TrimPrefix(req.value, "=") // accept ==
+		default:
+			req.key = part
+			req.exists = true
+		}
+
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if req.key == "" || strings.ContainsAny(req.key, "=!") {
+			return Selector{}, fmt.Errorf("invalid selector requirement %q", part)
+		}
+		sel.requirements = append(sel.requirements, req)
+	}
+
+	return sel, nil
+}
+
+// Empty reports whether the selector matches every image.
+func (s Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+// Matches reports whether the image satisfies every requirement.
+func (s Selector) Matches(img *Image) bool {
+	for _, req := range s.requirements {
+		if !req.matches(img) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r requirement) matches(img *Image) bool {
+	if r.key == GroupKey {
+		if r.exists {
+			return len(img.Groups) > 0
+		}
+		return slices.Contains(img.Groups, r.value) != r.negate
+	}
+
+	value, ok := img.Labels[r.key]
+	switch {
+	case r.exists:
+		return ok
+	case r.negate:
+		return !ok || value != r.value
+	default:
+		return ok && value == r.value
+	}
+}
+
+// Select returns the images matching the selector, in manifest order.
+func (m *ImageManifest) Select(sel Selector) []Image {
+	var images []Image
+	for _, img := range m.Spec.Images {
+		if sel.Matches(&img) {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+// selectorToken restricts group names and label keys and values to
+// characters that cannot be confused with selector syntax.
+var selectorToken = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// validateGroupsAndLabels checks that groups and labels can be selected.
+func (i *Image) validateGroupsAndLabels() []error {
+	var errs []error
+
+	for j, group := range i.Groups {
+		if !selectorToken.MatchString(group) {
+			errs = append(errs, i.errorAt(fmt.Sprintf("groups[%d]", j), fmt.Errorf("groups[%d] %q must be alphanumeric with '.', '_', '-' or '/' inside", j, group)))
+		}
+	}
+
+	keys := make([]string, 0, len(i.Labels))
+	for key := range i.Labels {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		value := i.Labels[key]
+		switch {
+		case key == GroupKey:
+			errs = append(errs, i.errorAt("labels."+key, fmt.Errorf("labels.%s is reserved, use groups instead", key)))
+		case !selectorToken.MatchString(key):
+			errs = append(errs, i.errorAt("labels."+key, fmt.Errorf("label key %q must be alphanumeric with '.', '_', '-' or '/' inside", key)))
+		case value != "" && !selectorToken.MatchString(value):
+			errs = append(errs, i.errorAt("labels."+key, fmt.Errorf("labels.%s value %q must be alphanumeric with '.', '_', '-' or '/' inside", key, value)))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	img := &Image{
+		Name:   "talos",
+		Groups: []string{"talos", "boot"},
+		Labels: map[string]string{"team": "platform", "arch": "amd64"},
+	}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{selector: "", want: true},
+		{selector: "group=talos", want: true},
+		{selector: "group=network", want: false},
+		{selector: "group!=network", want: true},
+		{selector: "group", want: true},
+		{selector: "team=platform", want: true},
+		{selector: "team==platform", want: true},
+		{selector: "team=apps", want: false},
+		{selector: "team!=apps", want: true},
+		{selector: "owner!=apps", want: true},
+		{selector: "team", want: true},
+		{selector: "owner", want: false},
+		{selector: "group=boot, team=platform, arch=amd64", want: true},
+		{selector: "group=boot,arch=arm64", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sel.Matches(img))
+		})
+	}
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	for _, s := range []string{"=talos", "group=talos,", "a!b=c"} {
+		t.Run(s, func(t *testing.T) {
+			_, err := ParseSelector(s)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid selector requirement")
+		})
+	}
+}
+
+func TestImageManifest_Select(t *testing.T) {
+	m := &ImageManifest{Spec: Spec{Images: []Image{
+		{Name: "a", Groups: []string{"talos"}},
+		{Name: "b"},
+		{Name: "c", Groups: []string{"talos"}},
+	}}}
+
+	sel, err := ParseSelector("group=talos")
+	require.NoError(t, err)
+
+	var names []string
+	for _, img := range m.Select(sel) {
+		names = append(names, img.Name)
+	}
+	assert.Equal(t, []string{"a", "c"}, names)
+	assert.Len(t, m.Select(Selector{}), 3)
+}
+
+func TestImage_ValidateGroupsAndLabels(t *testing.T) {
+	img := &Image{
+		Groups: []string{"talos", "bad group"},
+		Labels: map[string]string{"team": "platform", "group": "x", "bad,key": "v", "ok": "a=b"},
+	}
+
+	errs := img.validateGroupsAndLabels()
+	require.Len(t, errs, 4)
+	assert.Contains(t, errs[0].Error(), `groups[1] "bad group"`)
+	assert.Contains(t, errs[1].Error(), `label key "bad,key"`)
+	assert.Contains(t, errs[2].Error(), "labels.group is reserved")
+	assert.Contains(t, errs[3].Error(), `labels.ok value "a=b"`)
+}
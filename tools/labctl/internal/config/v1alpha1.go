@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The v1alpha1 manifest types. v1alpha1 differs from v1beta1 only in the
+// validation blocks, which carry an explicit algorithm that v1beta1 derives
+// from the expected checksum, so only the types that contain a validation
+// block are redeclared here.
+
+type v1alpha1ImageManifest struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   Metadata     `yaml:"metadata"`
+	Spec       v1alpha1Spec `yaml:"spec"`
+}
+
+type v1alpha1Spec struct {
+	Includes       []string          `yaml:"includes,omitempty"`
+	Vars           map[string]string `yaml:"vars,omitempty"`
+	TransferWindow *TransferWindow   `yaml:"transferWindow,omitempty"`
+	Publish        *Publish          `yaml:"publish,omitempty"`
+	Images         []v1alpha1Image   `yaml:"images"`
+}
+
+type v1alpha1Image struct {
+	Name        string              `yaml:"name"`
+	Groups      []string            `yaml:"groups,omitempty"`
+	Labels      map[string]string   `yaml:"labels,omitempty"`
+	Vars        map[string]string   `yaml:"vars,omitempty"`
+	Source      v1alpha1Source      `yaml:"source"`
+	Destination string              `yaml:"destination,omitempty"`
+	Validation  *v1alpha1Validation `yaml:"validation,omitempty"`
+	Transform   *Transform          `yaml:"transform,omitempty"`
+	UpdateFile  *UpdateFile         `yaml:"updateFile,omitempty"`
+	UpdateFiles []UpdateFile        `yaml:"updateFiles,omitempty"`
+	OCI         *OCITarget          `yaml:"oci,omitempty"`
+
+	positions map[string]Position
+}
+
+type v1alpha1Source struct {
+	URL        string           `yaml:"url,omitempty"`
+	Checksum   string           `yaml:"checksum,omitempty"`
+	Decompress string           `yaml:"decompress,omitempty"`
+	Mirrors    []string         `yaml:"mirrors,omitempty"`
+	GitHub     *GitHubSource    `yaml:"github,omitempty"`
+	Extract    *v1alpha1Extract `yaml:"extract,omitempty"`
+}
+
+type v1alpha1Extract struct {
+	Format  string                  `yaml:"format"`
+	Members []v1alpha1ExtractMember `yaml:"members"`
+}
+
+type v1alpha1ExtractMember struct {
+	Path        string              `yaml:"path"`
+	Destination string              `yaml:"destination"`
+	Validation  *v1alpha1Validation `yaml:"validation"`
+}
+
+type v1alpha1Validation struct {
+	Algorithm string `yaml:"algorithm,omitempty"` // sha256, sha512
+	Expected  string `yaml:"expected"`
+}
+
+// UnmarshalYAML decodes the image and records the position of each of its
+// fields, as Image does.
+func (i *v1alpha1Image) UnmarshalYAML(node *yaml.Node) error {
+	type plain v1alpha1Image
+	if err := node.Decode((*plain)(i)); err != nil {
+		return err
+	}
+
+	i.positions = map[string]Position{"": {Line: node.Line, Column: node.Column}}
+	recordPositions(node, "", i.positions)
+	return nil
+}
+
+// decodeV1Alpha1 decodes a v1alpha1 manifest document and converts it to
+// the current types.
+func decodeV1Alpha1(doc *yaml.Node) (*ImageManifest, error) {
+	var old v1alpha1ImageManifest
+	if err := doc.Decode(&old); err != nil {
+		return nil, err
+	}
+	return old.convert()
+}
+
+// convert returns the manifest at v1beta1.
+func (m *v1alpha1ImageManifest) convert() (*ImageManifest, error) {
+	converted := &ImageManifest{
+		APIVersion: APIVersionV1Beta1,
+		Kind:       m.Kind,
+		Metadata:   m.Metadata,
+		Spec: Spec{
+			Includes:       m.Spec.Includes,
+			Vars:           m.Spec.Vars,
+			TransferWindow: m.Spec.TransferWindow,
+			Publish:        m.Spec.Publish,
+		},
+	}
+	if m.Spec.Images != nil {
+		converted.Spec.Images = make([]Image, len(m.Spec.Images))
+	}
+	for j := range m.Spec.Images {
+		img, err := m.Spec.Images[j].convert()
+		if err != nil {
+			return nil, err
+		}
+		converted.Spec.Images[j] = img
+	}
+	return converted, nil
+}
+
+// convert returns the image at v1beta1, keeping its field positions.
+func (i *v1alpha1Image) convert() (Image, error) {
+	img := Image{
+		Name:        i.Name,
+		Groups:      i.Groups,
+		Labels:      i.Labels,
+		Vars:        i.Vars,
+		Destination: i.Destination,
+		Transform:   i.Transform,
+		UpdateFile:  i.UpdateFile,
+		UpdateFiles: i.UpdateFiles,
+		OCI:         i.OCI,
+		Source: Source{
+			URL:        i.Source.URL,
+			Checksum:   i.Source.Checksum,
+			Decompress: i.Source.Decompress,
+			Mirrors:    i.Source.Mirrors,
+			GitHub:     i.Source.GitHub,
+		},
+		positions: i.positions,
+	}
+
+	var err error
+	if img.Validation, err = i.convertValidation("validation", i.Validation); err != nil {
+		return Image{}, err
+	}
+	if ex := i.Source.Extract; ex != nil {
+		img.Source.Extract = &Extract{Format: ex.Format}
+		if ex.Members != nil {
+			img.Source.Extract.Members = make([]ExtractMember, len(ex.Members))
+		}
+		for j, member := range ex.Members {
+			field := fmt.Sprintf("source.extract.members[%d].validation", j)
+			validation, err := i.convertValidation(field, member.Validation)
+			if err != nil {
+				return Image{}, err
+			}
+			img.Source.Extract.Members[j] = ExtractMember{Path: member.Path, Destination: member.Destination, Validation: validation}
+		}
+	}
+	return img, nil
+}
+
+// convertValidation drops the algorithm of the validation block at field,
+// which v1beta1 derives from the prefix of the expected checksum. An
+// algorithm that disagrees with the prefix cannot be converted without
+// changing meaning and is an error.
+func (i *v1alpha1Image) convertValidation(field string, v *v1alpha1Validation) (*Validation, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	converted := &Validation{Expected: v.Expected}
+	prefix, _, ok := strings.Cut(v.Expected, ":")
+	if ok {
+		converted.Algorithm = prefix
+	}
+	if v.Algorithm != "" && ok && prefix != v.Algorithm {
+		err := fmt.Errorf("validation.algorithm %q does not match the %q prefix of validation.expected", v.Algorithm, prefix)
+		if pos, found := i.positions[field+".algorithm"]; found {
+			return nil, &ValidationError{Pos: pos, Err: err}
+		}
+		return nil, err
+	}
+	return converted, nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"strings"
+	"text/template"
+)
+
+// VarsData is the template data for manifest fields, e.g. {{ .Vars.version }}.
+type VarsData struct {
+	Vars map[string]string
+}
+
+// expandVars resolves templates in the manifest's images. Each image sees
+// spec.vars overlaid with its own vars, and ends up carrying the merged set
+// so that updateFile replacement values can use them at sync time. Image
+// vars may themselves refer to spec vars.
+func (m *ImageManifest) expandVars() error {
+	for i := range m.Spec.Images {
+		img := &m.Spec.Images[i]
+
+		vars := maps.Clone(m.Spec.Vars)
+		if vars == nil && len(img.Vars) > 0 {
+			vars = make(map[string]string, len(img.Vars))
+		}
+		for key, value := range img.Vars {
+			expanded, err := expand("vars."+key, value, VarsData{Vars: m.Spec.Vars})
+			if err != nil {
+				return fmt.Errorf("image[%d] %q: %w", i, img.Name, err)
+			}
+			vars[key] = expanded
+		}
+		img.Vars = vars
+
+		if err := img.expandFields(VarsData{Vars: vars}); err != nil {
+			return fmt.Errorf("image[%d] %q: %w", i, img.Name, err)
+		}
+	}
+	return nil
+}
+
+// templatedField is a manifest field that may contain template actions.
+type templatedField struct {
+	name  string
+	value *string
+}
+
+// expandFields expands the templated fields of the image in place.
+func (i *Image) expandFields(data VarsData) error {
+	fields := []templatedField{
+		{"name", &i.Name},
+		{"source.url", &i.Source.URL},
+		{"destination", &i.Destination},
+	}
+	for j := range i.Source.Mirrors {
+		fields = append(fields, templatedField{fmt.Sprintf("source.mirrors[%d]", j), &i.Source.Mirrors[j]})
+	}
+	if gh := i.Source.GitHub; gh != nil {
+		fields = append(fields,
+			templatedField{"source.github.tag", &gh.Tag},
+			templatedField{"source.github.asset", &gh.Asset},
+		)
+	}
+	if i.Source.Extract != nil {
+		for j := range i.Source.Extract.Members {
+			member := &i.Source.Extract.Members[j]
+			fields = append(fields, templatedField{fmt.Sprintf("source.extract.members[%d].destination", j), &member.Destination})
+		}
+	}
+	for _, uf := range i.updateFileFields() {
+		fields = append(fields, templatedField{uf.field + ".path", &uf.update.Path})
+	}
+	if i.OCI != nil {
+		fields = append(fields, templatedField{"oci.reference", &i.OCI.Reference})
+	}
+
+	for _, f := range fields {
+		expanded, err := expand(f.name, *f.value, data)
+		if err != nil {
+			return err
+		}
+		*f.value = expanded
+	}
+	return nil
+}
+
+// expand executes value as a template. Values without template actions are
+// returned unchanged, and unknown variables are errors rather than empty strings.
+func expand(field, value string, data VarsData) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	tmpl, err := template.New(field).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("parse %s template: %w", field, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("expand %s: %w", field, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifestRaw_Vars(t *testing.T) {
+	t.Run("expands spec and image vars", func(t *testing.T) {
+		data := []byte(`apiVersion: images.lab.gilman.io/v1alpha1
+kind: ImageManifest
+metadata:
+  name: test
+spec:
+  vars:
+    mirror: https://mirror.example.com
+    channel: rolling
+  images:
+    - name: vyos-{{ .Vars.version }}
+      vars:
+        version: 2025.12.20-0020-{{ .Vars.channel }}
+      source:
+        url: https://example.com/vyos-{{ .Vars.version }}.iso
+        mirrors:
+          - "{{ .Vars.mirror }}/vyos-{{ .Vars.version }}.iso"
+        checksum: sha256:abc1230000000000000000000000000000000000000000000000000000000000
+      destination: vyos/vyos-{{ .Vars.version }}.iso
+      updateFile:
+        path: infrastructure/{{ .Vars.channel }}.pkr.hcl
+        replacements:
+          - pattern: 'version = "[^"]*"'
+            value: 'version = "{{ .Vars.version }}"'
+`)
+
+		manifest, err := ParseManifestRaw(data)
+		require.NoError(t, err)
+		require.Len(t, manifest.Spec.Images, 1)
+
+		img := manifest.Spec.Images[0]
+		assert.Equal(t, "vyos-2025.12.20-0020-rolling", img.Name)
+		assert.Equal(t, "https://example.com/vyos-2025.12.20-0020-rolling.iso", img.Source.URL)
+		assert.Equal(t, []string{"https://mirror.example.com/vyos-2025.12.20-0020-rolling.iso"}, img.Source.Mirrors)
+		assert.Equal(t, "vyos/vyos-2025.12.20-0020-rolling.iso", img.Destination)
+		assert.Equal(t, "infrastructure/rolling.pkr.hcl", img.UpdateFile.Path)
+		assert.Equal(t, map[string]string{
+			"mirror":  "https://mirror.example.com",
+			"channel": "rolling",
+			"version": "2025.12.20-0020-rolling",
+		}, img.Vars)
+
+		// Replacement values are expanded at sync time with the merged vars
+		assert.Equal(t, `version = "{{ .Vars.version }}"`, img.UpdateFile.Replacements[0].Value)
+	})
+
+	t.Run("updateFiles paths", func(t *testing.T) {
+		data := []byte(`spec:
+  vars:
+    channel: rolling
+  images:
+    - name: vyos
+      updateFiles:
+        - path: infrastructure/{{ .Vars.channel }}/*.hcl
+        - path: docs/{{ .Vars.channel }}.md
+`)
+
+		manifest, err := ParseManifestRaw(data)
+		require.NoError(t, err)
+		img := manifest.Spec.Images[0]
+		assert.Equal(t, "infrastructure/rolling/*.hcl", img.UpdateFiles[0].Path)
+		assert.Equal(t, "docs/rolling.md", img.UpdateFiles[1].Path)
+	})
+
+	t.Run("image vars override spec vars", func(t *testing.T) {
+		data := []byte(`spec:
+  vars:
+    version: "1.0"
+  images:
+    - name: a-{{ .Vars.version }}
+    - name: b-{{ .Vars.version }}
+      vars:
+        version: "2.0"
+`)
+
+		manifest, err := ParseManifestRaw(data)
+		require.NoError(t, err)
+		assert.Equal(t, "a-1.0", manifest.Spec.Images[0].Name)
+		assert.Equal(t, "b-2.0", manifest.Spec.Images[1].Name)
+	})
+
+	t.Run("unknown variable", func(t *testing.T) {
+		data := []byte(`spec:
+  images:
+    - name: talos
+      destination: talos/{{ .Vars.missing }}.raw
+`)
+
+		_, err := ParseManifestRaw(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `image[0] "talos": expand destination`)
+		assert.Contains(t, err.Error(), "missing")
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		data := []byte(`spec:
+  images:
+    - name: talos
+      source:
+        url: https://example.com/{{ .Vars.version
+`)
+
+		_, err := ParseManifestRaw(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "parse source.url template")
+	})
+}
@@ -0,0 +1,93 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// Environment variables that override the AWS shared files, as in the AWS CLI.
+const (
+	EnvAWSProfile         = "AWS_PROFILE"
+	EnvAWSCredentialsFile = "AWS_SHARED_CREDENTIALS_FILE" //nolint:gosec // G101: This is the name of the environment variable
+	EnvAWSConfigFile      = "AWS_CONFIG_FILE"
+)
+
+// AWSProvider reads access keys from a profile in the AWS shared
+// credentials and config files. e2 is S3-compatible, so its keys can be
+// kept alongside other S3 accounts.
+//
+// The endpoint comes from Endpoint, the profile's endpoint_url or
+// E2_ENDPOINT, and the bucket from Bucket or E2_BUCKET.
+type AWSProvider struct {
+	// Profile is the profile name; empty means AWS_PROFILE. When neither
+	// is set the provider is not configured, so keys in an unrelated
+	// [default] AWS profile are never sent to e2.
+	Profile  string
+	Endpoint string
+	Bucket   string
+}
+
+// Name describes the provider.
+func (p *AWSProvider) Name() string {
+	if profile := p.profile(); profile != "" {
+		return fmt.Sprintf("AWS shared credentials profile %s", profile)
+	}
+	return fmt.Sprintf("AWS shared credentials profile from %s", EnvAWSProfile)
+}
+
+func (p *AWSProvider) profile() string {
+	if p.Profile != "" {
+		return p.Profile
+	}
+	return os.Getenv(EnvAWSProfile)
+}
+
+// Retrieve loads the profile's static keys. No profile name, a missing profile, a profile
+// without static keys, or no endpoint or bucket counts as not configured.
+func (p *AWSProvider) Retrieve() (*E2Credentials, error) {
+	profile := p.profile()
+	if profile == "" {
+		return nil, fmt.Errorf("%w: %s is not set", ErrNotFound, EnvAWSProfile)
+	}
+	cfg, err := awsconfig.LoadSharedConfigProfile(context.Background(), profile, func(o *awsconfig.LoadSharedConfigOptions) {
+		if file := os.Getenv(EnvAWSCredentialsFile); file != "" {
+			o.CredentialsFiles = []string{file}
+		}
+		if file := os.Getenv(EnvAWSConfigFile); file != "" {
+			o.ConfigFiles = []string{file}
+		}
+	})
+	if err != nil {
+		var notExist awsconfig.SharedConfigProfileNotExistError
+		if errors.As(err, &notExist) {
+			return nil, fmt.Errorf("%w: no AWS profile %q", ErrNotFound, profile)
+		}
+		return nil, fmt.Errorf("load AWS profile %q: %w", profile, err)
+	}
+	if !cfg.Credentials.HasKeys() {
+		return nil, fmt.Errorf("%w: AWS profile %q has no static access keys", ErrNotFound, profile)
+	}
+
+	creds := &E2Credentials{
+		AccessKey: cfg.Credentials.AccessKeyID,
+		SecretKey: cfg.Credentials.SecretAccessKey,
+		Endpoint:  cfg.BaseEndpoint,
+	}
+	envDefaults(creds)
+	if p.Endpoint != "" {
+		creds.Endpoint = p.Endpoint
+	}
+	if p.Bucket != "" {
+		creds.Bucket = p.Bucket
+	}
+	if creds.Endpoint == "" || creds.Bucket == "" {
+		return nil, fmt.Errorf("%w: AWS profile %q has keys but no e2 endpoint and bucket (set %s and %s, or use a labctl profile)",
+			ErrNotFound, profile, EnvEndpoint, EnvBucket)
+	}
+
+	return creds, nil
+}
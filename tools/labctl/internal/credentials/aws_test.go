@@ -0,0 +1,110 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAWSProvider_Retrieve(t *testing.T) {
+	writeAWSFiles := func(t *testing.T, credentials, config string) {
+		t.Helper()
+		credsFile := os.Getenv(EnvAWSCredentialsFile)
+		require.NoError(t, os.MkdirAll(filepath.Dir(credsFile), 0o700))
+		require.NoError(t, os.WriteFile(credsFile, []byte(credentials), 0o600))
+		require.NoError(t, os.WriteFile(os.Getenv(EnvAWSConfigFile), []byte(config), 0o600))
+	}
+
+	t.Run("reads keys and endpoint_url from the profile", func(t *testing.T) {
+		isolateProviders(t)
+		writeAWSFiles(t, `[e2]
+aws_access_key_id = access123
+aws_secret_access_key = secret456
+`, `[profile e2]
+endpoint_url = https://e2.example.com
+`)
+		t.Setenv(EnvBucket, "my-bucket")
+
+		creds, err := (&AWSProvider{Profile: "e2"}).Retrieve()
+		require.NoError(t, err)
+		assert.Equal(t, &E2Credentials{
+			AccessKey: "access123",
+			SecretKey: "secret456",
+			Endpoint:  "https://e2.example.com",
+			Bucket:    "my-bucket",
+		}, creds)
+	})
+
+	t.Run("uses AWS_PROFILE and explicit endpoint and bucket", func(t *testing.T) {
+		isolateProviders(t)
+		writeAWSFiles(t, `[idrive]
+aws_access_key_id = access123
+aws_secret_access_key = secret456
+`, "")
+		t.Setenv(EnvAWSProfile, "idrive")
+		t.Setenv(EnvEndpoint, "https://ignored.example.com")
+
+		p := &AWSProvider{Endpoint: "https://e2.example.com", Bucket: "other-bucket"}
+		assert.Equal(t, "AWS shared credentials profile idrive", p.Name())
+
+		creds, err := p.Retrieve()
+		require.NoError(t, err)
+		assert.Equal(t, "https://e2.example.com", creds.Endpoint)
+		assert.Equal(t, "other-bucket", creds.Bucket)
+	})
+
+	t.Run("missing profile", func(t *testing.T) {
+		isolateProviders(t)
+
+		_, err := (&AWSProvider{Profile: "e2"}).Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), `no AWS profile "e2"`)
+	})
+
+	t.Run("ignores the default profile without AWS_PROFILE", func(t *testing.T) {
+		isolateProviders(t)
+		writeAWSFiles(t, `[default]
+aws_access_key_id = access123
+aws_secret_access_key = secret456
+`, "")
+		t.Setenv(EnvEndpoint, "https://e2.example.com")
+		t.Setenv(EnvBucket, "my-bucket")
+
+		p := &AWSProvider{}
+		assert.Equal(t, "AWS shared credentials profile from AWS_PROFILE", p.Name())
+		_, err := p.Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), "AWS_PROFILE is not set")
+	})
+
+	t.Run("no endpoint or bucket", func(t *testing.T) {
+		isolateProviders(t)
+		writeAWSFiles(t, `[default]
+aws_access_key_id = access123
+aws_secret_access_key = secret456
+`, "")
+
+		_, err := (&AWSProvider{Profile: "default"}).Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), "no e2 endpoint and bucket")
+	})
+
+	t.Run("profile without static keys", func(t *testing.T) {
+		isolateProviders(t)
+		writeAWSFiles(t, "", `[default]
+region = us-east-1
+`)
+
+		_, err := (&AWSProvider{Profile: "default"}).Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), "no static access keys")
+	})
+}
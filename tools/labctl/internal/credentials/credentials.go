@@ -1,7 +1,13 @@
 // Package credentials provides credential resolution for e2 storage.
 package credentials
 
-import "fmt"
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
 
 // E2Credentials holds credentials for iDrive e2 storage.
 type E2Credentials struct {
@@ -11,20 +17,38 @@ type E2Credentials struct {
 	Bucket    string `yaml:"bucket"`
 }
 
-// Validate checks that all required fields are present.
+// bucketName matches S3 bucket names: 3-63 lowercase letters, digits, dots
+// and hyphens, starting and ending with a letter or digit.
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Validate checks that all required fields are present and well formed:
+// the keys have no whitespace, which usually means a bad paste, the endpoint
+// is an http or https URL, and the bucket is a valid bucket name.
 func (c *E2Credentials) Validate() error {
 	if c.AccessKey == "" {
 		return fmt.Errorf("access_key is required")
 	}
+	if strings.ContainsFunc(c.AccessKey, unicode.IsSpace) {
+		return fmt.Errorf("access_key contains whitespace")
+	}
 	if c.SecretKey == "" {
 		return fmt.Errorf("secret_key is required")
 	}
+	if strings.ContainsFunc(c.SecretKey, unicode.IsSpace) {
+		return fmt.Errorf("secret_key contains whitespace")
+	}
 	if c.Endpoint == "" {
 		return fmt.Errorf("endpoint is required")
 	}
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("endpoint %q is not an http or https URL", c.Endpoint)
+	}
 	if c.Bucket == "" {
 		return fmt.Errorf("bucket is required")
 	}
+	if !bucketName.MatchString(c.Bucket) {
+		return fmt.Errorf("bucket %q is not a valid bucket name", c.Bucket)
+	}
 	return nil
 }
 
@@ -32,29 +56,15 @@ func (c *E2Credentials) Validate() error {
 type ResolveOptions struct {
 	// SOPSFile is the path to a SOPS-encrypted credentials file.
 	SOPSFile string
-
 	// AgeKeyFile is the path to the age private key for SOPS decryption.
 	AgeKeyFile string
+	// Profile names a labctl config or AWS shared credentials profile.
+	Profile string
 }
 
-// Resolve attempts to resolve e2 credentials using the following order:
-// 1. Environment variables (if all are present)
-// 2. SOPS file (if specified in options)
+// Resolve resolves e2 credentials from the first provider in the chain
+// returned by NewChain that has them.
 func Resolve(opts ResolveOptions) (*E2Credentials, error) {
-	// Try environment variables first
-	creds, err := FromEnv()
-	if err == nil {
-		return creds, nil
-	}
-
-	// Try SOPS file if specified
-	if opts.SOPSFile != "" {
-		creds, err := FromSOPS(opts.SOPSFile, opts.AgeKeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("resolve from SOPS: %w", err)
-		}
-		return creds, nil
-	}
-
-	return nil, fmt.Errorf("no credentials found: environment variables incomplete and no SOPS file specified")
+	creds, _, err := NewChain(opts).Resolve()
+	return creds, err
 }
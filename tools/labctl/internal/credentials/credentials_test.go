@@ -3,6 +3,7 @@ package credentials
 import (
 	"testing"
 
+	sopsage "github.com/getsops/sops/v3/age"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -58,6 +59,46 @@ func TestE2Credentials_Validate(t *testing.T) {
 			},
 			wantErr: "bucket is required",
 		},
+		{
+			name: "access key with whitespace",
+			creds: E2Credentials{
+				AccessKey: "access123\n",
+				SecretKey: "secret456",
+				Endpoint:  "https://e2.example.com",
+				Bucket:    "my-bucket",
+			},
+			wantErr: "access_key contains whitespace",
+		},
+		{
+			name: "secret key with whitespace",
+			creds: E2Credentials{
+				AccessKey: "access123",
+				SecretKey: "secret 456",
+				Endpoint:  "https://e2.example.com",
+				Bucket:    "my-bucket",
+			},
+			wantErr: "secret_key contains whitespace",
+		},
+		{
+			name: "endpoint without scheme",
+			creds: E2Credentials{
+				AccessKey: "access123",
+				SecretKey: "secret456",
+				Endpoint:  "e2.example.com",
+				Bucket:    "my-bucket",
+			},
+			wantErr: `endpoint "e2.example.com" is not an http or https URL`,
+		},
+		{
+			name: "invalid bucket name",
+			creds: E2Credentials{
+				AccessKey: "access123",
+				SecretKey: "secret456",
+				Endpoint:  "https://e2.example.com",
+				Bucket:    "My_Bucket",
+			},
+			wantErr: `bucket "My_Bucket" is not a valid bucket name`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -91,10 +132,7 @@ func TestResolve(t *testing.T) {
 	})
 
 	t.Run("returns error when no credentials available", func(t *testing.T) {
-		// Clear all env vars
-		for _, key := range []string{EnvAccessKey, EnvSecretKey, EnvEndpoint, EnvBucket} {
-			t.Setenv(key, "")
-		}
+		isolateProviders(t)
 
 		_, err := Resolve(ResolveOptions{})
 		require.Error(t, err)
@@ -102,10 +140,7 @@ func TestResolve(t *testing.T) {
 	})
 
 	t.Run("returns error when SOPS file not found", func(t *testing.T) {
-		// Clear all env vars
-		for _, key := range []string{EnvAccessKey, EnvSecretKey, EnvEndpoint, EnvBucket} {
-			t.Setenv(key, "")
-		}
+		isolateProviders(t)
 
 		_, err := Resolve(ResolveOptions{
 			SOPSFile: "/nonexistent/path/credentials.sops.yaml",
@@ -113,4 +148,29 @@ func TestResolve(t *testing.T) {
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "SOPS file not found")
 	})
+	t.Run("SOPS file takes precedence over LABCTL_PROFILE", func(t *testing.T) {
+		isolateProviders(t)
+		isolateAgeKeys(t)
+		t.Setenv(EnvProfile, "staging")
+		identity := newAgeIdentity(t)
+		t.Setenv(sopsage.SopsAgeKeyEnv, identity.String())
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+
+		creds, err := Resolve(ResolveOptions{SOPSFile: sopsFile})
+		require.NoError(t, err)
+		assert.Equal(t, "test-access-key", creds.AccessKey)
+	})
+
+	t.Run("profile takes precedence over environment variables", func(t *testing.T) {
+		isolateProviders(t)
+		t.Setenv("E2_ACCESS_KEY", "access123")
+		t.Setenv("E2_SECRET_KEY", "secret456")
+		t.Setenv("E2_ENDPOINT", "https://e2.example.com")
+		t.Setenv("E2_BUCKET", "my-bucket")
+
+		_, err := Resolve(ResolveOptions{Profile: "prod"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "labctl profile prod: not configured")
+		assert.Contains(t, err.Error(), `no AWS profile "prod"`)
+	})
 }
@@ -47,3 +47,21 @@ func FromEnv() (*E2Credentials, error) {
 		Bucket:    bucket,
 	}, nil
 }
+
+// EnvProvider reads credentials from the E2_* environment variables.
+type EnvProvider struct{}
+
+// Name describes the provider.
+func (p *EnvProvider) Name() string {
+	return "environment variables"
+}
+
+// Retrieve returns the credentials from the environment. Incomplete
+// variables count as not configured.
+func (p *EnvProvider) Retrieve() (*E2Credentials, error) {
+	creds, err := FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return creds, nil
+}
@@ -0,0 +1,63 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvCredentialHelper is the environment variable naming a credential
+// helper command.
+const EnvCredentialHelper = "LABCTL_CREDENTIAL_HELPER"
+
+// ExecProvider runs a credential helper command, such as a password manager
+// CLI, that prints the credentials as YAML or JSON with the same keys as a
+// SOPS credentials file. The command is split on whitespace and run without
+// a shell.
+//
+// An endpoint or bucket the helper leaves out is taken from E2_ENDPOINT and
+// E2_BUCKET; Endpoint and Bucket, when set, take precedence over both.
+type ExecProvider struct {
+	// Command is the helper command; empty means LABCTL_CREDENTIAL_HELPER.
+	Command  string
+	Endpoint string
+	Bucket   string
+}
+
+// Name describes the provider.
+func (p *ExecProvider) Name() string {
+	if command := p.command(); command != "" {
+		return "credential helper " + strings.Fields(command)[0]
+	}
+	return "credential helper"
+}
+
+func (p *ExecProvider) command() string {
+	if p.Command != "" {
+		return p.Command
+	}
+	return strings.TrimSpace(os.Getenv(EnvCredentialHelper))
+}
+
+// Retrieve runs the helper and parses its output.
+func (p *ExecProvider) Retrieve() (*E2Credentials, error) {
+	args := strings.Fields(p.command())
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: %s is not set", ErrNotFound, EnvCredentialHelper)
+	}
+
+	stdout, stderr, err := commandRunner(args[0], args[1:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("credential helper failed: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+
+	var creds E2Credentials
+	if err := yaml.Unmarshal(stdout, &creds); err != nil {
+		return nil, fmt.Errorf("parse credential helper output: %w", err)
+	}
+	envDefaults(&creds)
+
+	return override(&creds, p.Endpoint, p.Bucket)
+}
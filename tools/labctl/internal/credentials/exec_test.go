@@ -0,0 +1,87 @@
+package credentials
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecProvider_Retrieve(t *testing.T) {
+	originalRunner := commandRunner
+	t.Cleanup(func() {
+		commandRunner = originalRunner
+	})
+
+	t.Run("parses helper output", func(t *testing.T) {
+		isolateProviders(t)
+		t.Setenv(EnvCredentialHelper, "pass-e2 show lab")
+
+		commandRunner = func(name string, args []string, _ []string) ([]byte, []byte, error) {
+			assert.Equal(t, "pass-e2", name)
+			assert.Equal(t, []string{"show", "lab"}, args)
+			return []byte(`{"access_key": "access123", "secret_key": "secret456", "endpoint": "https://e2.example.com", "bucket": "my-bucket"}`), nil, nil
+		}
+
+		p := &ExecProvider{}
+		assert.Equal(t, "credential helper pass-e2", p.Name())
+
+		creds, err := p.Retrieve()
+		require.NoError(t, err)
+		assert.Equal(t, &E2Credentials{
+			AccessKey: "access123",
+			SecretKey: "secret456",
+			Endpoint:  "https://e2.example.com",
+			Bucket:    "my-bucket",
+		}, creds)
+	})
+
+	t.Run("endpoint and bucket from environment and overrides", func(t *testing.T) {
+		isolateProviders(t)
+		t.Setenv(EnvEndpoint, "https://e2.example.com")
+		t.Setenv(EnvBucket, "env-bucket")
+
+		commandRunner = func(string, []string, []string) ([]byte, []byte, error) {
+			return []byte("access_key: access123\nsecret_key: secret456\n"), nil, nil
+		}
+
+		creds, err := (&ExecProvider{Command: "helper", Bucket: "profile-bucket"}).Retrieve()
+		require.NoError(t, err)
+		assert.Equal(t, "https://e2.example.com", creds.Endpoint)
+		assert.Equal(t, "profile-bucket", creds.Bucket)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		isolateProviders(t)
+
+		_, err := (&ExecProvider{}).Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("helper fails", func(t *testing.T) {
+		isolateProviders(t)
+
+		commandRunner = func(string, []string, []string) ([]byte, []byte, error) {
+			return nil, []byte("vault is locked\n"), errors.New("exit status 1")
+		}
+
+		_, err := (&ExecProvider{Command: "helper"}).Retrieve()
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, ErrNotFound))
+		assert.Equal(t, "credential helper failed: exit status 1: vault is locked", err.Error())
+	})
+
+	t.Run("incomplete output", func(t *testing.T) {
+		isolateProviders(t)
+
+		commandRunner = func(string, []string, []string) ([]byte, []byte, error) {
+			return []byte("access_key: access123\n"), nil, nil
+		}
+
+		_, err := (&ExecProvider{Command: "helper"}).Retrieve()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "secret_key is required")
+	})
+}
@@ -0,0 +1,187 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfile is the profile used when none is named.
+const DefaultProfile = "default"
+
+// Config is the labctl user configuration file.
+type Config struct {
+	// Profiles maps profile names to e2 accounts.
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile names an e2 endpoint and bucket, and where the keys for them come
+// from: exactly one of SOPSFile, AWSProfile or CredentialHelper.
+type Profile struct {
+	// Endpoint and Bucket override those of the key source.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Bucket   string `yaml:"bucket,omitempty"`
+
+	// SOPSFile is a SOPS-encrypted credentials file, relative to the config file.
+	SOPSFile string `yaml:"sopsFile,omitempty"`
+	// AgeKeyFile is the age private key for SOPSFile.
+	AgeKeyFile string `yaml:"ageKeyFile,omitempty"`
+	// AWSProfile is a profile in the AWS shared credentials files.
+	AWSProfile string `yaml:"awsProfile,omitempty"`
+	// CredentialHelper is a command printing the credentials, as for ExecProvider.
+	CredentialHelper string `yaml:"credentialHelper,omitempty"`
+}
+
+// validate checks that the profile has exactly one key source.
+func (p Profile) validate() error {
+	var sources []string
+	if p.SOPSFile != "" {
+		sources = append(sources, "sopsFile")
+	}
+	if p.AWSProfile != "" {
+		sources = append(sources, "awsProfile")
+	}
+	if p.CredentialHelper != "" {
+		sources = append(sources, "credentialHelper")
+	}
+
+	switch {
+	case len(sources) == 0:
+		return fmt.Errorf("one of sopsFile, awsProfile or credentialHelper is required")
+	case len(sources) > 1:
+		return fmt.Errorf("%s are mutually exclusive", strings.Join(sources, " and "))
+	case p.AgeKeyFile != "" && p.SOPSFile == "":
+		return fmt.Errorf("ageKeyFile requires sopsFile")
+	}
+	return nil
+}
+
+// DefaultConfigPath returns the path of the labctl config file,
+// $XDG_CONFIG_HOME/labctl/config.yaml or ~/.config/labctl/config.yaml.
+func DefaultConfigPath() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("determine user config directory: %w", err)
+	}
+	return filepath.Join(base, "labctl", "config.yaml"), nil
+}
+
+// LoadConfig reads and validates a labctl config file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: Path is the user's config file
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := cfg.Profiles[name].validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile %q: %w", path, name, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// ProfileProvider reads credentials through a named profile in the labctl
+// config file.
+type ProfileProvider struct {
+	// Profile is the profile name; empty means DefaultProfile.
+	Profile string
+	// ConfigFile is the config file; empty means DefaultConfigPath.
+	ConfigFile string
+}
+
+// Name describes the provider.
+func (p *ProfileProvider) Name() string {
+	return fmt.Sprintf("labctl profile %s", p.profile())
+}
+
+func (p *ProfileProvider) profile() string {
+	if p.Profile != "" {
+		return p.Profile
+	}
+	return DefaultProfile
+}
+
+// Retrieve loads the profile and retrieves credentials from its key source.
+// A missing config file or profile counts as not configured; a profile
+// whose key source has no credentials is an error.
+func (p *ProfileProvider) Retrieve() (*E2Credentials, error) {
+	path := p.ConfigFile
+	if path == "" {
+		var err error
+		if path, err = DefaultConfigPath(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+	}
+
+	cfg, err := LoadConfig(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: no config file %s", ErrNotFound, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profile, ok := cfg.Profiles[p.profile()]
+	if !ok {
+		return nil, fmt.Errorf("%w: no profile %q in %s", ErrNotFound, p.profile(), path)
+	}
+
+	source := profile.source(filepath.Dir(path))
+	creds, err := source.Retrieve()
+	if err != nil {
+		// The profile asked for this source, so it is not optional here
+		return nil, fmt.Errorf("%s: %s", source.Name(), err.Error())
+	}
+
+	return override(creds, profile.Endpoint, profile.Bucket)
+}
+
+// source returns the provider for the profile's key source. Relative paths
+// are resolved against dir.
+func (p Profile) source(dir string) Provider {
+	switch {
+	case p.SOPSFile != "":
+		return &SOPSProvider{File: resolvePath(dir, p.SOPSFile), AgeKeyFile: resolvePath(dir, p.AgeKeyFile)}
+	case p.AWSProfile != "":
+		return &AWSProvider{Profile: p.AWSProfile, Endpoint: p.Endpoint, Bucket: p.Bucket}
+	default:
+		return &ExecProvider{Command: p.CredentialHelper, Endpoint: p.Endpoint, Bucket: p.Bucket}
+	}
+}
+
+// resolvePath expands a leading ~/ and makes path relative to dir.
+func resolvePath(dir, path string) string {
+	if path == "" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
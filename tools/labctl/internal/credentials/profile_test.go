@@ -0,0 +1,163 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "valid profiles",
+			config: `profiles:
+  default:
+    sopsFile: e2.sops.yaml
+    ageKeyFile: ~/.config/sops/age/keys.txt
+  backup:
+    endpoint: https://e2.example.com
+    bucket: lab-backups
+    awsProfile: idrive
+  laptop:
+    credentialHelper: pass-e2 show
+`,
+		},
+		{
+			name:    "no key source",
+			config:  "profiles:\n  default:\n    bucket: lab-images\n",
+			wantErr: `profile "default": one of sopsFile, awsProfile or credentialHelper is required`,
+		},
+		{
+			name:    "several key sources",
+			config:  "profiles:\n  default:\n    sopsFile: e2.sops.yaml\n    awsProfile: idrive\n",
+			wantErr: "sopsFile and awsProfile are mutually exclusive",
+		},
+		{
+			name:    "age key without SOPS file",
+			config:  "profiles:\n  default:\n    awsProfile: idrive\n    ageKeyFile: keys.txt\n",
+			wantErr: "ageKeyFile requires sopsFile",
+		},
+		{
+			name:    "unknown field",
+			config:  "profiles:\n  default:\n    accessKey: plaintext\n",
+			wantErr: "field accessKey not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o600))
+
+			cfg, err := LoadConfig(path)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, cfg.Profiles, 3)
+		})
+	}
+}
+
+func TestProfileProvider_Retrieve(t *testing.T) {
+	originalRunner, originalLookPath := commandRunner, lookPath
+	t.Cleanup(func() {
+		commandRunner, lookPath = originalRunner, originalLookPath
+	})
+	lookPath = func(string) (string, error) {
+		return "", errors.New("not installed")
+	}
+
+	writeConfig := func(t *testing.T, config string) {
+		t.Helper()
+		path := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "labctl", "config.yaml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	}
+
+	t.Run("SOPS file relative to the config file", func(t *testing.T) {
+		isolateProviders(t)
+		isolateAgeKeys(t)
+		identity := newAgeIdentity(t)
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+		keyFile := filepath.Join(filepath.Dir(sopsFile), "keys.txt")
+		require.NoError(t, os.WriteFile(keyFile, []byte(identity.String()+"\n"), 0o600))
+
+		configFile := filepath.Join(filepath.Dir(sopsFile), "config.yaml")
+		require.NoError(t, os.WriteFile(configFile, []byte(`profiles:
+  default:
+    bucket: other-bucket
+    sopsFile: credentials.sops.yaml
+    ageKeyFile: keys.txt
+`), 0o600))
+
+		creds, err := (&ProfileProvider{ConfigFile: configFile}).Retrieve()
+		require.NoError(t, err)
+		assert.Equal(t, "test-access-key", creds.AccessKey)
+		assert.Equal(t, "https://e2.example.com", creds.Endpoint)
+		assert.Equal(t, "other-bucket", creds.Bucket)
+	})
+
+	t.Run("named profile with credential helper", func(t *testing.T) {
+		isolateProviders(t)
+		writeConfig(t, `profiles:
+  backup:
+    endpoint: https://backup.example.com
+    bucket: lab-backups
+    credentialHelper: pass-e2 show backup
+`)
+		commandRunner = func(name string, args []string, _ []string) ([]byte, []byte, error) {
+			assert.Equal(t, "pass-e2", name)
+			assert.Equal(t, []string{"show", "backup"}, args)
+			return []byte("access_key: access123\nsecret_key: secret456\n"), nil, nil
+		}
+
+		creds, err := (&ProfileProvider{Profile: "backup"}).Retrieve()
+		require.NoError(t, err)
+		assert.Equal(t, &E2Credentials{
+			AccessKey: "access123",
+			SecretKey: "secret456",
+			Endpoint:  "https://backup.example.com",
+			Bucket:    "lab-backups",
+		}, creds)
+	})
+
+	t.Run("missing config file", func(t *testing.T) {
+		isolateProviders(t)
+
+		_, err := (&ProfileProvider{}).Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), "no config file")
+	})
+
+	t.Run("missing profile", func(t *testing.T) {
+		isolateProviders(t)
+		writeConfig(t, "profiles:\n  default:\n    awsProfile: idrive\n")
+
+		_, err := (&ProfileProvider{Profile: "prod"}).Retrieve()
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), `no profile "prod"`)
+	})
+
+	t.Run("key source without credentials is an error", func(t *testing.T) {
+		isolateProviders(t)
+		writeConfig(t, "profiles:\n  default:\n    awsProfile: idrive\n")
+
+		_, err := (&ProfileProvider{}).Retrieve()
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, ErrNotFound))
+		assert.Contains(t, err.Error(), `AWS shared credentials profile idrive: not configured: no AWS profile "idrive"`)
+	})
+}
@@ -0,0 +1,109 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is wrapped by a provider's error when it has no credentials
+// configured, so that a chain moves on to the next provider.
+var ErrNotFound = errors.New("not configured")
+
+// EnvProfile is the environment variable naming the profile to use when
+// --profile is not given.
+const EnvProfile = "LABCTL_PROFILE"
+
+// Provider is a source of e2 credentials.
+type Provider interface {
+	// Name describes the provider and where it reads from, without secrets.
+	Name() string
+	// Retrieve returns the credentials, or an error wrapping ErrNotFound
+	// when the provider has none configured.
+	Retrieve() (*E2Credentials, error)
+}
+
+// Chain tries providers in order and uses the first that has credentials.
+type Chain []Provider
+
+// NewChain returns the provider chain for opts.
+//
+// Without a profile the chain is: environment variables, the SOPS file,
+// the default profile in the labctl config file, the AWS shared credentials
+// profile named by AWS_PROFILE, only when it is set, and the credential
+// helper named by LABCTL_CREDENTIAL_HELPER. A profile, from opts or LABCTL_PROFILE, is an
+// explicit choice, so only the labctl config and AWS shared credentials
+// profiles of that name are tried. An explicit SOPS file wins over
+// LABCTL_PROFILE, which is then ignored, and is tried before a profile given
+// in opts.
+func NewChain(opts ResolveOptions) Chain {
+	profile := opts.Profile
+	if profile == "" && opts.SOPSFile == "" {
+		profile = os.Getenv(EnvProfile)
+	}
+	if profile != "" {
+		var chain Chain
+		if opts.SOPSFile != "" {
+			chain = append(chain, &SOPSProvider{File: opts.SOPSFile, AgeKeyFile: opts.AgeKeyFile})
+		}
+		return append(chain,
+			&ProfileProvider{Profile: profile},
+			&AWSProvider{Profile: profile},
+		)
+	}
+
+	return Chain{
+		&EnvProvider{},
+		&SOPSProvider{File: opts.SOPSFile, AgeKeyFile: opts.AgeKeyFile},
+		&ProfileProvider{},
+		&AWSProvider{},
+		&ExecProvider{},
+	}
+}
+
+// Resolve returns the credentials of the first provider that has them,
+// together with that provider. A provider that is configured but fails
+// stops the chain. When no provider has credentials, the error lists why
+// each was skipped.
+func (c Chain) Resolve() (*E2Credentials, Provider, error) {
+	var skipped []string
+	for _, p := range c {
+		creds, err := p.Retrieve()
+		switch {
+		case err == nil:
+			return creds, p, nil
+		case errors.Is(err, ErrNotFound):
+			skipped = append(skipped, fmt.Sprintf("  %s: %v", p.Name(), err))
+		default:
+			return nil, p, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+	}
+
+	return nil, nil, fmt.Errorf("no credentials found:\n%s", strings.Join(skipped, "\n"))
+}
+
+// envDefaults fills an empty endpoint and bucket from E2_ENDPOINT and E2_BUCKET.
+func envDefaults(creds *E2Credentials) {
+	if creds.Endpoint == "" {
+		creds.Endpoint = os.Getenv(EnvEndpoint)
+	}
+	if creds.Bucket == "" {
+		creds.Bucket = os.Getenv(EnvBucket)
+	}
+}
+
+// override sets the endpoint and bucket of creds to those given, where
+// they are not empty, and validates the result.
+func override(creds *E2Credentials, endpoint, bucket string) (*E2Credentials, error) {
+	if endpoint != "" {
+		creds.Endpoint = endpoint
+	}
+	if bucket != "" {
+		creds.Bucket = bucket
+	}
+	if err := creds.Validate(); err != nil {
+		return nil, fmt.Errorf("validate credentials: %w", err)
+	}
+	return creds, nil
+}
@@ -0,0 +1,139 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// isolateProviders points every provider at empty sources, so tests do not
+// pick up the developer's environment, config or AWS files.
+func isolateProviders(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, key := range []string{EnvAccessKey, EnvSecretKey, EnvEndpoint, EnvBucket, EnvProfile, EnvCredentialHelper, EnvAWSProfile} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv(EnvAWSCredentialsFile, filepath.Join(dir, "aws", "credentials"))
+	t.Setenv(EnvAWSConfigFile, filepath.Join(dir, "aws", "config"))
+}
+
+// stubProvider is a Provider returning fixed results.
+type stubProvider struct {
+	name  string
+	creds *E2Credentials
+	err   error
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) Retrieve() (*E2Credentials, error) { return p.creds, p.err }
+
+func TestChain_Resolve(t *testing.T) {
+	creds := &E2Credentials{AccessKey: "a", SecretKey: "s", Endpoint: "https://e2.example.com", Bucket: "b"}
+
+	t.Run("first provider with credentials wins", func(t *testing.T) {
+		skipped := &stubProvider{name: "first", err: fmt.Errorf("%w: nothing here", ErrNotFound)}
+		winner := &stubProvider{name: "second", creds: creds}
+		chain := Chain{skipped, winner, &stubProvider{name: "third", err: errors.New("not reached")}}
+
+		got, provider, err := chain.Resolve()
+		require.NoError(t, err)
+		assert.Same(t, creds, got)
+		assert.Same(t, winner, provider)
+	})
+
+	t.Run("configured provider that fails stops the chain", func(t *testing.T) {
+		chain := Chain{
+			&stubProvider{name: "broken", err: errors.New("decrypt failed")},
+			&stubProvider{name: "fallback", creds: creds},
+		}
+
+		_, _, err := chain.Resolve()
+		require.Error(t, err)
+		assert.Equal(t, "broken: decrypt failed", err.Error())
+	})
+
+	t.Run("lists why each provider was skipped", func(t *testing.T) {
+		chain := Chain{
+			&stubProvider{name: "first", err: fmt.Errorf("%w: reason one", ErrNotFound)},
+			&stubProvider{name: "second", err: fmt.Errorf("%w: reason two", ErrNotFound)},
+		}
+
+		_, _, err := chain.Resolve()
+		require.Error(t, err)
+		assert.Equal(t, "no credentials found:\n  first: not configured: reason one\n  second: not configured: reason two", err.Error())
+	})
+}
+
+func TestNewChain(t *testing.T) {
+	names := func(chain Chain) []string {
+		var names []string
+		for _, p := range chain {
+			names = append(names, fmt.Sprintf("%T", p))
+		}
+		return names
+	}
+
+	t.Run("default chain", func(t *testing.T) {
+		isolateProviders(t)
+
+		chain := NewChain(ResolveOptions{SOPSFile: "e2.sops.yaml"})
+		assert.Equal(t, []string{"*credentials.EnvProvider", "*credentials.SOPSProvider", "*credentials.ProfileProvider", "*credentials.AWSProvider", "*credentials.ExecProvider"}, names(chain))
+	})
+
+	t.Run("default chain skips the default AWS profile", func(t *testing.T) {
+		isolateProviders(t)
+		credsFile := os.Getenv(EnvAWSCredentialsFile)
+		require.NoError(t, os.MkdirAll(filepath.Dir(credsFile), 0o700))
+		require.NoError(t, os.WriteFile(credsFile, []byte("[default]\naws_access_key_id = aws-access\naws_secret_access_key = aws-secret\n"), 0o600))
+		t.Setenv(EnvEndpoint, "https://e2.example.com")
+		t.Setenv(EnvBucket, "my-bucket")
+
+		_, _, err := NewChain(ResolveOptions{}).Resolve()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no credentials found")
+		assert.Contains(t, err.Error(), "AWS_PROFILE is not set")
+	})
+
+	t.Run("profile only tries profiles", func(t *testing.T) {
+		isolateProviders(t)
+
+		chain := NewChain(ResolveOptions{Profile: "prod"})
+		assert.Equal(t, []string{"*credentials.ProfileProvider", "*credentials.AWSProvider"}, names(chain))
+		assert.Equal(t, "labctl profile prod", chain[0].Name())
+		assert.Equal(t, "AWS shared credentials profile prod", chain[1].Name())
+	})
+
+	t.Run("SOPS file is tried before a profile", func(t *testing.T) {
+		isolateProviders(t)
+
+		chain := NewChain(ResolveOptions{Profile: "prod", SOPSFile: "e2.sops.yaml"})
+		assert.Equal(t, []string{"*credentials.SOPSProvider", "*credentials.ProfileProvider", "*credentials.AWSProvider"}, names(chain))
+	})
+
+	t.Run("SOPS file wins over profile from environment", func(t *testing.T) {
+		isolateProviders(t)
+		t.Setenv(EnvProfile, "staging")
+
+		chain := NewChain(ResolveOptions{SOPSFile: "e2.sops.yaml"})
+		assert.Equal(t, []string{"*credentials.EnvProvider", "*credentials.SOPSProvider", "*credentials.ProfileProvider", "*credentials.AWSProvider", "*credentials.ExecProvider"}, names(chain))
+		assert.Equal(t, "labctl profile default", chain[2].Name())
+	})
+
+	t.Run("profile from environment", func(t *testing.T) {
+		isolateProviders(t)
+		t.Setenv(EnvProfile, "staging")
+
+		chain := NewChain(ResolveOptions{})
+		require.Len(t, chain, 2)
+		assert.Equal(t, "labctl profile staging", chain[0].Name())
+	})
+}
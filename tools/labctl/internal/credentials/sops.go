@@ -2,10 +2,21 @@ package credentials
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/keyservice"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/getsops/sops/v3/version"
 	"gopkg.in/yaml.v3"
 )
 
@@ -25,35 +36,315 @@ var commandRunner = func(name string, args []string, env []string) (stdout, stde
 	return outBuf.Bytes(), errBuf.Bytes(), err
 }
 
+// lookPath finds the sops binary for the exec fallback.
+// This is a variable to allow mocking in tests.
+var lookPath = exec.LookPath
+
 // FromSOPS decrypts a SOPS-encrypted YAML file and parses e2 credentials.
-// If ageKeyFile is provided, it sets SOPS_AGE_KEY_FILE for the sops command.
+//
+// The file is decrypted in process. Age identities are read from ageKeyFile
+// if provided, otherwise from SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or the default
+// sops keys file; PGP keys are read from the GnuPG keyring. If in-process
+// decryption fails and the sops binary is installed, it is run as a fallback.
 func FromSOPS(sopsFile, ageKeyFile string) (*E2Credentials, error) {
+	creds, err := ReadSOPS(sopsFile, ageKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := creds.Validate(); err != nil {
+		return nil, fmt.Errorf("validate credentials: %w", err)
+	}
+
+	return creds, nil
+}
+
+// ReadSOPS decrypts a SOPS-encrypted YAML file like FromSOPS, but returns
+// the credentials without validating them, so that they can be edited.
+func ReadSOPS(sopsFile, ageKeyFile string) (*E2Credentials, error) {
 	// Verify the SOPS file exists
 	if _, err := os.Stat(sopsFile); err != nil {
 		return nil, fmt.Errorf("SOPS file not found: %w", err)
 	}
 
-	// Build sops command
+	plaintext, err := decryptSOPS(sopsFile, ageKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse decrypted YAML
+	var creds E2Credentials
+	if err := yaml.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("parse decrypted credentials: %w", err)
+	}
+
+	return &creds, nil
+}
+
+// WriteSOPS validates creds and writes them to sopsFile, encrypted for the
+// keys of the first creation rule in the nearest .sops.yaml that matches
+// the file, as the sops binary would when creating it. An existing file is
+// replaced atomically and keeps its mode.
+func WriteSOPS(sopsFile string, creds *E2Credentials) error {
+	if err := creds.Validate(); err != nil {
+		return fmt.Errorf("validate credentials: %w", err)
+	}
+
+	path, err := filepath.Abs(sopsFile)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", sopsFile, err)
+	}
+
+	plaintext, err := yaml.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("marshal credentials: %w", err)
+	}
+
+	encrypted, err := encryptNative(path, plaintext)
+	if err != nil {
+		return fmt.Errorf("sops encrypt failed: %w", err)
+	}
+
+	return writeFile(path, encrypted)
+}
+
+// encryptNative encrypts a YAML document for path with the SOPS library,
+// using the creation rules of the nearest .sops.yaml above path.
+func encryptNative(path string, plaintext []byte) ([]byte, error) {
+	confPath, err := config.FindConfigFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("find .sops.yaml for %s: %w", path, err)
+	}
+	rule, err := config.LoadCreationRuleForFile(confPath, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("load creation rules from %s: %w", confPath, err)
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("%s has no creation rules", confPath)
+	}
+
+	store := sopsyaml.NewStore(&config.YAMLStoreConfig{})
+	branches, err := store.LoadPlainFile(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("load plaintext: %w", err)
+	}
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:               rule.KeyGroups,
+			ShamirThreshold:         rule.ShamirThreshold,
+			UnencryptedSuffix:       rule.UnencryptedSuffix,
+			EncryptedSuffix:         rule.EncryptedSuffix,
+			UnencryptedRegex:        rule.UnencryptedRegex,
+			EncryptedRegex:          rule.EncryptedRegex,
+			UnencryptedCommentRegex: rule.UnencryptedCommentRegex,
+			EncryptedCommentRegex:   rule.EncryptedCommentRegex,
+			MACOnlyEncrypted:        rule.MACOnlyEncrypted,
+			Version:                 version.Version,
+		},
+	}
+	// Like the sops binary, encrypt everything unless the rule says otherwise
+	if rule.UnencryptedSuffix == "" && rule.EncryptedSuffix == "" && rule.UnencryptedRegex == "" &&
+		rule.EncryptedRegex == "" && rule.UnencryptedCommentRegex == "" && rule.EncryptedCommentRegex == "" {
+		tree.Metadata.UnencryptedSuffix = sops.DefaultUnencryptedSuffix
+	}
+
+	key, errs := tree.GenerateDataKey()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("encrypt data key: %w", errors.Join(errs...))
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(key, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("encrypt MAC: %w", err)
+	}
+
+	return store.EmitEncryptedFile(tree)
+}
+
+// writeFile atomically replaces path with content through a temp file in
+// the same directory. A new file is readable only by its owner.
+func writeFile(path string, content []byte) (err error) {
+	mode := os.FileMode(0o600)
+	if info, statErr := os.Stat(path); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".labctl-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(content); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("set mode: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+// SOPSProvider reads credentials from a SOPS-encrypted file.
+type SOPSProvider struct {
+	File       string
+	AgeKeyFile string
+}
+
+// Name describes the provider.
+func (p *SOPSProvider) Name() string {
+	if p.File == "" {
+		return "SOPS file"
+	}
+	return "SOPS file " + p.File
+}
+
+// Retrieve decrypts the file with FromSOPS.
+func (p *SOPSProvider) Retrieve() (*E2Credentials, error) {
+	if p.File == "" {
+		return nil, fmt.Errorf("%w: no file given with --credentials", ErrNotFound)
+	}
+	return FromSOPS(p.File, p.AgeKeyFile)
+}
+
+// decryptSOPS decrypts a SOPS file in process, falling back to the sops
+// binary when that fails and the binary is installed.
+func decryptSOPS(sopsFile, ageKeyFile string) ([]byte, error) {
+	plaintext, err := decryptNative(sopsFile, ageKeyFile)
+	if err == nil {
+		return plaintext, nil
+	}
+
+	if _, lookErr := lookPath("sops"); lookErr != nil {
+		return nil, fmt.Errorf("sops decrypt failed: %w", err)
+	}
+
+	plaintext, execErr := decryptExec(sopsFile, ageKeyFile)
+	if execErr != nil {
+		return nil, fmt.Errorf("sops decrypt failed: %w (in-process decryption: %v)", execErr, err)
+	}
+	return plaintext, nil
+}
+
+// decryptExec decrypts a SOPS file by running the sops binary.
+// If ageKeyFile is provided, it sets SOPS_AGE_KEY_FILE for the sops command.
+func decryptExec(sopsFile, ageKeyFile string) ([]byte, error) {
 	args := []string{"--decrypt", sopsFile}
 	var env []string
 	if ageKeyFile != "" {
-		env = append(os.Environ(), fmt.Sprintf("SOPS_AGE_KEY_FILE=%s", ageKeyFile))
+		env = append(os.Environ(), fmt.Sprintf("%s=%s", sopsage.SopsAgeKeyFileEnv, ageKeyFile))
 	}
 
 	stdout, stderr, err := commandRunner("sops", args, env)
 	if err != nil {
-		return nil, fmt.Errorf("sops decrypt failed: %w: %s", err, string(stderr))
+		return nil, fmt.Errorf("%w: %s", err, string(stderr))
 	}
+	return stdout, nil
+}
 
-	// Parse decrypted YAML
-	var creds E2Credentials
-	if err := yaml.Unmarshal(stdout, &creds); err != nil {
-		return nil, fmt.Errorf("parse decrypted credentials: %w", err)
+// decryptNative decrypts a SOPS-encrypted YAML file with the SOPS library,
+// verifying the MAC the same way the sops binary does.
+func decryptNative(sopsFile, ageKeyFile string) ([]byte, error) {
+	data, err := os.ReadFile(sopsFile) //nolint:gosec // G304: Path is provided by user
+	if err != nil {
+		return nil, fmt.Errorf("read SOPS file: %w", err)
 	}
 
-	if err := creds.Validate(); err != nil {
-		return nil, fmt.Errorf("validate credentials: %w", err)
+	store := sopsyaml.NewStore(&config.YAMLStoreConfig{})
+	tree, err := store.LoadEncryptedFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("load SOPS file: %w", err)
 	}
 
-	return &creds, nil
+	identities, err := ageIdentities(ageKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := keyservice.NewCustomLocalClient(&keyServer{identities: identities})
+	key, err := tree.Metadata.GetDataKeyWithKeyServices([]keyservice.KeyServiceClient{svc}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get data key: %w", err)
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Decrypt(key, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+
+	originalMAC, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt MAC: %w", err)
+	}
+	if originalMAC != mac {
+		return nil, fmt.Errorf("MAC mismatch, the file has been modified")
+	}
+
+	return store.EmitPlainFile(tree.Branches)
+}
+
+// ageIdentities reads the age identities in ageKeyFile. It returns none when
+// ageKeyFile is empty, leaving the SOPS library to find them.
+func ageIdentities(ageKeyFile string) (sopsage.ParsedIdentities, error) {
+	if ageKeyFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(ageKeyFile) //nolint:gosec // G304: Path is provided by user
+	if err != nil {
+		return nil, fmt.Errorf("read age key file: %w", err)
+	}
+
+	var identities sopsage.ParsedIdentities
+	if err := identities.Import(string(data)); err != nil {
+		return nil, fmt.Errorf("parse age key file %s: %w", ageKeyFile, err)
+	}
+	return identities, nil
+}
+
+// keyServer is a local SOPS key service that decrypts age keys with the
+// given identities. Other key types, and age keys when no identities are
+// given, are handled by the standard local key service.
+type keyServer struct {
+	keyservice.Server
+	identities sopsage.ParsedIdentities
+}
+
+// Decrypt decrypts a data key.
+func (s *keyServer) Decrypt(ctx context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	ageKey := req.GetKey().GetAgeKey()
+	if ageKey == nil || len(s.identities) == 0 {
+		return s.Server.Decrypt(ctx, req)
+	}
+
+	key := &sopsage.MasterKey{Recipient: ageKey.GetRecipient(), EncryptedKey: string(req.GetCiphertext())}
+	s.identities.ApplyToMasterKey(key)
+
+	plaintext, err := key.Decrypt()
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
 }
@@ -3,39 +3,224 @@ package credentials
 import (
 	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"filippo.io/age"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/getsops/sops/v3/version"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+const testCredentialsYAML = `access_key: test-access-key
+secret_key: test-secret-key
+endpoint: https://e2.example.com
+bucket: test-bucket
+`
+
+// newAgeIdentity generates an age identity for encrypting test fixtures.
+func newAgeIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	return identity
+}
+
+// writeSOPSFile encrypts plaintext YAML to the age recipient the same way
+// sops --encrypt does, and writes it to a file in a temp directory.
+func writeSOPSFile(t *testing.T, plaintext string, recipient *age.X25519Recipient) string {
+	t.Helper()
+
+	store := sopsyaml.NewStore(&config.YAMLStoreConfig{})
+	branches, err := store.LoadPlainFile([]byte(plaintext))
+	require.NoError(t, err)
+
+	key, err := sopsage.MasterKeyFromRecipient(recipient.String())
+	require.NoError(t, err)
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:         []sops.KeyGroup{{key}},
+			UnencryptedSuffix: "_unencrypted",
+			Version:           version.Version,
+		},
+	}
+	dataKey, errs := tree.GenerateDataKey()
+	require.Empty(t, errs)
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	require.NoError(t, err)
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	require.NoError(t, err)
+
+	encrypted, err := store.EmitEncryptedFile(tree)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "credentials.sops.yaml")
+	require.NoError(t, os.WriteFile(path, encrypted, 0o600))
+	return path
+}
+
+// isolateAgeKeys clears the age key sources the SOPS library reads from the
+// environment, so tests do not pick up the developer's keys.
+func isolateAgeKeys(t *testing.T) {
+	t.Helper()
+
+	t.Setenv(sopsage.SopsAgeKeyEnv, "")
+	t.Setenv(sopsage.SopsAgeKeyFileEnv, "")
+	t.Setenv(sopsage.SopsAgeKeyCmdEnv, "")
+	_ = os.Unsetenv(sopsage.SopsAgeKeyEnv)
+	_ = os.Unsetenv(sopsage.SopsAgeKeyFileEnv)
+	_ = os.Unsetenv(sopsage.SopsAgeKeyCmdEnv)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+}
+
 func TestFromSOPS(t *testing.T) {
 	// Save original command runner and restore after tests
-	originalRunner := commandRunner
+	originalRunner, originalLookPath := commandRunner, lookPath
 	t.Cleanup(func() {
-		commandRunner = originalRunner
+		commandRunner, lookPath = originalRunner, originalLookPath
 	})
 
-	t.Run("successful decryption", func(t *testing.T) {
-		// Create a temporary file to satisfy the file existence check
-		dir := t.TempDir()
-		sopsFile := filepath.Join(dir, "credentials.sops.yaml")
-		err := os.WriteFile(sopsFile, []byte("encrypted content"), 0o600)
+	// The sops binary is not installed unless a test says otherwise
+	commandRunner = func(string, []string, []string) ([]byte, []byte, error) {
+		t.Fatal("sops binary should not be run")
+		return nil, nil, nil
+	}
+	lookPath = func(string) (string, error) {
+		return "", exec.ErrNotFound
+	}
+
+	identity := newAgeIdentity(t)
+
+	t.Run("successful decryption with SOPS_AGE_KEY", func(t *testing.T) {
+		isolateAgeKeys(t)
+		t.Setenv(sopsage.SopsAgeKeyEnv, identity.String())
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+
+		creds, err := FromSOPS(sopsFile, "")
 		require.NoError(t, err)
+		assert.Equal(t, "test-access-key", creds.AccessKey)
+		assert.Equal(t, "test-secret-key", creds.SecretKey)
+		assert.Equal(t, "https://e2.example.com", creds.Endpoint)
+		assert.Equal(t, "test-bucket", creds.Bucket)
+	})
+
+	t.Run("successful decryption with SOPS_AGE_KEY_FILE", func(t *testing.T) {
+		isolateAgeKeys(t)
+		keyFile := filepath.Join(t.TempDir(), "keys.txt")
+		require.NoError(t, os.WriteFile(keyFile, []byte(identity.String()+"\n"), 0o600))
+		t.Setenv(sopsage.SopsAgeKeyFileEnv, keyFile)
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+
+		creds, err := FromSOPS(sopsFile, "")
+		require.NoError(t, err)
+		assert.Equal(t, "test-access-key", creds.AccessKey)
+	})
+
+	t.Run("successful decryption with age key file", func(t *testing.T) {
+		isolateAgeKeys(t)
+		keyFile := filepath.Join(t.TempDir(), "keys.txt")
+		require.NoError(t, os.WriteFile(keyFile, []byte("# created: test\n"+identity.String()+"\n"), 0o600))
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+
+		creds, err := FromSOPS(sopsFile, keyFile)
+		require.NoError(t, err)
+		assert.Equal(t, "test-access-key", creds.AccessKey)
+	})
+
+	t.Run("wrong age key", func(t *testing.T) {
+		isolateAgeKeys(t)
+		t.Setenv(sopsage.SopsAgeKeyEnv, newAgeIdentity(t).String())
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+
+		_, err := FromSOPS(sopsFile, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "sops decrypt failed")
+		assert.Contains(t, err.Error(), "get data key")
+	})
+
+	t.Run("missing age key file", func(t *testing.T) {
+		isolateAgeKeys(t)
+		sopsFile := writeSOPSFile(t, testCredentialsYAML, identity.Recipient())
+
+		_, err := FromSOPS(sopsFile, filepath.Join(t.TempDir(), "missing.txt"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "read age key file")
+	})
+
+	t.Run("file not found", func(t *testing.T) {
+		_, err := FromSOPS("/nonexistent/path/credentials.sops.yaml", "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SOPS file not found")
+	})
+
+	t.Run("invalid credentials yaml", func(t *testing.T) {
+		isolateAgeKeys(t)
+		t.Setenv(sopsage.SopsAgeKeyEnv, identity.String())
+		sopsFile := writeSOPSFile(t, "access_key:\n  - not\n  - a string\n", identity.Recipient())
+
+		_, err := FromSOPS(sopsFile, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "parse decrypted credentials")
+	})
+
+	t.Run("missing required fields in credentials", func(t *testing.T) {
+		isolateAgeKeys(t)
+		t.Setenv(sopsage.SopsAgeKeyEnv, identity.String())
+		// Missing access_key
+		sopsFile := writeSOPSFile(t, `secret_key: test-secret-key
+endpoint: https://e2.example.com
+bucket: test-bucket
+`, identity.Recipient())
+
+		_, err := FromSOPS(sopsFile, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "validate credentials")
+		assert.Contains(t, err.Error(), "access_key is required")
+	})
+}
+
+func TestFromSOPSExecFallback(t *testing.T) {
+	// Save original command runner and restore after tests
+	originalRunner, originalLookPath := commandRunner, lookPath
+	t.Cleanup(func() {
+		commandRunner, lookPath = originalRunner, originalLookPath
+	})
+
+	lookPath = func(string) (string, error) {
+		return "/usr/local/bin/sops", nil
+	}
+
+	// A file the SOPS library cannot load, so decryption falls back to sops
+	writeUnsupported := func(t *testing.T) string {
+		t.Helper()
+		sopsFile := filepath.Join(t.TempDir(), "credentials.sops.yaml")
+		require.NoError(t, os.WriteFile(sopsFile, []byte("encrypted content"), 0o600))
+		return sopsFile
+	}
+
+	t.Run("successful decryption", func(t *testing.T) {
+		sopsFile := writeUnsupported(t)
 
 		// Mock the command runner to return valid credentials
 		commandRunner = func(name string, args []string, _ []string) ([]byte, []byte, error) {
 			assert.Equal(t, "sops", name)
 			assert.Equal(t, []string{"--decrypt", sopsFile}, args)
-
-			yamlOutput := `access_key: test-access-key
-secret_key: test-secret-key
-endpoint: https://e2.example.com
-bucket: test-bucket
-`
-			return []byte(yamlOutput), nil, nil
+			return []byte(testCredentialsYAML), nil, nil
 		}
 
 		creds, err := FromSOPS(sopsFile, "")
@@ -47,12 +232,9 @@ bucket: test-bucket
 	})
 
 	t.Run("successful decryption with age key file", func(t *testing.T) {
-		dir := t.TempDir()
-		sopsFile := filepath.Join(dir, "credentials.sops.yaml")
-		err := os.WriteFile(sopsFile, []byte("encrypted content"), 0o600)
-		require.NoError(t, err)
-
-		ageKeyFile := "/path/to/age-key.txt"
+		sopsFile := writeUnsupported(t)
+		ageKeyFile := filepath.Join(t.TempDir(), "keys.txt")
+		require.NoError(t, os.WriteFile(ageKeyFile, []byte(newAgeIdentity(t).String()+"\n"), 0o600))
 
 		commandRunner = func(_ string, _ []string, env []string) ([]byte, []byte, error) {
 			// Verify age key file is in environment
@@ -65,13 +247,7 @@ bucket: test-bucket
 				}
 			}
 			assert.True(t, found, "SOPS_AGE_KEY_FILE should be set in environment")
-
-			yamlOutput := `access_key: test-access-key
-secret_key: test-secret-key
-endpoint: https://e2.example.com
-bucket: test-bucket
-`
-			return []byte(yamlOutput), nil, nil
+			return []byte(testCredentialsYAML), nil, nil
 		}
 
 		creds, err := FromSOPS(sopsFile, ageKeyFile)
@@ -79,61 +255,113 @@ bucket: test-bucket
 		assert.Equal(t, "test-access-key", creds.AccessKey)
 	})
 
-	t.Run("file not found", func(t *testing.T) {
-		_, err := FromSOPS("/nonexistent/path/credentials.sops.yaml", "")
-		require.Error(t, err)
-		assert.Contains(t, err.Error(), "SOPS file not found")
-	})
-
 	t.Run("sops command fails", func(t *testing.T) {
-		dir := t.TempDir()
-		sopsFile := filepath.Join(dir, "credentials.sops.yaml")
-		err := os.WriteFile(sopsFile, []byte("encrypted content"), 0o600)
-		require.NoError(t, err)
+		sopsFile := writeUnsupported(t)
 
 		commandRunner = func(_ string, _ []string, _ []string) ([]byte, []byte, error) {
 			return nil, []byte("error: could not decrypt"), errors.New("exit status 1")
 		}
 
-		_, err = FromSOPS(sopsFile, "")
+		_, err := FromSOPS(sopsFile, "")
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "sops decrypt failed")
 		assert.Contains(t, err.Error(), "could not decrypt")
+		assert.Contains(t, err.Error(), "in-process decryption")
 	})
 
 	t.Run("invalid yaml output", func(t *testing.T) {
-		dir := t.TempDir()
-		sopsFile := filepath.Join(dir, "credentials.sops.yaml")
-		err := os.WriteFile(sopsFile, []byte("encrypted content"), 0o600)
-		require.NoError(t, err)
+		sopsFile := writeUnsupported(t)
 
 		commandRunner = func(_ string, _ []string, _ []string) ([]byte, []byte, error) {
 			return []byte("invalid: yaml: content: ["), nil, nil
 		}
 
-		_, err = FromSOPS(sopsFile, "")
+		_, err := FromSOPS(sopsFile, "")
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "parse decrypted credentials")
 	})
+}
 
-	t.Run("missing required fields in credentials", func(t *testing.T) {
+func TestWriteSOPS(t *testing.T) {
+	creds := &E2Credentials{
+		AccessKey: "new-access-key",
+		SecretKey: "new-secret-key",
+		Endpoint:  "https://e2.example.com",
+		Bucket:    "test-bucket",
+	}
+
+	// writeRules writes a .sops.yaml and returns a credentials path beside it.
+	writeRules := func(t *testing.T, rules string) string {
+		t.Helper()
 		dir := t.TempDir()
-		sopsFile := filepath.Join(dir, "credentials.sops.yaml")
-		err := os.WriteFile(sopsFile, []byte("encrypted content"), 0o600)
-		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".sops.yaml"), []byte(rules), 0o600))
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "images"), 0o700))
+		return filepath.Join(dir, "images", "e2.sops.yaml")
+	}
 
-		commandRunner = func(_ string, _ []string, _ []string) ([]byte, []byte, error) {
-			// Missing access_key
-			yamlOutput := `secret_key: test-secret-key
-endpoint: https://e2.example.com
-bucket: test-bucket
+	identity := newAgeIdentity(t)
+	other := newAgeIdentity(t)
+	rules := `creation_rules:
+  - path_regex: other\.sops\.yaml$
+    age: ` + other.Recipient().String() + `
+  - path_regex: .*\.sops\.yaml$
+    key_groups:
+      - age:
+          - ` + identity.Recipient().String() + `
 `
-			return []byte(yamlOutput), nil, nil
-		}
 
-		_, err = FromSOPS(sopsFile, "")
+	t.Run("encrypts with the matching creation rule", func(t *testing.T) {
+		isolateAgeKeys(t)
+		path := writeRules(t, rules)
+
+		require.NoError(t, WriteSOPS(path, creds))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "new-secret-key")
+		assert.Contains(t, string(data), identity.Recipient().String())
+		assert.NotContains(t, string(data), other.Recipient().String())
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		t.Setenv(sopsage.SopsAgeKeyEnv, identity.String())
+		got, err := FromSOPS(path, "")
+		require.NoError(t, err)
+		assert.Equal(t, creds, got)
+	})
+
+	t.Run("replaces an existing file and keeps its mode", func(t *testing.T) {
+		isolateAgeKeys(t)
+		path := writeRules(t, rules)
+		require.NoError(t, os.WriteFile(path, []byte("old"), 0o640))
+
+		require.NoError(t, WriteSOPS(path, creds))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "temp file left behind")
+	})
+
+	t.Run("no matching creation rule", func(t *testing.T) {
+		path := writeRules(t, "creation_rules:\n  - path_regex: \\.json$\n    age: "+identity.Recipient().String()+"\n")
+
+		err := WriteSOPS(path, creds)
 		require.Error(t, err)
-		assert.Contains(t, err.Error(), "validate credentials")
-		assert.Contains(t, err.Error(), "access_key is required")
+		assert.Contains(t, err.Error(), "no matching creation rules found")
+		assert.NoFileExists(t, path)
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		path := writeRules(t, rules)
+
+		err := WriteSOPS(path, &E2Credentials{AccessKey: "a", SecretKey: "s", Endpoint: "https://e2.example.com"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "bucket is required")
+		assert.NoFileExists(t, path)
 	})
 }
@@ -0,0 +1,420 @@
+// Package diskimage converts disk images between raw and qcow2 formats.
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Supported disk image formats.
+const (
+	FormatRaw   = "raw"
+	FormatQCOW2 = "qcow2"
+)
+
+// Formats lists the supported disk image formats.
+var Formats = []string{FormatRaw, FormatQCOW2}
+
+// qcow2 layout constants. Images are written as version 3 with 64KiB clusters
+// and 16-bit refcounts, the defaults qemu-img uses.
+const (
+	qcow2Magic         = "QFI\xfb"
+	qcow2Version       = 3
+	qcow2HeaderLength  = 104
+	qcow2ClusterBits   = 16
+	qcow2ClusterSize   = 1 << qcow2ClusterBits
+	qcow2RefcountOrder = 4
+	qcow2RefcountBytes = 1 << qcow2RefcountOrder / 8
+
+	qcow2L2Entries       = qcow2ClusterSize / 8
+	qcow2RefcountsPerBlk = qcow2ClusterSize / qcow2RefcountBytes
+	qcow2FlagCopied      = uint64(1) << 63
+	qcow2FlagCompressed  = uint64(1) << 62
+	qcow2FlagZero        = uint64(1)
+	qcow2OffsetMask      = uint64(0x00fffffffffffe00)
+
+	// qemu refuses images whose L1 table exceeds QCOW_MAX_L1_SIZE, 32MiB
+	// (block/qcow2.h), which with 64KiB clusters caps images at 2PiB
+	qcow2MaxL1Size    = 32 << 20
+	qcow2MaxL1Entries = qcow2MaxL1Size / 8
+)
+
+// Options configures a conversion.
+type Options struct {
+	// Sparse leaves all-zero clusters unallocated when writing qcow2.
+	Sparse bool
+}
+
+// Detect returns the format of the image read from r.
+// Anything without a qcow2 header is treated as raw.
+func Detect(r io.ReaderAt) (string, error) {
+	magic := make([]byte, len(qcow2Magic))
+	if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return "", fmt.Errorf("read image header: %w", err)
+	}
+	if string(magic) == qcow2Magic {
+		return FormatQCOW2, nil
+	}
+	return FormatRaw, nil
+}
+
+// Convert converts src to format and writes the result to a new temp file,
+// which the caller must close and remove. An image already in format is
+// passed through: Convert returns src itself, which the caller still owns.
+func Convert(src *os.File, format string, opts Options) (*os.File, int64, error) {
+	from, err := Detect(src)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var convert func(*os.File, *os.File, Options) error
+	switch {
+	case from == format:
+		info, err := src.Stat()
+		if err != nil {
+			return nil, 0, fmt.Errorf("stat image: %w", err)
+		}
+		return src, info.Size(), nil
+	case format == FormatQCOW2:
+		convert = rawToQCOW2
+	case format == FormatRaw:
+		convert = qcow2ToRaw
+	default:
+		return nil, 0, fmt.Errorf("unsupported disk image format: %s", format)
+	}
+
+	dst, err := os.CreateTemp("", "labctl-transform-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("create temp file: %w", err)
+	}
+
+	if err := convert(src, dst, opts); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(dst.Name())
+		return nil, 0, fmt.Errorf("convert %s to %s: %w", from, format, err)
+	}
+
+	info, err := dst.Stat()
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(dst.Name())
+		return nil, 0, fmt.Errorf("stat converted image: %w", err)
+	}
+
+	return dst, info.Size(), nil
+}
+
+// qcow2Layout places the metadata and data clusters of a qcow2 image:
+// header, L1 table, refcount table, refcount blocks, L2 tables, then data.
+type qcow2Layout struct {
+	virtualSize int64
+	l1Entries   int64
+	l1Offset    int64
+	rtOffset    int64
+	rtClusters  int64
+	rbOffset    int64
+	rbClusters  int64
+	l2Offset    int64
+	dataOffset  int64
+	total       int64
+
+	// l2Index maps an L1 index to its L2 table number, or -1 when the L2
+	// table would be empty. dataIndex does the same for guest clusters.
+	l2Index   []int64
+	dataIndex []int64
+}
+
+func newQCOW2Layout(virtualSize int64, allocated []bool) *qcow2Layout {
+	clusters := int64(len(allocated))
+	l := &qcow2Layout{
+		virtualSize: virtualSize,
+		l1Entries:   ceilDiv(clusters, qcow2L2Entries),
+		dataIndex:   make([]int64, clusters),
+	}
+	l.l2Index = make([]int64, l.l1Entries)
+
+	var l2Count, dataCount int64
+	for i := range l.l2Index {
+		l.l2Index[i] = -1
+	}
+	for c, alloc := range allocated {
+		l.dataIndex[c] = -1
+		if !alloc {
+			continue
+		}
+		l1 := int64(c) / qcow2L2Entries
+		if l.l2Index[l1] < 0 {
+			l.l2Index[l1] = l2Count
+			l2Count++
+		}
+		l.dataIndex[c] = dataCount
+		dataCount++
+	}
+
+	// The L1 table always has at least one cluster so an empty image is valid
+	l1Clusters := max(ceilDiv(l.l1Entries*8, qcow2ClusterSize), 1)
+
+	// Refcount blocks must cover every cluster, including themselves and
+	// the refcount table, so grow them until the layout is stable.
+	fixed := 1 + l1Clusters + l2Count + dataCount
+	var rt, rb int64 = 1, 1
+	for {
+		total := fixed + rt + rb
+		nextRB := ceilDiv(total, qcow2RefcountsPerBlk)
+		nextRT := max(ceilDiv(nextRB*8, qcow2ClusterSize), 1)
+		if nextRB == rb && nextRT == rt {
+			break
+		}
+		rb, rt = nextRB, nextRT
+	}
+
+	l.l1Offset = qcow2ClusterSize
+	l.rtOffset = l.l1Offset + l1Clusters*qcow2ClusterSize
+	l.rtClusters = rt
+	l.rbOffset = l.rtOffset + rt*qcow2ClusterSize
+	l.rbClusters = rb
+	l.l2Offset = l.rbOffset + rb*qcow2ClusterSize
+	l.dataOffset = l.l2Offset + l2Count*qcow2ClusterSize
+	l.total = fixed + rt + rb
+
+	return l
+}
+
+// rawToQCOW2 writes src as a qcow2 image. The output only depends on the
+// input bytes and options, so converting the same image twice produces
+// identical files with identical checksums.
+func rawToQCOW2(src, dst *os.File, opts Options) error {
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat source: %w", err)
+	}
+	size := info.Size()
+	clusters := ceilDiv(size, qcow2ClusterSize)
+	if ceilDiv(clusters, qcow2L2Entries) > qcow2MaxL1Entries {
+		return fmt.Errorf("image of %d bytes is too large", size)
+	}
+
+	// First pass: find the clusters that need to be stored
+	buf := make([]byte, qcow2ClusterSize)
+	allocated := make([]bool, clusters)
+	for c := range allocated {
+		if !opts.Sparse {
+			allocated[c] = true
+			continue
+		}
+		n, err := src.ReadAt(buf, int64(c)*qcow2ClusterSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read source: %w", err)
+		}
+		allocated[c] = !isZero(buf[:n])
+	}
+
+	l := newQCOW2Layout(size, allocated)
+
+	if _, err := dst.WriteAt(l.header(), 0); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := l.writeTables(dst); err != nil {
+		return err
+	}
+
+	// Second pass: copy the data clusters in guest order
+	for c, idx := range l.dataIndex {
+		if idx < 0 {
+			continue
+		}
+		// The final cluster is padded with zeros to a full cluster
+		clear(buf)
+		if _, err := src.ReadAt(buf, int64(c)*qcow2ClusterSize); err != nil && err != io.EOF {
+			return fmt.Errorf("read source: %w", err)
+		}
+		if _, err := dst.WriteAt(buf, l.dataOffset+idx*qcow2ClusterSize); err != nil {
+			return fmt.Errorf("write data: %w", err)
+		}
+	}
+
+	// Extend the file to cover every allocated cluster, even if trailing
+	// metadata clusters were never written
+	if err := dst.Truncate(l.total * qcow2ClusterSize); err != nil {
+		return fmt.Errorf("extend image: %w", err)
+	}
+
+	return nil
+}
+
+func (l *qcow2Layout) header() []byte {
+	h := make([]byte, qcow2HeaderLength)
+	copy(h, qcow2Magic)
+	binary.BigEndian.PutUint32(h[4:], qcow2Version)
+	binary.BigEndian.PutUint32(h[20:], qcow2ClusterBits)
+	binary.BigEndian.PutUint64(h[24:], uint64(l.virtualSize))
+	binary.BigEndian.PutUint32(h[36:], uint32(l.l1Entries))
+	binary.BigEndian.PutUint64(h[40:], uint64(l.l1Offset))
+	binary.BigEndian.PutUint64(h[48:], uint64(l.rtOffset))
+	binary.BigEndian.PutUint32(h[56:], uint32(l.rtClusters))
+	binary.BigEndian.PutUint32(h[96:], qcow2RefcountOrder)
+	binary.BigEndian.PutUint32(h[100:], qcow2HeaderLength)
+	return h
+}
+
+// writeTables writes the L1 table, refcount structures, and L2 tables.
+func (l *qcow2Layout) writeTables(dst io.WriterAt) error {
+	l1 := make([]byte, l.l1Entries*8)
+	for i, idx := range l.l2Index {
+		if idx >= 0 {
+			binary.BigEndian.PutUint64(l1[i*8:], uint64(l.l2Offset+idx*qcow2ClusterSize)|qcow2FlagCopied)
+		}
+	}
+	if _, err := dst.WriteAt(l1, l.l1Offset); err != nil {
+		return fmt.Errorf("write L1 table: %w", err)
+	}
+
+	rt := make([]byte, l.rbClusters*8)
+	for i := int64(0); i < l.rbClusters; i++ {
+		binary.BigEndian.PutUint64(rt[i*8:], uint64(l.rbOffset+i*qcow2ClusterSize))
+	}
+	if _, err := dst.WriteAt(rt, l.rtOffset); err != nil {
+		return fmt.Errorf("write refcount table: %w", err)
+	}
+
+	// Every cluster in the image is referenced exactly once
+	rb := make([]byte, l.total*qcow2RefcountBytes)
+	for i := int64(0); i < l.total; i++ {
+		binary.BigEndian.PutUint16(rb[i*qcow2RefcountBytes:], 1)
+	}
+	if _, err := dst.WriteAt(rb, l.rbOffset); err != nil {
+		return fmt.Errorf("write refcount blocks: %w", err)
+	}
+
+	l2 := make([]byte, qcow2ClusterSize)
+	for i, idx := range l.l2Index {
+		if idx < 0 {
+			continue
+		}
+		clear(l2)
+		first := int64(i) * qcow2L2Entries
+		for j := int64(0); j < qcow2L2Entries && first+j < int64(len(l.dataIndex)); j++ {
+			if d := l.dataIndex[first+j]; d >= 0 {
+				binary.BigEndian.PutUint64(l2[j*8:], uint64(l.dataOffset+d*qcow2ClusterSize)|qcow2FlagCopied)
+			}
+		}
+		if _, err := dst.WriteAt(l2, l.l2Offset+idx*qcow2ClusterSize); err != nil {
+			return fmt.Errorf("write L2 table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// qcow2ToRaw writes the guest contents of a qcow2 image to dst. Unallocated
+// and zero clusters are left as holes, so dst is sparse on disk.
+func qcow2ToRaw(src, dst *os.File, _ Options) error {
+	h := make([]byte, qcow2HeaderLength)
+	if _, err := src.ReadAt(h[:72], 0); err != nil {
+		return fmt.Errorf("read qcow2 header: %w", err)
+	}
+
+	version := binary.BigEndian.Uint32(h[4:])
+	if version != 2 && version != 3 {
+		return fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+	if binary.BigEndian.Uint64(h[8:]) != 0 {
+		return fmt.Errorf("qcow2 images with a backing file are not supported")
+	}
+	if binary.BigEndian.Uint32(h[32:]) != 0 {
+		return fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+	if version == 3 {
+		if _, err := src.ReadAt(h[72:], 72); err != nil {
+			return fmt.Errorf("read qcow2 header: %w", err)
+		}
+		// Bit 0 (dirty) only affects refcounts, which are not read here
+		if incompatible := binary.BigEndian.Uint64(h[72:]); incompatible&^1 != 0 {
+			return fmt.Errorf("unsupported qcow2 incompatible features %#x", incompatible)
+		}
+	}
+
+	clusterBits := binary.BigEndian.Uint32(h[20:])
+	if clusterBits < 9 || clusterBits > 21 {
+		return fmt.Errorf("invalid qcow2 cluster size 2^%d", clusterBits)
+	}
+	clusterSize := int64(1) << clusterBits
+	l2Entries := clusterSize / 8
+	size := int64(binary.BigEndian.Uint64(h[24:]))
+	l1Entries := int64(binary.BigEndian.Uint32(h[36:]))
+	l1Offset := int64(binary.BigEndian.Uint64(h[40:]))
+
+	if size < 0 || l1Entries > qcow2MaxL1Entries || l1Entries < ceilDiv(ceilDiv(size, clusterSize), l2Entries) {
+		return fmt.Errorf("invalid qcow2 L1 table size %d for %d bytes", l1Entries, size)
+	}
+
+	l1 := make([]byte, l1Entries*8)
+	if _, err := src.ReadAt(l1, l1Offset); err != nil {
+		return fmt.Errorf("read L1 table: %w", err)
+	}
+
+	if err := dst.Truncate(size); err != nil {
+		return fmt.Errorf("size raw image: %w", err)
+	}
+
+	l2 := make([]byte, clusterSize)
+	buf := make([]byte, clusterSize)
+	for i := int64(0); i < l1Entries && i*l2Entries*clusterSize < size; i++ {
+		l2Offset := int64(binary.BigEndian.Uint64(l1[i*8:]) & qcow2OffsetMask)
+		if l2Offset == 0 {
+			continue
+		}
+		if _, err := src.ReadAt(l2, l2Offset); err != nil {
+			return fmt.Errorf("read L2 table: %w", err)
+		}
+
+		for j := int64(0); j < l2Entries; j++ {
+			guest := (i*l2Entries + j) * clusterSize
+			if guest >= size {
+				break
+			}
+
+			entry := binary.BigEndian.Uint64(l2[j*8:])
+			if entry&qcow2FlagCompressed != 0 {
+				return fmt.Errorf("compressed qcow2 clusters are not supported")
+			}
+			offset := int64(entry & qcow2OffsetMask)
+			if offset == 0 || (version == 3 && entry&qcow2FlagZero != 0) {
+				continue
+			}
+
+			n := min(clusterSize, size-guest)
+			if _, err := src.ReadAt(buf[:n], offset); err != nil {
+				return fmt.Errorf("read data cluster: %w", err)
+			}
+			if isZero(buf[:n]) {
+				continue
+			}
+			if _, err := dst.WriteAt(buf[:n], guest); err != nil {
+				return fmt.Errorf("write raw image: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for len(b) > 0 {
+		n := min(len(b), len(zeroBlock))
+		if !bytes.Equal(b[:n], zeroBlock[:n]) {
+			return false
+		}
+		b = b[n:]
+	}
+	return true
+}
+
+var zeroBlock = make([]byte, 4096)
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,160 @@
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRawImage returns a raw image of three and a bit clusters where the
+// second cluster is all zeros and the last cluster is partial.
+func testRawImage() []byte {
+	img := make([]byte, 3*qcow2ClusterSize+1000)
+	for i := 0; i < qcow2ClusterSize; i++ {
+		img[i] = byte(i % 251)
+	}
+	copy(img[2*qcow2ClusterSize:], "third cluster")
+	copy(img[3*qcow2ClusterSize:], "tail")
+	return img
+}
+
+func writeTemp(t *testing.T, data []byte) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "image-*")
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func convert(t *testing.T, src *os.File, format string, opts Options) (*os.File, []byte) {
+	t.Helper()
+	out, size, err := Convert(src, format, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+	})
+
+	_, err = out.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err := io.ReadAll(out)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	return out, data
+}
+
+func TestConvert(t *testing.T) {
+	raw := testRawImage()
+
+	t.Run("raw to qcow2 and back", func(t *testing.T) {
+		qcow2File, qcow2 := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+
+		assert.Equal(t, qcow2Magic, string(qcow2[:4]))
+		assert.Equal(t, uint32(3), binary.BigEndian.Uint32(qcow2[4:]))
+		assert.Equal(t, uint64(len(raw)), binary.BigEndian.Uint64(qcow2[24:]))
+
+		format, err := Detect(qcow2File)
+		require.NoError(t, err)
+		assert.Equal(t, FormatQCOW2, format)
+
+		_, back := convert(t, qcow2File, FormatRaw, Options{})
+		assert.Equal(t, raw, back)
+	})
+
+	t.Run("sparse skips zero clusters", func(t *testing.T) {
+		_, full := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+		sparseFile, sparse := convert(t, writeTemp(t, raw), FormatQCOW2, Options{Sparse: true})
+
+		assert.Equal(t, len(full)-qcow2ClusterSize, len(sparse))
+
+		_, back := convert(t, sparseFile, FormatRaw, Options{})
+		assert.Equal(t, raw, back)
+	})
+
+	t.Run("output is deterministic", func(t *testing.T) {
+		_, first := convert(t, writeTemp(t, raw), FormatQCOW2, Options{Sparse: true})
+		_, second := convert(t, writeTemp(t, raw), FormatQCOW2, Options{Sparse: true})
+
+		assert.True(t, bytes.Equal(first, second))
+	})
+
+	t.Run("empty image", func(t *testing.T) {
+		qcow2File, _ := convert(t, writeTemp(t, nil), FormatQCOW2, Options{})
+
+		_, back := convert(t, qcow2File, FormatRaw, Options{})
+		assert.Empty(t, back)
+	})
+
+	t.Run("refcounts cover every cluster", func(t *testing.T) {
+		_, qcow2 := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+
+		rtOffset := binary.BigEndian.Uint64(qcow2[48:])
+		rbOffset := binary.BigEndian.Uint64(qcow2[rtOffset:])
+		clusters := len(qcow2) / qcow2ClusterSize
+		for i := 0; i < clusters; i++ {
+			assert.Equal(t, uint16(1), binary.BigEndian.Uint16(qcow2[int(rbOffset)+i*2:]), "cluster %d", i)
+		}
+		assert.Equal(t, uint16(0), binary.BigEndian.Uint16(qcow2[int(rbOffset)+clusters*2:]))
+	})
+
+	t.Run("already in target format is passed through", func(t *testing.T) {
+		src := writeTemp(t, raw)
+		out, size, err := Convert(src, FormatRaw, Options{})
+		require.NoError(t, err)
+		assert.Same(t, src, out)
+		assert.Equal(t, int64(len(raw)), size)
+
+		qcow2File, qcow2 := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+		out, size, err = Convert(qcow2File, FormatQCOW2, Options{})
+		require.NoError(t, err)
+		assert.Same(t, qcow2File, out)
+		assert.Equal(t, int64(len(qcow2)), size)
+	})
+
+	t.Run("L1 table larger than qemu allows", func(t *testing.T) {
+		// QCOW_MAX_L1_SIZE in qemu is 32MiB of 8-byte entries
+		assert.Equal(t, 4*1024*1024, qcow2MaxL1Entries)
+
+		_, qcow2 := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+		binary.BigEndian.PutUint64(qcow2[24:], uint64(qcow2MaxL1Entries+1)*qcow2L2Entries*qcow2ClusterSize)
+		binary.BigEndian.PutUint32(qcow2[36:], uint32(qcow2MaxL1Entries+1))
+
+		_, _, err := Convert(writeTemp(t, qcow2), FormatRaw, Options{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid qcow2 L1 table size 4194305")
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, _, err := Convert(writeTemp(t, raw), "vmdk", Options{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported disk image format")
+	})
+
+	t.Run("qcow2 with backing file", func(t *testing.T) {
+		_, qcow2 := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+		binary.BigEndian.PutUint64(qcow2[8:], 4096)
+
+		_, _, err := Convert(writeTemp(t, qcow2), FormatRaw, Options{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "backing file")
+	})
+
+	t.Run("compressed clusters", func(t *testing.T) {
+		_, qcow2 := convert(t, writeTemp(t, raw), FormatQCOW2, Options{})
+		l1Offset := binary.BigEndian.Uint64(qcow2[40:])
+		l2Offset := binary.BigEndian.Uint64(qcow2[l1Offset:]) & qcow2OffsetMask
+		entry := binary.BigEndian.Uint64(qcow2[l2Offset:])
+		binary.BigEndian.PutUint64(qcow2[l2Offset:], entry|qcow2FlagCompressed)
+
+		_, _, err := Convert(writeTemp(t, qcow2), FormatRaw, Options{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "compressed qcow2 clusters are not supported")
+	})
+}
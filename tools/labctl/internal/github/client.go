@@ -0,0 +1,347 @@
+// Package github resolves and downloads GitHub release assets for the image pipeline.
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// DefaultBaseURL is the GitHub REST API endpoint.
+const DefaultBaseURL = "https://api.github.com"
+
+// EnvToken is the environment variable holding the GitHub API token.
+const EnvToken = "GITHUB_TOKEN" //nolint:gosec // G101: This is the name of the environment variable
+
+// TagLatest selects the most recent release, skipping prereleases unless
+// they are asked for.
+const TagLatest = "latest"
+
+const (
+	// maxRetries bounds how many times a rate-limited request is retried.
+	maxRetries = 3
+
+	// maxWait is the longest a single rate-limit backoff may sleep. Longer
+	// resets fail fast so CI does not hang for an hour.
+	maxWait = 5 * time.Minute
+
+	// maxReleasePages bounds how many pages of releases are searched for a tag pattern.
+	maxReleasePages = 10
+)
+
+// browserDownloadPattern matches the path of a release asset's browser
+// download URL, capturing the repository, tag and asset name.
+var browserDownloadPattern = regexp.MustCompile(`^/([^/]+/[^/]+)/releases/download/(.+)/([^/]+)$`)
+
+// HTTPClient defines the HTTP operations used by Client.
+// This interface enables mocking for unit tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Asset is a resolved release asset.
+type Asset struct {
+	Name               string `json:"name"`
+	URL                string `json:"url"` // API URL, required for private assets
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Size               int64  `json:"size"`
+	Digest             string `json:"digest"` // "sha256:..." when GitHub has computed it
+
+	// Tag is the release tag the asset was resolved from.
+	Tag string `json:"-"`
+}
+
+type release struct {
+	TagName    string  `json:"tag_name"`
+	Draft      bool    `json:"draft"`
+	Prerelease bool    `json:"prerelease"`
+	Assets     []Asset `json:"assets"`
+}
+
+// Client talks to the GitHub REST API.
+type Client struct {
+	http    HTTPClient
+	token   string
+	baseURL string
+	sleep   func(context.Context, time.Duration) error
+	now     func() time.Time
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL overrides the API endpoint (for GitHub Enterprise or tests).
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// NewClient creates a GitHub client. An empty token uses unauthenticated access.
+func NewClient(httpClient HTTPClient, token string, opts ...Option) *Client {
+	c := &Client{
+		http:    httpClient,
+		token:   token,
+		baseURL: DefaultBaseURL,
+		sleep:   sleepContext,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// TokenFromEnv returns the token from GITHUB_TOKEN, or an empty string.
+func TokenFromEnv() string {
+	return os.Getenv(EnvToken)
+}
+
+// HasToken reports whether the client authenticates its requests.
+func (c *Client) HasToken() bool {
+	return c.token != ""
+}
+
+// ResolveAsset finds the asset whose name matches the glob assetPattern in
+// the release selected by tag ("latest" or an exact tag) or, when tagPattern
+// is set, the newest release whose tag matches that regex. "latest" and
+// tagPattern skip prereleases unless prerelease is set; an exact tag selects
+// its release either way.
+func (c *Client) ResolveAsset(ctx context.Context, repo, tag, tagPattern string, prerelease bool, assetPattern string) (*Asset, error) {
+	rel, err := c.findRelease(ctx, repo, tag, tagPattern, prerelease)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []Asset
+	for _, a := range rel.Assets {
+		ok, err := path.Match(assetPattern, a.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid asset pattern %q: %w", assetPattern, err)
+		}
+		if ok {
+			matched = append(matched, a)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("release %s of %s has no asset matching %q", rel.TagName, repo, assetPattern)
+	case 1:
+		asset := matched[0]
+		asset.Tag = rel.TagName
+		return &asset, nil
+	default:
+		return nil, fmt.Errorf("release %s of %s has %d assets matching %q, expected exactly one", rel.TagName, repo, len(matched), assetPattern)
+	}
+}
+
+// Download opens an asset by its browser download URL. Authenticated clients
+// look the asset up by release tag and name and fetch it through the API
+// instead, so that private release assets can be downloaded; other URLs are
+// fetched as they are. The caller must close the returned reader.
+func (c *Client) Download(ctx context.Context, url string) (io.ReadCloser, error) {
+	if c.HasToken() {
+		apiURL, err := c.assetAPIURL(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		url = apiURL
+	}
+
+	resp, err := c.get(ctx, url, "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// assetAPIURL returns the API URL of the asset at a browser download URL, or
+// downloadURL itself when it is not one.
+func (c *Client) assetAPIURL(ctx context.Context, downloadURL string) (string, error) {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", fmt.Errorf("parse asset URL: %w", err)
+	}
+	m := browserDownloadPattern.FindStringSubmatch(u.Path)
+	if m == nil {
+		return downloadURL, nil
+	}
+	repo, tag, name := m[1], m[2], m[3]
+
+	rel, err := c.findRelease(ctx, repo, tag, "", true)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range rel.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s of %s has no asset named %q", tag, repo, name)
+}
+
+func (c *Client) findRelease(ctx context.Context, repo, tag, tagPattern string, prerelease bool) (*release, error) {
+	switch {
+	case tagPattern != "":
+		re, err := regexp.Compile(tagPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %w", tagPattern, err)
+		}
+		match := func(r *release) bool { return re.MatchString(r.TagName) }
+		return c.searchReleases(ctx, repo, match, prerelease, fmt.Sprintf("a tag matching %q", tagPattern))
+	case tag == TagLatest && prerelease:
+		// The latest endpoint never returns prereleases
+		match := func(*release) bool { return true }
+		return c.searchReleases(ctx, repo, match, prerelease, "been published")
+	}
+
+	u := fmt.Sprintf("%s/repos/%s/releases/tags/%s", c.baseURL, repo, url.PathEscape(tag))
+	if tag == TagLatest {
+		u = fmt.Sprintf("%s/repos/%s/releases/latest", c.baseURL, repo)
+	}
+
+	var rel release
+	if err := c.getJSON(ctx, u, &rel); err != nil {
+		return nil, fmt.Errorf("get release %s of %s: %w", tag, repo, err)
+	}
+	return &rel, nil
+}
+
+// searchReleases returns the newest release that is not a draft, is not a
+// prerelease unless prerelease is set, and satisfies match. want describes
+// match for the error when no release does.
+func (c *Client) searchReleases(ctx context.Context, repo string, match func(*release) bool, prerelease bool, want string) (*release, error) {
+	// Releases are listed newest first
+	for page := 1; page <= maxReleasePages; page++ {
+		var releases []release
+		u := fmt.Sprintf("%s/repos/%s/releases?per_page=100&page=%d", c.baseURL, repo, page)
+		if err := c.getJSON(ctx, u, &releases); err != nil {
+			return nil, fmt.Errorf("list releases of %s: %w", repo, err)
+		}
+		for i := range releases {
+			rel := &releases[i]
+			if !rel.Draft && (prerelease || !rel.Prerelease) && match(rel) {
+				return rel, nil
+			}
+		}
+		if len(releases) < 100 {
+			break
+		}
+	}
+
+	if !prerelease {
+		return nil, fmt.Errorf("no release of %s has %s, prereleases excluded", repo, want)
+	}
+	return nil, fmt.Errorf("no release of %s has %s", repo, want)
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v any) error {
+	resp, err := c.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("parse response: %w", err)
+	}
+	return nil
+}
+
+// get issues a GET request, backing off and retrying while GitHub reports a
+// primary or secondary rate limit.
+func (c *Client) get(ctx context.Context, url, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("User-Agent", "labctl/1.0")
+		req.Header.Set("Accept", accept)
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP request: %w", err)
+		}
+
+		wait, limited := c.rateLimitWait(resp, attempt)
+		if !limited {
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+
+		if attempt >= maxRetries {
+			return nil, fmt.Errorf("GitHub rate limit exceeded after %d retries", maxRetries)
+		}
+		if wait > maxWait {
+			return nil, fmt.Errorf("GitHub rate limit exceeded, resets in %s (set %s to raise the limit)", wait.Round(time.Second), EnvToken)
+		}
+		if err := c.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rateLimitWait reports whether resp is a rate-limit response and how long to
+// wait before retrying. Retry-After wins, then X-RateLimit-Reset, then an
+// exponential backoff starting at one second.
+func (c *Client) rateLimitWait(resp *http.Response, attempt int) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			wait := time.Unix(reset, 0).Sub(c.now())
+			if wait < 0 {
+				wait = 0
+			}
+			return wait, true
+		}
+		return time.Second << attempt, true
+	}
+
+	// A 429 without headers is still a rate limit; a bare 403 is a permissions error
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return time.Second << attempt, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
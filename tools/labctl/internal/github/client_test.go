@@ -0,0 +1,363 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const releaseJSON = `{
+  "tag_name": "2025.12.20-0020-rolling",
+  "assets": [
+    {
+      "name": "vyos-2025.12.20-0020-rolling-generic-amd64.iso",
+      "url": "%[1]s/repos/vyos/vyos-nightly-build/releases/assets/1",
+      "browser_download_url": "%[1]s/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso",
+      "size": 4,
+      "digest": "sha256:7f9eb1d6d9aacbd8fb684bb384cf2251d987097993fe7dbead8653ffbde31d04"
+    },
+    {
+      "name": "vyos-2025.12.20-0020-rolling-generic-amd64.iso.minisig",
+      "url": "%[1]s/repos/vyos/vyos-nightly-build/releases/assets/2",
+      "browser_download_url": "%[1]s/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso.minisig",
+      "size": 1
+    }
+  ]
+}`
+
+// newTestClient returns a client pointed at server that never actually sleeps.
+func newTestClient(server *httptest.Server, token string) (*Client, *[]time.Duration) {
+	var slept []time.Duration
+	c := NewClient(server.Client(), token, WithBaseURL(server.URL))
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return c, &slept
+}
+
+func TestClient_ResolveAsset(t *testing.T) {
+	t.Run("exact tag", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/repos/vyos/vyos-nightly-build/releases/tags/2025.12.20-0020-rolling", r.URL.Path)
+			assert.Equal(t, "application/vnd.github+json", r.Header.Get("Accept"))
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		asset, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", "2025.12.20-0020-rolling", "", false, "*-generic-amd64.iso")
+		require.NoError(t, err)
+		assert.Equal(t, "vyos-2025.12.20-0020-rolling-generic-amd64.iso", asset.Name)
+		assert.Equal(t, "2025.12.20-0020-rolling", asset.Tag)
+		assert.Equal(t, "sha256:7f9eb1d6d9aacbd8fb684bb384cf2251d987097993fe7dbead8653ffbde31d04", asset.Digest)
+		assert.Equal(t, server.URL+"/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso", asset.BrowserDownloadURL)
+	})
+
+	t.Run("latest release", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/repos/vyos/vyos-nightly-build/releases/latest", r.URL.Path)
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		asset, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", TagLatest, "", false, "*.iso")
+		require.NoError(t, err)
+		assert.Equal(t, "vyos-2025.12.20-0020-rolling-generic-amd64.iso", asset.Name)
+	})
+
+	t.Run("tag pattern picks newest match", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/repos/org/repo/releases", r.URL.Path)
+			_, _ = w.Write([]byte(`[
+  {"tag_name": "v2.0.0-rc1", "assets": [{"name": "image.iso"}]},
+  {"tag_name": "v1.9.2", "draft": true, "assets": [{"name": "image.iso"}]},
+  {"tag_name": "v1.9.1", "assets": [{"name": "image.iso", "browser_download_url": "https://example.com/v1.9.1/image.iso"}]}
+]`))
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		asset, err := client.ResolveAsset(context.Background(), "org/repo", "", `^v1\.9\.\d+$`, false, "image.iso")
+		require.NoError(t, err)
+		assert.Equal(t, "v1.9.1", asset.Tag)
+	})
+
+	t.Run("tag pattern and latest skip prereleases unless asked", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/repos/org/repo/releases", r.URL.Path)
+			_, _ = w.Write([]byte(`[
+  {"tag_name": "v2.0.0-rc1", "prerelease": true, "assets": [{"name": "image.iso"}]},
+  {"tag_name": "v1.9.1", "assets": [{"name": "image.iso"}]}
+]`))
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		asset, err := client.ResolveAsset(context.Background(), "org/repo", "", `^v`, false, "image.iso")
+		require.NoError(t, err)
+		assert.Equal(t, "v1.9.1", asset.Tag)
+
+		asset, err = client.ResolveAsset(context.Background(), "org/repo", "", `^v`, true, "image.iso")
+		require.NoError(t, err)
+		assert.Equal(t, "v2.0.0-rc1", asset.Tag)
+
+		asset, err = client.ResolveAsset(context.Background(), "org/repo", TagLatest, "", true, "image.iso")
+		require.NoError(t, err)
+		assert.Equal(t, "v2.0.0-rc1", asset.Tag)
+
+		_, err = client.ResolveAsset(context.Background(), "org/repo", "", `-rc`, false, "image.iso")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `no release of org/repo has a tag matching "-rc", prereleases excluded`)
+	})
+
+	t.Run("exact tag is escaped", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/repos/org/repo/releases/tags/release%2Fv1%3F", r.URL.EscapedPath())
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		_, err := client.ResolveAsset(context.Background(), "org/repo", "release/v1?", "", false, "*-generic-amd64.iso")
+		require.NoError(t, err)
+	})
+
+	t.Run("no matching asset", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		_, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", TagLatest, "", false, "*.qcow2")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no asset matching")
+	})
+
+	t.Run("ambiguous asset pattern", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		_, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", TagLatest, "", false, "vyos-*")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected exactly one")
+	})
+
+	t.Run("sends token", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "secret")
+
+		_, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", TagLatest, "", false, "*.iso")
+		require.NoError(t, err)
+	})
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	t.Run("waits for reset and retries", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		calls := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls++
+			if calls == 1 {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, slept := newTestClient(server, "")
+		client.now = func() time.Time { return now }
+
+		_, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", TagLatest, "", false, "*.iso")
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, []time.Duration{30 * time.Second}, *slept)
+	})
+
+	t.Run("honors Retry-After on secondary limits", func(t *testing.T) {
+		calls := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls++
+			if calls == 1 {
+				w.Header().Set("Retry-After", "7")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+		}))
+		defer server.Close()
+
+		client, slept := newTestClient(server, "")
+
+		_, err := client.ResolveAsset(context.Background(), "vyos/vyos-nightly-build", TagLatest, "", false, "*.iso")
+		require.NoError(t, err)
+		assert.Equal(t, []time.Duration{7 * time.Second}, *slept)
+	})
+
+	t.Run("fails fast when reset is far away", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(time.Hour).Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client, slept := newTestClient(server, "")
+		client.now = func() time.Time { return now }
+
+		_, err := client.ResolveAsset(context.Background(), "org/repo", TagLatest, "", false, "*.iso")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rate limit exceeded")
+		assert.Contains(t, err.Error(), EnvToken)
+		assert.Empty(t, *slept)
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		client, slept := newTestClient(server, "")
+
+		_, err := client.ResolveAsset(context.Background(), "org/repo", TagLatest, "", false, "*.iso")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "after 3 retries")
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, *slept)
+	})
+
+	t.Run("plain 403 is not retried", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		_, err := client.ResolveAsset(context.Background(), "org/repo", TagLatest, "", false, "*.iso")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "HTTP 403")
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestClient_Download(t *testing.T) {
+	browserPath := "/vyos/vyos-nightly-build/releases/download/2025.12.20-0020-rolling/vyos-2025.12.20-0020-rolling-generic-amd64.iso"
+
+	t.Run("browser download URL without a token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, browserPath, r.URL.Path)
+			assert.Empty(t, r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte("iso bytes"))
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		body, err := client.Download(context.Background(), server.URL+browserPath)
+		require.NoError(t, err)
+		defer func() { _ = body.Close() }()
+
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, "iso bytes", string(data))
+	})
+
+	t.Run("browser download URL through the API with a token", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			switch r.URL.Path {
+			case "/repos/vyos/vyos-nightly-build/releases/tags/2025.12.20-0020-rolling":
+				_, _ = fmt.Fprintf(w, releaseJSON, server.URL)
+			case "/repos/vyos/vyos-nightly-build/releases/assets/1":
+				assert.Equal(t, "application/octet-stream", r.Header.Get("Accept"))
+				_, _ = w.Write([]byte("iso bytes"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "secret")
+
+		body, err := client.Download(context.Background(), server.URL+browserPath)
+		require.NoError(t, err)
+		defer func() { _ = body.Close() }()
+
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, "iso bytes", string(data))
+	})
+
+	t.Run("API URL with a token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/octet-stream", r.Header.Get("Accept"))
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte("iso bytes"))
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "secret")
+
+		body, err := client.Download(context.Background(), server.URL+"/repos/org/repo/releases/assets/1")
+		require.NoError(t, err)
+		defer func() { _ = body.Close() }()
+
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, "iso bytes", string(data))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		client, _ := newTestClient(server, "")
+
+		_, err := client.Download(context.Background(), server.URL+"/missing")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "HTTP 404")
+	})
+}
@@ -0,0 +1,152 @@
+// Package lock reads and writes images.lock.yaml, which records the sources
+// that sync resolved for each image so that later runs can be reproduced.
+package lock
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// APIVersion is the lockfile format version.
+	APIVersion = "images.lab.gilman.io/v1beta1"
+
+	// Kind identifies lockfiles, so that directory manifests skip them.
+	Kind = "ImageLock"
+
+	// FileName is the lockfile name, next to the manifest.
+	FileName = "images.lock.yaml"
+)
+
+// header is written above the lockfile contents.
+const header = "# Written by labctl images sync. Do not edit; run sync without --frozen to update.\n"
+
+// Lockfile pins the resolved source of each synced image.
+type Lockfile struct {
+	APIVersion string  `yaml:"apiVersion"`
+	Kind       string  `yaml:"kind"`
+	Images     []Entry `yaml:"images"`
+}
+
+// Entry is the resolved source of one image.
+type Entry struct {
+	Name       string    `yaml:"name"`
+	URL        string    `yaml:"url"`
+	Checksum   string    `yaml:"checksum"`
+	Validation string    `yaml:"validation,omitempty"` // validation.expected, if set
+	Size       int64     `yaml:"size,omitempty"`       // Source size in bytes; 0 if not yet downloaded
+	ResolvedAt time.Time `yaml:"resolvedAt"`
+}
+
+// Pins reports whether e pins the same source as other.
+func (e Entry) Pins(other Entry) bool {
+	return e.URL == other.URL && e.Checksum == other.Checksum && e.Validation == other.Validation
+}
+
+// New returns an empty lockfile.
+func New() *Lockfile {
+	return &Lockfile{APIVersion: APIVersion, Kind: Kind}
+}
+
+// DefaultPath returns the lockfile path for a manifest file or directory:
+// images.lock.yaml in the same directory.
+func DefaultPath(manifestPath string) string {
+	if info, err := os.Stat(manifestPath); err == nil && info.IsDir() {
+		return filepath.Join(manifestPath, FileName)
+	}
+	return filepath.Join(filepath.Dir(manifestPath), FileName)
+}
+
+// Load reads a lockfile. A missing file returns an empty lockfile.
+func Load(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: Path is provided by user or derived from the manifest path
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read lockfile: %w", err)
+	}
+
+	var l Lockfile
+	if err := yaml.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("parse lockfile: %w", err)
+	}
+	if l.APIVersion != APIVersion {
+		return nil, fmt.Errorf("%s: unsupported apiVersion %q, expected %s", path, l.APIVersion, APIVersion)
+	}
+	if l.Kind != Kind {
+		return nil, fmt.Errorf("%s: unsupported kind %q, expected %s", path, l.Kind, Kind)
+	}
+	return &l, nil
+}
+
+// Get returns the entry for the named image.
+func (l *Lockfile) Get(name string) (Entry, bool) {
+	for _, e := range l.Images {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Set adds or replaces the entry for e.Name. An entry that pins the same
+// source keeps its resolution time, and its size when e has none, so that
+// re-syncing an unchanged image leaves the lockfile unchanged.
+func (l *Lockfile) Set(e Entry) {
+	for i, existing := range l.Images {
+		if existing.Name != e.Name {
+			continue
+		}
+		if existing.Pins(e) {
+			e.ResolvedAt = existing.ResolvedAt
+			if e.Size == 0 {
+				e.Size = existing.Size
+			}
+		}
+		l.Images[i] = e
+		return
+	}
+
+	l.Images = append(l.Images, e)
+	sort.Slice(l.Images, func(i, j int) bool { return l.Images[i].Name < l.Images[j].Name })
+}
+
+// Retain removes the entries for images not in names.
+func (l *Lockfile) Retain(names []string) {
+	l.Images = slices.DeleteFunc(l.Images, func(e Entry) bool {
+		return !slices.Contains(names, e.Name)
+	})
+}
+
+// Save writes the lockfile to path if its contents changed, and reports
+// whether it did.
+func (l *Lockfile) Save(path string) (bool, error) {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(l); err != nil {
+		return false, fmt.Errorf("encode lockfile: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return false, fmt.Errorf("encode lockfile: %w", err)
+	}
+
+	existing, err := os.ReadFile(path) //nolint:gosec // G304: Path is provided by user or derived from the manifest path
+	if err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return false, nil
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil { //nolint:gosec // G306: Lockfile is committed alongside the manifest
+		return false, fmt.Errorf("write lockfile: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,139 @@
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entry(name, url string) Entry {
+	return Entry{
+		Name:       name,
+		URL:        url,
+		Checksum:   "sha256:abc",
+		Size:       1024,
+		ResolvedAt: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "images.yaml")
+
+	assert.Equal(t, filepath.Join(dir, FileName), DefaultPath(manifest))
+	assert.Equal(t, filepath.Join(dir, FileName), DefaultPath(dir))
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("missing file is an empty lockfile", func(t *testing.T) {
+		l, err := Load(filepath.Join(t.TempDir(), FileName))
+		require.NoError(t, err)
+		assert.Equal(t, New(), l)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), FileName)
+		l := New()
+		l.Set(entry("talos", "https://example.com/talos.iso"))
+
+		changed, err := l.Save(path)
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		loaded, err := Load(path)
+		require.NoError(t, err)
+		assert.Equal(t, l, loaded)
+	})
+
+	t.Run("wrong kind", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), FileName)
+		require.NoError(t, os.WriteFile(path, []byte("apiVersion: "+APIVersion+"\nkind: ImageManifest\n"), 0o600))
+
+		_, err := Load(path)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unsupported kind "ImageManifest"`)
+	})
+}
+
+func TestLockfile_Set(t *testing.T) {
+	t.Run("keeps entries sorted by name", func(t *testing.T) {
+		l := New()
+		l.Set(entry("vyos", "https://example.com/vyos.iso"))
+		l.Set(entry("talos", "https://example.com/talos.iso"))
+
+		require.Len(t, l.Images, 2)
+		assert.Equal(t, "talos", l.Images[0].Name)
+		assert.Equal(t, "vyos", l.Images[1].Name)
+	})
+
+	t.Run("same source keeps resolution time and size", func(t *testing.T) {
+		l := New()
+		original := entry("talos", "https://example.com/talos.iso")
+		l.Set(original)
+
+		again := original
+		again.Size = 0
+		again.ResolvedAt = original.ResolvedAt.Add(time.Hour)
+		l.Set(again)
+
+		got, ok := l.Get("talos")
+		require.True(t, ok)
+		assert.Equal(t, original, got)
+	})
+
+	t.Run("new source replaces the entry", func(t *testing.T) {
+		l := New()
+		l.Set(entry("talos", "https://example.com/talos-1.9.0.iso"))
+
+		updated := entry("talos", "https://example.com/talos-1.9.1.iso")
+		updated.ResolvedAt = updated.ResolvedAt.Add(time.Hour)
+		l.Set(updated)
+
+		got, ok := l.Get("talos")
+		require.True(t, ok)
+		assert.Equal(t, updated, got)
+	})
+}
+
+func TestLockfile_Retain(t *testing.T) {
+	l := New()
+	l.Set(entry("talos", "https://example.com/talos.iso"))
+	l.Set(entry("vyos", "https://example.com/vyos.iso"))
+
+	l.Retain([]string{"vyos"})
+
+	_, ok := l.Get("talos")
+	assert.False(t, ok)
+	_, ok = l.Get("vyos")
+	assert.True(t, ok)
+}
+
+func TestLockfile_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	l := New()
+	l.Set(entry("talos", "https://example.com/talos.iso"))
+
+	changed, err := l.Save(path)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	data, err := os.ReadFile(path) //nolint:gosec // G304: Test file
+	require.NoError(t, err)
+	assert.Equal(t, header+`apiVersion: images.lab.gilman.io/v1beta1
+kind: ImageLock
+images:
+  - name: talos
+    url: https://example.com/talos.iso
+    checksum: sha256:abc
+    size: 1024
+    resolvedAt: 2025-01-01T12:00:00Z
+`, string(data))
+
+	changed, err = l.Save(path)
+	require.NoError(t, err)
+	assert.False(t, changed, "unchanged lockfile must not be rewritten")
+}
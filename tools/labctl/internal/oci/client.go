@@ -0,0 +1,531 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Media types used when pushing image artifacts.
+const (
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeEmpty    = "application/vnd.oci.empty.v1+json"
+	MediaTypeLayer    = "application/octet-stream"
+
+	// ArtifactType identifies disk images pushed by labctl.
+	ArtifactType = "application/vnd.gilmanlab.image.v1"
+
+	// AnnotationTitle carries the file name of a layer.
+	AnnotationTitle = "org.opencontainers.image.title"
+)
+
+// emptyConfig is the canonical empty JSON config blob for artifacts.
+var emptyConfig = []byte("{}")
+
+// Environment variable names for registry credentials.
+const (
+	EnvUsername = "OCI_USERNAME"
+	EnvPassword = "OCI_PASSWORD" //nolint:gosec // G101: This is the name of the environment variable
+	EnvToken    = "OCI_TOKEN"    //nolint:gosec // G101: This is the name of the environment variable
+)
+
+// HTTPClient defines the HTTP operations used by Client.
+// This interface enables mocking for unit tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Credentials authenticate against a registry. An empty value means anonymous
+// access. Token is used as a pre-issued bearer token; Username and Password
+// are exchanged for a bearer token or sent as basic auth, depending on the
+// challenge returned by the registry.
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// CredentialsFromEnv reads registry credentials from OCI_USERNAME,
+// OCI_PASSWORD and OCI_TOKEN. Missing variables yield anonymous access.
+func CredentialsFromEnv() Credentials {
+	return Credentials{
+		Username: os.Getenv(EnvUsername),
+		Password: os.Getenv(EnvPassword),
+		Token:    os.Getenv(EnvToken),
+	}
+}
+
+// Descriptor describes a blob referenced from a manifest.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Client talks to an OCI distribution registry over HTTPS.
+type Client struct {
+	http  HTTPClient
+	creds Credentials
+
+	mu     sync.Mutex
+	tokens map[string]string // keyed by registry and repository
+}
+
+// NewClient creates a registry client using the given HTTP client and credentials.
+func NewClient(httpClient HTTPClient, creds Credentials) *Client {
+	return &Client{
+		http:   httpClient,
+		creds:  creds,
+		tokens: make(map[string]string),
+	}
+}
+
+// FetchBlob downloads the blob identified by ref.Digest.
+// The caller must close the returned reader.
+func (c *Client) FetchBlob(ctx context.Context, ref Reference) (io.ReadCloser, int64, error) {
+	if ref.Digest == "" {
+		return nil, 0, fmt.Errorf("fetch blob %s: digest is required", ref)
+	}
+
+	resp, err := c.do(ctx, ref, http.MethodGet, blobURL(ref, ref.Digest), nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch blob %s: %w", ref, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, 0, fmt.Errorf("fetch blob %s: HTTP %d: %s", ref, resp.StatusCode, resp.Status)
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
+
+// BlobExists reports whether the registry has the blob with the given digest.
+func (c *Client) BlobExists(ctx context.Context, ref Reference, digest string) (bool, error) {
+	return c.exists(ctx, ref, blobURL(ref, digest))
+}
+
+// ManifestDigest returns the digest of the manifest that ref's tag or digest
+// points at, or "" if the registry has none.
+func (c *Client) ManifestDigest(ctx context.Context, ref Reference) (string, error) {
+	u := manifestURL(ref, manifestTarget(ref))
+	header := http.Header{"Accept": []string{MediaTypeManifest}}
+	resp, err := c.do(ctx, ref, http.MethodHead, u, header, nil)
+	if err != nil {
+		return "", fmt.Errorf("check %s: %w", u, err)
+	}
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("check %s: HTTP %d: %s", u, resp.StatusCode, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	// The digest header is optional, so hash the manifest itself
+	resp, err = c.do(ctx, ref, http.MethodGet, u, header, nil)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", u, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s: HTTP %d: %s", u, resp.StatusCode, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", u, err)
+	}
+	return digestBytes(data), nil
+}
+
+func (c *Client) exists(ctx context.Context, ref Reference, u string) (bool, error) {
+	header := http.Header{"Accept": []string{MediaTypeManifest}}
+	resp, err := c.do(ctx, ref, http.MethodHead, u, header, nil)
+	if err != nil {
+		return false, fmt.Errorf("check %s: %w", u, err)
+	}
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("check %s: HTTP %d: %s", u, resp.StatusCode, resp.Status)
+	}
+}
+
+// Layer is a file pushed as one layer of an artifact.
+type Layer struct {
+	File  io.ReadSeeker
+	Size  int64
+	Title string // File name, unique within the artifact
+}
+
+// PushArtifact pushes the layers as one OCI artifact tagged with ref.Tag, so
+// a multi-file image is a single manifest rather than one tag per file.
+// Returns the digest of the pushed manifest.
+func (c *Client) PushArtifact(ctx context.Context, ref Reference, layers []Layer) (string, error) {
+	if ref.Tag == "" {
+		return "", fmt.Errorf("push %s: tag is required", ref)
+	}
+	if len(layers) == 0 {
+		return "", fmt.Errorf("push %s: no layers", ref)
+	}
+
+	titles := make(map[string]bool)
+	for _, layer := range layers {
+		if titles[layer.Title] {
+			return "", fmt.Errorf("push %s: duplicate layer title %q", ref, layer.Title)
+		}
+		titles[layer.Title] = true
+	}
+
+	descriptors := make([]Descriptor, 0, len(layers))
+	for _, layer := range layers {
+		layerDigest, err := digestReader(layer.File)
+		if err != nil {
+			return "", fmt.Errorf("push %s: compute digest of %s: %w", ref, layer.Title, err)
+		}
+		if err := c.pushBlob(ctx, ref, layerDigest, layer.File); err != nil {
+			return "", fmt.Errorf("push %s: layer %s: %w", ref, layer.Title, err)
+		}
+		descriptors = append(descriptors, Descriptor{
+			MediaType:   MediaTypeLayer,
+			Digest:      layerDigest,
+			Size:        layer.Size,
+			Annotations: map[string]string{AnnotationTitle: layer.Title},
+		})
+	}
+
+	configDigest := digestBytes(emptyConfig)
+	if err := c.pushBlob(ctx, ref, configDigest, bytes.NewReader(emptyConfig)); err != nil {
+		return "", fmt.Errorf("push %s: config: %w", ref, err)
+	}
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		ArtifactType:  ArtifactType,
+		Config: Descriptor{
+			MediaType: MediaTypeEmpty,
+			Digest:    configDigest,
+			Size:      int64(len(emptyConfig)),
+		},
+		Layers: descriptors,
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("push %s: marshal manifest: %w", ref, err)
+	}
+
+	header := http.Header{"Content-Type": []string{MediaTypeManifest}}
+	resp, err := c.do(ctx, ref, http.MethodPut, manifestURL(ref, ref.Tag), header, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("push %s: manifest: %w", ref, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("push %s: manifest: HTTP %d: %s", ref, resp.StatusCode, resp.Status)
+	}
+
+	return digestBytes(data), nil
+}
+
+// pushBlob uploads a blob with a monolithic upload unless it already exists.
+func (c *Client) pushBlob(ctx context.Context, ref Reference, digest string, body io.ReadSeeker) error {
+	exists, err := c.BlobExists(ctx, ref, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	startURL := fmt.Sprintf("https://%s/v2/%s/blobs/uploads/", ref.Registry, ref.Repository)
+	resp, err := c.do(ctx, ref, http.MethodPost, startURL, nil, nil)
+	if err != nil {
+		return fmt.Errorf("start upload: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload: HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	base, err := url.Parse(startURL)
+	if err != nil {
+		return fmt.Errorf("parse upload URL: %w", err)
+	}
+	location, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("parse upload location: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": []string{"application/octet-stream"}}
+	resp, err = c.do(ctx, ref, http.MethodPut, location.String(), header, body)
+	if err != nil {
+		return fmt.Errorf("upload blob: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload blob: HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	return nil
+}
+
+// do sends a request, answering a single authentication challenge if needed.
+// Request bodies are seekable so they can be replayed after the challenge.
+func (c *Client) do(ctx context.Context, ref Reference, method, u string, header http.Header, body io.ReadSeeker) (*http.Response, error) {
+	key := ref.Registry + "/" + ref.Repository
+
+	resp, err := c.send(ctx, method, u, header, body, c.authorization(key))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_ = resp.Body.Close()
+
+	// A pre-issued token is used as is; exchanging nothing for an anonymous
+	// token instead would hide that it was rejected
+	if c.creds.Token != "" {
+		return nil, fmt.Errorf("registry %s rejected the token in %s", ref.Registry, EnvToken)
+	}
+
+	auth, err := c.authenticate(ctx, challenge, ref, method)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[key] = auth
+	c.mu.Unlock()
+
+	return c.send(ctx, method, u, header, body, auth)
+}
+
+func (c *Client) send(ctx context.Context, method, u string, header http.Header, body io.ReadSeeker, auth string) (*http.Response, error) {
+	var reqBody io.Reader = http.NoBody
+	var length int64
+	if body != nil {
+		n, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, fmt.Errorf("measure request body: %w", err)
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("rewind request body: %w", err)
+		}
+		reqBody, length = io.NopCloser(body), n
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = length
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "labctl/1.0")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request: %w", err)
+	}
+	return resp, nil
+}
+
+// authorization returns the cached Authorization header for a repository.
+func (c *Client) authorization(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if auth, ok := c.tokens[key]; ok {
+		return auth
+	}
+	if c.creds.Token != "" {
+		return "Bearer " + c.creds.Token
+	}
+	return ""
+}
+
+// authenticate answers a WWW-Authenticate challenge and returns the
+// Authorization header value to retry with.
+func (c *Client) authenticate(ctx context.Context, challenge string, ref Reference, method string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.creds.Username == "" {
+			return "", fmt.Errorf("registry %s requires credentials", ref.Registry)
+		}
+		userpass := c.creds.Username + ":" + c.creds.Password
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(userpass)), nil
+	case "bearer":
+		return c.fetchToken(ctx, params, ref, method)
+	default:
+		return "", fmt.Errorf("registry %s returned unsupported challenge %q", ref.Registry, challenge)
+	}
+}
+
+// fetchToken exchanges credentials (or nothing, for anonymous pulls) for a
+// bearer token at the realm named in the challenge.
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, ref Reference, method string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry %s returned bearer challenge without realm", ref.Registry)
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		action := "pull"
+		if method != http.MethodGet && method != http.MethodHead {
+			action = "pull,push"
+		}
+		scope = fmt.Sprintf("repository:%s:%s", ref.Repository, action)
+	}
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("parse token realm: %w", err)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("create token request: %w", err)
+	}
+	req.Header.Set("User-Agent", "labctl/1.0")
+	if c.creds.Username != "" {
+		req.SetBasicAuth(c.creds.Username, c.creds.Password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("parse token response: %w", err)
+	}
+
+	value := token.Token
+	if value == "" {
+		value = token.AccessToken
+	}
+	if value == "" {
+		return "", fmt.Errorf("token response did not include a token")
+	}
+
+	return "Bearer " + value, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters.
+// Example: Bearer realm="https://auth.example.com/token",service="registry"
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+
+	return scheme, params
+}
+
+func blobURL(ref Reference, digest string) string {
+	return fmt.Sprintf("https://%s/v2/%s/blobs/%s", ref.Registry, ref.Repository, digest)
+}
+
+func manifestURL(ref Reference, target string) string {
+	return fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Registry, ref.Repository, target)
+}
+
+func manifestTarget(ref Reference) string {
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+	return ref.Tag
+}
+
+func digestReader(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func digestBytes(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
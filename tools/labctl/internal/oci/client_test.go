@@ -0,0 +1,252 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/oci/ocitest"
+)
+
+func TestClient_FetchBlob(t *testing.T) {
+	t.Run("anonymous pull", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		content := []byte("talos installer layer")
+		digest := registry.PutBlob("siderolabs/installer", content)
+
+		client := NewClient(registry.Client(), Credentials{})
+		ref := Reference{Registry: registry.Host(), Repository: "siderolabs/installer", Digest: digest}
+
+		body, size, err := client.FetchBlob(context.Background(), ref)
+		require.NoError(t, err)
+		defer func() { _ = body.Close() }()
+
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+		assert.Equal(t, int64(len(content)), size)
+	})
+
+	t.Run("anonymous bearer token challenge", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "anonymous-token"
+		defer registry.Close()
+
+		digest := registry.PutBlob("org/repo", []byte("content"))
+
+		client := NewClient(registry.Client(), Credentials{})
+		ref := Reference{Registry: registry.Host(), Repository: "org/repo", Digest: digest}
+
+		body, _, err := client.FetchBlob(context.Background(), ref)
+		require.NoError(t, err)
+		_ = body.Close()
+	})
+
+	t.Run("token exchange with credentials", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "secret-token"
+		registry.Username = "user"
+		registry.Password = "pass"
+		defer registry.Close()
+
+		digest := registry.PutBlob("org/repo", []byte("content"))
+		ref := Reference{Registry: registry.Host(), Repository: "org/repo", Digest: digest}
+
+		_, _, err := NewClient(registry.Client(), Credentials{}).FetchBlob(context.Background(), ref)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "token request")
+
+		body, _, err := NewClient(registry.Client(), Credentials{Username: "user", Password: "pass"}).FetchBlob(context.Background(), ref)
+		require.NoError(t, err)
+		_ = body.Close()
+	})
+
+	t.Run("pre-issued token", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "issued"
+		defer registry.Close()
+
+		digest := registry.PutBlob("org/repo", []byte("content"))
+		ref := Reference{Registry: registry.Host(), Repository: "org/repo", Digest: digest}
+
+		body, _, err := NewClient(registry.Client(), Credentials{Token: "issued"}).FetchBlob(context.Background(), ref)
+		require.NoError(t, err)
+		_ = body.Close()
+	})
+
+	t.Run("missing blob", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		ref := Reference{Registry: registry.Host(), Repository: "org/repo", Digest: ocitest.Digest([]byte("missing"))}
+
+		_, _, err := NewClient(registry.Client(), Credentials{}).FetchBlob(context.Background(), ref)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "HTTP 404")
+	})
+
+	t.Run("digest required", func(t *testing.T) {
+		_, _, err := NewClient(nil, Credentials{}).FetchBlob(context.Background(), Reference{Registry: "r", Repository: "x", Tag: "v1"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "digest is required")
+	})
+}
+
+func TestClient_PushArtifact(t *testing.T) {
+	t.Run("pushes layer, config and manifest", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "push-token"
+		defer registry.Close()
+
+		content := []byte("raw disk image bytes")
+		client := NewClient(registry.Client(), Credentials{})
+		ref := Reference{Registry: registry.Host(), Repository: "images/talos", Tag: "v1.9.1"}
+
+		digest, err := client.PushArtifact(context.Background(), ref, []Layer{{File: bytes.NewReader(content), Size: int64(len(content)), Title: "talos.raw"}})
+		require.NoError(t, err)
+
+		data, ok := registry.Manifest("images/talos", "v1.9.1")
+		require.True(t, ok)
+		assert.Equal(t, ocitest.Digest(data), digest)
+
+		var manifest Manifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		assert.Equal(t, ArtifactType, manifest.ArtifactType)
+		assert.Equal(t, MediaTypeEmpty, manifest.Config.MediaType)
+		require.Len(t, manifest.Layers, 1)
+		assert.Equal(t, ocitest.Digest(content), manifest.Layers[0].Digest)
+		assert.Equal(t, int64(len(content)), manifest.Layers[0].Size)
+		assert.Equal(t, "talos.raw", manifest.Layers[0].Annotations[AnnotationTitle])
+
+		layer, ok := registry.Blob("images/talos", manifest.Layers[0].Digest)
+		require.True(t, ok)
+		assert.Equal(t, content, layer)
+
+		got, err := client.ManifestDigest(context.Background(), ref)
+		require.NoError(t, err)
+		assert.Equal(t, digest, got)
+	})
+
+	t.Run("pushes every file as a layer of one manifest", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		kernel, initrd := []byte("kernel"), []byte("initramfs")
+		client := NewClient(registry.Client(), Credentials{})
+		ref := Reference{Registry: registry.Host(), Repository: "images/hook", Tag: "v1"}
+
+		_, err := client.PushArtifact(context.Background(), ref, []Layer{
+			{File: bytes.NewReader(kernel), Size: int64(len(kernel)), Title: "vmlinuz"},
+			{File: bytes.NewReader(initrd), Size: int64(len(initrd)), Title: "initramfs"},
+		})
+		require.NoError(t, err)
+
+		data, ok := registry.Manifest("images/hook", "v1")
+		require.True(t, ok)
+		var manifest Manifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		require.Len(t, manifest.Layers, 2)
+		assert.Equal(t, ocitest.Digest(kernel), manifest.Layers[0].Digest)
+		assert.Equal(t, "vmlinuz", manifest.Layers[0].Annotations[AnnotationTitle])
+		assert.Equal(t, ocitest.Digest(initrd), manifest.Layers[1].Digest)
+		assert.Equal(t, "initramfs", manifest.Layers[1].Annotations[AnnotationTitle])
+	})
+
+	t.Run("skips existing blobs", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		defer registry.Close()
+
+		content := []byte("already present")
+		registry.PutBlob("images/vyos", content)
+		registry.PutBlob("images/vyos", []byte("{}"))
+
+		client := NewClient(registry.Client(), Credentials{})
+		ref := Reference{Registry: registry.Host(), Repository: "images/vyos", Tag: "latest"}
+
+		_, err := client.PushArtifact(context.Background(), ref, []Layer{{File: bytes.NewReader(content), Size: int64(len(content)), Title: "vyos.iso"}})
+		require.NoError(t, err)
+		assert.Zero(t, registry.Uploads())
+	})
+
+	t.Run("tag required", func(t *testing.T) {
+		_, err := NewClient(nil, Credentials{}).PushArtifact(context.Background(), Reference{Registry: "r", Repository: "x"}, []Layer{{File: bytes.NewReader(nil), Title: "x"}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "tag is required")
+	})
+
+	t.Run("duplicate titles", func(t *testing.T) {
+		ref := Reference{Registry: "r", Repository: "x", Tag: "v1"}
+		_, err := NewClient(nil, Credentials{}).PushArtifact(context.Background(), ref, []Layer{
+			{File: bytes.NewReader(nil), Title: "vmlinuz"},
+			{File: bytes.NewReader(nil), Title: "vmlinuz"},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `duplicate layer title "vmlinuz"`)
+	})
+}
+
+func TestClient_ManifestDigest(t *testing.T) {
+	registry := ocitest.NewRegistry()
+	defer registry.Close()
+
+	client := NewClient(registry.Client(), Credentials{})
+	ref := Reference{Registry: registry.Host(), Repository: "images/talos", Tag: "v1"}
+
+	digest, err := client.ManifestDigest(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Empty(t, digest, "missing tag")
+
+	content := []byte("image")
+	pushed, err := client.PushArtifact(context.Background(), ref, []Layer{{File: bytes.NewReader(content), Size: int64(len(content)), Title: "image"}})
+	require.NoError(t, err)
+
+	digest, err = client.ManifestDigest(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, pushed, digest)
+}
+
+func TestClient_Token(t *testing.T) {
+	t.Run("pre-issued token is sent as is", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "pre-issued"
+		defer registry.Close()
+
+		content := []byte("blob")
+		digest := registry.PutBlob("images/talos", content)
+
+		t.Setenv(EnvToken, "pre-issued")
+		client := NewClient(registry.Client(), CredentialsFromEnv())
+		exists, err := client.BlobExists(context.Background(), Reference{Registry: registry.Host(), Repository: "images/talos"}, digest)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Zero(t, registry.TokenRequests())
+	})
+
+	t.Run("rejected token is an error", func(t *testing.T) {
+		registry := ocitest.NewRegistry()
+		registry.Token = "current"
+		defer registry.Close()
+
+		client := NewClient(registry.Client(), Credentials{Token: "revoked"})
+		_, err := client.BlobExists(context.Background(), Reference{Registry: registry.Host(), Repository: "images/talos"}, ocitest.Digest(nil))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rejected the token in OCI_TOKEN")
+		assert.Zero(t, registry.TokenRequests())
+	})
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:org/repo:pull"`)
+
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, "https://ghcr.io/token", params["realm"])
+	assert.Equal(t, "ghcr.io", params["service"])
+	assert.Equal(t, "repository:org/repo:pull", params["scope"])
+}
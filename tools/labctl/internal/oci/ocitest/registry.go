@@ -0,0 +1,210 @@
+// Package ocitest provides an in-process OCI registry for tests.
+package ocitest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Registry is an in-memory OCI distribution registry served over TLS.
+// It implements the subset of the distribution API used by the oci package:
+// blob GET/HEAD, monolithic blob uploads, and manifest GET/HEAD/PUT.
+type Registry struct {
+	Server *httptest.Server
+
+	// Token, when set, makes the registry require a bearer token obtained
+	// from its /token endpoint. Username and Password, when set, are required
+	// as basic auth on the token endpoint.
+	Token    string
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte // keyed by repository and digest
+	manifests map[string][]byte // keyed by repository and tag or digest
+	uploads   int
+	tokens    int
+}
+
+// NewRegistry starts a registry. Callers must call Close when done.
+func NewRegistry() *Registry {
+	r := &Registry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// Close shuts down the registry.
+func (r *Registry) Close() {
+	r.Server.Close()
+}
+
+// Host returns the registry host for use in references.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.Server.URL, "https://")
+}
+
+// Client returns an HTTP client that trusts the registry certificate.
+func (r *Registry) Client() *http.Client {
+	return r.Server.Client()
+}
+
+// PutBlob stores a blob and returns its digest.
+func (r *Registry) PutBlob(repository string, data []byte) string {
+	digest := Digest(data)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[repository+"@"+digest] = data
+	return digest
+}
+
+// Blob returns a stored blob.
+func (r *Registry) Blob(repository, digest string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.blobs[repository+"@"+digest]
+	return data, ok
+}
+
+// Manifest returns a stored manifest by tag or digest.
+func (r *Registry) Manifest(repository, reference string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.manifests[repository+":"+reference]
+	return data, ok
+}
+
+// Uploads returns the number of completed blob uploads.
+func (r *Registry) Uploads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploads
+}
+
+// TokenRequests returns the number of requests to the token endpoint.
+func (r *Registry) TokenRequests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens
+}
+
+// Digest returns the sha256 digest of data in OCI form.
+func Digest(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	if r.Token != "" && req.Header.Get("Authorization") != "Bearer "+r.Token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="ocitest"`, r.Server.URL+"/token"))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		repo, _, _ := strings.Cut(path, "/blobs/uploads/")
+		r.serveUpload(w, req, repo)
+	case strings.Contains(path, "/blobs/"):
+		repo, digest, _ := strings.Cut(path, "/blobs/")
+		r.serveContent(w, req, r.blobs, repo+"@"+digest, "application/octet-stream")
+	case strings.Contains(path, "/manifests/"):
+		repo, reference, _ := strings.Cut(path, "/manifests/")
+		if req.Method == http.MethodPut {
+			r.putManifest(w, req, repo, reference)
+			return
+		}
+		r.serveContent(w, req, r.manifests, repo+":"+reference, "application/vnd.oci.image.manifest.v1+json")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.tokens++
+	r.mu.Unlock()
+
+	if r.Username != "" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != r.Username || pass != r.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprintf(w, `{"token":%q}`, r.Token)
+}
+
+func (r *Registry) serveContent(w http.ResponseWriter, req *http.Request, store map[string][]byte, key, contentType string) {
+	r.mu.Lock()
+	data, ok := store[key]
+	r.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.Header().Set("Docker-Content-Digest", Digest(data))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repo string) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest := req.URL.Query().Get("digest")
+		if Digest(data) != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.blobs[repo+"@"+digest] = data
+		r.uploads++
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) putManifest(w http.ResponseWriter, req *http.Request, repo, reference string) {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.manifests[repo+":"+reference] = data
+	r.manifests[repo+":"+Digest(data)] = data
+	r.mu.Unlock()
+	w.Header().Set("Docker-Content-Digest", Digest(data))
+	w.WriteHeader(http.StatusCreated)
+}
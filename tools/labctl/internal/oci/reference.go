@@ -0,0 +1,86 @@
+// Package oci provides a minimal OCI distribution client for the image pipeline.
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Scheme is the URL scheme used for OCI references in the image manifest.
+const Scheme = "oci://"
+
+// digestPattern matches a content digest such as "sha256:abc123...".
+var digestPattern = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
+// tagPattern matches a valid OCI tag.
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+
+// Reference identifies a repository in a registry, optionally narrowed to a
+// tag or a content digest.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// IsOCI reports whether the given URL uses the oci:// scheme.
+func IsOCI(url string) bool {
+	return strings.HasPrefix(url, Scheme)
+}
+
+// ParseReference parses a reference of the form
+// [oci://]registry/repository[:tag][@digest].
+func ParseReference(s string) (Reference, error) {
+	raw := strings.TrimPrefix(s, Scheme)
+
+	registry, rest, ok := strings.Cut(raw, "/")
+	if !ok || registry == "" || rest == "" {
+		return Reference{}, fmt.Errorf("invalid OCI reference %q: expected registry/repository", s)
+	}
+
+	ref := Reference{Registry: registry}
+
+	if repo, digest, found := strings.Cut(rest, "@"); found {
+		if !digestPattern.MatchString(digest) {
+			return Reference{}, fmt.Errorf("invalid OCI reference %q: malformed digest %q", s, digest)
+		}
+		ref.Digest = digest
+		rest = repo
+	}
+
+	// A colon after the last slash separates the tag from the repository
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.Tag = rest[i+1:]
+		rest = rest[:i]
+		if !tagPattern.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid OCI reference %q: malformed tag %q", s, ref.Tag)
+		}
+	}
+
+	if rest == "" || rest != strings.ToLower(rest) {
+		return Reference{}, fmt.Errorf("invalid OCI reference %q: repository must be lowercase and non-empty", s)
+	}
+	ref.Repository = rest
+
+	return ref, nil
+}
+
+// String returns the reference in oci:// form.
+func (r Reference) String() string {
+	var b strings.Builder
+	b.WriteString(Scheme)
+	b.WriteString(r.Registry)
+	b.WriteString("/")
+	b.WriteString(r.Repository)
+	if r.Tag != "" {
+		b.WriteString(":")
+		b.WriteString(r.Tag)
+	}
+	if r.Digest != "" {
+		b.WriteString("@")
+		b.WriteString(r.Digest)
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package oci
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    Reference
+		wantErr string
+	}{
+		{
+			name:  "digest reference",
+			input: "oci://ghcr.io/siderolabs/installer@" + digest,
+			want:  Reference{Registry: "ghcr.io", Repository: "siderolabs/installer", Digest: digest},
+		},
+		{
+			name:  "tag reference",
+			input: "oci://ghcr.io/gilmanlab/images/talos:v1.9.1",
+			want:  Reference{Registry: "ghcr.io", Repository: "gilmanlab/images/talos", Tag: "v1.9.1"},
+		},
+		{
+			name:  "registry with port and tag",
+			input: "localhost:5000/images/vyos:latest",
+			want:  Reference{Registry: "localhost:5000", Repository: "images/vyos", Tag: "latest"},
+		},
+		{
+			name:  "tag and digest",
+			input: "oci://ghcr.io/org/repo:v1@" + digest,
+			want:  Reference{Registry: "ghcr.io", Repository: "org/repo", Tag: "v1", Digest: digest},
+		},
+		{
+			name:    "missing repository",
+			input:   "oci://ghcr.io",
+			wantErr: "expected registry/repository",
+		},
+		{
+			name:    "malformed digest",
+			input:   "oci://ghcr.io/org/repo@sha256:abc",
+			wantErr: "malformed digest",
+		},
+		{
+			name:    "uppercase repository",
+			input:   "oci://ghcr.io/Org/Repo:v1",
+			wantErr: "must be lowercase",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := ParseReference(tt.input)
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ref)
+		})
+	}
+}
+
+func TestReference_String(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("b", 64)
+	ref := Reference{Registry: "ghcr.io", Repository: "org/repo", Tag: "v1", Digest: digest}
+
+	assert.Equal(t, "oci://ghcr.io/org/repo:v1@"+digest, ref.String())
+
+	parsed, err := ParseReference(ref.String())
+	require.NoError(t, err)
+	assert.Equal(t, ref, parsed)
+}
+
+func TestIsOCI(t *testing.T) {
+	assert.True(t, IsOCI("oci://ghcr.io/org/repo:v1"))
+	assert.False(t, IsOCI("https://example.com/image.iso"))
+}
@@ -0,0 +1,163 @@
+// Package ratelimit provides token-bucket bandwidth limiting for transfers.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GilmanLab/lab/tools/labctl/internal/bytesize"
+)
+
+// minBurst is the smallest bucket size, so slow limits still move data in
+// reasonably sized reads.
+const minBurst = 4 * 1024
+
+// Limiter is a token bucket that limits throughput to a number of bytes per
+// second. It is safe for concurrent use; readers sharing a Limiter share its rate.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  int
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// NewLimiter returns a limiter allowing bytesPerSecond on average, with
+// bursts of up to one second's worth of data. A rate of zero or less means
+// unlimited and returns nil, which NewReader treats as no limit.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := max(int(min(bytesPerSecond, 1<<30)), minBurst)
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// Wait blocks until n bytes may be transferred. n must not exceed the burst
+// size; NewReader takes care of that.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	// Reserve the tokens now and sleep off any deficit, so concurrent
+	// callers queue behind each other instead of racing for the refill
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, time.Duration(deficit/l.rate*float64(time.Second)))
+}
+
+// NewReader wraps r so reads are limited by l. A nil limiter returns r unchanged.
+//
+// When r is an io.Seeker, as an *os.File is, the result is too, and it keeps
+// io.ReaderAt as well: S3 uploads need to rewind the body to sign it and to
+// retry, and refuse unseekable bodies without TLS.
+func NewReader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	base := &reader{ctx: ctx, r: r, l: l}
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return base
+	}
+	sr := &seekReader{reader: base, s: seeker}
+	if ra, ok := r.(io.ReaderAt); ok {
+		return &fileReader{seekReader: sr, ra: ra}
+	}
+	return sr
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.l.burst {
+		p = p[:r.l.burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.l.Wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// seekReader is a limited reader whose underlying reader can seek.
+type seekReader struct {
+	*reader
+	s io.Seeker
+}
+
+func (r *seekReader) Seek(offset int64, whence int) (int64, error) {
+	return r.s.Seek(offset, whence)
+}
+
+// fileReader is a limited reader that can also read at an offset.
+type fileReader struct {
+	*seekReader
+	ra io.ReaderAt
+}
+
+func (r *fileReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		m, err := r.ra.ReadAt(p[n:min(len(p), n+r.l.burst)], off+int64(n))
+		n += m
+		if m > 0 {
+			if waitErr := r.l.Wait(r.ctx, m); waitErr != nil {
+				return n, waitErr
+			}
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ParseRate parses a rate such as "10MB/s", "512KiB", or "2000000" into
+// bytes per second. An empty string or "0" means unlimited and returns 0.
+func ParseRate(s string) (int64, error) {
+	n, err := bytesize.Parse(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, expected a value like 10MB/s or 512KiB/s", s)
+	}
+	return n, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
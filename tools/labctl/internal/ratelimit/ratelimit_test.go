@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLimiter returns a limiter on a fake clock that advances when it sleeps.
+func newTestLimiter(bytesPerSecond int64) (*Limiter, *time.Duration) {
+	clock := time.Unix(1_700_000_000, 0)
+	var slept time.Duration
+	l := NewLimiter(bytesPerSecond)
+	l.now = func() time.Time { return clock }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		slept += d
+		clock = clock.Add(d)
+		return nil
+	}
+	return l, &slept
+}
+
+func TestReader(t *testing.T) {
+	t.Run("limits throughput", func(t *testing.T) {
+		l, slept := newTestLimiter(10_000)
+		data := bytes.Repeat([]byte("x"), 50_000)
+
+		out, err := io.ReadAll(NewReader(context.Background(), bytes.NewReader(data), l))
+		require.NoError(t, err)
+		assert.Equal(t, data, out)
+
+		// The first second's worth is the initial burst; the rest is paced
+		assert.InDelta(t, 4*time.Second, *slept, float64(10*time.Millisecond))
+	})
+
+	t.Run("nil limiter is unlimited", func(t *testing.T) {
+		r := bytes.NewReader([]byte("data"))
+		assert.Same(t, r, NewReader(context.Background(), r, nil))
+	})
+
+	t.Run("reads are capped to the burst size", func(t *testing.T) {
+		l, _ := newTestLimiter(1)
+		r := NewReader(context.Background(), bytes.NewReader(make([]byte, 10*minBurst)), l)
+
+		n, err := r.Read(make([]byte, 10*minBurst))
+		require.NoError(t, err)
+		assert.Equal(t, minBurst, n)
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		l := NewLimiter(1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := io.ReadAll(NewReader(ctx, bytes.NewReader(make([]byte, 2*minBurst)), l))
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+
+	t.Run("keeps Seek and ReadAt of the underlying reader", func(t *testing.T) {
+		l, slept := newTestLimiter(10_000)
+		data := bytes.Repeat([]byte("x"), 30_000)
+
+		r := NewReader(context.Background(), bytes.NewReader(data), l)
+		seeker, ok := r.(io.ReadSeeker)
+		require.True(t, ok, "reader is not an io.ReadSeeker")
+		readerAt, ok := r.(io.ReaderAt)
+		require.True(t, ok, "reader is not an io.ReaderAt")
+
+		_, err := io.ReadAll(seeker)
+		require.NoError(t, err)
+		pos, err := seeker.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		assert.Zero(t, pos)
+		out, err := io.ReadAll(seeker)
+		require.NoError(t, err)
+		assert.Equal(t, data, out)
+
+		buf := make([]byte, 20_000)
+		n, err := readerAt.ReadAt(buf, 10_000)
+		require.NoError(t, err)
+		assert.Equal(t, 20_000, n)
+
+		// Both reads and the ReadAt are paced
+		assert.InDelta(t, 7*time.Second, *slept, float64(10*time.Millisecond))
+	})
+
+	t.Run("plain readers stay unseekable", func(t *testing.T) {
+		l, _ := newTestLimiter(10_000)
+		r := NewReader(context.Background(), io.LimitReader(bytes.NewReader(nil), 0), l)
+		_, ok := r.(io.Seeker)
+		assert.False(t, ok)
+	})
+}
+
+func TestNewLimiter(t *testing.T) {
+	assert.Nil(t, NewLimiter(0))
+	assert.Nil(t, NewLimiter(-1))
+	assert.Equal(t, minBurst, NewLimiter(1).burst)
+	assert.Equal(t, 1_000_000, NewLimiter(1_000_000).burst)
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0", want: 0},
+		{in: "2000000", want: 2_000_000},
+		{in: "10MB/s", want: 10_000_000},
+		{in: "10M", want: 10_000_000},
+		{in: "512KiB/s", want: 512 * 1024},
+		{in: "1.5GB", want: 1_500_000_000},
+		{in: "100B/s", want: 100},
+		{in: "fast", wantErr: true},
+		{in: "-1MB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRate(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -29,6 +30,7 @@ type Client interface {
 	GetMetadata(ctx context.Context, imagePath string) (*ImageMetadata, error)
 	PutMetadata(ctx context.Context, imagePath string, metadata *ImageMetadata) error
 	ChecksumMatches(ctx context.Context, imagePath, expectedChecksum string) (bool, error)
+	PresignURL(ctx context.Context, key string, expiry time.Duration) (string, error)
 }
 
 // ImageMetadata represents metadata stored alongside each image.
@@ -38,6 +40,35 @@ type ImageMetadata struct {
 	Size       int64          `json:"size"`
 	UploadedAt time.Time      `json:"uploadedAt"`
 	Source     SourceMetadata `json:"source"`
+
+	// Transform is set when the image was converted to another disk format
+	// before upload. Checksum above stays the pre-transform checksum from the
+	// manifest; sync compares both it and Transform with the manifest, so
+	// adding or changing a transform re-syncs the image.
+	Transform *TransformMetadata `json:"transform,omitempty"`
+
+	// OCI is set when the image was also pushed to an OCI registry. Sync
+	// compares the digest with the one the reference resolves to, so a tag
+	// moved or overwritten elsewhere is pushed again.
+	OCI *OCIMetadata `json:"oci,omitempty"`
+}
+
+// OCIMetadata records the OCI artifact an image was pushed as.
+type OCIMetadata struct {
+	// Reference is the tagged reference the artifact was pushed to.
+	Reference string `json:"reference"`
+	// Digest is the digest of the pushed manifest.
+	Digest string `json:"digest"`
+}
+
+// TransformMetadata describes a disk format conversion applied during sync.
+type TransformMetadata struct {
+	// Format is the disk format the image was converted to.
+	Format string `json:"format"`
+	// Sparse records whether all-zero clusters were left unallocated.
+	Sparse bool `json:"sparse,omitempty"`
+	// Checksum is the checksum of the uploaded, converted image.
+	Checksum string `json:"checksum"`
 }
 
 // SourceMetadata describes the origin of an image.
@@ -58,12 +89,19 @@ type s3API interface {
 	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// presignAPI defines the presigning operation used by S3Client.
+type presignAPI interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
 }
 
 // S3Client wraps the AWS S3 client for image storage operations.
 type S3Client struct {
-	api    s3API
-	bucket string
+	api     s3API
+	presign presignAPI
+	bucket  string
 }
 
 // S3Option configures the S3 client.
@@ -107,8 +145,9 @@ func NewS3Client(creds *labcreds.E2Credentials, opts ...S3Option) (*S3Client, er
 	})
 
 	return &S3Client{
-		api:    client,
-		bucket: creds.Bucket,
+		api:     client,
+		presign: s3.NewPresignClient(client),
+		bucket:  creds.Bucket,
 	}, nil
 }
 
@@ -215,6 +254,22 @@ func (c *S3Client) List(ctx context.Context, prefix string) ([]string, error) {
 	return keys, nil
 }
 
+// CheckAccess proves the credentials work: it checks that the bucket exists
+// and is reachable with HeadBucket, then lists one object with ListObjectsV2,
+// which needs read permission on the bucket's contents.
+func (c *S3Client) CheckAccess(ctx context.Context) error {
+	if _, err := c.api.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.bucket)}); err != nil {
+		return fmt.Errorf("head bucket s3://%s: %w", c.bucket, err)
+	}
+	if _, err := c.api.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.bucket),
+		MaxKeys: aws.Int32(1),
+	}); err != nil {
+		return fmt.Errorf("list objects in s3://%s: %w", c.bucket, err)
+	}
+	return nil
+}
+
 // Delete deletes an object from the S3 bucket.
 func (c *S3Client) Delete(ctx context.Context, key string) error {
 	_, err := c.api.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -227,6 +282,23 @@ func (c *S3Client) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// PresignURL returns a URL that downloads the object at key without
+// credentials until expiry passes.
+func (c *S3Client) PresignURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if c.presign == nil {
+		return "", fmt.Errorf("presign s3://%s/%s: presigning is not configured", c.bucket, key)
+	}
+
+	req, err := c.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", fmt.Errorf("presign s3://%s/%s: %w", c.bucket, key, err)
+	}
+	return req.URL, nil
+}
+
 // MetadataKey returns the metadata key for a given image path.
 // Example: "vyos/vyos-1.5.iso" -> "metadata/vyos/vyos-1.5.iso.json"
 func MetadataKey(imagePath string) string {
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +15,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	labcreds "github.com/GilmanLab/lab/tools/labctl/internal/credentials"
+	"github.com/GilmanLab/lab/tools/labctl/internal/store/s3test"
 )
 
 // mockS3API is a mock implementation of s3API for testing.
@@ -23,6 +27,7 @@ type mockS3API struct {
 	headObjectFunc    func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 	deleteObjectFunc  func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 	listObjectsV2Func func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	headBucketFunc    func(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
 }
 
 func (m *mockS3API) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
@@ -60,6 +65,13 @@ func (m *mockS3API) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2I
 	return &s3.ListObjectsV2Output{}, nil
 }
 
+func (m *mockS3API) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	if m.headBucketFunc != nil {
+		return m.headBucketFunc(ctx, params, optFns...)
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
 // nopCloser wraps an io.Reader to implement io.ReadCloser.
 type nopCloser struct {
 	io.Reader
@@ -431,6 +443,34 @@ func TestS3Client_ChecksumMatches(t *testing.T) {
 	})
 }
 
+func TestS3Client_PresignURL(t *testing.T) {
+	t.Run("signs a GET for the object", func(t *testing.T) {
+		client, err := NewS3Client(&labcreds.E2Credentials{
+			AccessKey: "AKIATEST",
+			SecretKey: "secret",
+			Endpoint:  "https://e2.example.com",
+			Bucket:    "lab-images",
+		})
+		require.NoError(t, err)
+
+		url, err := client.PresignURL(context.Background(), "images/vyos/vyos.iso", time.Hour)
+
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(url, "https://e2.example.com/lab-images/images/vyos/vyos.iso?"), url)
+		assert.Contains(t, url, "X-Amz-Expires=3600")
+		assert.Contains(t, url, "X-Amz-Signature=")
+	})
+
+	t.Run("error without presigner", func(t *testing.T) {
+		client := newS3ClientWithAPI(&mockS3API{}, "test-bucket")
+
+		_, err := client.PresignURL(context.Background(), "images/test.iso", time.Hour)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "presigning is not configured")
+	})
+}
+
 func TestMetadataKey(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -584,3 +624,69 @@ func TestIsNotFoundError(t *testing.T) {
 		})
 	}
 }
+
+func TestS3Client_CheckAccess(t *testing.T) {
+	t.Run("heads the bucket then lists one object", func(t *testing.T) {
+		var calls []string
+		mock := &mockS3API{
+			headBucketFunc: func(_ context.Context, params *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+				calls = append(calls, "head")
+				assert.Equal(t, "test-bucket", aws.ToString(params.Bucket))
+				return &s3.HeadBucketOutput{}, nil
+			},
+			listObjectsV2Func: func(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+				calls = append(calls, "list")
+				assert.Equal(t, int32(1), aws.ToInt32(params.MaxKeys))
+				return &s3.ListObjectsV2Output{}, nil
+			},
+		}
+
+		client := newS3ClientWithAPI(mock, "test-bucket")
+		require.NoError(t, client.CheckAccess(context.Background()))
+		assert.Equal(t, []string{"head", "list"}, calls)
+	})
+
+	t.Run("head bucket error", func(t *testing.T) {
+		mock := &mockS3API{
+			headBucketFunc: func(_ context.Context, _ *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+				return nil, errors.New("forbidden")
+			},
+			listObjectsV2Func: func(_ context.Context, _ *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+				t.Fatal("list should not be called")
+				return nil, nil
+			},
+		}
+
+		client := newS3ClientWithAPI(mock, "test-bucket")
+		err := client.CheckAccess(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "head bucket s3://test-bucket")
+	})
+
+	t.Run("against a local S3 endpoint", func(t *testing.T) {
+		server := s3test.NewServer("access123", "secret456", "test-bucket")
+		defer server.Close()
+		server.Put("test-bucket", "images/a.iso", []byte("iso"))
+
+		newClient := func(accessKey, secretKey, bucket string) *S3Client {
+			client, err := NewS3Client(&labcreds.E2Credentials{
+				AccessKey: accessKey,
+				SecretKey: secretKey,
+				Endpoint:  server.URL(),
+				Bucket:    bucket,
+			})
+			require.NoError(t, err)
+			return client
+		}
+
+		require.NoError(t, newClient("access123", "secret456", "test-bucket").CheckAccess(context.Background()))
+
+		err := newClient("access123", "wrong", "test-bucket").CheckAccess(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "head bucket s3://test-bucket")
+
+		err = newClient("access123", "secret456", "missing").CheckAccess(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "NotFound")
+	})
+}
@@ -0,0 +1,262 @@
+// Package s3test provides an in-process S3 endpoint for tests.
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Server is an in-memory S3 endpoint with path-style addressing. It
+// implements the subset of the S3 API labctl uses: HeadBucket, ListObjectsV2,
+// and PutObject, GetObject and HeadObject. It verifies the SigV4 signature of
+// every request against AccessKey and SecretKey, so wrong keys fail as they
+// would against e2, and like S3 it rejects PutObject bodies whose payload
+// hash was not signed.
+type Server struct {
+	Server *httptest.Server
+
+	AccessKey string
+	SecretKey string
+
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte // keyed by bucket, then object key
+}
+
+// NewServer starts a server holding the given empty buckets. Callers must
+// call Close when done.
+func NewServer(accessKey, secretKey string, buckets ...string) *Server {
+	s := &Server{
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		buckets:   make(map[string]map[string][]byte),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string][]byte)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL returns the endpoint URL.
+func (s *Server) URL() string {
+	return s.Server.URL
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.Server.Close()
+}
+
+// Object returns a stored object.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.buckets[bucket][key]
+	return data, ok
+}
+
+// Put stores an object, creating the bucket if needed.
+func (s *Server) Put(bucket, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	s.buckets[bucket][key] = data
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if code, msg := s.authenticate(r); code != "" {
+		writeError(w, r, http.StatusForbidden, code, msg)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.listObjects(w, r, bucket, objects)
+	case key != "" && r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		s.mu.Lock()
+		data, ok := objects[key]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.Path+" is not implemented")
+	}
+}
+
+// putObject stores a request body whose SHA-256 is the signed payload hash.
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(sum[:]) {
+		writeError(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch",
+			"The provided 'x-amz-content-sha256' header does not match what was computed: "+got)
+		return
+	}
+
+	s.Put(bucket, key, data)
+	w.WriteHeader(http.StatusOK)
+}
+
+// listResult is a ListObjectsV2 response.
+type listResult struct {
+	XMLName     xml.Name      `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string        `xml:"Name"`
+	Prefix      string        `xml:"Prefix"`
+	KeyCount    int           `xml:"KeyCount"`
+	MaxKeys     int           `xml:"MaxKeys"`
+	IsTruncated bool          `xml:"IsTruncated"`
+	Contents    []listContent `xml:"Contents"`
+}
+
+type listContent struct {
+	Key  string `xml:"Key"`
+	Size int    `xml:"Size"`
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string, objects map[string][]byte) {
+	query := r.URL.Query()
+	result := listResult{Name: bucket, Prefix: query.Get("prefix"), MaxKeys: 1000}
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys "+v)
+			return
+		}
+		result.MaxKeys = n
+	}
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(objects))
+	for k := range objects {
+		if strings.HasPrefix(k, result.Prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(result.Contents) == result.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, listContent{Key: k, Size: len(objects[k])})
+	}
+	s.mu.Unlock()
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// authenticate checks the request's SigV4 signature by signing a copy of it
+// with the server's keys. It returns an S3 error code and message on failure.
+func (s *Server) authenticate(r *http.Request) (code, msg string) {
+	auth := r.Header.Get("Authorization")
+	params, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "AccessDenied", "request is not signed with SigV4"
+	}
+
+	fields := make(map[string]string)
+	for _, p := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		fields[k] = v
+	}
+	scope := strings.Split(fields["Credential"], "/")
+	if len(scope) != 5 {
+		return "AuthorizationHeaderMalformed", "malformed credential scope"
+	}
+	if scope[0] != s.AccessKey {
+		return "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."
+	}
+
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "AuthorizationHeaderMalformed", "missing or invalid X-Amz-Date"
+	}
+
+	// Sign only the headers the client signed; transports add others later
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return "AuthorizationHeaderMalformed", err.Error()
+	}
+	req.ContentLength = r.ContentLength
+	for _, h := range strings.Split(fields["SignedHeaders"], ";") {
+		if h != "host" {
+			req.Header[http.CanonicalHeaderKey(h)] = r.Header.Values(h)
+		}
+	}
+
+	creds := aws.Credentials{AccessKeyID: s.AccessKey, SecretAccessKey: s.SecretKey}
+	err = v4.NewSigner().SignHTTP(r.Context(), creds, req, r.Header.Get("X-Amz-Content-Sha256"), scope[3], scope[2], signedAt,
+		func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+	if err != nil {
+		return "AuthorizationHeaderMalformed", err.Error()
+	}
+
+	_, want, _ := strings.Cut(req.Header.Get("Authorization"), "Signature=")
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."
+	}
+	return "", ""
+}
+
+// s3Error is an S3 error response.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	if r.Method == http.MethodHead {
+		// HEAD responses have no body, so S3 reports only the status
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprint(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(s3Error{Code: code, Message: msg})
+}
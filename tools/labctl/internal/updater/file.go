@@ -5,13 +5,28 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"text/template"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 // TemplateData contains variables available for template substitution.
 type TemplateData struct {
-	Source SourceData
+	Name         string            // Image name
+	Destination  string            // Destination path relative to the images/ prefix
+	ImageKey     string            // Full object key in the bucket
+	NASPath      string            // Path of the image on the NAS
+	URL          string            // Public download URL under publish.baseURL
+	PresignedURL string            // Presigned download URL, only for files git ignores
+	Validation   string            // Validation digest of the image, if any
+	Size         int64             // Size of the image in bytes
+	UploadedAt   time.Time         // When the image was uploaded
+	Source       SourceData        // Upstream source of the image
+	Vars         map[string]string // Manifest spec.vars merged with the image's vars
 }
 
 // SourceData contains source-related template variables.
@@ -20,82 +35,255 @@ type SourceData struct {
 	Checksum string
 }
 
-// Replacement defines a regex-based replacement operation.
+// Replacement types. Regex replacements rewrite every match of a pattern;
+// the others set the scalar at a path in a structured file.
+const (
+	TypeRegex    = "regex"
+	TypeYAMLPath = "yamlpath"
+	TypeJSONPath = "jsonpath"
+	TypeTOML     = "toml"
+)
+
+// Types lists the supported replacement types.
+var Types = []string{TypeRegex, TypeYAMLPath, TypeJSONPath, TypeTOML}
+
+// structuredEditor sets the value at a path and returns the number of
+// values it set. A path that is not found is an error.
+type structuredEditor func(content []byte, path []pathElem, value string) ([]byte, int, error)
+
+// structuredEditors set the value at a path for each structured type.
+var structuredEditors = map[string]structuredEditor{
+	TypeYAMLPath: editYAML,
+	TypeJSONPath: single(editJSON),
+	TypeTOML:     single(editTOML),
+}
+
+// single adapts an editor that sets exactly one value.
+func single(edit func([]byte, []pathElem, string) ([]byte, error)) structuredEditor {
+	return func(content []byte, path []pathElem, value string) ([]byte, int, error) {
+		result, err := edit(content, path, value)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result, 1, nil
+	}
+}
+
+// Replacement defines a replacement operation.
 type Replacement struct {
-	Pattern string // Regex pattern to match
-	Value   string // Replacement value (may contain Go templates)
+	Type          string // Replacement type; empty means TypeRegex
+	Pattern       string // Regex pattern to match, for TypeRegex
+	Path          string // Path expression such as spec.url, for the structured types
+	Value         string // Replacement value (may contain Go templates, and $1 or ${name} for regex)
+	ExpectMatches int    // Exact number of matches required; 0 means any
+	Required      bool   // At least one match is required
 }
 
-// FileUpdater performs regex-based file updates with template substitution.
+// FileUpdater performs file updates with template substitution.
 type FileUpdater struct {
 	replacements []compiledReplacement
 	data         TemplateData
+	root         string // Files must resolve inside root; empty allows any path
+}
+
+// Option configures a FileUpdater.
+type Option func(*FileUpdater)
+
+// WithRoot confines the files the updater reads and writes to root. Paths
+// that resolve outside it, directly or through a symlink, are refused. An
+// empty root allows any path.
+func WithRoot(root string) Option {
+	return func(u *FileUpdater) {
+		u.root = root
+	}
 }
 
 type compiledReplacement struct {
-	regex    *regexp.Regexp
 	template *template.Template
+
+	// edit applies the rendered value to the content and returns the
+	// number of matches
+	edit func(content []byte, value string) ([]byte, int, error)
+
+	target        string // Pattern or path, for errors
+	expectMatches int
+	required      bool
+}
+
+// check returns an error if matches violates the replacement's expectations.
+func (r *compiledReplacement) check(matches int) error {
+	switch {
+	case r.expectMatches > 0 && matches != r.expectMatches:
+		return fmt.Errorf("%s matched %d time(s), expected %d", r.target, matches, r.expectMatches)
+	case r.required && matches == 0:
+		return fmt.Errorf("%s matched nothing but is required", r.target)
+	}
+	return nil
 }
 
 // New creates a new FileUpdater with the given replacements and template data.
-func New(replacements []Replacement, data TemplateData) (*FileUpdater, error) {
+func New(replacements []Replacement, data TemplateData, opts ...Option) (*FileUpdater, error) {
 	compiled := make([]compiledReplacement, 0, len(replacements))
 
 	for i, r := range replacements {
-		regex, err := regexp.Compile(r.Pattern)
+		edit, err := compileEdit(r)
 		if err != nil {
-			return nil, fmt.Errorf("compile pattern[%d] %q: %w", i, r.Pattern, err)
+			return nil, fmt.Errorf("replacement[%d]: %w", i, err)
 		}
 
-		tmpl, err := template.New(fmt.Sprintf("replacement-%d", i)).Parse(r.Value)
+		tmpl, err := template.New(fmt.Sprintf("replacement-%d", i)).Funcs(funcs).Parse(r.Value)
 		if err != nil {
 			return nil, fmt.Errorf("parse template[%d] %q: %w", i, r.Value, err)
 		}
 
+		target := fmt.Sprintf("pattern %q", r.Pattern)
+		if r.Type != "" && r.Type != TypeRegex {
+			target = "path " + r.Path
+		}
 		compiled = append(compiled, compiledReplacement{
-			regex:    regex,
-			template: tmpl,
+			template:      tmpl,
+			edit:          edit,
+			target:        target,
+			expectMatches: r.ExpectMatches,
+			required:      r.Required,
 		})
 	}
 
-	return &FileUpdater{
+	u := &FileUpdater{
 		replacements: compiled,
 		data:         data,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	if u.root != "" {
+		root, err := filepath.EvalSymlinks(u.root)
+		if err != nil {
+			return nil, fmt.Errorf("resolve root: %w", err)
+		}
+		if u.root, err = filepath.Abs(root); err != nil {
+			return nil, fmt.Errorf("resolve root: %w", err)
+		}
+	}
+	return u, nil
+}
+
+// compileEdit returns the edit function for a replacement.
+func compileEdit(r Replacement) (func([]byte, string) ([]byte, int, error), error) {
+	if r.ExpectMatches < 0 {
+		return nil, fmt.Errorf("expectMatches must not be negative")
+	}
+	if r.Type == "" || r.Type == TypeRegex {
+		regex, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile pattern %q: %w", r.Pattern, err)
+		}
+		return func(content []byte, value string) ([]byte, int, error) {
+			return replaceAll(regex, content, value)
+		}, nil
+	}
+
+	editor, ok := structuredEditors[r.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %q", r.Type)
+	}
+	path, err := parsePath(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	return func(content []byte, value string) ([]byte, int, error) {
+		return editor(content, path, value)
 	}, nil
 }
 
+// replaceAll replaces every match of regex in content with value, expanding
+// $1 and ${name} to the match's capture groups, and returns the number of
+// matches. Use $$ for a literal $.
+func replaceAll(regex *regexp.Regexp, content []byte, value string) ([]byte, int, error) {
+	matches := regex.FindAllSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return content, 0, nil
+	}
+
+	result := make([]byte, 0, len(content))
+	last := 0
+	for _, m := range matches {
+		result = append(result, content[last:m[0]]...)
+		result = regex.Expand(result, []byte(value), content, m)
+		last = m[1]
+	}
+	return append(result, content[last:]...), len(matches), nil
+}
+
 // UpdateContent applies all replacements to the given content.
-// Returns the modified content and whether any changes were made.
+// Returns the modified content and whether any changes were made. A
+// replacement whose match count violates ExpectMatches or Required is an
+// error.
 func (u *FileUpdater) UpdateContent(content []byte) (result []byte, modified bool, err error) {
-	result = content
+	result, _, err = u.apply(content)
+	if err != nil {
+		return nil, false, err
+	}
+	return result, !bytes.Equal(result, content), nil
+}
+
+// apply applies all replacements to content and returns the result and the
+// match count of each replacement.
+func (u *FileUpdater) apply(content []byte) ([]byte, []int, error) {
+	result := content
+	counts := make([]int, len(u.replacements))
 
 	for i, r := range u.replacements {
 		// Execute the template to get the replacement value
 		var buf bytes.Buffer
-		if err = r.template.Execute(&buf, u.data); err != nil {
-			return nil, false, fmt.Errorf("execute template[%d]: %w", i, err)
+		if err := r.template.Execute(&buf, u.data); err != nil {
+			return nil, nil, fmt.Errorf("execute template[%d]: %w", i, err)
+		}
+
+		newResult, matches, err := r.edit(result, buf.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf("apply replacement[%d]: %w", i, err)
 		}
-		replacement := buf.Bytes()
-
-		// Check if the pattern matches
-		if r.regex.Match(result) {
-			newResult := r.regex.ReplaceAll(result, replacement)
-			if !bytes.Equal(result, newResult) {
-				modified = true
-				result = newResult
-			}
+		if err := r.check(matches); err != nil {
+			return nil, nil, fmt.Errorf("replacement[%d]: %w", i, err)
 		}
+		counts[i] = matches
+		result = newResult
 	}
 
-	return result, modified, nil
+	return result, counts, nil
+}
+
+// Simulation is the outcome of applying the replacements to a file without
+// writing it.
+type Simulation struct {
+	Matches []int // Match count of each replacement, in order
+	Changed bool  // Whether the file would change
+}
+
+// Simulate applies the replacements to the file at path in memory and
+// reports how often each matched and whether the file would change.
+func (u *FileUpdater) Simulate(path string) (*Simulation, error) {
+	_, content, _, err := u.read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result, counts, err := u.apply(content)
+	if err != nil {
+		return nil, fmt.Errorf("update content: %w", err)
+	}
+	return &Simulation{Matches: counts, Changed: !bytes.Equal(result, content)}, nil
 }
 
 // UpdateFile reads a file, applies replacements, and writes back if modified.
-// Returns whether the file was modified.
+// Returns whether the file was modified. Symlinks are resolved and the file
+// they point to is replaced atomically, keeping its mode and ownership.
 func (u *FileUpdater) UpdateFile(path string) (bool, error) {
-	content, err := os.ReadFile(path) //nolint:gosec // G304: Path is provided by user
+	realPath, content, info, err := u.read(path)
 	if err != nil {
-		return false, fmt.Errorf("read file %s: %w", path, err)
+		return false, err
 	}
 
 	updated, modified, err := u.UpdateContent(content)
@@ -107,15 +295,105 @@ func (u *FileUpdater) UpdateFile(path string) (bool, error) {
 		return false, nil
 	}
 
-	// Get original file permissions
-	info, err := os.Stat(path)
+	if err := writeFile(realPath, updated, info); err != nil {
+		return false, fmt.Errorf("write file %s: %w", path, err)
+	}
+
+	return true, nil
+}
+
+// read resolves path and returns its real path, content and file info.
+func (u *FileUpdater) read(path string) (string, []byte, os.FileInfo, error) {
+	realPath, err := u.resolve(path)
 	if err != nil {
-		return false, fmt.Errorf("stat file %s: %w", path, err)
+		return "", nil, nil, err
 	}
 
-	if err := os.WriteFile(path, updated, info.Mode()); err != nil {
-		return false, fmt.Errorf("write file %s: %w", path, err)
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("stat file %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, nil, fmt.Errorf("read file %s: not a regular file", path)
 	}
 
-	return true, nil
+	content, err := os.ReadFile(realPath) //nolint:gosec // G304: Path is confined to the root by resolve
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("read file %s: %w", path, err)
+	}
+	return realPath, content, info, nil
+}
+
+// label returns path relative to the root when it is inside it, for diff
+// headers, or path unchanged otherwise.
+func (u *FileUpdater) label(path string) string {
+	if u.root == "" || !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(u.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// resolve returns the absolute path of the file path refers to, following
+// symlinks, and refuses files outside the root.
+func (u *FileUpdater) resolve(path string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("read file %s: %w", path, err)
+	}
+	if realPath, err = filepath.Abs(realPath); err != nil {
+		return "", fmt.Errorf("read file %s: %w", path, err)
+	}
+	if u.root == "" {
+		return realPath, nil
+	}
+
+	rel, err := filepath.Rel(u.root, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s resolves to %s, outside the repository root %s", path, realPath, u.root)
+	}
+	return realPath, nil
+}
+
+// Diff reads a file and returns the unified diff that UpdateFile would apply,
+// without writing anything. An empty diff means the file would not change.
+func (u *FileUpdater) Diff(path string) (string, error) {
+	_, content, _, err := u.read(path)
+	if err != nil {
+		return "", err
+	}
+
+	updated, modified, err := u.UpdateContent(content)
+	if err != nil {
+		return "", fmt.Errorf("update content: %w", err)
+	}
+	if !modified {
+		return "", nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(content),
+		B:        splitLines(updated),
+		FromFile: u.label(path),
+		ToFile:   u.label(path),
+		Context:  3,
+	})
+	if err != nil {
+		return "", fmt.Errorf("diff file %s: %w", path, err)
+	}
+	return diff, nil
+}
+
+// splitLines splits content into lines that each end in a newline.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
 }
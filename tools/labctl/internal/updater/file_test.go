@@ -3,6 +3,7 @@ package updater
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,6 +49,18 @@ func TestNew(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "parse template")
 	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		_, err := New([]Replacement{{Type: "xml", Path: "a", Value: "b"}}, TemplateData{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unsupported type "xml"`)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		_, err := New([]Replacement{{Type: TypeYAMLPath, Path: "spec..url", Value: "b"}}, TemplateData{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "empty key")
+	})
 }
 
 func TestFileUpdater_UpdateContent(t *testing.T) {
@@ -79,6 +92,16 @@ func TestFileUpdater_UpdateContent(t *testing.T) {
 			want:         `checksum = "sha256:abc123"`,
 			wantModified: true,
 		},
+		{
+			name: "manifest vars",
+			replacements: []Replacement{
+				{Pattern: `version\s*=\s*"[^"]*"`, Value: `version = "{{ .Vars.version }}"`},
+			},
+			data:         TemplateData{Vars: map[string]string{"version": "1.9.1"}},
+			content:      `version = "1.9.0"`,
+			want:         `version = "1.9.1"`,
+			wantModified: true,
+		},
 		{
 			name: "multiple replacements",
 			replacements: []Replacement{
@@ -128,6 +151,53 @@ vyos_iso_checksum = "sha256:abc123"
 			want: `# Auto-generated by labctl images sync
 vyos_iso_url = "https://github.com/vyos/releases/vyos-1.5.iso"
 vyos_iso_checksum = "sha256:abc123"
+`,
+			wantModified: true,
+		},
+		{
+			name: "numbered capture groups alongside templates",
+			replacements: []Replacement{
+				{Pattern: `(talos_version)\s*=\s*"[^"]*"`, Value: `$1 = "{{ .Vars.version }}"`},
+			},
+			data:         TemplateData{Vars: map[string]string{"version": "1.9.1"}},
+			content:      `talos_version   = "1.9.0"`,
+			want:         `talos_version = "1.9.1"`,
+			wantModified: true,
+		},
+		{
+			name: "named capture groups keep surrounding text",
+			replacements: []Replacement{
+				{Pattern: `(?P<key>\w+_url)(?P<sep>\s*=\s*)"[^"]*"`, Value: `${key}${sep}"{{ .Source.URL }}"`},
+			},
+			data:         TemplateData{Source: SourceData{URL: "https://new.example.com/vyos.iso"}},
+			content:      "vyos_url  = \"https://old\"\nharvester_url = \"https://old\"\n",
+			want:         "vyos_url  = \"https://new.example.com/vyos.iso\"\nharvester_url = \"https://new.example.com/vyos.iso\"\n",
+			wantModified: true,
+		},
+		{
+			name: "expected match count met",
+			replacements: []Replacement{
+				{Pattern: `talos-[0-9.]+`, Value: "talos-1.9.1", ExpectMatches: 2},
+				{Type: TypeYAMLPath, Path: "image", Value: "talos-1.9.1", Required: true},
+			},
+			content:      "image: talos-1.9.0\n# talos-1.9.0\n",
+			want:         "image: talos-1.9.1\n# talos-1.9.1\n",
+			wantModified: true,
+		},
+		{
+			name: "structured and regex replacements together",
+			replacements: []Replacement{
+				{Type: TypeYAMLPath, Path: "spec.url", Value: "{{ .Source.URL }}"},
+				{Pattern: `checksum: \S+`, Value: "checksum: {{ .Source.Checksum }}"},
+			},
+			data: TemplateData{Source: SourceData{URL: "https://new.example.com/vyos.qcow2", Checksum: "sha256:def456"}},
+			content: `spec:
+  url: https://old.example.com/vyos.qcow2 # image URL
+  checksum: sha256:abc123
+`,
+			want: `spec:
+  url: https://new.example.com/vyos.qcow2 # image URL
+  checksum: sha256:def456
 `,
 			wantModified: true,
 		},
@@ -147,6 +217,62 @@ vyos_iso_checksum = "sha256:abc123"
 	}
 }
 
+func TestFileUpdater_UpdateContentExpectations(t *testing.T) {
+	tests := []struct {
+		name        string
+		replacement Replacement
+		content     string
+		wantErr     string
+	}{
+		{
+			name:        "required pattern matches nothing",
+			replacement: Replacement{Pattern: `vyos_iso_url = "[^"]*"`, Value: "x", Required: true},
+			content:     `iso_url = "https://old.example.com"`,
+			wantErr:     `replacement[0]: pattern "vyos_iso_url = \"[^\"]*\"" matched nothing but is required`,
+		},
+		{
+			name:        "too few matches",
+			replacement: Replacement{Pattern: `talos-[0-9.]+`, Value: "talos-1.9.1", ExpectMatches: 2},
+			content:     "image: talos-1.9.0\n",
+			wantErr:     "matched 1 time(s), expected 2",
+		},
+		{
+			name:        "too many matches",
+			replacement: Replacement{Pattern: `talos-[0-9.]+`, Value: "talos-1.9.1", ExpectMatches: 1},
+			content:     "image: talos-1.9.0\ninstaller: talos-1.9.0\n",
+			wantErr:     "matched 2 time(s), expected 1",
+		},
+		{
+			name:        "structured path counts documents",
+			replacement: Replacement{Type: TypeYAMLPath, Path: "spec.url", Value: "x", ExpectMatches: 1},
+			content:     "spec:\n  url: a\n---\nspec:\n  url: b\n",
+			wantErr:     "path spec.url matched 2 time(s), expected 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := New([]Replacement{tt.replacement}, TemplateData{})
+			require.NoError(t, err)
+
+			_, _, err = u.UpdateContent([]byte(tt.content))
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	t.Run("unrequired pattern may match nothing", func(t *testing.T) {
+		u, err := New([]Replacement{{Pattern: `missing`, Value: "x"}}, TemplateData{})
+		require.NoError(t, err)
+
+		_, modified, err := u.UpdateContent([]byte("content"))
+
+		require.NoError(t, err)
+		assert.False(t, modified)
+	})
+}
+
 func TestFileUpdater_UpdateFile(t *testing.T) {
 	t.Run("updates file and preserves permissions", func(t *testing.T) {
 		dir := t.TempDir()
@@ -210,3 +336,201 @@ func TestFileUpdater_UpdateFile(t *testing.T) {
 		assert.Contains(t, err.Error(), "read file")
 	})
 }
+
+func TestFileUpdater_UpdateFileSafety(t *testing.T) {
+	replacements := []Replacement{{Pattern: `old`, Value: `new`}}
+	read := func(t *testing.T, path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("replaces atomically and keeps the mode", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "build.sh")
+		require.NoError(t, os.WriteFile(path, []byte("echo old"), 0o600))
+		require.NoError(t, os.Chmod(path, 0o750)) //nolint:gosec // G302: test file
+
+		u, err := New(replacements, TemplateData{}, WithRoot(dir))
+		require.NoError(t, err)
+
+		modified, err := u.UpdateFile(path)
+
+		require.NoError(t, err)
+		assert.True(t, modified)
+		assert.Equal(t, "echo new", read(t, path))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+
+		// No temp files are left behind
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("updates the target of a symlink inside the root", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "vars", "vyos.hcl")
+		link := filepath.Join(dir, "vyos.hcl")
+		require.NoError(t, os.MkdirAll(filepath.Dir(target), 0o750))
+		require.NoError(t, os.WriteFile(target, []byte("url = old"), 0o600))
+		require.NoError(t, os.Symlink(filepath.Join("vars", "vyos.hcl"), link))
+
+		u, err := New(replacements, TemplateData{}, WithRoot(dir))
+		require.NoError(t, err)
+
+		modified, err := u.UpdateFile(link)
+
+		require.NoError(t, err)
+		assert.True(t, modified)
+		assert.Equal(t, "url = new", read(t, target))
+		info, err := os.Lstat(link)
+		require.NoError(t, err)
+		assert.Equal(t, os.ModeSymlink, info.Mode().Type(), "symlink is kept")
+	})
+
+	t.Run("refuses a symlink that escapes the root", func(t *testing.T) {
+		root := t.TempDir()
+		outside := filepath.Join(t.TempDir(), "secret.txt")
+		require.NoError(t, os.WriteFile(outside, []byte("old"), 0o600))
+		link := filepath.Join(root, "innocent.txt")
+		require.NoError(t, os.Symlink(outside, link))
+
+		u, err := New(replacements, TemplateData{}, WithRoot(root))
+		require.NoError(t, err)
+
+		_, err = u.UpdateFile(link)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the repository root")
+		assert.Equal(t, "old", read(t, outside))
+
+		_, err = u.Diff(link)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the repository root")
+	})
+
+	t.Run("refuses a relative path that escapes the root", func(t *testing.T) {
+		parent := t.TempDir()
+		root := filepath.Join(parent, "repo")
+		require.NoError(t, os.Mkdir(root, 0o750))
+		outside := filepath.Join(parent, "other.txt")
+		require.NoError(t, os.WriteFile(outside, []byte("old"), 0o600))
+
+		u, err := New(replacements, TemplateData{}, WithRoot(root))
+		require.NoError(t, err)
+
+		_, err = u.UpdateFile(filepath.Join(root, "..", "other.txt"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the repository root")
+		assert.Equal(t, "old", read(t, outside))
+	})
+
+	t.Run("refuses directories", func(t *testing.T) {
+		dir := t.TempDir()
+		u, err := New(replacements, TemplateData{}, WithRoot(dir))
+		require.NoError(t, err)
+
+		_, err = u.UpdateFile(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not a regular file")
+	})
+}
+
+func TestFileUpdater_Diff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.hcl")
+	content := "# VyOS\nvyos_iso_url = \"https://old.example.com/old.iso\"\nmemory = 2048\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644)) //nolint:gosec // G306: test file
+
+	t.Run("returns unified diff without writing", func(t *testing.T) {
+		replacements := []Replacement{
+			{Pattern: `vyos_iso_url\s*=\s*"[^"]*"`, Value: `vyos_iso_url = "{{ .Source.URL }}"`},
+		}
+		updater, err := New(replacements, TemplateData{Source: SourceData{URL: "https://new.example.com/new.iso"}})
+		require.NoError(t, err)
+
+		diff, err := updater.Diff(path)
+		require.NoError(t, err)
+		assert.Equal(t, "--- "+path+"\n+++ "+path+"\n@@ -1,3 +1,3 @@\n # VyOS\n"+
+			"-vyos_iso_url = \"https://old.example.com/old.iso\"\n"+
+			"+vyos_iso_url = \"https://new.example.com/new.iso\"\n"+
+			" memory = 2048\n", diff)
+
+		got, err := os.ReadFile(path) //nolint:gosec // G304: test file from t.TempDir()
+		require.NoError(t, err)
+		assert.Equal(t, content, string(got))
+	})
+
+	t.Run("empty when unchanged", func(t *testing.T) {
+		updater, err := New([]Replacement{{Pattern: `nonexistent`, Value: `replacement`}}, TemplateData{})
+		require.NoError(t, err)
+
+		diff, err := updater.Diff(path)
+		require.NoError(t, err)
+		assert.Empty(t, diff)
+	})
+}
+
+func TestFileUpdater_Simulate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "talos.yaml")
+	content := "image: talos-1.9.0\ninstaller: talos-1.9.0\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644)) //nolint:gosec // G306: test file
+
+	tests := []struct {
+		name         string
+		replacements []Replacement
+		wantMatches  []int
+		wantChanged  bool
+	}{
+		{
+			name: "would change",
+			replacements: []Replacement{
+				{Pattern: `talos-[0-9.]+`, Value: `talos-1.9.1`},
+				{Pattern: `kernel: .*`, Value: `kernel: 6.12`},
+			},
+			wantMatches: []int{2, 0},
+			wantChanged: true,
+		},
+		{
+			name:         "up to date",
+			replacements: []Replacement{{Pattern: `talos-[0-9.]+`, Value: `talos-1.9.0`}},
+			wantMatches:  []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updater, err := New(tt.replacements, TemplateData{}, WithRoot(dir))
+			require.NoError(t, err)
+
+			sim, err := updater.Simulate(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMatches, sim.Matches)
+			assert.Equal(t, tt.wantChanged, sim.Changed)
+
+			got, err := os.ReadFile(path) //nolint:gosec // G304: test file from t.TempDir()
+			require.NoError(t, err)
+			assert.Equal(t, content, string(got))
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		updater, err := New([]Replacement{{Pattern: `talos`, Value: `talos`}}, TemplateData{}, WithRoot(dir))
+		require.NoError(t, err)
+
+		_, err = updater.Simulate(filepath.Join(dir, "missing.yaml"))
+		assert.Error(t, err)
+	})
+
+	t.Run("diff labels are relative to the root", func(t *testing.T) {
+		updater, err := New([]Replacement{{Pattern: `talos-[0-9.]+`, Value: `talos-1.9.1`}}, TemplateData{}, WithRoot(dir))
+		require.NoError(t, err)
+
+		diff, err := updater.Diff(path)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(diff, "--- talos.yaml\n+++ talos.yaml\n"), diff)
+	})
+}
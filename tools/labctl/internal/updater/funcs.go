@@ -0,0 +1,24 @@
+package updater
+
+import (
+	"path"
+	"strings"
+	"text/template"
+)
+
+// funcs are the helper functions available in replacement templates. Names
+// and argument order follow sprig, so the string being transformed comes
+// last and helpers chain in pipelines:
+//
+//	{{ .Source.URL | base | trimSuffix ".iso" }}
+var funcs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"trim":       strings.TrimSpace,
+	"replace":    func(old, replacement, s string) string { return strings.ReplaceAll(s, old, replacement) },
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"base":       path.Base,
+	"dir":        path.Dir,
+	"ext":        path.Ext,
+}
@@ -0,0 +1,53 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	data := TemplateData{
+		Name:        "vyos-stream",
+		Destination: "vyos/vyos-2025.11-generic-amd64.iso",
+		ImageKey:    "images/vyos/vyos-2025.11-generic-amd64.iso",
+		NASPath:     "/volume1/images/vyos/vyos-2025.11-generic-amd64.iso",
+		URL:         "https://images.lab.gilman.io/vyos/vyos-2025.11-generic-amd64.iso",
+		Validation:  "sha256:abc123",
+		Size:        524288000,
+		UploadedAt:  time.Date(2025, 11, 20, 10, 0, 0, 0, time.UTC),
+		Source:      SourceData{URL: "https://github.com/vyos/releases/vyos-2025.11-generic-amd64.iso"},
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "base", value: `{{ .Source.URL | base }}`, want: "vyos-2025.11-generic-amd64.iso"},
+		{name: "dir", value: `{{ .Destination | dir }}`, want: "vyos"},
+		{name: "ext", value: `{{ .Destination | ext }}`, want: ".iso"},
+		{name: "trimPrefix", value: `{{ .Validation | trimPrefix "sha256:" }}`, want: "abc123"},
+		{name: "trimSuffix chained", value: `{{ .Destination | base | trimSuffix ".iso" }}`, want: "vyos-2025.11-generic-amd64"},
+		{name: "replace", value: `{{ .Name | replace "-" "_" }}`, want: "vyos_stream"},
+		{name: "upper and lower", value: `{{ .Name | upper }} {{ "ISO" | lower }}`, want: "VYOS-STREAM iso"},
+		{name: "trim", value: `{{ " padded " | trim }}`, want: "padded"},
+		{name: "image fields", value: `{{ .ImageKey }} {{ .NASPath }} {{ .Size }}`, want: "images/vyos/vyos-2025.11-generic-amd64.iso /volume1/images/vyos/vyos-2025.11-generic-amd64.iso 524288000"},
+		{name: "upload time", value: `{{ .UploadedAt.Format "2006-01-02" }}`, want: "2025-11-20"},
+		{name: "download URL", value: `{{ .URL }}`, want: "https://images.lab.gilman.io/vyos/vyos-2025.11-generic-amd64.iso"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := New([]Replacement{{Pattern: `^.*$`, Value: tt.value}}, data)
+			require.NoError(t, err)
+
+			got, _, err := u.UpdateContent([]byte("placeholder"))
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// jsonFrame tracks the position inside one JSON object or array.
+type jsonFrame struct {
+	object      bool
+	awaitingKey bool
+	key         string
+	index       int
+}
+
+// editJSON sets the scalar at path in a JSON document. Only the value's bytes
+// are rewritten, so indentation and key order are untouched.
+func editJSON(content []byte, path []pathElem, value string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+
+	var stack []jsonFrame
+	// valueDone advances the enclosing container past a completed value
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := &stack[len(stack)-1]
+		if top.object {
+			top.awaitingKey = true
+		} else {
+			top.index++
+		}
+	}
+
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) && len(stack) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse JSON: %w", err)
+		}
+
+		if n := len(stack); n > 0 && stack[n-1].object && stack[n-1].awaitingKey {
+			if tok == json.Delim('}') {
+				stack = stack[:n-1]
+				valueDone()
+				continue
+			}
+			stack[n-1].key, _ = tok.(string)
+			stack[n-1].awaitingKey = false
+			continue
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			if jsonPathMatches(stack, path) {
+				return nil, fmt.Errorf("path %s is not a scalar", formatPath(path))
+			}
+			stack = append(stack, jsonFrame{object: tok == json.Delim('{'), awaitingKey: true})
+			continue
+		case json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			valueDone()
+			continue
+		}
+
+		if jsonPathMatches(stack, path) {
+			start := int(before)
+			for start < len(content) && bytes.IndexByte([]byte(" \t\r\n:,"), content[start]) >= 0 {
+				start++
+			}
+			_, isString := tok.(string)
+			return splice(content, start, int(dec.InputOffset()), jsonScalar(isString, value)), nil
+		}
+		valueDone()
+	}
+
+	return nil, fmt.Errorf("path %s not found", formatPath(path))
+}
+
+// jsonPathMatches reports whether the value about to be read is at path.
+func jsonPathMatches(stack []jsonFrame, path []pathElem) bool {
+	if len(stack) != len(path) {
+		return false
+	}
+	for i, f := range stack {
+		e := path[i]
+		if f.object == e.isIndex || (f.object && f.key != e.key) || (!f.object && f.index != e.index) {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonScalar renders value as a JSON string, or as a literal when replacing
+// a non-string scalar with a value that is valid JSON.
+func jsonScalar(isString bool, value string) string {
+	if !isString && value != "" && value[0] != '{' && value[0] != '[' && value[0] != '"' && json.Valid([]byte(value)) {
+		return value
+	}
+	return quote(value)
+}
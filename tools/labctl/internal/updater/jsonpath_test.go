@@ -0,0 +1,89 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditJSON(t *testing.T) {
+	const document = `{
+  "builders": [
+    {"type": "qemu", "iso_url": "https://old.example.com/vyos.iso"},
+    {
+      "type": "qemu",
+      "iso_url": "https://old.example.com/talos.iso",
+      "disk_size": 8192,
+      "headless": true
+    }
+  ],
+  "url": "top-level"
+}
+`
+
+	tests := []struct {
+		name    string
+		path    string
+		value   string
+		old     string
+		new     string
+		wantErr string
+	}{
+		{
+			name:  "string in array element",
+			path:  "$.builders[1].iso_url",
+			value: "https://new.example.com/talos.iso",
+			old:   `"iso_url": "https://old.example.com/talos.iso"`,
+			new:   `"iso_url": "https://new.example.com/talos.iso"`,
+		},
+		{
+			name:  "inline object",
+			path:  "builders[0].iso_url",
+			value: `https://new.example.com/a&b"c`,
+			old:   `"iso_url": "https://old.example.com/vyos.iso"`,
+			new:   `"iso_url": "https://new.example.com/a&b\"c"`,
+		},
+		{
+			name:  "number stays a number",
+			path:  "builders[1].disk_size",
+			value: "16384",
+			old:   `"disk_size": 8192`,
+			new:   `"disk_size": 16384`,
+		},
+		{
+			name:  "top-level key after nested keys of the same name",
+			path:  "url",
+			value: "changed",
+			old:   `"url": "top-level"`,
+			new:   `"url": "changed"`,
+		},
+		{name: "missing index", path: "builders[2].iso_url", value: "x", wantErr: "not found"},
+		{name: "array is not a scalar", path: "builders", value: "x", wantErr: "is not a scalar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := parsePath(tt.path)
+			require.NoError(t, err)
+
+			got, err := editJSON([]byte(document), path, tt.value)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, replaceOnce(document, tt.old, tt.new), string(got))
+		})
+	}
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		path, err := parsePath("a")
+		require.NoError(t, err)
+
+		_, err = editJSON([]byte(`{"a": `), path, "x")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "parse JSON")
+	})
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package updater
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown gives f the owner and group recorded in info, when they differ from
+// f's. Changing them usually needs privileges, so it is only attempted when
+// the original file belongs to someone else.
+func chown(f *os.File, info os.FileInfo) error {
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if got, ok := current.Sys().(*syscall.Stat_t); ok && got.Uid == want.Uid && got.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid)) //nolint:gosec // G115: uid and gid fit in int
+}
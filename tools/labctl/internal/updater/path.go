@@ -0,0 +1,114 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathElem is one step of a path expression: a mapping key or a list index.
+type pathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (e pathElem) String() string {
+	if e.isIndex {
+		return fmt.Sprintf("[%d]", e.index)
+	}
+	return e.key
+}
+
+// ValidatePath reports whether expr is a valid path expression.
+func ValidatePath(expr string) error {
+	_, err := parsePath(expr)
+	return err
+}
+
+// parsePath parses a path expression such as spec.url, images[0].url or
+// metadata.annotations["lab.gilman.io/source"]. A leading "$." is allowed,
+// as in JSONPath.
+func parsePath(expr string) ([]pathElem, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	if rest == "" {
+		return nil, fmt.Errorf("path %q is empty", expr)
+	}
+
+	var path []pathElem
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unterminated quoted key", expr)
+			}
+			path = append(path, pathElem{key: rest[2:end]})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unterminated index", expr)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("path %q has an invalid index %q", expr, rest[1:end])
+			}
+			path = append(path, pathElem{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("path %q has an empty key", expr)
+			}
+			path = append(path, pathElem{key: rest[:end]})
+			rest = rest[end:]
+		}
+
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' || rest[0] == '[' {
+				return nil, fmt.Errorf("path %q has an empty key", expr)
+			}
+		} else if rest != "" && rest[0] != '[' {
+			return nil, fmt.Errorf("path %q is invalid near %q", expr, rest)
+		}
+	}
+
+	return path, nil
+}
+
+// formatPath returns the path expression for path.
+func formatPath(path []pathElem) string {
+	var b strings.Builder
+	for i, e := range path {
+		if i > 0 && !e.isIndex {
+			b.WriteByte('.')
+		}
+		b.WriteString(e.String())
+	}
+	return b.String()
+}
+
+// quote returns s as a double-quoted string. JSON escapes are also valid in
+// YAML double-quoted scalars and TOML basic strings.
+func quote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s) // Encoding a string cannot fail
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// splice replaces content[start:end] with value.
+func splice(content []byte, start, end int, value string) []byte {
+	result := make([]byte, 0, len(content)-(end-start)+len(value))
+	result = append(result, content[:start]...)
+	result = append(result, value...)
+	return append(result, content[end:]...)
+}
@@ -0,0 +1,40 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    []pathElem
+		wantErr string
+	}{
+		{expr: "spec.url", want: []pathElem{{key: "spec"}, {key: "url"}}},
+		{expr: "$.spec.url", want: []pathElem{{key: "spec"}, {key: "url"}}},
+		{expr: "images[1].url", want: []pathElem{{key: "images"}, {index: 1, isIndex: true}, {key: "url"}}},
+		{expr: `metadata.annotations["lab.gilman.io/source"]`, want: []pathElem{{key: "metadata"}, {key: "annotations"}, {key: "lab.gilman.io/source"}}},
+		{expr: "", wantErr: "is empty"},
+		{expr: "spec..url", wantErr: "empty key"},
+		{expr: "spec.", wantErr: "empty key"},
+		{expr: "images[x]", wantErr: "invalid index"},
+		{expr: "images[0", wantErr: "unterminated index"},
+		{expr: `a["b`, wantErr: "unterminated quoted key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parsePath(tt.expr)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
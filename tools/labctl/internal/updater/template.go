@@ -0,0 +1,87 @@
+package updater
+
+import (
+	"text/template"
+	"text/template/parse"
+)
+
+// UsesField reports whether the replacement template value refers to the
+// top-level TemplateData field name, as in {{ .URL }} or {{ .URL | base }}.
+// Inside with and range, dot is rebound, so {{ with .Source }}{{ .URL }}{{ end }}
+// does not use the top-level URL; $ always refers to the top level.
+// A value that does not parse uses nothing; New reports the parse error.
+func UsesField(value, name string) bool {
+	tmpl, err := template.New("value").Funcs(funcs).Parse(value)
+	if err != nil || tmpl.Tree == nil {
+		return false
+	}
+	return nodeUsesField(tmpl.Tree.Root, name, true)
+}
+
+// nodeUsesField reports whether any field reference under node starts with
+// name. top reports whether dot is the top-level data at node.
+func nodeUsesField(node parse.Node, name string, top bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if nodeUsesField(child, name, top) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeUsesField(n.Pipe, name, top)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if nodeUsesField(cmd, name, top) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if nodeUsesField(arg, name, top) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return top && len(n.Ident) > 0 && n.Ident[0] == name
+	case *parse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == name
+	case *parse.ChainNode:
+		return nodeUsesField(n.Node, name, top)
+	case *parse.IfNode:
+		return nodeUsesField(&n.BranchNode, name, top)
+	case *parse.RangeNode:
+		return branchUsesField(&n.BranchNode, name, top)
+	case *parse.WithNode:
+		return branchUsesField(&n.BranchNode, name, top)
+	case *parse.BranchNode:
+		return nodeUsesField(n.Pipe, name, top) || nodeUsesField(n.List, name, top) || nodeUsesField(n.ElseList, name, top)
+	case *parse.TemplateNode:
+		return nodeUsesField(n.Pipe, name, top)
+	}
+	return false
+}
+
+// branchUsesField is nodeUsesField for a with or range branch, whose body runs
+// with dot set to the value of its pipeline. The else branch keeps the outer
+// dot.
+func branchUsesField(n *parse.BranchNode, name string, top bool) bool {
+	return nodeUsesField(n.Pipe, name, top) ||
+		nodeUsesField(n.List, name, top && isDot(n.Pipe)) ||
+		nodeUsesField(n.ElseList, name, top)
+}
+
+// isDot reports whether pipe is just dot, as in {{ with . }}.
+func isDot(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
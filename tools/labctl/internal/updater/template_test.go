@@ -0,0 +1,37 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsesField(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "plain field", value: `{{ .URL }}`, want: true},
+		{name: "in pipeline", value: `url = "{{ .URL | base }}"`, want: true},
+		{name: "as argument", value: `{{ trimSuffix ".iso" .URL }}`, want: true},
+		{name: "root variable", value: `{{ $.URL }}`, want: true},
+		{name: "in if branch", value: `{{ if .Name }}{{ .URL }}{{ end }}`, want: true},
+		{name: "in else branch", value: `{{ with .Vars.x }}{{ . }}{{ else }}{{ .URL }}{{ end }}`, want: true},
+		{name: "nested field of another", value: `{{ .Source.URL }}`, want: false},
+		{name: "field of with dot", value: `{{ with .Source }}{{ .URL }}{{ end }}`, want: false},
+		{name: "field of range dot", value: `{{ range .Vars }}{{ .URL }}{{ end }}`, want: false},
+		{name: "root variable in with", value: `{{ with .Source }}{{ $.URL }}{{ end }}`, want: true},
+		{name: "with pipeline", value: `{{ with .URL }}{{ . }}{{ end }}`, want: true},
+		{name: "with dot", value: `{{ with . }}{{ .URL }}{{ end }}`, want: true},
+		{name: "other field", value: `{{ .PresignedURL }}`, want: false},
+		{name: "literal text", value: `.URL`, want: false},
+		{name: "invalid template", value: `{{ .URL`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, UsesField(tt.value, "URL"))
+		})
+	}
+}
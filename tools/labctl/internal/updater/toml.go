@@ -0,0 +1,242 @@
+package updater
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tomlBareValue matches values that can be written unquoted: booleans,
+// numbers and dates.
+var tomlBareValue = regexp.MustCompile(`^(true|false|[+-]?(inf|nan)|[+-]?[0-9][0-9A-Za-z_.:+-]*)$`)
+
+// editTOML sets the value of the key at path in a TOML document. Tables and
+// arrays of tables ([servers], [[packages]]) give the leading path elements,
+// so packages[1].url is the url key of the second [[packages]] table. Only
+// the value's bytes are rewritten, so comments and layout are untouched.
+// Multi-line strings, arrays and inline tables cannot be edited.
+func editTOML(content []byte, path []pathElem, value string) ([]byte, error) {
+	lines := strings.SplitAfter(string(content), "\n")
+	offset := 0
+
+	var table []pathElem
+	arrayCounts := make(map[string]int)
+	skipUntil := "" // Closing delimiter of a multi-line value being skipped
+	depth := 0      // Bracket depth of a multi-line array being skipped
+
+	for _, line := range lines {
+		lineStart := offset
+		offset += len(line)
+
+		switch {
+		case skipUntil != "":
+			if strings.Contains(line, skipUntil) {
+				skipUntil = ""
+			}
+			continue
+		case depth > 0:
+			depth += tomlBracketDepth(line)
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		if trimmed[0] == '[' {
+			header, isArray, err := tomlTableHeader(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			table = header
+			if isArray {
+				name := formatPath(header)
+				table = append(table, pathElem{index: arrayCounts[name], isIndex: true})
+				arrayCounts[name]++
+			}
+			continue
+		}
+
+		key, rest, ok := tomlKey(trimmed)
+		if !ok {
+			continue
+		}
+		full := append(append([]pathElem{}, table...), key...)
+		// valueText is a suffix of trimmed, which starts after the indentation
+		valueText := strings.TrimLeft(rest, " \t")
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		valueStart := lineStart + indent + len(trimmed) - len(valueText)
+
+		if !slices.Equal(full, path) {
+			switch {
+			case strings.HasPrefix(valueText, `"""`) && !strings.Contains(valueText[3:], `"""`):
+				skipUntil = `"""`
+			case strings.HasPrefix(valueText, `'''`) && !strings.Contains(valueText[3:], `'''`):
+				skipUntil = `'''`
+			case strings.HasPrefix(valueText, "["):
+				depth = tomlBracketDepth(valueText)
+			}
+			continue
+		}
+
+		end, quoting, err := tomlValueEnd(valueText)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", formatPath(path), err)
+		}
+		return splice(content, valueStart, valueStart+end, tomlScalar(quoting, value)), nil
+	}
+
+	return nil, fmt.Errorf("path %s not found", formatPath(path))
+}
+
+// tomlTableHeader parses a [table] or [[array]] header line.
+func tomlTableHeader(line string) ([]pathElem, bool, error) {
+	isArray := strings.HasPrefix(line, "[[")
+	open, closing := "[", "]"
+	if isArray {
+		open, closing = "[[", "]]"
+	}
+
+	end := strings.Index(line, closing)
+	if end < 0 {
+		return nil, false, fmt.Errorf("invalid TOML table header %q", line)
+	}
+	key, rest, ok := tomlDottedKey(strings.TrimSpace(line[len(open):end]))
+	if !ok || strings.TrimSpace(rest) != "" {
+		return nil, false, fmt.Errorf("invalid TOML table header %q", line)
+	}
+	return key, isArray, nil
+}
+
+// tomlKey parses the key of a key = value line, returning the text after "=".
+func tomlKey(line string) ([]pathElem, string, bool) {
+	key, rest, ok := tomlDottedKey(line)
+	if !ok {
+		return nil, "", false
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(rest, "=") {
+		return nil, "", false
+	}
+	return key, rest[1:], true
+}
+
+// tomlDottedKey parses a dotted key of bare and quoted parts from the start
+// of s and returns the remaining text.
+func tomlDottedKey(s string) ([]pathElem, string, bool) {
+	var key []pathElem
+	for {
+		s = strings.TrimLeft(s, " \t")
+		switch {
+		case s == "":
+			return nil, "", false
+		case s[0] == '"' || s[0] == '\'':
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return nil, "", false
+			}
+			key = append(key, pathElem{key: s[1 : end+1]})
+			s = s[end+2:]
+		default:
+			end := 0
+			for end < len(s) && isTOMLBareKeyChar(s[end]) {
+				end++
+			}
+			if end == 0 {
+				return nil, "", false
+			}
+			key = append(key, pathElem{key: s[:end]})
+			s = s[end:]
+		}
+
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return key, s, true
+		}
+		s = s[1:]
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// tomlValueEnd returns the length of the scalar value at the start of s and
+// its quote character, or 0 for bare values.
+func tomlValueEnd(s string) (int, byte, error) {
+	switch {
+	case s == "":
+		return 0, 0, fmt.Errorf("missing value")
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, `'''`):
+		return 0, 0, fmt.Errorf("multi-line strings are not supported")
+	case s[0] == '[' || s[0] == '{':
+		return 0, 0, fmt.Errorf("value is not a scalar")
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, '"', nil
+			}
+		}
+		return 0, 0, fmt.Errorf("unterminated string")
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return 0, 0, fmt.Errorf("unterminated string")
+		}
+		return end + 2, '\'', nil
+	default:
+		end := strings.IndexAny(s, " \t\r\n#")
+		if end < 0 {
+			end = len(s)
+		}
+		return end, 0, nil
+	}
+}
+
+// tomlScalar renders value with the quoting of the value it replaces. Bare
+// values stay bare only if the new value is a bare literal too.
+func tomlScalar(quoting byte, value string) string {
+	switch quoting {
+	case '\'':
+		if !strings.ContainsAny(value, "'\r\n") {
+			return "'" + value + "'"
+		}
+	case 0:
+		if tomlBareValue.MatchString(value) {
+			return value
+		}
+	}
+	return quote(value)
+}
+
+// tomlBracketDepth returns the change in array bracket depth over s,
+// ignoring brackets inside strings and comments.
+func tomlBracketDepth(s string) int {
+	depth := 0
+	var inString byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString != 0:
+			if c == '\\' && inString == '"' {
+				i++
+			} else if c == inString {
+				inString = 0
+			}
+		case c == '"' || c == '\'':
+			inString = c
+		case c == '#':
+			return depth
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
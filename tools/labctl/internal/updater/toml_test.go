@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replaceOnce replaces the single occurrence of old in s.
+func replaceOnce(s, old, replacement string) string {
+	return strings.Replace(s, old, replacement, 1)
+}
+
+func TestEditTOML(t *testing.T) {
+	const flavor = `# VyOS generic flavor
+image_format = "iso"
+version = '2025.12.01'
+build.retries = 3
+
+packages = [
+  "vim",
+  "url = not-a-key",
+]
+
+description = """
+url = also not a key
+"""
+
+[boot_settings]
+console_type = "ttyS" # serial console
+
+[[includes_chroot]]
+path = "config/one"
+url = "https://old.example.com/one"
+
+[[includes_chroot]]
+path = "config/two"
+url = "https://old.example.com/two"
+`
+
+	tests := []struct {
+		name    string
+		path    string
+		value   string
+		old     string
+		new     string
+		wantErr string
+	}{
+		{
+			name:  "top-level basic string",
+			path:  "image_format",
+			value: "qcow2",
+			old:   `image_format = "iso"`,
+			new:   `image_format = "qcow2"`,
+		},
+		{
+			name:  "literal string",
+			path:  "version",
+			value: "2025.12.20",
+			old:   `version = '2025.12.01'`,
+			new:   `version = '2025.12.20'`,
+		},
+		{
+			name:  "dotted key with bare value",
+			path:  "build.retries",
+			value: "5",
+			old:   `build.retries = 3`,
+			new:   `build.retries = 5`,
+		},
+		{
+			name:  "bare value replaced by a string is quoted",
+			path:  "build.retries",
+			value: "many",
+			old:   `build.retries = 3`,
+			new:   `build.retries = "many"`,
+		},
+		{
+			name:  "table keeps comment",
+			path:  "boot_settings.console_type",
+			value: "hvc",
+			old:   `console_type = "ttyS" # serial console`,
+			new:   `console_type = "hvc" # serial console`,
+		},
+		{
+			name:  "array of tables",
+			path:  "includes_chroot[1].url",
+			value: "https://new.example.com/two",
+			old:   `url = "https://old.example.com/two"`,
+			new:   `url = "https://new.example.com/two"`,
+		},
+		{name: "lines inside arrays and multi-line strings are not keys", path: "url", value: "x", wantErr: "path url not found"},
+		{name: "array is not a scalar", path: "packages", value: "x", wantErr: "value is not a scalar"},
+		{name: "multi-line string", path: "description", value: "x", wantErr: "multi-line strings are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := parsePath(tt.path)
+			require.NoError(t, err)
+
+			got, err := editTOML([]byte(flavor), path, tt.value)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, replaceOnce(flavor, tt.old, tt.new), string(got))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// modeBits are the parts of a file mode that writeFile preserves.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// writeFile atomically replaces path with content. It writes a temp file in
+// the same directory, syncs it, and renames it over path, so an interrupted
+// write never leaves path truncated. The new file gets the mode and
+// ownership in info, the original file's.
+func writeFile(path string, content []byte, info os.FileInfo) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".labctl-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(content); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err = tmp.Chmod(info.Mode() & modeBits); err != nil {
+		return fmt.Errorf("preserve mode: %w", err)
+	}
+	if err = chown(tmp, info); err != nil {
+		return fmt.Errorf("preserve ownership: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+
+	// Persist the rename; not every platform can sync a directory
+	if d, openErr := os.Open(dir); openErr == nil { //nolint:gosec // G304: Directory of a resolved path
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package updater
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// editYAML sets the scalar at path in every document of a YAML stream that
+// has it. Only the scalar's bytes are rewritten, so comments, indentation
+// and the rest of the file are untouched. The scalar keeps its quoting style
+// unless the new value needs quotes. It returns the number of documents
+// edited.
+func editYAML(content []byte, path []pathElem, value string) ([]byte, int, error) {
+	var targets []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("parse YAML: %w", err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if node := findYAML(doc.Content[0], path); node != nil {
+			targets = append(targets, node)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, 0, fmt.Errorf("path %s not found", formatPath(path))
+	}
+
+	lines := lineOffsets(content)
+	result := content
+	// Edit from the end so earlier offsets stay valid
+	for i := len(targets) - 1; i >= 0; i-- {
+		node := targets[i]
+		if node.Kind != yaml.ScalarNode {
+			return nil, 0, fmt.Errorf("path %s is not a scalar", formatPath(path))
+		}
+
+		start, ok := offsetOf(content, lines, node.Line, node.Column)
+		if !ok {
+			return nil, 0, fmt.Errorf("path %s: position %d:%d is outside the file", formatPath(path), node.Line, node.Column)
+		}
+		end, err := yamlScalarEnd(content, start, node)
+		if err != nil {
+			return nil, 0, fmt.Errorf("path %s: %w", formatPath(path), err)
+		}
+		result = splice(result, start, end, yamlScalar(node, value))
+	}
+
+	return result, len(targets), nil
+}
+
+// findYAML returns the node at path below node, or nil.
+func findYAML(node *yaml.Node, path []pathElem) *yaml.Node {
+	for _, e := range path {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		switch {
+		case e.isIndex && node.Kind == yaml.SequenceNode:
+			if e.index >= len(node.Content) {
+				return nil
+			}
+			node = node.Content[e.index]
+		case !e.isIndex && node.Kind == yaml.MappingNode:
+			var next *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == e.key {
+					next = node.Content[i+1]
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			node = next
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// yamlScalarEnd returns the offset just past the scalar that starts at start.
+func yamlScalarEnd(content []byte, start int, node *yaml.Node) (int, error) {
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := start + 1; i < len(content); i++ {
+			switch content[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := start + 1; i < len(content); i++ {
+			if content[i] != '\'' {
+				continue
+			}
+			if i+1 < len(content) && content[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	case 0:
+		// A single-line plain scalar is exactly its value
+		if end := start + len(node.Value); end <= len(content) && string(content[start:end]) == node.Value {
+			return end, nil
+		}
+		return 0, fmt.Errorf("multi-line plain scalars are not supported")
+	default:
+		return 0, fmt.Errorf("tagged and block scalars are not supported")
+	}
+	return 0, fmt.Errorf("unterminated quoted scalar")
+}
+
+// yamlScalar renders value in the style of node. Plain scalars stay plain
+// only if the value reads back as the same string with the same tag.
+func yamlScalar(node *yaml.Node, value string) string {
+	switch node.Style {
+	case yaml.SingleQuotedStyle:
+		if !bytes.ContainsAny([]byte(value), "\n\r") {
+			return "'" + string(bytes.ReplaceAll([]byte(value), []byte("'"), []byte("''"))) + "'"
+		}
+	case 0:
+		var doc yaml.Node
+		if yaml.Unmarshal([]byte(value), &doc) == nil && len(doc.Content) == 1 {
+			n := doc.Content[0]
+			if n.Kind == yaml.ScalarNode && n.Style == 0 && n.Value == value && n.Tag == node.Tag {
+				return value
+			}
+		}
+	}
+	return quote(value)
+}
+
+// lineOffsets returns the byte offset at which each line starts.
+func lineOffsets(content []byte) []int {
+	offsets := []int{0}
+	for i, b := range content {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// offsetOf converts a 1-based line and character column to a byte offset.
+func offsetOf(content []byte, lines []int, line, column int) (int, bool) {
+	if line < 1 || line > len(lines) {
+		return 0, false
+	}
+	offset := lines[line-1]
+	for c := 1; c < column; c++ {
+		if offset >= len(content) || content[offset] == '\n' {
+			return 0, false
+		}
+		_, size := utf8.DecodeRune(content[offset:])
+		offset += size
+	}
+	return offset, true
+}
@@ -0,0 +1,112 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditYAML(t *testing.T) {
+	const manifest = `# Harvester image for VyOS
+apiVersion: harvesterhci.io/v1beta1
+kind: VirtualMachineImage
+metadata:
+  name: vyos # keep in sync with the template
+spec:
+  displayName: 'vyos-2025.12.01'
+  url: "https://old.example.com/vyos.qcow2"  # updated by labctl
+  sourceType: download
+  checksum: abc
+  retry: 3
+---
+kind: VirtualMachineImage
+spec:
+  url: https://old.example.com/other.qcow2
+`
+
+	tests := []struct {
+		name    string
+		path    string
+		value   string
+		want    map[string]string // old -> new fragments
+		wantErr string
+	}{
+		{
+			name:  "double-quoted scalar in every document",
+			path:  "spec.url",
+			value: "https://new.example.com/vyos.qcow2",
+			want: map[string]string{
+				`url: "https://old.example.com/vyos.qcow2"  # updated by labctl`: `url: "https://new.example.com/vyos.qcow2"  # updated by labctl`,
+				`url: https://old.example.com/other.qcow2`:                       `url: https://new.example.com/vyos.qcow2`,
+			},
+		},
+		{
+			name:  "single-quoted scalar",
+			path:  "spec.displayName",
+			value: "vyos-2025.12.20",
+			want:  map[string]string{`displayName: 'vyos-2025.12.01'`: `displayName: 'vyos-2025.12.20'`},
+		},
+		{
+			name:  "plain scalar keeps its comment",
+			path:  "metadata.name",
+			value: "vyos-rolling",
+			want:  map[string]string{`name: vyos # keep`: `name: vyos-rolling # keep`},
+		},
+		{
+			name:  "plain string that would change type is quoted",
+			path:  "spec.checksum",
+			value: "1.5",
+			want:  map[string]string{`checksum: abc`: `checksum: "1.5"`},
+		},
+		{
+			name:  "plain number stays plain",
+			path:  "spec.retry",
+			value: "5",
+			want:  map[string]string{`retry: 3`: `retry: 5`},
+		},
+		{name: "missing path", path: "spec.missing", value: "x", wantErr: "path spec.missing not found"},
+		{name: "mapping is not a scalar", path: "spec", value: "x", wantErr: "is not a scalar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := parsePath(tt.path)
+			require.NoError(t, err)
+
+			got, edited, err := editYAML([]byte(manifest), path, tt.value)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.want), edited)
+
+			want := manifest
+			for old, replacement := range tt.want {
+				require.Contains(t, want, old)
+				want = replaceOnce(want, old, replacement)
+			}
+			assert.Equal(t, want, string(got))
+		})
+	}
+
+	t.Run("block scalars are not supported", func(t *testing.T) {
+		path, err := parsePath("spec.url")
+		require.NoError(t, err)
+
+		_, _, err = editYAML([]byte("spec:\n  url: |\n    https://example.com\n"), path, "x")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "block scalars are not supported")
+	})
+
+	t.Run("multi-byte characters before the scalar", func(t *testing.T) {
+		path, err := parsePath("spec.url")
+		require.NoError(t, err)
+
+		got, _, err := editYAML([]byte("spec: {name: ü, url: old}\n"), path, "new")
+		require.NoError(t, err)
+		assert.Equal(t, "spec: {name: ü, url: new}\n", string(got))
+	})
+}
@@ -2,13 +2,18 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	"github.com/GilmanLab/lab/tools/labctl/cmd"
+	"github.com/GilmanLab/lab/tools/labctl/cmd/images"
 )
 
 func main() {
 	if err := cmd.Execute(); err != nil {
+		if errors.Is(err, images.ErrChangesPending) {
+			os.Exit(2)
+		}
 		os.Exit(1)
 	}
 }